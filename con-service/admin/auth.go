@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// RequireToken rejects requests without token as their bearer token.
+func RequireToken(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				logger.Get().Warn("rejected admin request", zap.String("method", r.Method), zap.String("path", r.URL.Path),
+					zap.String("remote_addr", r.RemoteAddr))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="con-service admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
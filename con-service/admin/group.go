@@ -0,0 +1,143 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+const name = "admin"
+
+var (
+	tracer = otel.Tracer(name)
+)
+
+// GroupAdmin manages the consumer group, implemented by kafka.GroupAdmin.
+type GroupAdmin interface {
+	Describe() (kafka.GroupDescription, error)
+	Offsets(topics ...string) ([]kafka.GroupOffset, error)
+	Reset(ctx context.Context, reset kafka.OffsetReset) ([]kafka.ResetResult, error)
+}
+
+// GroupHandler serves the consumer group admin endpoints.
+type GroupHandler struct {
+	Group GroupAdmin
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type ResetResponse struct {
+	DryRun  bool                `json:"dry_run"`
+	Results []kafka.ResetResult `json:"results"`
+}
+
+// Describe reports the state of the group and the assignments of its
+// members.
+func (h *GroupHandler) Describe(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "describe group")
+	defer span.End()
+
+	desc, err := h.Group.Describe()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, desc)
+}
+
+// Members reports the members of the group and their assignments.
+func (h *GroupHandler) Members(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "list group members")
+	defer span.End()
+
+	desc, err := h.Group.Describe()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, desc.Members)
+}
+
+// Offsets reports the committed offsets and lag of the group, in the topics
+// of the topic query parameters or every topic it committed offsets for.
+func (h *GroupHandler) Offsets(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "list group offsets")
+	defer span.End()
+
+	offsets, err := h.Group.Offsets(r.URL.Query()["topic"]...)
+	switch {
+	case errors.Is(err, sarama.ErrUnknownTopicOrPartition):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if offsets == nil {
+		offsets = []kafka.GroupOffset{}
+	}
+	writeJSON(w, http.StatusOK, offsets)
+}
+
+// Reset moves the committed offsets of the group as the kafka.OffsetReset in
+// the request body says. It answers 409 unless the group is empty, the
+// partitions are paused by this consumer or the reset is a dry run.
+func (h *GroupHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "reset group offsets")
+	defer span.End()
+	log := logger.Get()
+
+	var reset kafka.OffsetReset
+	if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := h.Group.Reset(ctx, reset)
+	switch {
+	case err == nil:
+	case errors.Is(err, kafka.ErrInvalidReset):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, sarama.ErrUnknownTopicOrPartition):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, kafka.ErrGroupActive):
+		writeError(w, http.StatusConflict, err)
+		return
+	default:
+		log.Error("failed to reset offsets", zap.String("topic", reset.Topic), zap.String("to", reset.To), zap.Error(err))
+		span.SetStatus(codes.Error, err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !reset.DryRun {
+		log.Info("reset offsets", zap.String("topic", reset.Topic), zap.String("to", reset.To), zap.Any("results", results))
+	}
+	writeJSON(w, http.StatusOK, ResetResponse{DryRun: reset.DryRun, Results: results})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Get().Error("failed to encode response", zap.Error(err))
+	}
+}
@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rlindsey28/con-service/kafka"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeGroupAdmin struct {
+	reset kafka.OffsetReset
+	err   error
+}
+
+func (a *fakeGroupAdmin) Describe() (kafka.GroupDescription, error) {
+	return kafka.GroupDescription{Group: "con-service", State: "Stable", Members: []kafka.GroupMember{
+		{MemberID: "member-1", Assignment: map[string][]int32{"dice-rolls": {0, 1}}},
+	}}, a.err
+}
+
+func (a *fakeGroupAdmin) Offsets(topics ...string) ([]kafka.GroupOffset, error) {
+	return []kafka.GroupOffset{{Topic: "dice-rolls", Partition: 0, Committed: 5, HighWaterMark: 8, Lag: 3}}, a.err
+}
+
+func (a *fakeGroupAdmin) Reset(_ context.Context, reset kafka.OffsetReset) ([]kafka.ResetResult, error) {
+	a.reset = reset
+	if a.err != nil {
+		return nil, a.err
+	}
+	return []kafka.ResetResult{{Topic: reset.Topic, Partition: 0, Previous: 5, Offset: 0}}, nil
+}
+
+func newTestRouter(group GroupAdmin) *mux.Router {
+	h := GroupHandler{Group: group}
+	router := mux.NewRouter()
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(RequireToken("secret"))
+	adminRouter.HandleFunc("/group", h.Describe).Methods("GET")
+	adminRouter.HandleFunc("/group/members", h.Members).Methods("GET")
+	adminRouter.HandleFunc("/group/offsets", h.Offsets).Methods("GET")
+	adminRouter.HandleFunc("/group/offsets/reset", h.Reset).Methods("POST")
+	return router
+}
+
+func request(method, url, token, body string) *http.Request {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestRequireToken(t *testing.T) {
+	router := newTestRouter(&fakeGroupAdmin{})
+	for token, code := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, request(http.MethodGet, "/admin/group", token, ""))
+		if rr.Code != code {
+			t.Errorf("got status %d with token %q, want %d", rr.Code, token, code)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	router := newTestRouter(&fakeGroupAdmin{})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request(http.MethodGet, "/admin/group/members", "secret", ""))
+	var members []kafka.GroupMember
+	if err := json.NewDecoder(rr.Body).Decode(&members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || len(members[0].Assignment["dice-rolls"]) != 2 {
+		t.Errorf("got members %+v", members)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, request(http.MethodGet, "/admin/group/offsets?topic=dice-rolls", "secret", ""))
+	var offsets []kafka.GroupOffset
+	if err := json.NewDecoder(rr.Body).Decode(&offsets); err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 || offsets[0].Lag != 3 {
+		t.Errorf("got offsets %+v", offsets)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  error
+		code int
+	}{
+		{name: "reset", body: `{"topic":"dice-rolls","to":"earliest"}`, code: http.StatusOK},
+		{name: "invalid body", body: `{"topic":`, code: http.StatusBadRequest},
+		{name: "invalid reset", body: `{"topic":"dice-rolls","to":"soon"}`, err: fmt.Errorf("%w: to", kafka.ErrInvalidReset), code: http.StatusBadRequest},
+		{name: "active group", body: `{"topic":"dice-rolls","to":"latest"}`, err: fmt.Errorf("%w: group con-service is Stable", kafka.ErrGroupActive), code: http.StatusConflict},
+		{name: "failed", body: `{"topic":"dice-rolls","to":"latest"}`, err: errors.New("coordinator not available"), code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &fakeGroupAdmin{err: tt.err}
+			rr := httptest.NewRecorder()
+			newTestRouter(group).ServeHTTP(rr, request(http.MethodPost, "/admin/group/offsets/reset", "secret", tt.body))
+			if rr.Code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.code, rr.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp ResetResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if group.reset.To != kafka.ResetEarliest || len(resp.Results) != 1 || resp.Results[0].Previous != 5 {
+				t.Errorf("got reset %+v answered with %+v", group.reset, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rlindsey28/con-service/kafka"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Pauser pauses and resumes the consumption of partitions, implemented by
+// kafka.Consumer.
+type Pauser interface {
+	Pause(partitions map[string][]int32) error
+	Resume(partitions map[string][]int32) error
+	Paused() kafka.PauseStatus
+}
+
+// PauseHandler serves the endpoints that pause and resume consumption.
+type PauseHandler struct {
+	Consumer Pauser
+}
+
+// Status reports the paused partitions.
+func (h *PauseHandler) Status(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "pause status")
+	defer span.End()
+
+	writeJSON(w, http.StatusOK, h.Consumer.Paused())
+}
+
+// Pause pauses the partitions of the topic and partition query parameters,
+// or every partition without parameters. A topic without partitions stands
+// for all of its partitions. The consumer stays in the group.
+func (h *PauseHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "pause partitions", h.Consumer.Pause)
+}
+
+// Resume resumes the partitions selected as for Pause.
+func (h *PauseHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	h.update(w, r, "resume partitions", h.Consumer.Resume)
+}
+
+func (h *PauseHandler) update(w http.ResponseWriter, r *http.Request, spanName string, update func(map[string][]int32) error) {
+	_, span := tracer.Start(r.Context(), spanName)
+	defer span.End()
+
+	partitions, err := selectedPartitions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = update(partitions)
+	switch {
+	case err == nil:
+	case errors.Is(err, kafka.ErrUnknownTopic):
+		writeError(w, http.StatusNotFound, err)
+		return
+	default:
+		span.SetStatus(codes.Error, err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Consumer.Paused())
+}
+
+// selectedPartitions reads the topic and partition query parameters. The
+// partitions belong to the single topic given with them.
+func selectedPartitions(r *http.Request) (map[string][]int32, error) {
+	query := r.URL.Query()
+	topics, partitions := query["topic"], query["partition"]
+	if len(partitions) > 0 && len(topics) != 1 {
+		return nil, errors.New("partitions must be given with a single topic")
+	}
+	selected := make(map[string][]int32)
+	for _, topic := range topics {
+		selected[topic] = nil
+	}
+	for _, s := range partitions {
+		partition, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid partition %q", s)
+		}
+		selected[topics[0]] = append(selected[topics[0]], int32(partition))
+	}
+	return selected, nil
+}
@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rlindsey28/con-service/kafka"
+
+	"github.com/gorilla/mux"
+)
+
+type fakePauser struct {
+	paused  map[string][]int32
+	resumed map[string][]int32
+}
+
+func (p *fakePauser) Pause(partitions map[string][]int32) error {
+	if _, ok := partitions["unknown"]; ok {
+		return fmt.Errorf("%w unknown", kafka.ErrUnknownTopic)
+	}
+	p.paused = partitions
+	return nil
+}
+
+func (p *fakePauser) Resume(partitions map[string][]int32) error {
+	p.resumed = partitions
+	return nil
+}
+
+func (p *fakePauser) Paused() kafka.PauseStatus {
+	return kafka.PauseStatus{All: len(p.paused) == 0, Partitions: p.paused}
+}
+
+func newTestPauseRouter(consumer Pauser) *mux.Router {
+	h := PauseHandler{Consumer: consumer}
+	router := mux.NewRouter()
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(RequireToken("secret"))
+	adminRouter.HandleFunc("/pause", h.Status).Methods("GET")
+	adminRouter.HandleFunc("/pause", h.Pause).Methods("POST")
+	adminRouter.HandleFunc("/resume", h.Resume).Methods("POST")
+	return router
+}
+
+func TestPause(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want map[string][]int32
+		code int
+	}{
+		{name: "all", url: "/admin/pause", want: map[string][]int32{}, code: http.StatusOK},
+		{name: "topic", url: "/admin/pause?topic=dice-rolls", want: map[string][]int32{"dice-rolls": nil}, code: http.StatusOK},
+		{name: "partitions", url: "/admin/pause?topic=dice-rolls&partition=0&partition=2", want: map[string][]int32{"dice-rolls": {0, 2}}, code: http.StatusOK},
+		{name: "invalid partition", url: "/admin/pause?topic=dice-rolls&partition=x", code: http.StatusBadRequest},
+		{name: "partition without topic", url: "/admin/pause?partition=0", code: http.StatusBadRequest},
+		{name: "unknown topic", url: "/admin/pause?topic=unknown", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &fakePauser{}
+			rr := httptest.NewRecorder()
+			newTestPauseRouter(consumer).ServeHTTP(rr, request(http.MethodPost, tt.url, "secret", ""))
+			if rr.Code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.code, rr.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if fmt.Sprint(consumer.paused) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v paused, want %v", consumer.paused, tt.want)
+			}
+			var status kafka.PauseStatus
+			if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	consumer := &fakePauser{paused: map[string][]int32{"dice-rolls": {1}}}
+	router := newTestPauseRouter(consumer)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request(http.MethodPost, "/admin/resume?topic=dice-rolls&partition=1", "secret", ""))
+	if rr.Code != http.StatusOK || len(consumer.resumed["dice-rolls"]) != 1 {
+		t.Errorf("got status %d resuming %v", rr.Code, consumer.resumed)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, request(http.MethodGet, "/admin/pause", "", ""))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without a token, want 401", rr.Code)
+	}
+}
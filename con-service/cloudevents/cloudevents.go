@@ -0,0 +1,349 @@
+// Package cloudevents implements the Kafka protocol binding of CloudEvents
+// 1.0. In binary mode the attributes are sent as ce_ prefixed headers and the
+// value is the event data; in structured mode the value is a JSON envelope.
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+)
+
+const (
+	ModeNone       = "none"
+	ModeBinary     = "binary"
+	ModeStructured = "structured"
+)
+
+const (
+	SpecVersion = "1.0"
+	// ContentTypeStructured is the content-type header of structured mode
+	// messages.
+	ContentTypeStructured = "application/cloudevents+json"
+
+	headerPrefix      = "ce_"
+	headerContentType = "content-type"
+)
+
+var (
+	ErrNotCloudEvent = errors.New("message is not a cloudevent")
+	ErrInvalidEvent  = errors.New("invalid cloudevent")
+)
+
+// Event holds the context attributes of a CloudEvent and its data. Data is
+// the raw payload, whatever DataContentType says it is.
+type Event struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Subject         string
+	// Extensions holds any other attribute, keyed by its name.
+	Extensions map[string]string
+	Data       []byte
+}
+
+// Encoder wraps message values in CloudEvents. Every event is a copy of the
+// template the Encoder was created with, with a new ID and Time.
+type Encoder struct {
+	mode     string
+	template Event
+	now      func() time.Time
+}
+
+// NewEncoder returns an encoder for mode, or nil for ModeNone. Source and
+// Type of template are required.
+func NewEncoder(mode string, template Event) (*Encoder, error) {
+	switch mode {
+	case "", ModeNone:
+		return nil, nil
+	case ModeBinary, ModeStructured:
+	default:
+		return nil, fmt.Errorf("invalid cloudevents mode %q, must be one of none, binary or structured", mode)
+	}
+	if template.Source == "" || template.Type == "" {
+		return nil, fmt.Errorf("%w: source and type are required", ErrInvalidEvent)
+	}
+	template.SpecVersion = SpecVersion
+	return &Encoder{mode: mode, template: template, now: time.Now}, nil
+}
+
+// Mode returns the binding mode of e. A nil Encoder does not wrap messages.
+func (e *Encoder) Mode() string {
+	if e == nil {
+		return ModeNone
+	}
+	return e.mode
+}
+
+// Encode sets the value of msg to data wrapped in a new event with subject,
+// which may be empty. A nil Encoder sets the value to data unchanged.
+func (e *Encoder) Encode(msg *sarama.ProducerMessage, data []byte, subject string) (*Event, error) {
+	if e == nil {
+		msg.Value = sarama.ByteEncoder(data)
+		return nil, nil
+	}
+	event := e.template
+	event.ID = uuid.NewString()
+	event.Time = e.now().UTC()
+	event.Subject = subject
+	event.Data = data
+
+	if e.mode == ModeBinary {
+		msg.Headers = append(msg.Headers, binaryHeaders(&event)...)
+		msg.Value = sarama.ByteEncoder(data)
+		return &event, nil
+	}
+	value, err := marshalStructured(&event)
+	if err != nil {
+		return nil, err
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(headerContentType), Value: []byte(ContentTypeStructured)})
+	msg.Value = sarama.ByteEncoder(value)
+	return &event, nil
+}
+
+func binaryHeaders(event *Event) []sarama.RecordHeader {
+	var headers []sarama.RecordHeader
+	add := func(attr, value string) {
+		if value != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(headerPrefix + attr), Value: []byte(value)})
+		}
+	}
+	add("specversion", event.SpecVersion)
+	add("id", event.ID)
+	add("source", event.Source)
+	add("type", event.Type)
+	if !event.Time.IsZero() {
+		add("time", event.Time.Format(time.RFC3339Nano))
+	}
+	add("dataschema", event.DataSchema)
+	add("subject", event.Subject)
+	for _, k := range slices.Sorted(maps.Keys(event.Extensions)) {
+		add(k, event.Extensions[k])
+	}
+	// datacontenttype maps to the content-type header in binary mode.
+	if event.DataContentType != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(headerContentType), Value: []byte(event.DataContentType)})
+	}
+	return headers
+}
+
+// envelope is the JSON event format. JSON data is embedded as is, anything
+// else is base64 encoded.
+type envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+var envelopeFields = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "time": true,
+	"datacontenttype": true, "dataschema": true, "subject": true, "data": true, "data_base64": true,
+}
+
+func marshalStructured(event *Event) ([]byte, error) {
+	env := envelope{
+		SpecVersion:     event.SpecVersion,
+		ID:              event.ID,
+		Source:          event.Source,
+		Type:            event.Type,
+		DataContentType: event.DataContentType,
+		DataSchema:      event.DataSchema,
+		Subject:         event.Subject,
+	}
+	if !event.Time.IsZero() {
+		env.Time = event.Time.Format(time.RFC3339Nano)
+	}
+	if isJSON(event.DataContentType) {
+		if !json.Valid(event.Data) {
+			return nil, fmt.Errorf("%w: data is not valid json", ErrInvalidEvent)
+		}
+		env.Data = event.Data
+	} else if event.Data != nil {
+		env.DataBase64 = base64.StdEncoding.EncodeToString(event.Data)
+	}
+	if len(event.Extensions) == 0 {
+		return json.Marshal(env)
+	}
+
+	// Extensions are top level attributes next to the known ones.
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range event.Extensions {
+		if envelopeFields[k] {
+			continue
+		}
+		fields[k], _ = json.Marshal(v)
+	}
+	return json.Marshal(fields)
+}
+
+// isJSON reports whether contentType is JSON. Data without a content type is
+// JSON as well.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Decode reads the event of a message in either mode. It returns
+// ErrNotCloudEvent for plain messages, whose value should be used as is.
+func Decode(headers []*sarama.RecordHeader, value []byte) (*Event, error) {
+	var contentType string
+	binary := false
+	for _, h := range headers {
+		key := strings.ToLower(string(h.Key))
+		switch {
+		case key == headerContentType:
+			contentType = string(h.Value)
+		case key == headerPrefix+"specversion":
+			binary = true
+		}
+	}
+	if binary {
+		return decodeBinary(headers, value, contentType)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == ContentTypeStructured {
+		return decodeStructured(value)
+	}
+	return nil, ErrNotCloudEvent
+}
+
+func decodeBinary(headers []*sarama.RecordHeader, value []byte, contentType string) (*Event, error) {
+	event := &Event{DataContentType: contentType, Data: value}
+	for _, h := range headers {
+		key := strings.ToLower(string(h.Key))
+		if !strings.HasPrefix(key, headerPrefix) {
+			continue
+		}
+		if err := event.set(strings.TrimPrefix(key, headerPrefix), string(h.Value)); err != nil {
+			return nil, err
+		}
+	}
+	return event, event.validate()
+}
+
+func decodeStructured(value []byte) (*Event, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+	}
+	event := &Event{}
+	for k, raw := range fields {
+		switch k {
+		case "data":
+			if !bytes.Equal(raw, []byte("null")) {
+				event.Data = raw
+			}
+			continue
+		case "data_base64":
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, fmt.Errorf("%w: data_base64: %w", ErrInvalidEvent, err)
+			}
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("%w: data_base64: %w", ErrInvalidEvent, err)
+			}
+			event.Data = data
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			// Extensions may be numbers or booleans as well.
+			s = string(raw)
+		}
+		if err := event.set(k, s); err != nil {
+			return nil, err
+		}
+	}
+	return event, event.validate()
+}
+
+func (e *Event) set(attr, value string) error {
+	switch attr {
+	case "specversion":
+		e.SpecVersion = value
+	case "id":
+		e.ID = value
+	case "source":
+		e.Source = value
+	case "type":
+		e.Type = value
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("%w: time: %w", ErrInvalidEvent, err)
+		}
+		e.Time = t
+	case "datacontenttype":
+		e.DataContentType = value
+	case "dataschema":
+		e.DataSchema = value
+	case "subject":
+		e.Subject = value
+	default:
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[attr] = value
+	}
+	return nil
+}
+
+func (e *Event) validate() error {
+	if e.SpecVersion != SpecVersion {
+		return fmt.Errorf("%w: unsupported specversion %q", ErrInvalidEvent, e.SpecVersion)
+	}
+	if e.ID == "" || e.Source == "" || e.Type == "" {
+		return fmt.Errorf("%w: id, source and type are required", ErrInvalidEvent)
+	}
+	return nil
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying event.
+func NewContext(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, ctxKey{}, event)
+}
+
+// FromContext returns the event of the message being handled, or nil if it
+// was not a CloudEvent.
+func FromContext(ctx context.Context) *Event {
+	event, _ := ctx.Value(ctxKey{}).(*Event)
+	return event
+}
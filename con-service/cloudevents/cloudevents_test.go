@@ -0,0 +1,136 @@
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+var testTime = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func headers(kv ...string) []*sarama.RecordHeader {
+	var hs []*sarama.RecordHeader
+	for i := 0; i < len(kv); i += 2 {
+		hs = append(hs, &sarama.RecordHeader{Key: []byte(kv[i]), Value: []byte(kv[i+1])})
+	}
+	return hs
+}
+
+func TestDecodeBinary(t *testing.T) {
+	data := []byte{0, 0, 0, 0, 1, 12}
+	event, err := Decode(headers(
+		"ce_specversion", "1.0",
+		"ce_id", "0190a1b2-7c3d-7e4f-8a9b-0c1d2e3f4a5b",
+		"ce_source", "/pub-service",
+		"ce_type", "dev.go-sandbox.dice.rolled",
+		"ce_time", "2024-05-01T12:30:00Z",
+		"ce_subject", "table-7",
+		"ce_dataschema", "http://schema-registry:8081/schemas/ids/1",
+		"CE_Tenant", "acme",
+		"content-type", "application/avro",
+		"traceparent", "00-abc-def-01",
+	), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != "0190a1b2-7c3d-7e4f-8a9b-0c1d2e3f4a5b" || event.Source != "/pub-service" || event.Type != "dev.go-sandbox.dice.rolled" {
+		t.Errorf("got attributes %+v", event)
+	}
+	if !event.Time.Equal(testTime) || event.Subject != "table-7" || event.DataContentType != "application/avro" {
+		t.Errorf("got time %v, subject %q and content type %q", event.Time, event.Subject, event.DataContentType)
+	}
+	if event.DataSchema != "http://schema-registry:8081/schemas/ids/1" {
+		t.Errorf("got data schema %q", event.DataSchema)
+	}
+	if len(event.Extensions) != 1 || event.Extensions["tenant"] != "acme" {
+		t.Errorf("got extensions %v, want tenant only", event.Extensions)
+	}
+	if !bytes.Equal(event.Data, data) {
+		t.Errorf("got data %v, want the message value", event.Data)
+	}
+}
+
+func TestDecodeStructured(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		data  []byte
+	}{
+		{
+			name:  "json data",
+			value: `{"specversion":"1.0","id":"1","source":"/pub-service","type":"dev.go-sandbox.dice.rolled","datacontenttype":"application/json","data":{"rolls":3,"sides":6}}`,
+			data:  []byte(`{"rolls":3,"sides":6}`),
+		},
+		{
+			name:  "base64 data",
+			value: `{"specversion":"1.0","id":"1","source":"/pub-service","type":"dev.go-sandbox.dice.rolled","datacontenttype":"application/avro","data_base64":"AAAAAAEM"}`,
+			data:  []byte{0, 0, 0, 0, 1, 12},
+		},
+		{
+			name:  "without data",
+			value: `{"specversion":"1.0","id":"1","source":"/pub-service","type":"dev.go-sandbox.dice.rolled","data":null,"seat":4}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := Decode(headers("content-type", ContentTypeStructured+"; charset=utf-8"), []byte(tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.ID != "1" || event.Source != "/pub-service" || event.Type != "dev.go-sandbox.dice.rolled" {
+				t.Errorf("got attributes %+v", event)
+			}
+			if !bytes.Equal(event.Data, tt.data) {
+				t.Errorf("got data %q, want %q", event.Data, tt.data)
+			}
+		})
+	}
+
+	event, err := Decode(headers("content-type", ContentTypeStructured), []byte(tests[2].value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Extensions["seat"] != "4" {
+		t.Errorf("got extensions %v, want the numeric seat extension", event.Extensions)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*sarama.RecordHeader
+		value   string
+		err     error
+	}{
+		{name: "plain json", headers: headers("content-type", "application/json"), value: `{"sides":6}`, err: ErrNotCloudEvent},
+		{name: "no headers", value: `{"sides":6}`, err: ErrNotCloudEvent},
+		{name: "binary without id", headers: headers("ce_specversion", "1.0", "ce_source", "/pub-service", "ce_type", "rolled"), err: ErrInvalidEvent},
+		{name: "binary with bad time", headers: headers("ce_specversion", "1.0", "ce_id", "1", "ce_source", "/pub-service", "ce_type", "rolled", "ce_time", "yesterday"), err: ErrInvalidEvent},
+		{name: "unsupported version", headers: headers("ce_specversion", "0.3", "ce_id", "1", "ce_source", "/pub-service", "ce_type", "rolled"), err: ErrInvalidEvent},
+		{name: "structured not json", headers: headers("content-type", ContentTypeStructured), value: `{"specversion":`, err: ErrInvalidEvent},
+		{name: "structured bad base64", headers: headers("content-type", ContentTypeStructured),
+			value: `{"specversion":"1.0","id":"1","source":"/pub-service","type":"rolled","data_base64":"%%"}`, err: ErrInvalidEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.headers, []byte(tt.value)); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if event := FromContext(ctx); event != nil {
+		t.Errorf("got %+v from a context without an event", event)
+	}
+	event := &Event{ID: "1"}
+	if got := FromContext(NewContext(ctx, event)); got != event {
+		t.Errorf("got %+v, want the event of the context", got)
+	}
+}
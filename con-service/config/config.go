@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type AppConfig struct {
 	ServiceName string           `env:"SERVICE_NAME"`
 	Host        string           `env:"HOST"`
@@ -7,6 +9,13 @@ type AppConfig struct {
 	LogLevel    string           `env:"LOG_LEVEL"`
 	Kafka       *KafkaConfig     `env:", prefix=KAFKA_"`
 	Telemetry   *TelemetryConfig `env:", prefix=OTEL_"`
+	Fair        *FairConfig      `env:", prefix=FAIR_"`
+	Schema      *SchemaConfig    `env:", prefix=SCHEMA_"`
+	Health      *HealthConfig    `env:", prefix=HEALTH_"`
+	Stats       *StatsConfig     `env:", prefix=STATS_"`
+	State       *StateConfig     `env:", prefix=STATE_"`
+	Dedup       *DedupConfig     `env:", prefix=DEDUP_"`
+	Admin       *AdminConfig     `env:", prefix=ADMIN_"`
 }
 
 type TelemetryConfig struct {
@@ -16,9 +25,158 @@ type TelemetryConfig struct {
 	Insecure         bool   `env:"EXPORTER_OTLP_INSECURE"`
 }
 
+// KafkaConfig configures the consumer. Assignor is a comma separated list of
+// the balance strategies to offer the group, in order of preference, out of
+// range, roundrobin and sticky. Version is the Kafka version the brokers
+// support at least, such as 3.6.0, and defaults to Sarama's. CommitMode is
+// one of auto, manual or batch: auto commits the marked offsets every
+// CommitInterval, manual after every message and batch after CommitBatchSize
+// messages or CommitInterval, whichever comes first.
 type KafkaConfig struct {
-	Brokers       []string `env:"BROKERS, delimiter=;"`
-	Topic         string   `env:"TOPIC"`
-	Assignor      string   `env:"ASSIGNOR"`
-	ConsumerGroup string   `env:"CONSUMER_GROUP"`
+	Brokers         []string        `env:"BROKERS, delimiter=;"`
+	Topic           string          `env:"TOPIC"`
+	Assignor        string          `env:"ASSIGNOR, default=range"`
+	ConsumerGroup   string          `env:"CONSUMER_GROUP"`
+	Version         string          `env:"VERSION"`
+	Consumer        *ConsumerConfig `env:", prefix=CONSUMER_"`
+	CommitMode      string          `env:"COMMIT_MODE, default=auto"`
+	CommitInterval  time.Duration   `env:"COMMIT_INTERVAL, default=1s"`
+	CommitBatchSize int             `env:"COMMIT_BATCH_SIZE, default=100"`
+	Retry           *RetryConfig    `env:", prefix=RETRY_"`
+	Provision       *TopicConfig    `env:", prefix=TOPIC_"`
+	TLS             *TLSConfig      `env:", prefix=TLS_"`
+	SASL            *SASLConfig     `env:", prefix=SASL_"`
+}
+
+// ConsumerConfig tunes the consumer group membership and fetching.
+// InstanceID enables static membership, which needs Kafka 2.3 or later.
+// OffsetsInitial is where a group without committed offsets starts, oldest or
+// newest. IsolationLevel is read_uncommitted or read_committed. FetchMax of 0
+// leaves the size of fetches unlimited. Concurrency is the number of messages
+// of a partition processed at the same time; messages with the same key are
+// always processed in order. DrainTimeout is how long the messages in flight
+// when partitions are revoked are given to complete; it should be well below
+// the RebalanceTimeout. LagInterval is how often the committed offsets are
+// queried for the lag metrics, 0 to not query them.
+type ConsumerConfig struct {
+	InstanceID        string        `env:"INSTANCE_ID"`
+	OffsetsInitial    string        `env:"OFFSETS_INITIAL, default=newest"`
+	SessionTimeout    time.Duration `env:"SESSION_TIMEOUT, default=10s"`
+	HeartbeatInterval time.Duration `env:"HEARTBEAT_INTERVAL, default=3s"`
+	RebalanceTimeout  time.Duration `env:"REBALANCE_TIMEOUT, default=60s"`
+	MaxProcessingTime time.Duration `env:"MAX_PROCESSING_TIME, default=100ms"`
+	MaxWaitTime       time.Duration `env:"MAX_WAIT_TIME, default=500ms"`
+	FetchMin          int32         `env:"FETCH_MIN, default=1"`
+	FetchDefault      int32         `env:"FETCH_DEFAULT, default=1048576"`
+	FetchMax          int32         `env:"FETCH_MAX"`
+	IsolationLevel    string        `env:"ISOLATION_LEVEL, default=read_uncommitted"`
+	Concurrency       int           `env:"CONCURRENCY, default=1"`
+	DrainTimeout      time.Duration `env:"DRAIN_TIMEOUT, default=10s"`
+	LagInterval       time.Duration `env:"LAG_INTERVAL, default=30s"`
+}
+
+// RetryConfig sets how failed messages are retried. They are retried in place
+// every Backoff unless Enabled is set, in which case they are republished to
+// the retry topic <topic>.retry.<delay> of each of the Tiers in turn, and
+// consumed again after the tier's delay. Messages that fail the last tier, or
+// fail permanently, go to the dead-letter topic <topic>.dlq.
+type RetryConfig struct {
+	Backoff time.Duration   `env:"BACKOFF, default=1s"`
+	Enabled bool            `env:"ENABLED"`
+	Tiers   []time.Duration `env:"TIERS, default=5s,1m"`
+}
+
+// TopicConfig is the spec topics are created with and validated against at
+// startup. Policy is one of off, warn, fail or reconcile.
+type TopicConfig struct {
+	Policy            string            `env:"POLICY, default=warn"`
+	Partitions        int32             `env:"PARTITIONS, default=3"`
+	ReplicationFactor int16             `env:"REPLICATION_FACTOR, default=1"`
+	Retention         time.Duration     `env:"RETENTION"`
+	CleanupPolicy     string            `env:"CLEANUP_POLICY"`
+	MinInsyncReplicas int               `env:"MIN_INSYNC_REPLICAS"`
+	Configs           map[string]string `env:"CONFIGS"`
+}
+
+// TLSConfig encrypts connections to the brokers. CertFile and KeyFile are
+// only needed for mutual TLS. Certificate files are reloaded when they change.
+type TLSConfig struct {
+	Enabled            bool   `env:"ENABLED"`
+	CAFile             string `env:"CA_FILE"`
+	CertFile           string `env:"CERT_FILE"`
+	KeyFile            string `env:"KEY_FILE"`
+	ServerName         string `env:"SERVER_NAME"`
+	InsecureSkipVerify bool   `env:"INSECURE_SKIP_VERIFY"`
+}
+
+// SASLConfig authenticates to the brokers. Mechanism is one of PLAIN,
+// SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER, or empty to disable SASL.
+// OAUTHBEARER tokens come from TokenProvider: client_credentials fetches them
+// from TokenURL and file reads them from TokenFile.
+type SASLConfig struct {
+	Mechanism     string   `env:"MECHANISM"`
+	Username      string   `env:"USERNAME"`
+	Password      string   `env:"PASSWORD" json:"-"`
+	TokenProvider string   `env:"TOKEN_PROVIDER, default=client_credentials"`
+	TokenURL      string   `env:"TOKEN_URL"`
+	ClientID      string   `env:"CLIENT_ID"`
+	ClientSecret  string   `env:"CLIENT_SECRET" json:"-"`
+	Scopes        []string `env:"SCOPES"`
+	TokenFile     string   `env:"TOKEN_FILE"`
+}
+
+type FairConfig struct {
+	SeedURL       string        `env:"SEED_URL"`
+	RetryInterval time.Duration `env:"RETRY_INTERVAL, default=1m"`
+}
+
+// SchemaConfig points at the Schema Registry used to decode avro and protobuf
+// rolls. JSON rolls are decoded without it.
+type SchemaConfig struct {
+	RegistryURL string `env:"REGISTRY_URL"`
+}
+
+// HealthConfig tunes the readiness check. A consumer with lag that has not
+// read a message for MaxIdle is reported as not ready.
+type HealthConfig struct {
+	MaxIdle time.Duration `env:"MAX_IDLE, default=5m"`
+}
+
+// StatsConfig sets the time windows dice statistics are kept for. Tumbling is
+// a list of window sizes and Hopping a list of size/hop pairs such as 5m/1m.
+// A window closes once rolls made AllowedLateness after its end have been
+// seen; later rolls are not counted in it. History is the number of closed
+// windows of each kind kept for the API. Closed windows are published to
+// Topic, if set.
+type StatsConfig struct {
+	Tumbling        []time.Duration `env:"TUMBLING, default=1m,5m,1h"`
+	Hopping         []string        `env:"HOPPING, default=5m/1m,1h/5m"`
+	AllowedLateness time.Duration   `env:"ALLOWED_LATENESS, default=30s"`
+	History         int             `env:"HISTORY, default=60"`
+	Topic           string          `env:"TOPIC"`
+}
+
+// StateConfig enables the state stores, whose writes are mirrored to
+// compacted changelog topics and restored from them when partitions are
+// assigned. Backend is memory or disk; disk stores are kept under Dir and
+// only restore what was written to the changelog since they were last open.
+type StateConfig struct {
+	Enabled bool   `env:"ENABLED"`
+	Backend string `env:"BACKEND, default=memory"`
+	Dir     string `env:"DIR, default=state"`
+}
+
+// DedupConfig sets how long the IDs of handled messages are remembered to
+// skip redeliveries: for TTL, and at most MaxIDs per partition. They survive
+// restarts when the state stores are enabled.
+type DedupConfig struct {
+	Enabled bool          `env:"ENABLED, default=true"`
+	TTL     time.Duration `env:"TTL, default=24h"`
+	MaxIDs  int           `env:"MAX_IDS, default=100000"`
+}
+
+// AdminConfig protects the admin endpoints, which are only served when Token
+// is set and require it as a bearer token.
+type AdminConfig struct {
+	Token string `env:"TOKEN" json:"-"`
 }
@@ -1,20 +1,81 @@
 package config
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
 )
 
 func TestInitialize(t *testing.T) {
-	config := Initialize()
-	if config == nil {
-		t.Error("Expected config to be initialized, but it was nil")
+	var config AppConfig
+	err := envconfig.ProcessWith(context.Background(), &envconfig.Config{
+		Target: &config,
+		Lookuper: envconfig.MapLookuper(map[string]string{
+			"SERVICE_NAME":        "con-service",
+			"PORT":                ":8081",
+			"KAFKA_BROKERS":       "broker-1:9092;broker-2:9092",
+			"SCHEMA_REGISTRY_URL": "http://schema-registry:8081",
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if config.Service.Name == "" {
+	if config.ServiceName == "" {
 		t.Error("Expected Name to be set, but it was empty")
 	}
 
-	if config.Service.Port == "" {
+	if config.Port == "" {
 		t.Error("Expected Port to be set, but it was empty")
 	}
+
+	if len(config.Kafka.Brokers) != 2 {
+		t.Errorf("Expected 2 brokers, got %v", config.Kafka.Brokers)
+	}
+
+	if config.Fair.RetryInterval != time.Minute {
+		t.Errorf("Expected default retry interval of 1m, got %v", config.Fair.RetryInterval)
+	}
+
+	if p := config.Kafka.Provision; p.Policy != "warn" || p.Partitions != 3 || p.ReplicationFactor != 1 {
+		t.Errorf("Expected topics to be provisioned with the warn policy by default, got %+v", p)
+	}
+
+	if config.Kafka.TLS.Enabled || config.Kafka.SASL.Mechanism != "" {
+		t.Errorf("Expected TLS and SASL to be disabled by default, got %+v and %+v", config.Kafka.TLS, config.Kafka.SASL)
+	}
+
+	if config.Schema.RegistryURL != "http://schema-registry:8081" {
+		t.Errorf("Expected schema registry url to be set, got %q", config.Schema.RegistryURL)
+	}
+
+	if k := config.Kafka; k.CommitMode != "auto" || k.CommitInterval != time.Second || k.CommitBatchSize != 100 {
+		t.Errorf("Expected offsets to be auto committed every second by default, got %+v", k)
+	}
+
+	if c := config.Kafka.Consumer; config.Kafka.Assignor != "range" || c.OffsetsInitial != "newest" || c.IsolationLevel != "read_uncommitted" || c.SessionTimeout != 10*time.Second || c.Concurrency != 1 || c.DrainTimeout != 10*time.Second || c.LagInterval != 30*time.Second {
+		t.Errorf("Expected sarama's consumer group defaults, got %q and %+v", config.Kafka.Assignor, c)
+	}
+
+	if r := config.Kafka.Retry; r.Enabled || r.Backoff != time.Second || len(r.Tiers) != 2 || r.Tiers[1] != time.Minute {
+		t.Errorf("Expected retry topics to be disabled with tiers of 5s and 1m by default, got %+v", r)
+	}
+
+	if config.Health.MaxIdle != 5*time.Minute {
+		t.Errorf("Expected default max idle of 5m, got %v", config.Health.MaxIdle)
+	}
+
+	if s := config.Stats; len(s.Tumbling) != 3 || s.Tumbling[2] != time.Hour || len(s.Hopping) != 2 || s.Hopping[0] != "5m/1m" || s.AllowedLateness != 30*time.Second || s.Topic != "" {
+		t.Errorf("Expected 1m, 5m and 1h windows without publishing by default, got %+v", s)
+	}
+
+	if s := config.State; s.Enabled || s.Backend != "memory" {
+		t.Errorf("Expected state stores to be disabled and kept in memory by default, got %+v", s)
+	}
+
+	if d := config.Dedup; !d.Enabled || d.TTL != 24*time.Hour || d.MaxIDs != 100000 {
+		t.Errorf("Expected deduplication for 24h by default, got %+v", d)
+	}
 }
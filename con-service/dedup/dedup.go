@@ -0,0 +1,90 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// HeaderMessageID is the record header pub-service stamps every message with
+// a unique ID in.
+const HeaderMessageID = "message-id"
+
+const name = "dedup"
+
+// Metrics counts the messages skipped as duplicates, by topic.
+type Metrics struct {
+	Duplicates metric.Int64Counter
+}
+
+func (m *Metrics) InitMetrics() {
+	var err error
+	m.Duplicates, err = otel.Meter(name).Int64Counter("kafka.consumer.duplicates",
+		metric.WithDescription("The number of redelivered messages skipped because their message ID was already handled"),
+		metric.WithUnit("{message}"))
+	if err != nil {
+		logger.Get().Error("failed to create counter", zap.Error(err))
+	}
+}
+
+// Handler skips messages whose message ID was already handled successfully,
+// such as messages redelivered after a rebalance or sent twice by a
+// producer retry, and passes every other message to Next. Messages without
+// a message ID are always passed on. Handler is a kafka.PartitionListener
+// that tells Seen and Next, if it is one, of partition assignments.
+type Handler struct {
+	Next    kafka.Handler
+	Seen    *Seen
+	Metrics Metrics
+}
+
+func (h *Handler) Handle(ctx context.Context, msg *kafka.Message) error {
+	id := msg.Header(HeaderMessageID)
+	if id == "" {
+		return h.Next.Handle(ctx, msg)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(semconv.MessagingMessageID(id))
+	if h.Seen.Contains(msg.Topic, msg.Partition, id) {
+		h.Metrics.Duplicates.Add(ctx, 1, metric.WithAttributes(semconv.MessagingDestinationName(msg.Topic)))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("messaging.duplicate", true))
+		logger.FromCtx(ctx).Info("skipped duplicate message", zap.String("message_id", id),
+			zap.String("topic", msg.Topic), zap.Int32("partition", msg.Partition), zap.Int64("offset", msg.Offset))
+		return nil
+	}
+	if err := h.Next.Handle(ctx, msg); err != nil {
+		return err
+	}
+	// The message was handled, so failing to remember it only risks handling
+	// a redelivery of it again.
+	if err := h.Seen.Add(ctx, msg.Topic, msg.Partition, id); err != nil {
+		logger.FromCtx(ctx).Warn("failed to record message id", zap.String("message_id", id), zap.Error(err))
+	}
+	return nil
+}
+
+func (h *Handler) OnAssigned(ctx context.Context, topic string, partitions []int32) error {
+	if err := h.Seen.OnAssigned(ctx, topic, partitions); err != nil {
+		return err
+	}
+	if listener, ok := h.Next.(kafka.PartitionListener); ok {
+		return listener.OnAssigned(ctx, topic, partitions)
+	}
+	return nil
+}
+
+func (h *Handler) OnRevoked(ctx context.Context, topic string, partitions []int32) error {
+	var errs []error
+	if listener, ok := h.Next.(kafka.PartitionListener); ok {
+		errs = append(errs, listener.OnRevoked(ctx, topic, partitions))
+	}
+	return errors.Join(append(errs, h.Seen.OnRevoked(ctx, topic, partitions))...)
+}
@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/kafka"
+
+	"github.com/IBM/sarama"
+)
+
+func message(offset int64, id string) *kafka.Message {
+	msg := &sarama.ConsumerMessage{Topic: "dice-rolls", Offset: offset}
+	if id != "" {
+		msg.Headers = []*sarama.RecordHeader{{Key: []byte(HeaderMessageID), Value: []byte(id)}}
+	}
+	return &kafka.Message{ConsumerMessage: msg}
+}
+
+type countingHandler struct {
+	handled []int64
+	err     error
+}
+
+func (h *countingHandler) Handle(_ context.Context, msg *kafka.Message) error {
+	h.handled = append(h.handled, msg.Offset)
+	return h.err
+}
+
+func TestHandlerSkipsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	next := &countingHandler{}
+	h := &Handler{Next: next, Seen: NewSeen(nil, time.Hour, 10)}
+	h.Metrics.InitMetrics()
+
+	for offset, id := range []string{"a", "b", "a", "", "", "b"} {
+		if err := h.Handle(ctx, message(int64(offset), id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []int64{0, 1, 3, 4}; !slices.Equal(next.handled, want) {
+		t.Errorf("handled offsets %v, want %v", next.handled, want)
+	}
+}
+
+func TestHandlerFailedMessageIsNotSeen(t *testing.T) {
+	ctx := context.Background()
+	next := &countingHandler{err: errors.New("database down")}
+	h := &Handler{Next: next, Seen: NewSeen(nil, time.Hour, 10)}
+	h.Metrics.InitMetrics()
+
+	if err := h.Handle(ctx, message(0, "a")); err == nil {
+		t.Fatal("got no error from a failed handler")
+	}
+	next.err = nil
+	if err := h.Handle(ctx, message(0, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.handled) != 2 {
+		t.Errorf("handled %d times, want a failed message to be retried", len(next.handled))
+	}
+}
+
+func TestSeenBounds(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewSeen(nil, time.Minute, 2)
+	s.now = func() time.Time { return now }
+
+	s.Add(ctx, "dice-rolls", 0, "a")
+	s.Add(ctx, "dice-rolls", 0, "b")
+	s.Add(ctx, "dice-rolls", 1, "c")
+	if !s.Contains("dice-rolls", 0, "a") || s.Contains("dice-rolls", 1, "a") {
+		t.Error("got IDs that are not kept per partition")
+	}
+	s.Add(ctx, "dice-rolls", 0, "d")
+	if s.Contains("dice-rolls", 0, "a") || !s.Contains("dice-rolls", 0, "b") {
+		t.Error("got the oldest ID kept beyond the bound")
+	}
+
+	now = now.Add(time.Minute)
+	if s.Contains("dice-rolls", 0, "b") || s.Contains("dice-rolls", 1, "c") {
+		t.Error("got expired IDs")
+	}
+	s.Add(ctx, "dice-rolls", 0, "e")
+	if p := s.partitions[partitionKey{"dice-rolls", 0}]; p.order.Len() != 1 {
+		t.Errorf("got %d IDs kept after they expired, want 1", p.order.Len())
+	}
+}
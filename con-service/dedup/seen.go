@@ -0,0 +1,185 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rlindsey28/con-service/state"
+)
+
+// Seen is the set of message IDs handled per partition. An ID is forgotten
+// once it is older than the TTL or when a partition has more than max IDs,
+// oldest first. With a table the IDs are written to it, so that they survive
+// restarts and move with their partition on a rebalance; otherwise they are
+// kept in memory only.
+type Seen struct {
+	table *state.Table
+	ttl   time.Duration
+	max   int
+	now   func() time.Time
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*seenPartition
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// seenPartition holds the IDs of a partition in the order they were seen.
+type seenPartition struct {
+	ids   map[string]*list.Element
+	order *list.List
+}
+
+type entry struct {
+	id string
+	at time.Time
+}
+
+func newSeenPartition() *seenPartition {
+	return &seenPartition{ids: make(map[string]*list.Element), order: list.New()}
+}
+
+// NewSeen creates a Seen keeping IDs for ttl and at most max per partition.
+// table is optional.
+func NewSeen(table *state.Table, ttl time.Duration, max int) *Seen {
+	return &Seen{
+		table:      table,
+		ttl:        ttl,
+		max:        max,
+		now:        time.Now,
+		partitions: make(map[partitionKey]*seenPartition),
+	}
+}
+
+// Contains reports whether id was seen on the partition of topic within the
+// TTL.
+func (s *Seen) Contains(topic string, partition int32, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.partitions[partitionKey{topic, partition}]
+	if !ok {
+		return false
+	}
+	e, ok := p.ids[id]
+	return ok && s.now().Sub(e.Value.(entry).at) < s.ttl
+}
+
+// Add records id as seen on the partition of topic, and forgets the IDs that
+// expired or exceed the bound.
+func (s *Seen) Add(ctx context.Context, topic string, partition int32, id string) error {
+	now := s.now()
+	key := partitionKey{topic, partition}
+	if s.table != nil {
+		var value [8]byte
+		binary.BigEndian.PutUint64(value[:], uint64(now.UnixNano()))
+		err := s.table.Put(ctx, topic, partition, id, value[:])
+		// IDs of partitions the table does not hold, such as those of retry
+		// topics, are only kept in memory.
+		if err != nil && !errors.Is(err, state.ErrNotAssigned) {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	p, ok := s.partitions[key]
+	if !ok {
+		p = newSeenPartition()
+		s.partitions[key] = p
+	}
+	if e, ok := p.ids[id]; ok {
+		p.order.Remove(e)
+	}
+	p.ids[id] = p.order.PushBack(entry{id: id, at: now})
+	expired := s.evict(p, now)
+	s.mu.Unlock()
+
+	if s.table == nil {
+		return nil
+	}
+	var errs []error
+	for _, id := range expired {
+		if err := s.table.Delete(ctx, topic, partition, id); err != nil && !errors.Is(err, state.ErrNotAssigned) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// evict removes the oldest IDs of p while they are expired or p holds more
+// than the bound, and returns them. s.mu must be held.
+func (s *Seen) evict(p *seenPartition, now time.Time) []string {
+	var evicted []string
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		oldest := e.Value.(entry)
+		if p.order.Len() <= s.max && now.Sub(oldest.at) < s.ttl {
+			break
+		}
+		p.order.Remove(e)
+		delete(p.ids, oldest.id)
+		evicted = append(evicted, oldest.id)
+	}
+	return evicted
+}
+
+// OnAssigned restores the IDs of the partitions of topic from the table.
+func (s *Seen) OnAssigned(ctx context.Context, topic string, partitions []int32) error {
+	if s.table == nil {
+		return nil
+	}
+	if err := s.table.OnAssigned(ctx, topic, partitions); err != nil {
+		return err
+	}
+	now := s.now()
+	for _, partition := range partitions {
+		var entries []entry
+		err := s.table.Range(topic, partition, func(id string, value []byte) bool {
+			if len(value) == 8 {
+				entries = append(entries, entry{id: id, at: time.Unix(0, int64(binary.BigEndian.Uint64(value)))})
+			}
+			return true
+		})
+		if errors.Is(err, state.ErrNotAssigned) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+		p := newSeenPartition()
+		for _, e := range entries {
+			p.ids[e.id] = p.order.PushBack(e)
+		}
+		s.mu.Lock()
+		expired := s.evict(p, now)
+		s.partitions[partitionKey{topic, partition}] = p
+		s.mu.Unlock()
+		for _, id := range expired {
+			if err := s.table.Delete(ctx, topic, partition, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OnRevoked forgets the IDs of the partitions of topic, which are now kept by
+// the consumer they were assigned to.
+func (s *Seen) OnRevoked(ctx context.Context, topic string, partitions []int32) error {
+	if s.table == nil {
+		return nil
+	}
+	s.mu.Lock()
+	for _, partition := range partitions {
+		delete(s.partitions, partitionKey{topic, partition})
+	}
+	s.mu.Unlock()
+	return s.table.OnRevoked(ctx, topic, partitions)
+}
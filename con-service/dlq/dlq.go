@@ -0,0 +1,83 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+)
+
+const name = "dlq"
+
+var (
+	tracer = otel.Tracer(name)
+)
+
+// Redriver republishes the messages of a dead-letter topic, implemented by
+// kafka.Consumer.
+type Redriver interface {
+	Redrive(ctx context.Context, topic string, limit int) (int, error)
+}
+
+// Handler serves the dead-letter queue admin endpoints.
+type Handler struct {
+	Redriver Redriver
+}
+
+type RedriveResponse struct {
+	Topic    string `json:"topic"`
+	DLQ      string `json:"dlq"`
+	Redriven int    `json:"redriven"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Redrive republishes the messages of the dead-letter topic of the topic in
+// the path to it. The optional limit query parameter caps how many are.
+func (h *Handler) Redrive(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "redrive")
+	defer span.End()
+	log := logger.Get()
+
+	topic := mux.Vars(r)["topic"]
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	n, err := h.Redriver.Redrive(ctx, topic, limit)
+	resp := RedriveResponse{Topic: topic, DLQ: kafka.DLQTopic(topic), Redriven: n}
+	code := http.StatusOK
+	switch {
+	case err == nil:
+		log.Info("redrove dead-letter messages", zap.String("topic", topic), zap.Int("redriven", n))
+	case errors.Is(err, kafka.ErrUnknownTopic):
+		code = http.StatusNotFound
+	case errors.Is(err, kafka.ErrRetryDisabled):
+		code = http.StatusConflict
+	default:
+		log.Error("failed to redrive dead-letter messages", zap.String("topic", topic), zap.Int("redriven", n), zap.Error(err))
+		code = http.StatusInternalServerError
+	}
+	if err != nil {
+		span.RecordError(err)
+		resp.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error("failed to encode response", zap.Error(err))
+	}
+}
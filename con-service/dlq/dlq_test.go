@@ -0,0 +1,73 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rlindsey28/con-service/kafka"
+
+	"github.com/gorilla/mux"
+)
+
+type fakeRedriver struct {
+	topic string
+	limit int
+	err   error
+}
+
+func (r *fakeRedriver) Redrive(_ context.Context, topic string, limit int) (int, error) {
+	r.topic, r.limit = topic, limit
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 3, nil
+}
+
+func TestRedrive(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		err   error
+		code  int
+		limit int
+	}{
+		{name: "all", url: "/dlq/dice-rolls/redrive", code: http.StatusOK},
+		{name: "limit", url: "/dlq/dice-rolls/redrive?limit=10", code: http.StatusOK, limit: 10},
+		{name: "invalid limit", url: "/dlq/dice-rolls/redrive?limit=-1", code: http.StatusBadRequest},
+		{name: "unknown topic", url: "/dlq/dice-rolls/redrive", err: fmt.Errorf("%w dice-rolls", kafka.ErrUnknownTopic), code: http.StatusNotFound},
+		{name: "disabled", url: "/dlq/dice-rolls/redrive", err: kafka.ErrRetryDisabled, code: http.StatusConflict},
+		{name: "failed", url: "/dlq/dice-rolls/redrive", err: errors.New("broker down"), code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redriver := &fakeRedriver{err: tt.err}
+			h := Handler{Redriver: redriver}
+			router := mux.NewRouter()
+			router.HandleFunc("/dlq/{topic}/redrive", h.Redrive).Methods("POST")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.url, nil))
+			if rr.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.code)
+			}
+			if tt.code == http.StatusBadRequest {
+				return
+			}
+			if redriver.topic != "dice-rolls" || redriver.limit != tt.limit {
+				t.Errorf("redrove %s with limit %d", redriver.topic, redriver.limit)
+			}
+			var resp RedriveResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.DLQ != "dice-rolls.dlq" || (tt.err == nil && resp.Redriven != 3) {
+				t.Errorf("unexpected response %+v", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package fair
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Version identifies the derivation scheme pub-service uses for provably
+// fair rolls.
+const Version = "hmac-sha256-v1"
+
+// Draw is a single value taken from the derived stream: a face in [1, Sides].
+type Draw struct {
+	Sides int `json:"sides"`
+	Value int `json:"value"`
+}
+
+// Proof is published with every provably fair roll.
+type Proof struct {
+	Version        string `json:"version"`
+	ServerSeedHash string `json:"server_seed_hash"`
+	ClientSeed     string `json:"client_seed"`
+	Nonce          uint64 `json:"nonce"`
+	Draws          []Draw `json:"draws"`
+}
+
+// stream mirrors pub-service's fair.Stream: values are drawn from
+// HMAC-SHA256(serverSeed, "clientSeed:nonce:round") by rejection sampling.
+type stream struct {
+	secret     []byte
+	clientSeed string
+	nonce      uint64
+	round      uint64
+	buf        []byte
+}
+
+func (s *stream) uint32() uint32 {
+	if len(s.buf) < 4 {
+		mac := hmac.New(sha256.New, s.secret)
+		fmt.Fprintf(mac, "%s:%d:%d", s.clientSeed, s.nonce, s.round)
+		s.round++
+		s.buf = mac.Sum(nil)
+	}
+	v := binary.BigEndian.Uint32(s.buf)
+	s.buf = s.buf[4:]
+	return v
+}
+
+func (s *stream) intN(n int) int {
+	limit := (1 << 32) - (1<<32)%uint64(n)
+	for {
+		v := uint64(s.uint32())
+		if v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// Verify re-derives the draws in p from a revealed server seed.
+func Verify(serverSeed string, p *Proof) error {
+	if p.Version != Version {
+		return fmt.Errorf("unsupported derivation version %q", p.Version)
+	}
+	secret, err := hex.DecodeString(serverSeed)
+	if err != nil {
+		return fmt.Errorf("invalid server seed: %w", err)
+	}
+	sum := sha256.Sum256(secret)
+	if hex.EncodeToString(sum[:]) != p.ServerSeedHash {
+		return errors.New("server seed does not match committed hash")
+	}
+	s := &stream{secret: secret, clientSeed: p.ClientSeed, nonce: p.Nonce}
+	for i, d := range p.Draws {
+		if d.Sides <= 0 {
+			return fmt.Errorf("draw %d: invalid number of sides %d", i, d.Sides)
+		}
+		if v := s.intN(d.Sides) + 1; v != d.Value {
+			return fmt.Errorf("draw %d: expected %d, got %d", i, v, d.Value)
+		}
+	}
+	return nil
+}
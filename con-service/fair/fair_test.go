@@ -0,0 +1,74 @@
+package fair
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testSeed = "3031323334353637383961626364656630313233343536373839616263646566"
+	testHash = "3eb1bd439947eb762998e566ccc2e099c791118b2f40579cc4f7da2b5061b7f9"
+)
+
+// testProof was produced by pub-service's fair.Stream for testSeed.
+func testProof() *Proof {
+	return &Proof{
+		Version:        Version,
+		ServerSeedHash: testHash,
+		ClientSeed:     "player-1",
+		Nonce:          3,
+		Draws: []Draw{
+			{Sides: 6, Value: 2}, {Sides: 6, Value: 4}, {Sides: 6, Value: 5},
+			{Sides: 6, Value: 6}, {Sides: 6, Value: 1}, {Sides: 20, Value: 15},
+		},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	if err := Verify(testSeed, testProof()); err != nil {
+		t.Errorf("expected proof to verify, got %v", err)
+	}
+
+	tampered := testProof()
+	tampered.Draws[5].Value = 20
+	if err := Verify(testSeed, tampered); err == nil {
+		t.Error("expected tampered proof to fail verification")
+	}
+
+	if err := Verify("00"+testSeed[2:], testProof()); err == nil {
+		t.Error("expected wrong seed to fail verification")
+	}
+}
+
+func TestVerifierWaitsForReveal(t *testing.T) {
+	revealed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fair/seeds/"+testHash {
+			http.NotFound(w, r)
+			return
+		}
+		if !revealed {
+			http.Error(w, "active", http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"server_seed":"` + testSeed + `","server_seed_hash":"` + testHash + `"}`))
+	}))
+	defer srv.Close()
+
+	v := NewVerifier(srv.URL)
+	err := v.Verify(context.Background(), testProof())
+	if !errors.Is(err, ErrNotRevealed) {
+		t.Fatalf("expected ErrNotRevealed, got %v", err)
+	}
+	if v.waiting != 1 {
+		t.Errorf("expected 1 pending proof, got %d", v.waiting)
+	}
+
+	revealed = true
+	if err := v.Verify(context.Background(), testProof()); err != nil {
+		t.Errorf("expected proof to verify after reveal, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package fair
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"go.uber.org/zap"
+)
+
+// maxPending bounds the number of proofs waiting for their server seed to be
+// revealed.
+const maxPending = 10000
+
+var (
+	ErrNotRevealed = errors.New("server seed has not been revealed yet")
+	ErrUnknownSeed = errors.New("unknown server seed")
+)
+
+// Verifier checks provably fair rolls against the server seeds revealed by
+// pub-service. Proofs whose seed is still active are kept until it rotates.
+type Verifier struct {
+	seedURL string
+	client  *http.Client
+
+	mu      sync.Mutex
+	seeds   map[string]string
+	pending map[string][]*Proof
+	waiting int
+}
+
+// NewVerifier creates a Verifier that looks up revealed seeds from the
+// pub-service at seedURL.
+func NewVerifier(seedURL string) *Verifier {
+	return &Verifier{
+		seedURL: seedURL,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		seeds:   make(map[string]string),
+		pending: make(map[string][]*Proof),
+	}
+}
+
+// Verify checks p. If its server seed has not been revealed yet p is queued
+// and ErrNotRevealed is returned; Run verifies it once the seed rotates.
+func (v *Verifier) Verify(ctx context.Context, p *Proof) error {
+	seed, err := v.seed(ctx, p.ServerSeedHash)
+	if errors.Is(err, ErrNotRevealed) {
+		v.mu.Lock()
+		if v.waiting < maxPending {
+			v.pending[p.ServerSeedHash] = append(v.pending[p.ServerSeedHash], p)
+			v.waiting++
+		}
+		v.mu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	return Verify(seed, p)
+}
+
+// Run periodically retries pending proofs until ctx is done.
+func (v *Verifier) Run(ctx context.Context, interval time.Duration) {
+	log := logger.FromCtx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			v.mu.Lock()
+			hashes := make([]string, 0, len(v.pending))
+			for hash := range v.pending {
+				hashes = append(hashes, hash)
+			}
+			v.mu.Unlock()
+
+			for _, hash := range hashes {
+				seed, err := v.seed(ctx, hash)
+				if errors.Is(err, ErrNotRevealed) {
+					continue
+				}
+
+				v.mu.Lock()
+				proofs := v.pending[hash]
+				delete(v.pending, hash)
+				v.waiting -= len(proofs)
+				v.mu.Unlock()
+
+				if err != nil {
+					log.Error("failed to fetch server seed", zap.String("server_seed_hash", hash), zap.Int("proofs", len(proofs)), zap.Error(err))
+					continue
+				}
+				for _, p := range proofs {
+					if err := Verify(seed, p); err != nil {
+						log.Error("provably fair roll failed verification", zap.String("server_seed_hash", hash), zap.Uint64("nonce", p.Nonce), zap.Error(err))
+					} else {
+						log.Info("provably fair roll verified", zap.String("server_seed_hash", hash), zap.Uint64("nonce", p.Nonce))
+					}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (v *Verifier) seed(ctx context.Context, hash string) (string, error) {
+	v.mu.Lock()
+	seed, ok := v.seeds[hash]
+	v.mu.Unlock()
+	if ok {
+		return seed, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.seedURL+"/fair/seeds/"+url.PathEscape(hash), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch server seed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		return "", ErrNotRevealed
+	case http.StatusNotFound:
+		return "", ErrUnknownSeed
+	default:
+		return "", fmt.Errorf("unexpected status fetching server seed: %s", resp.Status)
+	}
+
+	var revealed struct {
+		Secret string `json:"server_seed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&revealed); err != nil {
+		return "", fmt.Errorf("failed to decode server seed: %w", err)
+	}
+
+	v.mu.Lock()
+	v.seeds[hash] = revealed.Secret
+	v.mu.Unlock()
+	return revealed.Secret, nil
+}
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusOK          = "OK"
+	StatusUnavailable = "UNAVAILABLE"
+)
+
+const name = "healthcheck"
+
+var (
+	tracer = otel.Tracer(name)
+)
+
+// Consumer is the part of kafka.Consumer the readiness checks read.
+type Consumer interface {
+	Status() kafka.Status
+	CheckBrokers() error
+}
+
+// Handler serves the liveness, readiness and status endpoints. The consumer
+// is ready once it is a member of its group with assigned partitions, can
+// reach the group coordinator and has not stalled: a consumer with lag that
+// has not read a message for MaxIdle is not ready. Paused partitions are
+// reported, but neither they nor their lag make the consumer unready, so
+// that it stays reachable to be resumed.
+type Handler struct {
+	Consumer Consumer
+	MaxIdle  time.Duration
+}
+
+type Response struct {
+	Status string             `json:"status"`
+	Checks map[string]string  `json:"checks,omitempty"`
+	Paused map[string][]int32 `json:"paused,omitempty"`
+}
+
+// Livez reports that the process is up.
+func (h *Handler) Livez(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "livez")
+	defer span.End()
+
+	writeJSON(w, http.StatusOK, Response{Status: StatusOK})
+}
+
+// HealthCheck reports that the process is up. It is kept for clients of the
+// pub-service style /health endpoint.
+func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), name)
+	defer span.End()
+
+	resp := Response{Status: StatusOK}
+	logger.Get().Debug("health check", zap.String("status", resp.Status))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Readyz runs the readiness checks and answers 503 if any of them fails.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "readyz")
+	defer span.End()
+
+	resp := h.ready(time.Now())
+	code := http.StatusOK
+	if resp.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+		logger.Get().Info("consumer not ready", zap.Any("checks", resp.Checks))
+	}
+	writeJSON(w, code, resp)
+}
+
+// Status reports the consumer's group membership and the offsets and lag of
+// its claims.
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "status")
+	defer span.End()
+
+	writeJSON(w, http.StatusOK, h.Consumer.Status())
+}
+
+func (h *Handler) ready(now time.Time) Response {
+	status := h.Consumer.Status()
+	checks := map[string]string{
+		"group":      "ok",
+		"partitions": "ok",
+		"brokers":    "ok",
+		"progress":   "ok",
+	}
+	if !status.Member {
+		checks["group"] = fmt.Sprintf("not a member of group %s", status.Group)
+	}
+	if len(status.Claims) == 0 {
+		checks["partitions"] = "no partitions assigned"
+	}
+	if err := h.Consumer.CheckBrokers(); err != nil {
+		checks["brokers"] = err.Error()
+	}
+	if lag := status.ActiveLag(); lag > 0 && h.MaxIdle > 0 {
+		// A consumer that has not read anything yet is measured from when it
+		// joined the group.
+		since := status.LastMessage
+		if since.Before(status.Joined) {
+			since = status.Joined
+		}
+		if idle := now.Sub(since); idle > h.MaxIdle {
+			checks["progress"] = fmt.Sprintf("no message for %s with a lag of %d", idle.Round(time.Second), lag)
+		}
+	}
+
+	resp := Response{Status: StatusOK, Checks: checks}
+	for _, c := range status.Claims {
+		if c.Paused {
+			if resp.Paused == nil {
+				resp.Paused = make(map[string][]int32)
+			}
+			resp.Paused[c.Topic] = append(resp.Paused[c.Topic], c.Partition)
+		}
+	}
+	for _, check := range checks {
+		if check != "ok" {
+			resp.Status = StatusUnavailable
+		}
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Get().Error("failed to encode response", zap.Error(err))
+	}
+}
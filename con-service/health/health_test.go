@@ -0,0 +1,133 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/kafka"
+)
+
+type fakeConsumer struct {
+	status  kafka.Status
+	brokers error
+}
+
+func (c *fakeConsumer) Status() kafka.Status { return c.status }
+func (c *fakeConsumer) CheckBrokers() error  { return c.brokers }
+
+func readyConsumer() *fakeConsumer {
+	now := time.Now()
+	return &fakeConsumer{status: kafka.Status{
+		Group:       "con-service",
+		Member:      true,
+		Joined:      now.Add(-time.Hour),
+		LastMessage: now,
+		Claims: []kafka.ClaimStatus{
+			{Topic: "dice-rolls", Partition: 0, Offset: 10, HighWaterMark: 12, Lag: 2},
+		},
+	}}
+}
+
+func serve(t *testing.T, handler http.HandlerFunc) (*httptest.ResponseRecorder, Response) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected content type application/json; got %v", contentType)
+	}
+	var resp Response
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	return rr, resp
+}
+
+func TestLivez(t *testing.T) {
+	h := Handler{Consumer: &fakeConsumer{}}
+	for _, handler := range []http.HandlerFunc{h.Livez, h.HealthCheck} {
+		rr, resp := serve(t, handler)
+		if rr.Code != http.StatusOK || resp.Status != StatusOK {
+			t.Errorf("got %d %s, want 200 OK", rr.Code, resp.Status)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *fakeConsumer)
+		failed string
+	}{
+		{name: "ready", modify: func(*fakeConsumer) {}},
+		{name: "not a member", modify: func(c *fakeConsumer) { c.status.Member = false }, failed: "group"},
+		{name: "no partitions", modify: func(c *fakeConsumer) { c.status.Claims = nil }, failed: "partitions"},
+		{name: "broker unreachable", modify: func(c *fakeConsumer) { c.brokers = errors.New("no coordinator") }, failed: "brokers"},
+		{name: "stalled", modify: func(c *fakeConsumer) { c.status.LastMessage = time.Now().Add(-time.Hour) }, failed: "progress"},
+		{name: "idle without lag", modify: func(c *fakeConsumer) {
+			c.status.LastMessage = time.Now().Add(-time.Hour)
+			c.status.Claims[0].Lag = 0
+		}},
+		{name: "stalled since joining", modify: func(c *fakeConsumer) { c.status.LastMessage = time.Time{} }, failed: "progress"},
+		{name: "paused with lag", modify: func(c *fakeConsumer) {
+			c.status.LastMessage = time.Now().Add(-time.Hour)
+			c.status.Claims[0].Paused = true
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := readyConsumer()
+			tt.modify(consumer)
+			h := Handler{Consumer: consumer, MaxIdle: time.Minute}
+
+			rr, resp := serve(t, h.Readyz)
+			if tt.failed == "" {
+				if rr.Code != http.StatusOK || resp.Status != StatusOK {
+					t.Errorf("got %d %v, want ready", rr.Code, resp)
+				}
+				return
+			}
+			if rr.Code != http.StatusServiceUnavailable || resp.Status != StatusUnavailable {
+				t.Errorf("got %d %s, want 503 %s", rr.Code, resp.Status, StatusUnavailable)
+			}
+			for check, result := range resp.Checks {
+				if (check == tt.failed) == (result == "ok") {
+					t.Errorf("check %s = %q", check, result)
+				}
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	h := Handler{Consumer: readyConsumer()}
+	rr := httptest.NewRecorder()
+	h.Status(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d", rr.Code)
+	}
+	var status kafka.Status
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(status.Claims) != 1 || status.Claims[0].Lag != 2 {
+		t.Errorf("unexpected claims %+v", status.Claims)
+	}
+}
+
+func TestReadyzPaused(t *testing.T) {
+	consumer := readyConsumer()
+	consumer.status.Claims = append(consumer.status.Claims, kafka.ClaimStatus{Topic: "dice-rolls", Partition: 1, Paused: true})
+	h := Handler{Consumer: consumer, MaxIdle: time.Minute}
+
+	rr, resp := serve(t, h.Readyz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d, want ready while partitions are paused", rr.Code)
+	}
+	if got := resp.Paused["dice-rolls"]; len(got) != 1 || got[0] != 1 {
+		t.Errorf("got paused %v, want dice-rolls partition 1", resp.Paused)
+	}
+}
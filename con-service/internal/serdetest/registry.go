@@ -0,0 +1,220 @@
+// Package serdetest provides an in-process Schema Registry for tests.
+package serdetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rlindsey28/con-service/serde"
+
+	"github.com/hamba/avro/v2"
+)
+
+// Registry is an in-process Schema Registry. It implements serde.Registry
+// directly and serves the matching REST endpoints, so it can also back a
+// serde.Client through httptest.NewServer.
+//
+// Compatibility is BACKWARD: a new Avro schema must be able to read data
+// written with the latest version. Protobuf schemas are always compatible
+// with each other.
+type Registry struct {
+	mu       sync.Mutex
+	schemas  []serde.Schema
+	subjects map[string][]int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{subjects: make(map[string][]int)}
+}
+
+func (f *Registry) Register(_ context.Context, subject string, schema serde.Schema) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.lookup(subject, schema); ok {
+		return id, nil
+	}
+	ok, err := f.compatible(subject, schema)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, serde.ErrIncompatibleSchema
+	}
+
+	id, found := 0, false
+	for i, s := range f.schemas {
+		if schemaType(s) == schemaType(schema) && s.Schema == schema.Schema {
+			id, found = i+1, true
+			break
+		}
+	}
+	if !found {
+		f.schemas = append(f.schemas, schema)
+		id = len(f.schemas)
+	}
+	f.subjects[subject] = append(f.subjects[subject], id)
+	return id, nil
+}
+
+func (f *Registry) Lookup(_ context.Context, subject string, schema serde.Schema) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.lookup(subject, schema); ok {
+		return id, nil
+	}
+	return 0, serde.ErrSchemaNotFound
+}
+
+func (f *Registry) lookup(subject string, schema serde.Schema) (int, bool) {
+	for _, id := range f.subjects[subject] {
+		s := f.schemas[id-1]
+		if schemaType(s) == schemaType(schema) && s.Schema == schema.Schema {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (f *Registry) SchemaByID(_ context.Context, id int) (serde.Schema, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id < 1 || id > len(f.schemas) {
+		return serde.Schema{}, serde.ErrSchemaNotFound
+	}
+	return f.schemas[id-1], nil
+}
+
+func (f *Registry) Compatible(_ context.Context, subject string, schema serde.Schema) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.compatible(subject, schema)
+}
+
+func (f *Registry) compatible(subject string, schema serde.Schema) (bool, error) {
+	versions := f.subjects[subject]
+	if len(versions) == 0 {
+		return true, nil
+	}
+	latest := f.schemas[versions[len(versions)-1]-1]
+	if schemaType(latest) != schemaType(schema) {
+		return false, nil
+	}
+	if schemaType(schema) != serde.SchemaTypeAvro {
+		return true, nil
+	}
+
+	reader, err := avro.Parse(schema.Schema)
+	if err != nil {
+		return false, err
+	}
+	writer, err := avro.Parse(latest.Schema)
+	if err != nil {
+		return false, err
+	}
+	return avro.NewSchemaCompatibility().Compatible(reader, writer) == nil, nil
+}
+
+// ServeHTTP implements the registry endpoints used by Client.
+func (f *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	ctx := r.Context()
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "schemas" && parts[1] == "ids":
+		id, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeRegistryError(w, http.StatusNotFound, "schema not found")
+			return
+		}
+		schema, err := f.SchemaByID(ctx, id)
+		if err != nil {
+			writeRegistryError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeRegistryJSON(w, schema)
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions":
+		schema, ok := decodeSchema(w, r)
+		if !ok {
+			return
+		}
+		id, err := f.Register(ctx, parts[1], schema)
+		if errors.Is(err, serde.ErrIncompatibleSchema) {
+			writeRegistryError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			writeRegistryError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeRegistryJSON(w, map[string]int{"id": id})
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[0] == "subjects":
+		schema, ok := decodeSchema(w, r)
+		if !ok {
+			return
+		}
+		id, err := f.Lookup(ctx, parts[1], schema)
+		if err != nil {
+			writeRegistryError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeRegistryJSON(w, map[string]int{"id": id})
+
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[0] == "compatibility" && parts[1] == "subjects" && parts[3] == "versions":
+		schema, ok := decodeSchema(w, r)
+		if !ok {
+			return
+		}
+		f.mu.Lock()
+		exists := len(f.subjects[parts[2]]) > 0
+		f.mu.Unlock()
+		if !exists {
+			writeRegistryError(w, http.StatusNotFound, "subject not found")
+			return
+		}
+		compatible, err := f.Compatible(ctx, parts[2], schema)
+		if err != nil {
+			writeRegistryError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeRegistryJSON(w, map[string]bool{"is_compatible": compatible})
+
+	default:
+		writeRegistryError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func decodeSchema(w http.ResponseWriter, r *http.Request) (serde.Schema, bool) {
+	var schema serde.Schema
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		writeRegistryError(w, http.StatusUnprocessableEntity, "invalid schema")
+		return schema, false
+	}
+	return schema, true
+}
+
+func writeRegistryJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error_code": status, "message": message})
+}
+
+// schemaType is the type of s, where an empty type means AVRO.
+func schemaType(s serde.Schema) string {
+	if s.Type == "" {
+		return serde.SchemaTypeAvro
+	}
+	return s.Type
+}
@@ -2,22 +2,22 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 	"go.opentelemetry.io/otel/trace"
-	"os"
-	"os/signal"
-	"strings"
+	"sort"
 	"sync"
-	"syscall"
+	"time"
 
+	"github.com/rlindsey28/con-service/cloudevents"
 	"github.com/rlindsey28/con-service/config"
 	"github.com/rlindsey28/con-service/logger"
-	"github.com/rlindsey28/con-service/rolldice"
 
 	"github.com/IBM/sarama"
 	"go.uber.org/zap"
@@ -29,83 +29,230 @@ var (
 	tracer = otel.Tracer(name)
 )
 
-func NewConsumer(conf *config.KafkaConfig) {
-	keepRunning := true
+const (
+	CommitAuto   = "auto"
+	CommitManual = "manual"
+	CommitBatch  = "batch"
+)
+
+var ErrNoHandlers = errors.New("no handlers registered")
+
+// Consumer reads the topics handlers are registered for as a member of the
+// consumer group. Offsets are marked once the handler of a message returns
+// and committed according to the commit mode: periodically by sarama (auto),
+// after every message (manual) or after a batch of messages or the commit
+// interval, whichever comes first (batch). Failed messages are retried in
+// place, or through retry topics and a dead-letter topic if those are
+// enabled. With a concurrency above 1 the messages of a partition are
+// processed by a pool of workers, see consumeConcurrently. When partitions
+// are revoked, the messages in flight are given DrainTimeout to complete
+// before the offsets are committed. Status reports its group membership,
+// claims and lag, and partitions can be paused without leaving the group,
+// see Pause.
+type Consumer struct {
+	client       sarama.Client
+	group        sarama.ConsumerGroup
+	groupID      string
+	routes       map[string]route
+	retry        *retrier
+	retryTiers   []time.Duration
+	commitMode   string
+	commitSize   int
+	commitEvery  time.Duration
+	retryBackoff time.Duration
+	concurrency  int
+	drainTimeout time.Duration
+	metrics      ConsumerMetrics
+	admin        offsetFetcher
+	lagInterval  time.Duration
+	lagCallback  metric.Registration
+
+	commitMu    sync.Mutex
+	uncommitted int
+
+	pauseMu sync.Mutex
+	pause   pauseState
+
+	mu          sync.Mutex
+	session     sarama.ConsumerGroupSession
+	rejoin      context.CancelFunc
+	member      bool
+	memberID    string
+	generation  int32
+	joinedAt    time.Time
+	revokedAt   time.Time
+	claims      map[string]map[int32]*claimState
+	lastMessage time.Time
+}
+
+func NewConsumer(conf *config.KafkaConfig) (*Consumer, error) {
 	log := logger.Get()
 	log.Info("Starting a new Sarama consumer")
 
-	/**
-	 * Setup a new Sarama consumer group
-	 */
-	consumer := Consumer{
-		ready: make(chan bool),
+	saramaConfig, err := NewSaramaConfig(conf)
+	if err != nil {
+		return nil, err
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	client, err := sarama.NewConsumerGroup(conf.Brokers, conf.ConsumerGroup, nil)
+	client, err := sarama.NewClient(conf.Brokers, saramaConfig)
 	if err != nil {
-		log.Panic("Error creating consumer group client: %v", zap.Error(err))
-	}
-
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			// `Consume` should be called inside an infinite loop, when a
-			// server-side rebalance happens, the consumer session will need to be
-			// recreated to get the new claims
-			if err := client.Consume(ctx, strings.Split(conf.Topic, ","), &consumer); err != nil {
-				if errors.Is(err, sarama.ErrClosedConsumerGroup) {
-					return
-				}
-				log.Panic("Error from consumer", zap.Error(err))
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				return
-			}
-			consumer.ready = make(chan bool)
-		}
-	}()
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+	group, err := sarama.NewConsumerGroupFromClient(conf.ConsumerGroup, client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	retry, err := newRetrier(client, conf.Retry)
+	if err != nil {
+		group.Close()
+		client.Close()
+		return nil, err
+	}
 
-	<-consumer.ready // Await till the consumer has been set up
-	log.Info("Sarama consumer up and running!...")
+	concurrency, drainTimeout, lagInterval := 1, time.Duration(0), time.Duration(0)
+	if conf.Consumer != nil {
+		concurrency = max(conf.Consumer.Concurrency, 1)
+		drainTimeout = conf.Consumer.DrainTimeout
+		lagInterval = conf.Consumer.LagInterval
+	}
+	consumer := &Consumer{
+		client:       client,
+		group:        group,
+		groupID:      conf.ConsumerGroup,
+		routes:       make(map[string]route),
+		retry:        retry,
+		retryTiers:   retry.tierDelays(),
+		commitMode:   commitMode(conf),
+		commitSize:   conf.CommitBatchSize,
+		commitEvery:  conf.CommitInterval,
+		retryBackoff: conf.Retry.Backoff,
+		concurrency:  concurrency,
+		drainTimeout: drainTimeout,
+		lagInterval:  lagInterval,
+		claims:       make(map[string]map[int32]*claimState),
+	}
+	consumer.metrics.InitMetrics()
+	if consumer.lagCallback, err = consumer.registerLagCallback(); err != nil {
+		log.Error("failed to register lag callback", zap.Error(err))
+	}
+	return consumer, nil
+}
 
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+// Handle registers handler for the messages of topic, and of its retry
+// topics if they are enabled. It must be called before Run.
+func (consumer *Consumer) Handle(topic string, handler Handler) {
+	consumer.routes[topic] = route{handler: handler, topic: topic}
+	for i, delay := range consumer.retryTiers {
+		consumer.routes[RetryTopic(topic, delay)] = route{handler: handler, topic: topic, tier: i + 1}
+	}
+}
 
-	for keepRunning {
-		select {
-		case <-ctx.Done():
-			log.Info("terminating: context cancelled")
-			keepRunning = false
-		case <-sigterm:
-			log.Info("terminating: via signal")
-			keepRunning = false
+// Topics returns the topics consumed for the registered handlers.
+func (consumer *Consumer) Topics() []string {
+	topics := make([]string, 0, len(consumer.routes))
+	for topic := range consumer.routes {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Run consumes until ctx is done or the group is closed. Consume has to be
+// called again after every rebalance, so it runs in a loop.
+func (consumer *Consumer) Run(ctx context.Context) error {
+	log := logger.Get()
+	topics := consumer.Topics()
+	if len(topics) == 0 {
+		return ErrNoHandlers
+	}
+	if consumer.lagInterval > 0 {
+		go consumer.monitorLag(ctx)
+	}
+	for {
+		// The session can be ended without stopping the consumer, see
+		// ResetPaused.
+		sessionCtx, rejoin := context.WithCancel(ctx)
+		consumer.mu.Lock()
+		consumer.rejoin = rejoin
+		consumer.mu.Unlock()
+		err := consumer.group.Consume(sessionCtx, topics, consumer)
+		rejoin()
+		if err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			log.Error("Error from consumer", zap.Error(err))
+			return err
+		}
+		// check if context was cancelled, signaling that the consumer should stop
+		if ctx.Err() != nil {
+			return nil
 		}
 	}
-	cancel()
-	wg.Wait()
-	if err = client.Close(); err != nil {
-		log.Panic("Error closing client", zap.Error(err))
+}
+
+// Close leaves the consumer group and closes the client.
+func (consumer *Consumer) Close() error {
+	var err error
+	if consumer.lagCallback != nil {
+		err = consumer.lagCallback.Unregister()
 	}
+	return errors.Join(err, consumer.group.Close(), consumer.retry.Close(), consumer.client.Close())
 }
 
-type Consumer struct {
-	ready chan bool
+// NewSaramaConfig builds the sarama client configuration for conf.
+func NewSaramaConfig(conf *config.KafkaConfig) (*sarama.Config, error) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.MetricRegistry = MetricsRegistry()
+	// Required by the producer of the retry topics.
+	saramaConfig.Producer.Return.Successes = true
+	switch mode := commitMode(conf); mode {
+	case CommitAuto:
+	case CommitManual, CommitBatch:
+		saramaConfig.Consumer.Offsets.AutoCommit.Enable = false
+	default:
+		return nil, fmt.Errorf("invalid commit mode %q, must be one of auto, manual or batch", mode)
+	}
+	if conf.CommitInterval > 0 {
+		saramaConfig.Consumer.Offsets.AutoCommit.Interval = conf.CommitInterval
+	}
+	if err := configureConsumer(saramaConfig, conf); err != nil {
+		return nil, err
+	}
+	if err := ConfigureSecurity(saramaConfig, conf); err != nil {
+		return nil, err
+	}
+	if err := saramaConfig.Validate(); err != nil {
+		return nil, err
+	}
+	return saramaConfig, nil
+}
+
+func commitMode(conf *config.KafkaConfig) string {
+	if conf.CommitMode == "" {
+		return CommitAuto
+	}
+	return conf.CommitMode
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
-	// Mark the consumer as ready
-	close(consumer.ready)
+func (consumer *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	logger.Get().Info("Sarama consumer up and running!...", zap.String("member_id", session.MemberID()), zap.Int32("generation_id", session.GenerationID()), zap.Any("claims", session.Claims()))
+	consumer.joined(session)
+	consumer.watchRevoke(session)
+	if err := consumer.assigned(session); err != nil {
+		return err
+	}
+	if consumer.commitMode == CommitBatch && consumer.commitEvery > 0 {
+		go consumer.commitLoop(session)
+	}
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
-	return nil
+func (consumer *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	defer consumer.left()
+	return consumer.revoked(session)
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
@@ -113,28 +260,33 @@ func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
 // loop and exit.
 func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	log := logger.Get()
+	consumer.claimed(claim)
+	consumer.repause(claim)
+	ctx, cancel := consumer.drainContext(session.Context())
+	defer cancel()
+	if consumer.concurrency > 1 {
+		return consumer.consumeConcurrently(ctx, session, claim)
+	}
 	// NOTE:
 	// Do not move the code below to a goroutine.
 	// The `ConsumeClaim` itself is called within a goroutine, see:
 	// https://github.com/IBM/sarama/blob/main/consumer_group.go#L27-L29
 	for {
+		// Stop pulling messages as soon as the partition is revoked.
+		if session.Context().Err() != nil {
+			return nil
+		}
 		select {
 		case message, ok := <-claim.Messages():
 			if !ok {
 				log.Info("message channel was closed")
 				return nil
 			}
-			createConsumerSpan(message)
-			log.Info("Message claimed", zap.Any("value", message.Value), zap.Time("timestamp", message.Timestamp))
-			session.MarkMessage(message, "")
-
-			roll := &rolldice.DiceRoll{}
-
-			err := json.Unmarshal(message.Value, roll)
-			if err != nil {
-				log.Info("Error unmarshalling message", zap.Error(err))
-			} else {
-				log.Info("Dice roll", zap.Any("roll", roll))
+			if err := consumer.process(ctx, session, message); err != nil {
+				// The session ended while the message was retried. Its
+				// offset is not marked, so the next owner of the partition
+				// handles it again.
+				return nil
 			}
 		// Should return when `session.Context()` is done.
 		// If not, will raise `ErrRebalanceInProgress` or `read tcp <ip>:<port>: i/o timeout` when kafka rebalance. see:
@@ -145,7 +297,180 @@ func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, clai
 	}
 }
 
-func createConsumerSpan(msg *sarama.ConsumerMessage) trace.Span {
+// process delivers message, then marks it as consumed. It only returns an
+// error if the session ends first.
+func (consumer *Consumer) process(ctx context.Context, session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
+	if err := consumer.deliver(ctx, session.Context(), message); err != nil {
+		return err
+	}
+	session.MarkMessage(message, "")
+	consumer.consumed(message.Topic, message.Partition, message.Offset+1)
+	consumer.commit(session)
+	return nil
+}
+
+// deliver hands message to the handler of its topic until it succeeds, fails
+// permanently or is forwarded to a retry or dead-letter topic. The handler
+// runs with ctx, while waiting for a paused partition to resume and retries
+// stop as soon as revoked is done, in which case deliver returns its error.
+func (consumer *Consumer) deliver(ctx, revoked context.Context, message *sarama.ConsumerMessage) error {
+	log := logger.Get()
+	if err := consumer.waitResumed(revoked, message.Topic, message.Partition); err != nil {
+		return err
+	}
+	log.Info("Message claimed", zap.String("topic", message.Topic), zap.Int32("partition", message.Partition), zap.Int64("offset", message.Offset), zap.Time("timestamp", message.Timestamp))
+	if err := consumer.retry.wait(revoked, message); err != nil {
+		return err
+	}
+	rt, ok := consumer.routes[message.Topic]
+	if !ok {
+		rt = route{topic: message.Topic}
+	}
+	attrs := metric.WithAttributes(
+		semconv.MessagingDestinationName(message.Topic),
+		semconv.MessagingKafkaDestinationPartition(int(message.Partition)),
+	)
+	consumer.metrics.Messages.Add(ctx, 1, attrs)
+	consumer.metrics.Bytes.Add(ctx, int64(len(message.Value)), attrs)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := consumer.handle(ctx, rt, message)
+		consumer.metrics.ProcessDuration.Record(ctx, time.Since(start).Seconds(), attrs, metric.WithAttributes(outcome(err)))
+		if err == nil {
+			if !message.Timestamp.IsZero() {
+				consumer.metrics.EndToEndLatency.Record(ctx, time.Since(message.Timestamp).Seconds(), attrs)
+			}
+			break
+		}
+		consumer.metrics.Errors.Add(ctx, 1, attrs, metric.WithAttributes(errorType(err)))
+		fields := []zap.Field{zap.String("topic", message.Topic), zap.Int32("partition", message.Partition), zap.Int64("offset", message.Offset), zap.Int("attempt", attempt), zap.Error(err)}
+		if consumer.retry != nil {
+			topic, ferr := consumer.retry.forward(rt, message, err)
+			if ferr == nil {
+				log.Warn("failed to handle message, forwarded", append(fields, zap.String("forwarded_to", topic))...)
+				break
+			}
+			fields = append(fields, zap.NamedError("forward_error", ferr))
+		} else if errors.Is(err, ErrPermanent) {
+			log.Error("skipping message that cannot be handled", fields...)
+			break
+		}
+		log.Warn("failed to handle message, retrying", append(fields, zap.Duration("backoff", consumer.retryBackoff))...)
+		select {
+		case <-time.After(consumer.retryBackoff):
+		case <-revoked.Done():
+			return revoked.Err()
+		}
+	}
+	return nil
+}
+
+// outcome is the attribute of a processing duration.
+func outcome(err error) attribute.KeyValue {
+	if err != nil {
+		return attribute.String("outcome", "error")
+	}
+	return attribute.String("outcome", "success")
+}
+
+// errorType classifies a handler error: permanent errors are not retried,
+// timeouts are usually caused by a slow dependency or a drain and transient
+// errors are everything else.
+func errorType(err error) attribute.KeyValue {
+	switch {
+	case errors.Is(err, ErrPermanent):
+		return semconv.ErrorTypeKey.String("permanent")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return semconv.ErrorTypeKey.String("timeout")
+	default:
+		return semconv.ErrorTypeKey.String("transient")
+	}
+}
+
+// handle passes message to the handler of its topic.
+func (consumer *Consumer) handle(ctx context.Context, rt route, message *sarama.ConsumerMessage) error {
+	return handleMessage(ctx, rt.handler, message)
+}
+
+// handleMessage unwraps message and passes it to handler in a consumer span.
+func handleMessage(ctx context.Context, handler Handler, message *sarama.ConsumerMessage) (err error) {
+	ctx, span := startConsumerSpan(ctx, message)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if handler == nil {
+		return Permanent(fmt.Errorf("%w %s", ErrUnknownTopic, message.Topic))
+	}
+	msg, err := newMessage(message)
+	if err != nil {
+		return Permanent(err)
+	}
+	if event := msg.Event; event != nil {
+		ctx = cloudevents.NewContext(ctx, event)
+		logger.Get().Info("CloudEvent", zap.String("id", event.ID), zap.String("source", event.Source), zap.String("type", event.Type), zap.Time("time", event.Time), zap.String("dataschema", event.DataSchema))
+	}
+	return handler.Handle(ctx, msg)
+}
+
+// commit commits the marked offsets as the commit mode requires after a
+// message has been marked.
+func (consumer *Consumer) commit(session sarama.ConsumerGroupSession) {
+	switch consumer.commitMode {
+	case CommitManual:
+		session.Commit()
+	case CommitBatch:
+		consumer.commitMu.Lock()
+		consumer.uncommitted++
+		full := consumer.uncommitted >= consumer.commitSize
+		consumer.commitMu.Unlock()
+		if full {
+			consumer.flush(session)
+		}
+	}
+}
+
+// commitNow commits the marked offsets whatever the commit mode, as sarama
+// only commits on release when it commits automatically.
+func (consumer *Consumer) commitNow(session sarama.ConsumerGroupSession) {
+	consumer.commitMu.Lock()
+	defer consumer.commitMu.Unlock()
+	session.Commit()
+	consumer.uncommitted = 0
+}
+
+// flush commits the offsets marked since the last batch.
+func (consumer *Consumer) flush(session sarama.ConsumerGroupSession) {
+	consumer.commitMu.Lock()
+	defer consumer.commitMu.Unlock()
+	if consumer.uncommitted == 0 {
+		return
+	}
+	session.Commit()
+	consumer.uncommitted = 0
+}
+
+// commitLoop flushes the batch every commit interval until the session ends.
+func (consumer *Consumer) commitLoop(session sarama.ConsumerGroupSession) {
+	ticker := time.NewTicker(consumer.commitEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			consumer.flush(session)
+		case <-session.Context().Done():
+			return
+		}
+	}
+}
+
+// startConsumerSpan starts the span of handling msg, as a child of the span
+// propagated in its headers.
+func startConsumerSpan(ctx context.Context, msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
 
 	headers := propagation.MapCarrier{}
 
@@ -154,9 +479,9 @@ func createConsumerSpan(msg *sarama.ConsumerMessage) trace.Span {
 	}
 
 	propagator := otel.GetTextMapPropagator()
-	ctx := propagator.Extract(context.Background(), headers)
+	ctx = propagator.Extract(ctx, headers)
 
-	_, span := tracer.Start(
+	return tracer.Start(
 		ctx,
 		fmt.Sprintf("%s receive", msg.Topic),
 		trace.WithSpanKind(trace.SpanKindConsumer),
@@ -171,7 +496,4 @@ func createConsumerSpan(msg *sarama.ConsumerMessage) trace.Span {
 			semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
 		),
 	)
-	defer span.End()
-
-	return span
 }
@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"github.com/rlindsey28/con-service/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// ConsumerMetrics are the instruments the consumer records its throughput,
+// lag and group membership in. Message metrics carry the topic and partition,
+// errors an error.type of permanent, transient or timeout, and partition and
+// rebalance counts a kafka.rebalance.event attribute, assigned or revoked.
+type ConsumerMetrics struct {
+	Rebalances    metric.Int64Counter
+	Partitions    metric.Int64Counter
+	DrainDuration metric.Float64Histogram
+
+	Messages        metric.Int64Counter
+	Bytes           metric.Int64Counter
+	ProcessDuration metric.Float64Histogram
+	Errors          metric.Int64Counter
+	EndToEndLatency metric.Float64Histogram
+	Lag             metric.Int64ObservableGauge
+	HighWaterMark   metric.Int64ObservableGauge
+	CommittedOffset metric.Int64ObservableGauge
+	Paused          metric.Int64ObservableGauge
+}
+
+func (m *ConsumerMetrics) InitMetrics() {
+	log := logger.Get()
+	meter := otel.Meter(name)
+
+	var err error
+	m.Rebalances, err = meter.Int64Counter("kafka.consumer.rebalances",
+		metric.WithDescription("The number of partition assignments and revocations"),
+		metric.WithUnit("{rebalance}"))
+	if err != nil {
+		log.Error("failed to create counter", zap.Error(err))
+	}
+	m.Partitions, err = meter.Int64Counter("kafka.consumer.rebalance.partitions",
+		metric.WithDescription("The number of partitions assigned and revoked"),
+		metric.WithUnit("{partition}"))
+	if err != nil {
+		log.Error("failed to create counter", zap.Error(err))
+	}
+	m.DrainDuration, err = meter.Float64Histogram("kafka.consumer.rebalance.drain.duration",
+		metric.WithDescription("The time from a revocation until in-flight messages were processed and offsets committed"),
+		metric.WithUnit("s"))
+	if err != nil {
+		log.Error("failed to create histogram", zap.Error(err))
+	}
+
+	m.Messages, err = meter.Int64Counter("kafka.consumer.messages",
+		metric.WithDescription("The number of messages consumed"),
+		metric.WithUnit("{message}"))
+	if err != nil {
+		log.Error("failed to create counter", zap.Error(err))
+	}
+	m.Bytes, err = meter.Int64Counter("kafka.consumer.bytes",
+		metric.WithDescription("The size of the values of the messages consumed"),
+		metric.WithUnit("By"))
+	if err != nil {
+		log.Error("failed to create counter", zap.Error(err))
+	}
+	m.ProcessDuration, err = meter.Float64Histogram("kafka.consumer.process.duration",
+		metric.WithDescription("The time a handler took to process a message, per attempt"),
+		metric.WithUnit("s"))
+	if err != nil {
+		log.Error("failed to create histogram", zap.Error(err))
+	}
+	m.Errors, err = meter.Int64Counter("kafka.consumer.errors",
+		metric.WithDescription("The number of failed attempts to handle a message"),
+		metric.WithUnit("{error}"))
+	if err != nil {
+		log.Error("failed to create counter", zap.Error(err))
+	}
+	m.EndToEndLatency, err = meter.Float64Histogram("kafka.consumer.end_to_end.latency",
+		metric.WithDescription("The time from when a message was produced until it was processed"),
+		metric.WithUnit("s"))
+	if err != nil {
+		log.Error("failed to create histogram", zap.Error(err))
+	}
+	m.Lag, err = meter.Int64ObservableGauge("kafka.consumer.lag",
+		metric.WithDescription("The high water mark minus the committed offset of each claimed partition"),
+		metric.WithUnit("{message}"))
+	if err != nil {
+		log.Error("failed to create gauge", zap.Error(err))
+	}
+	m.HighWaterMark, err = meter.Int64ObservableGauge("kafka.consumer.high_water_mark",
+		metric.WithDescription("The offset of the next message produced to each claimed partition"))
+	if err != nil {
+		log.Error("failed to create gauge", zap.Error(err))
+	}
+	m.CommittedOffset, err = meter.Int64ObservableGauge("kafka.consumer.committed_offset",
+		metric.WithDescription("The offset committed by the group for each claimed partition"))
+	if err != nil {
+		log.Error("failed to create gauge", zap.Error(err))
+	}
+	m.Paused, err = meter.Int64ObservableGauge("kafka.consumer.paused",
+		metric.WithDescription("Whether each claimed partition is paused, 1 if it is and 0 otherwise"))
+	if err != nil {
+		log.Error("failed to create gauge", zap.Error(err))
+	}
+}
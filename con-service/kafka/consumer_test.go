@@ -0,0 +1,221 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+
+	"github.com/IBM/sarama"
+)
+
+const testRoll = `{"rolls":3,"sides":6,"table":"table-7","distribution":{"2":1,"5":2}}`
+
+func TestNewMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		value   string
+		event   bool
+	}{
+		{
+			name:  "plain",
+			value: testRoll,
+		},
+		{
+			name: "binary",
+			headers: map[string]string{
+				"ce_specversion": "1.0",
+				"ce_id":          "7f1b",
+				"ce_source":      "/pub-service",
+				"ce_type":        "dev.go-sandbox.dice.rolled",
+				"ce_time":        "2024-05-01T12:30:00Z",
+				"content-type":   "application/json",
+			},
+			value: testRoll,
+			event: true,
+		},
+		{
+			name:    "structured",
+			headers: map[string]string{"content-type": "application/cloudevents+json"},
+			value:   `{"specversion":"1.0","id":"7f1b","source":"/pub-service","type":"dev.go-sandbox.dice.rolled","datacontenttype":"application/json","data":` + testRoll + `}`,
+			event:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &sarama.ConsumerMessage{Value: []byte(tt.value)}
+			for k, v := range tt.headers {
+				message.Headers = append(message.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+			}
+			msg, err := newMessage(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.Data()) != testRoll {
+				t.Errorf("unexpected data %s", msg.Data())
+			}
+			if tt.event && (msg.Event == nil || msg.Event.ID != "7f1b" || msg.Event.Type != "dev.go-sandbox.dice.rolled") {
+				t.Errorf("unexpected event %+v", msg.Event)
+			}
+			if !tt.event && msg.Event != nil {
+				t.Errorf("expected no event, got %+v", msg.Event)
+			}
+		})
+	}
+}
+
+func TestNewMessageInvalidCloudEvent(t *testing.T) {
+	message := &sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{{Key: []byte("ce_specversion"), Value: []byte("1.0")}},
+		Value:   []byte(testRoll),
+	}
+	if _, err := newMessage(message); err == nil {
+		t.Error("expected an error for an event without id, source and type")
+	}
+}
+
+// commitSession records the offsets marked and committed during a session.
+type commitSession struct {
+	sarama.ConsumerGroupSession
+	ctx       context.Context
+	mu        sync.Mutex
+	marked    []int64
+	committed []int64
+}
+
+func (s *commitSession) Context() context.Context { return s.ctx }
+
+func (s *commitSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *commitSession) MarkOffset(_ string, _ int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, offset)
+}
+
+func (s *commitSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.marked) > 0 {
+		s.committed = append(s.committed, s.marked[len(s.marked)-1])
+	}
+}
+
+func newTestConsumer(handler Handler) *Consumer {
+	consumer := &Consumer{
+		routes:       make(map[string]route),
+		commitMode:   CommitAuto,
+		retryBackoff: time.Millisecond,
+		claims:       make(map[string]map[int32]*claimState),
+	}
+	consumer.metrics.InitMetrics()
+	consumer.Handle("dice-rolls", handler)
+	return consumer
+}
+
+func testMessage(offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{Topic: "dice-rolls", Offset: offset, Value: []byte(testRoll)}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		marked   bool
+		attempts int
+	}{
+		{name: "success", marked: true, attempts: 1},
+		{name: "retried", failures: 2, err: errors.New("store unavailable"), marked: true, attempts: 3},
+		{name: "permanent", failures: 1, err: Permanent(errors.New("invalid roll")), marked: true, attempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			consumer := newTestConsumer(HandlerFunc(func(context.Context, *Message) error {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			}))
+			session := &commitSession{ctx: context.Background()}
+			if err := consumer.process(session.ctx, session, testMessage(41)); err != nil {
+				t.Fatal(err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("handled %d times, want %d", attempts, tt.attempts)
+			}
+			if len(session.marked) != 1 || session.marked[0] != 42 {
+				t.Errorf("marked %v, want [42]", session.marked)
+			}
+		})
+	}
+}
+
+func TestProcessNotMarkedUntilHandled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := newTestConsumer(HandlerFunc(func(context.Context, *Message) error {
+		cancel()
+		return errors.New("store unavailable")
+	}))
+	session := &commitSession{ctx: ctx}
+	if err := consumer.process(session.ctx, session, testMessage(41)); err == nil {
+		t.Error("expected an error once the session ended")
+	}
+	if len(session.marked) != 0 {
+		t.Errorf("marked %v before the message was handled", session.marked)
+	}
+}
+
+func TestCommitModes(t *testing.T) {
+	tests := []struct {
+		mode      string
+		committed []int64
+	}{
+		{mode: CommitAuto},
+		{mode: CommitManual, committed: []int64{1, 2, 3, 4, 5}},
+		{mode: CommitBatch, committed: []int64{2, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			consumer := newTestConsumer(HandlerFunc(func(context.Context, *Message) error { return nil }))
+			consumer.commitMode = tt.mode
+			consumer.commitSize = 2
+			session := &commitSession{ctx: context.Background()}
+			for offset := int64(0); offset < 5; offset++ {
+				if err := consumer.process(session.ctx, session, testMessage(offset)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			consumer.flush(session)
+			if len(session.committed) != len(tt.committed) {
+				t.Fatalf("committed %v, want %v", session.committed, tt.committed)
+			}
+			for i := range tt.committed {
+				if session.committed[i] != tt.committed[i] {
+					t.Errorf("committed %v, want %v", session.committed, tt.committed)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSaramaConfigCommitMode(t *testing.T) {
+	saramaConfig, err := NewSaramaConfig(&config.KafkaConfig{CommitMode: CommitBatch, CommitInterval: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saramaConfig.Consumer.Offsets.AutoCommit.Enable {
+		t.Error("expected auto commit to be disabled in batch mode")
+	}
+	if _, err := NewSaramaConfig(&config.KafkaConfig{CommitMode: "sometimes"}); err == nil {
+		t.Error("expected an error for an invalid commit mode")
+	}
+}
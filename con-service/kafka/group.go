@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rlindsey28/con-service/config"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	AssignorRange             = "range"
+	AssignorRoundRobin        = "roundrobin"
+	AssignorSticky            = "sticky"
+	AssignorCooperativeSticky = "cooperative-sticky"
+
+	OffsetsOldest = "oldest"
+	OffsetsNewest = "newest"
+
+	ReadUncommitted = "read_uncommitted"
+	ReadCommitted   = "read_committed"
+)
+
+// ErrCooperativeRebalancing is returned for the cooperative-sticky assignor:
+// Sarama only implements the eager rebalance protocol, in which every member
+// gives up all its partitions on each rebalance.
+var ErrCooperativeRebalancing = errors.New("cooperative-sticky is not supported, sarama only implements eager rebalancing; use sticky to keep assignments stable across rebalances")
+
+// configureConsumer applies the consumer group settings of conf to
+// saramaConfig.
+func configureConsumer(saramaConfig *sarama.Config, conf *config.KafkaConfig) error {
+	if conf.Version != "" {
+		version, err := sarama.ParseKafkaVersion(conf.Version)
+		if err != nil {
+			return fmt.Errorf("invalid kafka version %q: %w", conf.Version, err)
+		}
+		saramaConfig.Version = version
+	}
+	if conf.Assignor != "" {
+		strategies, err := balanceStrategies(conf.Assignor)
+		if err != nil {
+			return err
+		}
+		saramaConfig.Consumer.Group.Rebalance.GroupStrategies = strategies
+	}
+
+	c := conf.Consumer
+	if c == nil {
+		return nil
+	}
+	group := &saramaConfig.Consumer.Group
+	if c.InstanceID != "" {
+		if !saramaConfig.Version.IsAtLeast(sarama.V2_3_0_0) {
+			return fmt.Errorf("static membership with instance id %q needs KAFKA_VERSION 2.3.0 or later, got %s", c.InstanceID, saramaConfig.Version)
+		}
+		group.InstanceId = c.InstanceID
+	}
+	switch c.OffsetsInitial {
+	case "", OffsetsNewest:
+		saramaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	case OffsetsOldest:
+		saramaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		return fmt.Errorf("invalid initial offset %q, must be oldest or newest", c.OffsetsInitial)
+	}
+	switch c.IsolationLevel {
+	case "", ReadUncommitted:
+		saramaConfig.Consumer.IsolationLevel = sarama.ReadUncommitted
+	case ReadCommitted:
+		saramaConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+	default:
+		return fmt.Errorf("invalid isolation level %q, must be read_uncommitted or read_committed", c.IsolationLevel)
+	}
+	if c.SessionTimeout > 0 {
+		group.Session.Timeout = c.SessionTimeout
+	}
+	if c.HeartbeatInterval > 0 {
+		group.Heartbeat.Interval = c.HeartbeatInterval
+	}
+	if c.RebalanceTimeout > 0 {
+		group.Rebalance.Timeout = c.RebalanceTimeout
+	}
+	if c.MaxProcessingTime > 0 {
+		saramaConfig.Consumer.MaxProcessingTime = c.MaxProcessingTime
+	}
+	if c.MaxWaitTime > 0 {
+		saramaConfig.Consumer.MaxWaitTime = c.MaxWaitTime
+	}
+	if c.FetchMin > 0 {
+		saramaConfig.Consumer.Fetch.Min = c.FetchMin
+	}
+	if c.FetchDefault > 0 {
+		saramaConfig.Consumer.Fetch.Default = c.FetchDefault
+	}
+	saramaConfig.Consumer.Fetch.Max = c.FetchMax
+	if c.DrainTimeout >= group.Rebalance.Timeout {
+		return fmt.Errorf("drain timeout %s must be lower than the rebalance timeout %s", c.DrainTimeout, group.Rebalance.Timeout)
+	}
+	if group.Heartbeat.Interval >= group.Session.Timeout {
+		return fmt.Errorf("heartbeat interval %s must be lower than the session timeout %s", group.Heartbeat.Interval, group.Session.Timeout)
+	}
+	return nil
+}
+
+// balanceStrategies returns the strategies named in the comma separated list
+// assignors, in order.
+func balanceStrategies(assignors string) ([]sarama.BalanceStrategy, error) {
+	var strategies []sarama.BalanceStrategy
+	for _, assignor := range strings.Split(assignors, ",") {
+		switch assignor = strings.TrimSpace(assignor); assignor {
+		case AssignorRange:
+			strategies = append(strategies, sarama.NewBalanceStrategyRange())
+		case AssignorRoundRobin:
+			strategies = append(strategies, sarama.NewBalanceStrategyRoundRobin())
+		case AssignorSticky:
+			strategies = append(strategies, sarama.NewBalanceStrategySticky())
+		case AssignorCooperativeSticky:
+			return nil, ErrCooperativeRebalancing
+		default:
+			return nil, fmt.Errorf("invalid assignor %q, must be one of range, roundrobin or sticky", assignor)
+		}
+	}
+	return strategies, nil
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+
+	"github.com/IBM/sarama"
+)
+
+func TestConfigureConsumer(t *testing.T) {
+	saramaConfig, err := NewSaramaConfig(&config.KafkaConfig{
+		Assignor: "sticky, roundrobin",
+		Version:  "3.6.0",
+		Consumer: &config.ConsumerConfig{
+			InstanceID:        "con-service-0",
+			OffsetsInitial:    OffsetsOldest,
+			SessionTimeout:    30 * time.Second,
+			HeartbeatInterval: 5 * time.Second,
+			MaxProcessingTime: time.Second,
+			FetchMin:          1024,
+			FetchDefault:      4 << 20,
+			FetchMax:          16 << 20,
+			IsolationLevel:    ReadCommitted,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := saramaConfig.Consumer.Group
+	if strategies := group.Rebalance.GroupStrategies; len(strategies) != 2 || strategies[0].Name() != "sticky" || strategies[1].Name() != "roundrobin" {
+		t.Errorf("unexpected strategies %v", strategies)
+	}
+	if group.InstanceId != "con-service-0" || group.Session.Timeout != 30*time.Second || group.Heartbeat.Interval != 5*time.Second {
+		t.Errorf("unexpected group config %+v", group)
+	}
+	c := saramaConfig.Consumer
+	if c.Offsets.Initial != sarama.OffsetOldest || c.IsolationLevel != sarama.ReadCommitted || c.MaxProcessingTime != time.Second {
+		t.Errorf("unexpected consumer config %+v", c)
+	}
+	if c.Fetch.Min != 1024 || c.Fetch.Default != 4<<20 || c.Fetch.Max != 16<<20 {
+		t.Errorf("unexpected fetch config %+v", c.Fetch)
+	}
+}
+
+func TestConfigureConsumerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.KafkaConfig
+		want string
+	}{
+		{name: "unknown assignor", conf: config.KafkaConfig{Assignor: "range,balanced"}, want: `invalid assignor "balanced"`},
+		{name: "invalid version", conf: config.KafkaConfig{Version: "three"}, want: "invalid kafka version"},
+		{name: "static membership on old version", conf: config.KafkaConfig{Version: "2.1.0", Consumer: &config.ConsumerConfig{InstanceID: "con-service-0"}}, want: "needs KAFKA_VERSION 2.3.0"},
+		{name: "initial offset", conf: config.KafkaConfig{Consumer: &config.ConsumerConfig{OffsetsInitial: "earliest"}}, want: `invalid initial offset "earliest"`},
+		{name: "isolation level", conf: config.KafkaConfig{Consumer: &config.ConsumerConfig{IsolationLevel: "serializable"}}, want: `invalid isolation level "serializable"`},
+		{name: "heartbeat", conf: config.KafkaConfig{Consumer: &config.ConsumerConfig{SessionTimeout: time.Second, HeartbeatInterval: 3 * time.Second}}, want: "must be lower than the session timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSaramaConfig(&tt.conf)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCooperativeStickyUnsupported(t *testing.T) {
+	_, err := NewSaramaConfig(&config.KafkaConfig{Assignor: AssignorCooperativeSticky})
+	if !errors.Is(err, ErrCooperativeRebalancing) {
+		t.Errorf("got %v, want ErrCooperativeRebalancing", err)
+	}
+}
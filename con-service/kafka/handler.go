@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rlindsey28/con-service/cloudevents"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrPermanent marks handler errors that retrying cannot fix, such as a
+// message that cannot be decoded.
+var ErrPermanent = errors.New("permanent failure")
+
+// Handler processes the messages of a topic. The message's offset is only
+// committed once Handle returns nil, so every message is handled at least
+// once. Failed messages are retried until they succeed, unless the error
+// wraps ErrPermanent, in which case the message is logged and skipped.
+type Handler interface {
+	Handle(ctx context.Context, msg *Message) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(ctx context.Context, msg *Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Message is a record read from Kafka. Event is the CloudEvent the record
+// was wrapped in, or nil for bare records.
+type Message struct {
+	*sarama.ConsumerMessage
+	Event *cloudevents.Event
+}
+
+// Data is the payload of the message: the event data of a CloudEvent or the
+// record value otherwise.
+func (m *Message) Data() []byte {
+	if m.Event != nil {
+		return m.Event.Data
+	}
+	return m.Value
+}
+
+// Header returns the value of the record header key, or "" if it is not set.
+func (m *Message) Header(key string) string {
+	return header(m.Headers, key)
+}
+
+// Time is the event time of the message: the time of its CloudEvent if it
+// has one, the record timestamp otherwise.
+func (m *Message) Time() time.Time {
+	if m.Event != nil && !m.Event.Time.IsZero() {
+		return m.Event.Time
+	}
+	return m.Timestamp
+}
+
+// Permanent wraps err so that it is recognised as ErrPermanent.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() []error { return []error{ErrPermanent, e.err} }
+
+// newMessage unwraps message if it was sent as a CloudEvent in binary or
+// structured mode.
+func newMessage(message *sarama.ConsumerMessage) (*Message, error) {
+	event, err := cloudevents.Decode(message.Headers, message.Value)
+	switch {
+	case err == nil:
+		return &Message{ConsumerMessage: message, Event: event}, nil
+	case errors.Is(err, cloudevents.ErrNotCloudEvent):
+		return &Message{ConsumerMessage: message}, nil
+	default:
+		return nil, err
+	}
+}
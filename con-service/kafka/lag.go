@@ -0,0 +1,140 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.uber.org/zap"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// offsetFetcher reads the offsets committed by a consumer group, implemented
+// by sarama.ClusterAdmin.
+type offsetFetcher interface {
+	ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error)
+}
+
+// monitorLag refreshes the committed offsets of the claimed partitions every
+// lag interval until ctx is done. The admin client shares the consumer's
+// client, so it is never closed on its own.
+func (consumer *Consumer) monitorLag(ctx context.Context) {
+	log := logger.Get()
+	ticker := time.NewTicker(consumer.lagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if consumer.admin == nil {
+			admin, err := sarama.NewClusterAdminFromClient(consumer.client)
+			if err != nil {
+				log.Warn("failed to create admin client for lag", zap.Error(err))
+				continue
+			}
+			consumer.admin = admin
+		}
+		if err := consumer.refreshOffsets(); err != nil {
+			log.Warn("failed to refresh committed offsets", zap.Error(err))
+		}
+	}
+}
+
+// refreshOffsets queries the committed offsets of the claimed partitions, and
+// the high water marks of those whose claim has not fetched yet.
+func (consumer *Consumer) refreshOffsets() error {
+	consumer.mu.Lock()
+	claimed := make(map[string][]int32, len(consumer.claims))
+	var unfetched []topicPartition
+	for topic, partitions := range consumer.claims {
+		for partition, state := range partitions {
+			claimed[topic] = append(claimed[topic], partition)
+			if state.claim == nil || state.claim.HighWaterMarkOffset() <= 0 {
+				unfetched = append(unfetched, topicPartition{topic, partition})
+			}
+		}
+	}
+	consumer.mu.Unlock()
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	resp, err := consumer.admin.ListConsumerGroupOffsets(consumer.groupID, claimed)
+	if err != nil {
+		return err
+	}
+	newest := make(map[topicPartition]int64, len(unfetched))
+	if consumer.client != nil {
+		for _, tp := range unfetched {
+			if offset, err := consumer.client.GetOffset(tp.topic, tp.partition, sarama.OffsetNewest); err == nil {
+				newest[tp] = offset
+			}
+		}
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	for topic, partitions := range claimed {
+		for _, partition := range partitions {
+			state, ok := consumer.claims[topic][partition]
+			if !ok {
+				// Revoked while the offsets were queried.
+				continue
+			}
+			if block := resp.GetBlock(topic, partition); block != nil && block.Err == sarama.ErrNoError && block.Offset >= 0 {
+				state.committed = block.Offset
+			}
+		}
+	}
+	for tp, offset := range newest {
+		if state, ok := consumer.claims[tp.topic][tp.partition]; ok {
+			state.newest = offset
+		}
+	}
+	return nil
+}
+
+// registerLagCallback reports the lag and the paused state of the claimed
+// partitions on every collection of the meter provider. Without a committed
+// offset yet, the lag is measured from the consumer's position.
+func (consumer *Consumer) registerLagCallback() (metric.Registration, error) {
+	m := &consumer.metrics
+	return otel.Meter(name).RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		for _, c := range consumer.Status().Claims {
+			attrs := metric.WithAttributes(
+				semconv.MessagingDestinationName(c.Topic),
+				semconv.MessagingKafkaDestinationPartition(int(c.Partition)),
+			)
+			var paused int64
+			if c.Paused {
+				paused = 1
+			}
+			obs.ObserveInt64(m.Paused, paused, attrs)
+			if c.HighWaterMark >= 0 {
+				obs.ObserveInt64(m.HighWaterMark, c.HighWaterMark, attrs)
+			}
+			if c.Committed >= 0 {
+				obs.ObserveInt64(m.CommittedOffset, c.Committed, attrs)
+			}
+			from := c.Committed
+			if from < 0 {
+				from = c.Offset
+			}
+			if c.HighWaterMark >= 0 && from >= 0 {
+				obs.ObserveInt64(m.Lag, max(c.HighWaterMark-from, 0), attrs)
+			}
+		}
+		return nil
+	}, m.Lag, m.HighWaterMark, m.CommittedOffset, m.Paused)
+}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type fakeOffsetFetcher struct {
+	offsets map[int32]int64
+}
+
+func (f *fakeOffsetFetcher) ListConsumerGroupOffsets(_ string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	resp := &sarama.OffsetFetchResponse{}
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			if offset, ok := f.offsets[partition]; ok {
+				resp.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: offset})
+			}
+		}
+	}
+	return resp, nil
+}
+
+// collect reads the metrics of the manual reader keyed by name.
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+	return got
+}
+
+func newMetricsReader(t *testing.T) *sdkmetric.ManualReader {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	otel.SetMeterProvider(provider)
+	return reader
+}
+
+func TestLagMetrics(t *testing.T) {
+	reader := newMetricsReader(t)
+	consumer := newTestConsumer(nil)
+	consumer.groupID = "con-service"
+	consumer.admin = &fakeOffsetFetcher{offsets: map[int32]int64{0: 5}}
+	registration, err := consumer.registerLagCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registration.Unregister()
+
+	consumer.joined(&fakeSession{claims: map[string][]int32{"dice-rolls": {0, 1}}})
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 0, initial: 5, hwm: 12})
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 1, initial: 3, hwm: 4})
+	consumer.consumed("dice-rolls", 0, 9)
+	if err := consumer.refreshOffsets(); err != nil {
+		t.Fatal(err)
+	}
+
+	lag, ok := collect(t, reader)["kafka.consumer.lag"].(metricdata.Gauge[int64])
+	if !ok || len(lag.DataPoints) != 2 {
+		t.Fatalf("expected the lag of two partitions, got %+v", lag)
+	}
+	got := map[int64]int64{}
+	for _, dp := range lag.DataPoints {
+		partition, _ := dp.Attributes.Value("messaging.kafka.destination.partition")
+		got[partition.AsInt64()] = dp.Value
+	}
+	// Partition 0 is measured from its committed offset, partition 1 has no
+	// commit yet and is measured from the consumer's position.
+	if got[0] != 7 || got[1] != 1 {
+		t.Errorf("unexpected lag %v, want map[0:7 1:1]", got)
+	}
+	if status := consumer.Status(); status.Claims[0].Committed != 5 {
+		t.Errorf("committed offset %d not in the status", status.Claims[0].Committed)
+	}
+}
+
+func TestThroughputMetrics(t *testing.T) {
+	reader := newMetricsReader(t)
+	failures := 1
+	consumer := newTestConsumer(HandlerFunc(func(_ context.Context, msg *Message) error {
+		if msg.Offset == 1 {
+			return Permanent(errors.New("invalid roll"))
+		}
+		if failures > 0 {
+			failures--
+			return errors.New("store unavailable")
+		}
+		return nil
+	}))
+	session := &commitSession{ctx: context.Background()}
+	for offset := int64(0); offset < 2; offset++ {
+		message := testMessage(offset)
+		message.Timestamp = time.Now().Add(-time.Second)
+		if err := consumer.process(session.ctx, session, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := collect(t, reader)
+	messages := got["kafka.consumer.messages"].(metricdata.Sum[int64])
+	if len(messages.DataPoints) != 1 || messages.DataPoints[0].Value != 2 {
+		t.Errorf("expected 2 messages, got %+v", messages.DataPoints)
+	}
+	bytes := got["kafka.consumer.bytes"].(metricdata.Sum[int64])
+	if bytes.DataPoints[0].Value != int64(2*len(testRoll)) {
+		t.Errorf("expected %d bytes, got %d", 2*len(testRoll), bytes.DataPoints[0].Value)
+	}
+	errs := map[string]int64{}
+	for _, dp := range got["kafka.consumer.errors"].(metricdata.Sum[int64]).DataPoints {
+		errorType, _ := dp.Attributes.Value(attribute.Key("error.type"))
+		errs[errorType.AsString()] += dp.Value
+	}
+	if errs["transient"] != 1 || errs["permanent"] != 1 {
+		t.Errorf("unexpected errors by type %v", errs)
+	}
+	duration := got["kafka.consumer.process.duration"].(metricdata.Histogram[float64])
+	var attempts uint64
+	for _, dp := range duration.DataPoints {
+		attempts += dp.Count
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 processing attempts, got %d", attempts)
+	}
+	latency := got["kafka.consumer.end_to_end.latency"].(metricdata.Histogram[float64])
+	if len(latency.DataPoints) != 1 || latency.DataPoints[0].Count != 1 || latency.DataPoints[0].Sum < 1 {
+		t.Errorf("expected the end to end latency of the handled message, got %+v", latency.DataPoints)
+	}
+}
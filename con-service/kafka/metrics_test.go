@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetricsBridge(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	bridge := NewMetricsBridge(provider.Meter(meterName))
+
+	metrics.GetOrRegisterMeter("consumer-fetch-rate-for-topic-dice-rolls", bridge).Mark(1)
+	batchSize := func() metrics.Histogram { return metrics.NewHistogram(metrics.NewUniformSample(10)) }
+	bridge.GetOrRegister("consumer-batch-size", batchSize).(metrics.Histogram).Update(42)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+
+	rate, ok := got["kafka.client.consumer_fetch_rate"].(metricdata.Gauge[float64])
+	if !ok || len(rate.DataPoints) != 1 {
+		t.Fatalf("expected one consumer fetch rate, got %+v", got["kafka.client.consumer_fetch_rate"])
+	}
+	if topic, _ := rate.DataPoints[0].Attributes.Value("messaging.destination.name"); topic.AsString() != "dice-rolls" {
+		t.Errorf("expected the dice-rolls topic, got %q", topic.AsString())
+	}
+	hist, ok := got["kafka.client.consumer_batch_size"].(metricdata.Histogram[int64])
+	if !ok || len(hist.DataPoints) != 1 || hist.DataPoints[0].Sum != 42 {
+		t.Errorf("expected a batch size histogram of 42, got %+v", got["kafka.client.consumer_batch_size"])
+	}
+}
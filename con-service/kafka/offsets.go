@@ -0,0 +1,381 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+
+	"github.com/IBM/sarama"
+)
+
+// Offset reset strategies.
+const (
+	ResetEarliest  = "earliest"
+	ResetLatest    = "latest"
+	ResetTimestamp = "timestamp"
+	ResetOffset    = "offset"
+	ResetShift     = "shift"
+)
+
+// Consumer group states reported by the group coordinator.
+const (
+	GroupStateEmpty = "Empty"
+	GroupStateDead  = "Dead"
+)
+
+var (
+	ErrGroupActive  = errors.New("consumer group has active members")
+	ErrInvalidReset = errors.New("invalid offset reset")
+)
+
+// groupAdmin reads a consumer group, implemented by sarama.ClusterAdmin.
+type groupAdmin interface {
+	offsetFetcher
+	DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error)
+}
+
+// offsetCommitter commits the offsets of a consumer group, implemented by the
+// group's coordinator *sarama.Broker.
+type offsetCommitter interface {
+	CommitOffset(request *sarama.OffsetCommitRequest) (*sarama.OffsetCommitResponse, error)
+}
+
+// pausedResetter resets the offsets of partitions it has paused, implemented
+// by Consumer.
+type pausedResetter interface {
+	ResetPaused(results []ResetResult) error
+}
+
+// GroupAdmin describes a consumer group and manages its committed offsets
+// from outside the group, the way kafka-consumer-groups does.
+type GroupAdmin struct {
+	client      sarama.Client
+	admin       groupAdmin
+	group       string
+	coordinator func() (offsetCommitter, error)
+	member      pausedResetter
+}
+
+// NewGroupAdmin creates a GroupAdmin for the consumer group of conf with a
+// client of its own.
+func NewGroupAdmin(conf *config.KafkaConfig, saramaConfig *sarama.Config) (*GroupAdmin, error) {
+	client, err := sarama.NewClient(conf.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create admin client: %w", err)
+	}
+	return &GroupAdmin{
+		client: client,
+		admin:  admin,
+		group:  conf.ConsumerGroup,
+		coordinator: func() (offsetCommitter, error) {
+			return client.Coordinator(conf.ConsumerGroup)
+		},
+	}, nil
+}
+
+// SetMember lets resets of the partitions member has paused run while the
+// group has members, see Consumer.ResetPaused.
+func (a *GroupAdmin) SetMember(member *Consumer) {
+	a.member = member
+}
+
+// Close closes the client, which the admin client shares.
+func (a *GroupAdmin) Close() error {
+	return a.client.Close()
+}
+
+// GroupDescription is the state of the consumer group and the partitions
+// assigned to each of its members. Protocol is the balance strategy the
+// members agreed on.
+type GroupDescription struct {
+	Group    string        `json:"group"`
+	State    string        `json:"state"`
+	Protocol string        `json:"protocol,omitempty"`
+	Members  []GroupMember `json:"members"`
+}
+
+type GroupMember struct {
+	MemberID   string             `json:"member_id"`
+	InstanceID string             `json:"instance_id,omitempty"`
+	ClientID   string             `json:"client_id"`
+	ClientHost string             `json:"client_host"`
+	Assignment map[string][]int32 `json:"assignment"`
+}
+
+// Empty reports whether the group has no members, so its offsets can be
+// committed from outside it.
+func (d GroupDescription) Empty() bool {
+	return d.State == GroupStateEmpty || d.State == GroupStateDead
+}
+
+// Describe returns the state and the members of the group.
+func (a *GroupAdmin) Describe() (GroupDescription, error) {
+	groups, err := a.admin.DescribeConsumerGroups([]string{a.group})
+	if err != nil {
+		return GroupDescription{}, err
+	}
+	if len(groups) != 1 {
+		return GroupDescription{}, fmt.Errorf("described %d groups instead of %s", len(groups), a.group)
+	}
+	group := groups[0]
+	if group.Err != sarama.ErrNoError {
+		return GroupDescription{}, group.Err
+	}
+	desc := GroupDescription{Group: group.GroupId, State: group.State, Protocol: group.Protocol, Members: []GroupMember{}}
+	for id, m := range group.Members {
+		member := GroupMember{MemberID: id, ClientID: m.ClientId, ClientHost: m.ClientHost, Assignment: map[string][]int32{}}
+		if m.GroupInstanceId != nil {
+			member.InstanceID = *m.GroupInstanceId
+		}
+		assignment, err := m.GetMemberAssignment()
+		if err != nil {
+			return GroupDescription{}, fmt.Errorf("member %s: %w", id, err)
+		}
+		if assignment != nil {
+			for topic, partitions := range assignment.Topics {
+				sorted := append([]int32(nil), partitions...)
+				sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+				member.Assignment[topic] = sorted
+			}
+		}
+		desc.Members = append(desc.Members, member)
+	}
+	sort.Slice(desc.Members, func(i, j int) bool { return desc.Members[i].MemberID < desc.Members[j].MemberID })
+	return desc, nil
+}
+
+// GroupOffset is the offset the group committed in a partition and the
+// number of messages it is behind the high water mark. Committed and Lag are
+// -1 if the group has not committed an offset in the partition.
+type GroupOffset struct {
+	Topic         string `json:"topic"`
+	Partition     int32  `json:"partition"`
+	Committed     int64  `json:"committed"`
+	HighWaterMark int64  `json:"high_water_mark"`
+	Lag           int64  `json:"lag"`
+}
+
+// Offsets returns the committed offsets and lag of every partition of topics,
+// or of the topics the group has committed offsets for if none are given.
+func (a *GroupAdmin) Offsets(topics ...string) ([]GroupOffset, error) {
+	var request map[string][]int32
+	if len(topics) > 0 {
+		request = make(map[string][]int32, len(topics))
+		for _, topic := range topics {
+			partitions, err := a.client.Partitions(topic)
+			if err != nil {
+				return nil, fmt.Errorf("topic %s: %w", topic, err)
+			}
+			request[topic] = partitions
+		}
+	}
+	resp, err := a.admin.ListConsumerGroupOffsets(a.group, request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, resp.Err
+	}
+	if request == nil {
+		request = make(map[string][]int32, len(resp.Blocks))
+		for topic, blocks := range resp.Blocks {
+			for partition := range blocks {
+				request[topic] = append(request[topic], partition)
+			}
+		}
+	}
+
+	var offsets []GroupOffset
+	for topic, partitions := range request {
+		for _, partition := range partitions {
+			o := GroupOffset{Topic: topic, Partition: partition, Committed: -1, Lag: -1}
+			if block := resp.GetBlock(topic, partition); block != nil && block.Err == sarama.ErrNoError && block.Offset >= 0 {
+				o.Committed = block.Offset
+			}
+			if o.HighWaterMark, err = a.client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return nil, fmt.Errorf("topic %s partition %d: %w", topic, partition, err)
+			}
+			if o.Committed >= 0 {
+				o.Lag = max(o.HighWaterMark-o.Committed, 0)
+			}
+			offsets = append(offsets, o)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		if offsets[i].Topic != offsets[j].Topic {
+			return offsets[i].Topic < offsets[j].Topic
+		}
+		return offsets[i].Partition < offsets[j].Partition
+	})
+	return offsets, nil
+}
+
+// OffsetReset moves the committed offsets of Topic, in Partitions or all of
+// its partitions, to the earliest or latest offset, the first offset at or
+// after Timestamp, Offset, or Shift messages from the committed offset. The
+// new offsets are kept within the partition. A DryRun only computes them.
+type OffsetReset struct {
+	Topic      string    `json:"topic"`
+	Partitions []int32   `json:"partitions,omitempty"`
+	To         string    `json:"to"`
+	Timestamp  time.Time `json:"timestamp,omitempty"`
+	Offset     int64     `json:"offset,omitempty"`
+	Shift      int64     `json:"shift,omitempty"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+}
+
+// ResetResult is the offset a partition was reset from and to. Previous is
+// -1 if the group had not committed an offset.
+type ResetResult struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Previous  int64  `json:"previous"`
+	Offset    int64  `json:"offset"`
+}
+
+// position is where the reset moves a partition with the committed offset
+// previous to, or nil for a shift from no committed offset.
+func (r OffsetReset) position(previous int64) (*Position, error) {
+	switch r.To {
+	case ResetEarliest:
+		return &Position{Offset: sarama.OffsetOldest}, nil
+	case ResetLatest:
+		return &Position{Offset: sarama.OffsetNewest}, nil
+	case ResetTimestamp:
+		if r.Timestamp.IsZero() {
+			return nil, fmt.Errorf("%w: a timestamp reset needs a timestamp", ErrInvalidReset)
+		}
+		return &Position{Time: r.Timestamp}, nil
+	case ResetOffset:
+		if r.Offset < 0 {
+			return nil, fmt.Errorf("%w: offset %d is negative", ErrInvalidReset, r.Offset)
+		}
+		return &Position{Offset: r.Offset}, nil
+	case ResetShift:
+		if previous < 0 {
+			return nil, nil
+		}
+		return &Position{Offset: max(previous+r.Shift, 0)}, nil
+	default:
+		return nil, fmt.Errorf("%w: to must be one of %s, %s, %s, %s or %s, got %q", ErrInvalidReset,
+			ResetEarliest, ResetLatest, ResetTimestamp, ResetOffset, ResetShift, r.To)
+	}
+}
+
+// Reset commits the offsets reset selects for the group. The group must be
+// empty, as the coordinator only accepts commits from outside the group
+// while it has no members, and its members would overwrite them anyway.
+// Otherwise the partitions must have been paused by the member, which then
+// commits the offsets itself.
+func (a *GroupAdmin) Reset(ctx context.Context, reset OffsetReset) ([]ResetResult, error) {
+	if _, err := reset.position(0); err != nil {
+		return nil, err
+	}
+	if reset.Topic == "" {
+		return nil, fmt.Errorf("%w: no topic", ErrInvalidReset)
+	}
+	desc, err := a.Describe()
+	if err != nil {
+		return nil, err
+	}
+	if !desc.Empty() && !reset.DryRun && a.member == nil {
+		return nil, fmt.Errorf("%w: group %s is %s with %d members", ErrGroupActive, a.group, desc.State, len(desc.Members))
+	}
+
+	partitions, err := a.client.Partitions(reset.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("topic %s: %w", reset.Topic, err)
+	}
+	if len(reset.Partitions) > 0 {
+		for _, p := range reset.Partitions {
+			if p < 0 || int(p) >= len(partitions) {
+				return nil, fmt.Errorf("%w: topic %s has no partition %d", ErrInvalidReset, reset.Topic, p)
+			}
+		}
+		partitions = reset.Partitions
+	}
+	current, err := a.Offsets(reset.Topic)
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[int32]int64, len(current))
+	for _, o := range current {
+		previous[o.Partition] = o.Committed
+	}
+
+	results := make([]ResetResult, 0, len(partitions))
+	for _, partition := range partitions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p, err := reset.position(previous[partition])
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, fmt.Errorf("%w: partition %d has no committed offset to shift", ErrInvalidReset, partition)
+		}
+		oldest, err := a.client.GetOffset(reset.Topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		newest, err := a.client.GetOffset(reset.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := resolvePosition(a.client, reset.Topic, partition, *p, oldest, newest)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ResetResult{Topic: reset.Topic, Partition: partition, Previous: previous[partition], Offset: offset})
+	}
+	switch {
+	case reset.DryRun:
+		return results, nil
+	case desc.Empty():
+		return results, a.commit(results)
+	}
+	if err := a.member.ResetPaused(results); err != nil {
+		return nil, fmt.Errorf("%w: group %s is %s with %d members, %w", ErrGroupActive, a.group, desc.State, len(desc.Members), err)
+	}
+	return results, nil
+}
+
+// commit commits the offsets of results as a client outside the group.
+func (a *GroupAdmin) commit(results []ResetResult) error {
+	coordinator, err := a.coordinator()
+	if err != nil {
+		return err
+	}
+	request := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           a.group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	for _, r := range results {
+		request.AddBlock(r.Topic, r.Partition, r.Offset, 0, "")
+	}
+	resp, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for topic, partitions := range resp.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				errs = append(errs, fmt.Errorf("topic %s partition %d: %w", topic, partition, kerr))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
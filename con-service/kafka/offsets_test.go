@@ -0,0 +1,182 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+type fakeGroupAdmin struct {
+	state     string
+	members   map[string]*sarama.GroupMemberDescription
+	committed map[int32]int64
+}
+
+func (a *fakeGroupAdmin) DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error) {
+	return []*sarama.GroupDescription{{GroupId: groups[0], State: a.state, Protocol: "sticky", Members: a.members}}, nil
+}
+
+func (a *fakeGroupAdmin) ListConsumerGroupOffsets(_ string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	resp := &sarama.OffsetFetchResponse{}
+	if topicPartitions == nil {
+		topicPartitions = map[string][]int32{"dice-rolls": {0, 1}}
+	}
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			offset, ok := a.committed[partition]
+			if !ok {
+				offset = -1
+			}
+			resp.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: offset})
+		}
+	}
+	return resp, nil
+}
+
+type fakeCommitter struct {
+	commits int
+	err     sarama.KError
+}
+
+func (c *fakeCommitter) CommitOffset(request *sarama.OffsetCommitRequest) (*sarama.OffsetCommitResponse, error) {
+	c.commits++
+	if request.ConsumerGroupGeneration != sarama.GroupGenerationUndefined || request.ConsumerID != "" {
+		return nil, errors.New("committed as a member of the group")
+	}
+	resp := &sarama.OffsetCommitResponse{}
+	resp.AddError("dice-rolls", 0, c.err)
+	return resp, nil
+}
+
+// memberAssignment encodes the assignment of topic's partitions the way the
+// group leader sends it.
+func memberAssignment(topic string, partitions ...int32) []byte {
+	b := binary.BigEndian.AppendUint16(nil, 0)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(topic)))
+	b = append(b, topic...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(partitions)))
+	for _, p := range partitions {
+		b = binary.BigEndian.AppendUint32(b, uint32(p))
+	}
+	return binary.BigEndian.AppendUint32(b, 0xffffffff)
+}
+
+func newTestGroupAdmin(state string, committed map[int32]int64) (*GroupAdmin, *fakeCommitter) {
+	committer := &fakeCommitter{}
+	return &GroupAdmin{
+		client: newFakeTopic(2, 10),
+		admin:  &fakeGroupAdmin{state: state, committed: committed},
+		group:  "con-service",
+		coordinator: func() (offsetCommitter, error) {
+			return committer, nil
+		},
+	}, committer
+}
+
+func TestGroupAdminDescribe(t *testing.T) {
+	a, _ := newTestGroupAdmin("Stable", nil)
+	a.admin.(*fakeGroupAdmin).members = map[string]*sarama.GroupMemberDescription{
+		"member-2": {ClientId: "con-service", ClientHost: "/10.0.0.2", MemberAssignment: memberAssignment("dice-rolls", 1, 0)},
+		"member-1": {ClientId: "con-service", ClientHost: "/10.0.0.1"},
+	}
+	desc, err := a.Describe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Empty() || len(desc.Members) != 2 || desc.Members[0].MemberID != "member-1" {
+		t.Fatalf("got %+v", desc)
+	}
+	if got := desc.Members[1].Assignment["dice-rolls"]; len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Errorf("got assignment %v of member-2, want partitions 0 and 1", got)
+	}
+}
+
+func TestGroupAdminOffsets(t *testing.T) {
+	a, _ := newTestGroupAdmin("Empty", map[int32]int64{0: 4})
+	offsets, err := a.Offsets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GroupOffset{
+		{Topic: "dice-rolls", Partition: 0, Committed: 4, HighWaterMark: 10, Lag: 6},
+		{Topic: "dice-rolls", Partition: 1, Committed: -1, HighWaterMark: 10, Lag: -1},
+	}
+	if len(offsets) != len(want) || offsets[0] != want[0] || offsets[1] != want[1] {
+		t.Errorf("got offsets %+v, want %+v", offsets, want)
+	}
+}
+
+func TestGroupAdminReset(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		reset OffsetReset
+		want  []int64
+		err   error
+	}{
+		{name: "earliest", reset: OffsetReset{To: ResetEarliest}, want: []int64{0, 0}},
+		{name: "latest", reset: OffsetReset{To: ResetLatest, Partitions: []int32{1}}, want: []int64{10}},
+		{name: "offset", reset: OffsetReset{To: ResetOffset, Offset: 42}, want: []int64{10, 10}},
+		{name: "timestamp", reset: OffsetReset{To: ResetTimestamp, Timestamp: replayEpoch.Add(150 * time.Second)}, want: []int64{3, 3}},
+		{name: "shift", reset: OffsetReset{To: ResetShift, Shift: -6, Partitions: []int32{0}}, want: []int64{0}},
+		{name: "shift without commit", reset: OffsetReset{To: ResetShift, Shift: 1}, err: ErrInvalidReset},
+		{name: "unknown partition", reset: OffsetReset{To: ResetEarliest, Partitions: []int32{2}}, err: ErrInvalidReset},
+		{name: "unknown strategy", reset: OffsetReset{To: "soon"}, err: ErrInvalidReset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, committer := newTestGroupAdmin("Empty", map[int32]int64{0: 4})
+			tt.reset.Topic = "dice-rolls"
+			results, err := a.Reset(ctx, tt.reset)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if committer.commits != 0 {
+					t.Error("got offsets committed for an invalid reset")
+				}
+				return
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got results %+v, want offsets %v", results, tt.want)
+			}
+			for i, r := range results {
+				if r.Offset != tt.want[i] {
+					t.Errorf("got partition %d reset to %d, want %d", r.Partition, r.Offset, tt.want[i])
+				}
+			}
+			if results[0].Partition == 0 && results[0].Previous != 4 {
+				t.Errorf("got previous offset %d, want 4", results[0].Previous)
+			}
+			if committer.commits != 1 {
+				t.Errorf("got %d commits, want 1", committer.commits)
+			}
+		})
+	}
+}
+
+func TestGroupAdminResetActiveGroup(t *testing.T) {
+	ctx := context.Background()
+	a, committer := newTestGroupAdmin("Stable", map[int32]int64{0: 4})
+	if _, err := a.Reset(ctx, OffsetReset{Topic: "dice-rolls", To: ResetEarliest}); !errors.Is(err, ErrGroupActive) {
+		t.Fatalf("got %v resetting a stable group, want ErrGroupActive", err)
+	}
+	results, err := a.Reset(ctx, OffsetReset{Topic: "dice-rolls", To: ResetEarliest, DryRun: true})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("got %+v, %v for a dry run", results, err)
+	}
+	if committer.commits != 0 {
+		t.Error("got offsets committed while the group is active")
+	}
+
+	a, committer = newTestGroupAdmin("Empty", nil)
+	committer.err = sarama.ErrOffsetMetadataTooLarge
+	if _, err := a.Reset(ctx, OffsetReset{Topic: "dice-rolls", To: ResetEarliest}); !errors.Is(err, sarama.ErrOffsetMetadataTooLarge) {
+		t.Errorf("got %v, want the partition error of the commit", err)
+	}
+}
@@ -0,0 +1,232 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+var ErrNotPaused = errors.New("partitions are not paused by this consumer")
+
+// PauseStatus is what the consumer has paused: every partition it is or will
+// be assigned if All is set, except the partitions in Resumed, and the
+// partitions in Partitions.
+type PauseStatus struct {
+	All        bool               `json:"all"`
+	Partitions map[string][]int32 `json:"partitions"`
+	Resumed    map[string][]int32 `json:"resumed,omitempty"`
+}
+
+// pauseState is the set of paused partitions. It outlives sessions, as
+// sarama forgets what was paused when partitions are claimed again.
+type pauseState struct {
+	all     bool
+	paused  map[topicPartition]bool
+	resumed map[topicPartition]bool
+	// wake is closed and replaced whenever partitions are resumed.
+	wake chan struct{}
+}
+
+func (s *pauseState) isPaused(tp topicPartition) bool {
+	return s.paused[tp] || (s.all && !s.resumed[tp])
+}
+
+// Pause stops the consumption of partitions, a map of topics to partitions,
+// or of every partition if it is empty. A topic without partitions stands
+// for all of its partitions. Fetching from the partitions stops and the
+// messages already fetched wait until they are resumed, while the consumer
+// stays in the group, so pausing does not trigger a rebalance. Partitions
+// stay paused across rebalances, and partitions assigned later are paused
+// too if every partition is.
+func (consumer *Consumer) Pause(partitions map[string][]int32) error {
+	selected, err := consumer.selectPartitions(partitions)
+	if err != nil {
+		return err
+	}
+	consumer.pauseMu.Lock()
+	s := &consumer.pause
+	if selected == nil {
+		s.all, s.paused, s.resumed = true, nil, nil
+	} else {
+		if s.paused == nil {
+			s.paused = make(map[topicPartition]bool)
+		}
+		for _, tp := range selected {
+			s.paused[tp] = true
+			delete(s.resumed, tp)
+		}
+	}
+	consumer.pauseMu.Unlock()
+
+	if consumer.group != nil {
+		if selected == nil {
+			consumer.group.PauseAll()
+		} else {
+			consumer.group.Pause(partitionMap(selected))
+		}
+	}
+	logger.Get().Info("paused consumption", zap.Any("partitions", partitions))
+	return nil
+}
+
+// Resume resumes the consumption of partitions as selected for Pause, or of
+// every partition if it is empty.
+func (consumer *Consumer) Resume(partitions map[string][]int32) error {
+	selected, err := consumer.selectPartitions(partitions)
+	if err != nil {
+		return err
+	}
+	consumer.pauseMu.Lock()
+	s := &consumer.pause
+	if selected == nil {
+		s.all, s.paused, s.resumed = false, nil, nil
+	} else {
+		for _, tp := range selected {
+			delete(s.paused, tp)
+			if s.all {
+				if s.resumed == nil {
+					s.resumed = make(map[topicPartition]bool)
+				}
+				s.resumed[tp] = true
+			}
+		}
+	}
+	if s.wake != nil {
+		close(s.wake)
+		s.wake = nil
+	}
+	consumer.pauseMu.Unlock()
+
+	if consumer.group != nil {
+		if selected == nil {
+			consumer.group.ResumeAll()
+		} else {
+			consumer.group.Resume(partitionMap(selected))
+		}
+	}
+	logger.Get().Info("resumed consumption", zap.Any("partitions", partitions))
+	return nil
+}
+
+// Paused returns what the consumer has paused.
+func (consumer *Consumer) Paused() PauseStatus {
+	consumer.pauseMu.Lock()
+	defer consumer.pauseMu.Unlock()
+	s := &consumer.pause
+	status := PauseStatus{All: s.all, Partitions: map[string][]int32{}}
+	for tp := range s.paused {
+		status.Partitions[tp.topic] = append(status.Partitions[tp.topic], tp.partition)
+	}
+	for tp := range s.resumed {
+		if status.Resumed == nil {
+			status.Resumed = map[string][]int32{}
+		}
+		status.Resumed[tp.topic] = append(status.Resumed[tp.topic], tp.partition)
+	}
+	for _, m := range []map[string][]int32{status.Partitions, status.Resumed} {
+		for _, partitions := range m {
+			sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		}
+	}
+	return status
+}
+
+func (consumer *Consumer) isPaused(topic string, partition int32) bool {
+	consumer.pauseMu.Lock()
+	defer consumer.pauseMu.Unlock()
+	return consumer.pause.isPaused(topicPartition{topic, partition})
+}
+
+// waitResumed blocks while the partition is paused, until ctx is done.
+func (consumer *Consumer) waitResumed(ctx context.Context, topic string, partition int32) error {
+	for {
+		consumer.pauseMu.Lock()
+		if !consumer.pause.isPaused(topicPartition{topic, partition}) {
+			consumer.pauseMu.Unlock()
+			return nil
+		}
+		if consumer.pause.wake == nil {
+			consumer.pause.wake = make(chan struct{})
+		}
+		wake := consumer.pause.wake
+		consumer.pauseMu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// repause pauses the fetching of a new claim of a paused partition, which
+// sarama starts unpaused.
+func (consumer *Consumer) repause(claim sarama.ConsumerGroupClaim) {
+	if consumer.group != nil && consumer.isPaused(claim.Topic(), claim.Partition()) {
+		consumer.group.Pause(map[string][]int32{claim.Topic(): {claim.Partition()}})
+	}
+}
+
+// selectPartitions lists the partitions of the topics of partitions, or
+// returns nil if it selects every partition.
+func (consumer *Consumer) selectPartitions(partitions map[string][]int32) ([]topicPartition, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	selected := []topicPartition{}
+	for topic, ps := range partitions {
+		if _, ok := consumer.routes[topic]; !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownTopic, topic)
+		}
+		if len(ps) == 0 {
+			var err error
+			if ps, err = consumer.client.Partitions(topic); err != nil {
+				return nil, fmt.Errorf("topic %s: %w", topic, err)
+			}
+		}
+		for _, p := range ps {
+			selected = append(selected, topicPartition{topic, p})
+		}
+	}
+	return selected, nil
+}
+
+func partitionMap(tps []topicPartition) map[string][]int32 {
+	m := make(map[string][]int32)
+	for _, tp := range tps {
+		m[tp.topic] = append(m[tp.topic], tp.partition)
+	}
+	return m
+}
+
+// ResetPaused commits the offsets of results, which must all be partitions
+// the consumer has claimed and paused, through its session. It then ends the
+// session, as sarama keeps fetching from where it was, so that the consumer
+// rejoins the group and its claims restart from the new offsets. Rejoining
+// rebalances the group; the partitions stay paused.
+func (consumer *Consumer) ResetPaused(results []ResetResult) error {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	if consumer.session == nil {
+		return fmt.Errorf("%w: not a member of the group", ErrNotPaused)
+	}
+	for _, r := range results {
+		if _, ok := consumer.claims[r.Topic][r.Partition]; !ok || !consumer.isPaused(r.Topic, r.Partition) {
+			return fmt.Errorf("%w: %s partition %d", ErrNotPaused, r.Topic, r.Partition)
+		}
+	}
+	for _, r := range results {
+		consumer.session.ResetOffset(r.Topic, r.Partition, r.Offset, "")
+	}
+	consumer.session.Commit()
+	logger.Get().Info("reset offsets of paused partitions, rejoining the group", zap.Any("results", results))
+	if consumer.rejoin != nil {
+		consumer.rejoin()
+	}
+	return nil
+}
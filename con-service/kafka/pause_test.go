@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type resetSession struct {
+	fakeSession
+	resets  map[int32]int64
+	commits int
+}
+
+func (s *resetSession) ResetOffset(_ string, partition int32, offset int64, _ string) {
+	s.resets[partition] = offset
+}
+
+func (s *resetSession) Commit() { s.commits++ }
+
+type fakeResetter struct {
+	results []ResetResult
+	err     error
+}
+
+func (r *fakeResetter) ResetPaused(results []ResetResult) error {
+	r.results = results
+	return r.err
+}
+
+func TestPause(t *testing.T) {
+	tests := []struct {
+		name   string
+		pause  map[string][]int32
+		resume map[string][]int32
+		paused []int32
+	}{
+		{name: "all", paused: []int32{0, 1}},
+		{name: "all but resumed", resume: map[string][]int32{"dice-rolls": {1}}, paused: []int32{0}},
+		{name: "partition", pause: map[string][]int32{"dice-rolls": {1}}, paused: []int32{1}},
+		{name: "topic", pause: map[string][]int32{"dice-rolls": nil}, paused: []int32{0, 1}},
+		{name: "resumed", pause: map[string][]int32{"dice-rolls": {0}}, resume: map[string][]int32{"dice-rolls": {0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := newTestConsumer(nil)
+			consumer.client = newFakeTopic(2, 1)
+			if err := consumer.Pause(tt.pause); err != nil {
+				t.Fatal(err)
+			}
+			if tt.resume != nil {
+				if err := consumer.Resume(tt.resume); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var paused []int32
+			for _, p := range []int32{0, 1} {
+				if consumer.isPaused("dice-rolls", p) {
+					paused = append(paused, p)
+				}
+			}
+			if len(paused) != len(tt.paused) || (len(paused) > 0 && paused[0] != tt.paused[0]) {
+				t.Errorf("got partitions %v paused, want %v", paused, tt.paused)
+			}
+		})
+	}
+
+	consumer := newTestConsumer(nil)
+	if err := consumer.Pause(map[string][]int32{"unknown": nil}); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("got %v pausing an unknown topic, want ErrUnknownTopic", err)
+	}
+}
+
+func TestWaitResumed(t *testing.T) {
+	consumer := newTestConsumer(nil)
+	if err := consumer.Pause(map[string][]int32{"dice-rolls": {0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.waitResumed(context.Background(), "dice-rolls", 1); err != nil {
+		t.Errorf("got %v waiting for a partition that is not paused", err)
+	}
+
+	resumed := make(chan error, 1)
+	go func() {
+		resumed <- consumer.waitResumed(context.Background(), "dice-rolls", 0)
+	}()
+	select {
+	case err := <-resumed:
+		t.Fatalf("got %v before the partition was resumed", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := consumer.Resume(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-resumed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after the partition was resumed")
+	}
+
+	consumer.Pause(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := consumer.waitResumed(ctx, "dice-rolls", 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestPausedStatus(t *testing.T) {
+	consumer := newTestConsumer(nil)
+	consumer.joined(&fakeSession{claims: map[string][]int32{"dice-rolls": {0, 1}}})
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 0, initial: 2, hwm: 9})
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 1, initial: 5, hwm: 9})
+	consumer.Pause(map[string][]int32{"dice-rolls": {1}})
+
+	status := consumer.Status()
+	if len(status.Claims) != 2 || status.Claims[0].Paused || !status.Claims[1].Paused {
+		t.Fatalf("got claims %+v, want partition 1 paused", status.Claims)
+	}
+	if lag := status.ActiveLag(); lag != 7 {
+		t.Errorf("active lag = %d, want 7", lag)
+	}
+	if paused := consumer.Paused(); paused.All || len(paused.Partitions["dice-rolls"]) != 1 {
+		t.Errorf("got %+v", paused)
+	}
+}
+
+func TestResetPaused(t *testing.T) {
+	consumer := newTestConsumer(nil)
+	results := []ResetResult{{Topic: "dice-rolls", Partition: 0, Offset: 3}}
+	if err := consumer.ResetPaused(results); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("got %v outside the group, want ErrNotPaused", err)
+	}
+
+	session := &resetSession{fakeSession: fakeSession{claims: map[string][]int32{"dice-rolls": {0}}}, resets: make(map[int32]int64)}
+	consumer.joined(session)
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 0, initial: 5, hwm: 9})
+	if err := consumer.ResetPaused(results); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("got %v for a partition that is not paused, want ErrNotPaused", err)
+	}
+	consumer.Pause(nil)
+	if err := consumer.ResetPaused([]ResetResult{{Topic: "dice-rolls", Partition: 1}}); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("got %v for a partition that is not claimed, want ErrNotPaused", err)
+	}
+	if len(session.resets) != 0 {
+		t.Fatalf("got offsets %v reset after failures", session.resets)
+	}
+
+	rejoined := false
+	consumer.rejoin = func() { rejoined = true }
+	if err := consumer.ResetPaused(results); err != nil {
+		t.Fatal(err)
+	}
+	if session.resets[0] != 3 || session.commits != 1 || !rejoined {
+		t.Errorf("got resets %v, %d commits, rejoined %t", session.resets, session.commits, rejoined)
+	}
+}
+
+func TestGroupAdminResetPaused(t *testing.T) {
+	ctx := context.Background()
+	a, committer := newTestGroupAdmin("Stable", map[int32]int64{0: 4})
+	member := &fakeResetter{}
+	a.member = member
+
+	results, err := a.Reset(ctx, OffsetReset{Topic: "dice-rolls", To: ResetEarliest, Partitions: []int32{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(member.results) != 1 || member.results[0] != results[0] || committer.commits != 0 {
+		t.Errorf("got %+v reset by the member and %d commits", member.results, committer.commits)
+	}
+
+	member.err = ErrNotPaused
+	if _, err := a.Reset(ctx, OffsetReset{Topic: "dice-rolls", To: ResetEarliest}); !errors.Is(err, ErrGroupActive) || !errors.Is(err, ErrNotPaused) {
+		t.Errorf("got %v, want ErrGroupActive and ErrNotPaused", err)
+	}
+}
@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+)
+
+// workerQueueSize is how many messages may wait for each worker of a claim.
+const workerQueueSize = 16
+
+// consumeConcurrently processes the messages of claim with a pool of
+// consumer.concurrency workers. Messages are assigned to a worker by key, so
+// the messages of a key are processed one after the other in offset order,
+// while other keys are processed in parallel. Messages without a key have no
+// order to keep and are spread over the workers. As messages complete out of
+// order, the offset marked is the watermark: the lowest offset not processed
+// yet. Messages are handled with ctx, see drainContext.
+func (consumer *Consumer) consumeConcurrently(ctx context.Context, session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	revoked := session.Context()
+	marks := &watermark{}
+	queues := make([]chan *sarama.ConsumerMessage, consumer.concurrency)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queue := make(chan *sarama.ConsumerMessage, workerQueueSize)
+		queues[i] = queue
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range queue {
+				// Once the session has ended, the remaining messages are left
+				// unmarked for the next owner of the partition.
+				if revoked.Err() != nil {
+					continue
+				}
+				if err := consumer.deliver(ctx, revoked, message); err != nil {
+					continue
+				}
+				marks.done(message.Offset, func(next int64) {
+					session.MarkOffset(message.Topic, message.Partition, next, "")
+					consumer.consumed(message.Topic, message.Partition, next)
+					consumer.commit(session)
+				})
+			}
+		}()
+	}
+	// Workers finish their current message before the claim is released.
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		if revoked.Err() != nil {
+			return nil
+		}
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				logger.Get().Info("message channel was closed")
+				return nil
+			}
+			marks.add(message.Offset)
+			select {
+			case queues[workerFor(message, len(queues))] <- message:
+			case <-revoked.Done():
+				return nil
+			}
+		case <-revoked.Done():
+			return nil
+		}
+	}
+}
+
+// workerFor returns the index of the worker out of n that processes message.
+func workerFor(message *sarama.ConsumerMessage, n int) int {
+	if len(message.Key) == 0 {
+		return int(message.Offset % int64(n))
+	}
+	h := fnv.New32a()
+	h.Write(message.Key)
+	return int(h.Sum32() % uint32(n))
+}
+
+// watermark tracks the offsets of a partition in flight and the offset below
+// which all of them have been processed.
+type watermark struct {
+	mu       sync.Mutex
+	pending  []int64 // offsets in flight, in the order they were read
+	complete map[int64]bool
+}
+
+// add records that the message at offset is in flight. Offsets must be added
+// in increasing order.
+func (w *watermark) add(offset int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, offset)
+}
+
+// done records that the message at offset has been processed. If that moves
+// the watermark, advanced is called with the new one while the watermark is
+// locked, so successive calls see increasing offsets.
+func (w *watermark) done(offset int64, advanced func(next int64)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.complete == nil {
+		w.complete = make(map[int64]bool)
+	}
+	w.complete[offset] = true
+	next := int64(-1)
+	for len(w.pending) > 0 && w.complete[w.pending[0]] {
+		delete(w.complete, w.pending[0])
+		next = w.pending[0] + 1
+		w.pending = w.pending[1:]
+	}
+	if next >= 0 {
+		advanced(next)
+	}
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestWatermark(t *testing.T) {
+	w := &watermark{}
+	for offset := int64(10); offset < 15; offset++ {
+		w.add(offset)
+	}
+	var marked []int64
+	mark := func(next int64) { marked = append(marked, next) }
+	for _, offset := range []int64{12, 10, 11, 14, 13} {
+		w.done(offset, mark)
+	}
+	want := []int64{11, 13, 15}
+	if len(marked) != len(want) {
+		t.Fatalf("marked %v, want %v", marked, want)
+	}
+	for i := range want {
+		if marked[i] != want[i] {
+			t.Errorf("marked %v, want %v", marked, want)
+		}
+	}
+}
+
+type channelClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *channelClaim) Topic() string                            { return "dice-rolls" }
+func (c *channelClaim) Partition() int32                         { return 0 }
+func (c *channelClaim) InitialOffset() int64                     { return 0 }
+func (c *channelClaim) HighWaterMarkOffset() int64               { return 20 }
+func (c *channelClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumeConcurrently(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		seen     = make(map[string][]int64)
+		inFlight int
+		peak     int
+	)
+	consumer := newTestConsumer(HandlerFunc(func(_ context.Context, msg *Message) error {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		// Earlier messages take longer, so they would finish last if the
+		// messages of a key were not processed in order.
+		time.Sleep(time.Duration(20-msg.Offset) * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		seen[string(msg.Key)] = append(seen[string(msg.Key)], msg.Offset)
+		mu.Unlock()
+		return nil
+	}))
+	consumer.concurrency = 4
+
+	claim := &channelClaim{messages: make(chan *sarama.ConsumerMessage, 20)}
+	keys := []string{"table-1", "table-2", "table-3"}
+	for offset := int64(0); offset < 20; offset++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "dice-rolls", Offset: offset, Key: []byte(keys[offset%3]), Value: []byte(testRoll)}
+	}
+	close(claim.messages)
+
+	session := &commitSession{ctx: context.Background()}
+	if err := consumer.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, offsets := range seen {
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] < offsets[i-1] {
+				t.Errorf("messages of %s processed out of order: %v", key, offsets)
+			}
+		}
+	}
+	if peak < 2 {
+		t.Errorf("expected keys to be processed in parallel, at most %d were", peak)
+	}
+	for i := 1; i < len(session.marked); i++ {
+		if session.marked[i] <= session.marked[i-1] {
+			t.Errorf("marked offsets did not increase: %v", session.marked)
+		}
+	}
+	if last := session.marked[len(session.marked)-1]; last != 20 {
+		t.Errorf("marked up to %d, want 20", last)
+	}
+	if status := consumer.Status(); status.Claims[0].Offset != 20 {
+		t.Errorf("status offset %d, want 20", status.Claims[0].Offset)
+	}
+}
+
+func TestWorkerFor(t *testing.T) {
+	a := &sarama.ConsumerMessage{Key: []byte("table-1"), Offset: 1}
+	b := &sarama.ConsumerMessage{Key: []byte("table-1"), Offset: 2}
+	if workerFor(a, 8) != workerFor(b, 8) {
+		t.Error("messages with the same key must go to the same worker")
+	}
+	if workerFor(&sarama.ConsumerMessage{Offset: 1}, 8) == workerFor(&sarama.ConsumerMessage{Offset: 2}, 8) {
+		t.Error("messages without a key should be spread over the workers")
+	}
+}
@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	RebalanceAssigned = "assigned"
+	RebalanceRevoked  = "revoked"
+)
+
+// PartitionListener is implemented by handlers that keep state per
+// partition. OnAssigned is called before the first message of the partitions
+// of topic is handled, and OnRevoked once the last in-flight message has been
+// handled and before the offsets are committed. Handlers registered for a
+// topic with retry topics are called for those topics too. An error from
+// OnAssigned ends the session.
+type PartitionListener interface {
+	OnAssigned(ctx context.Context, topic string, partitions []int32) error
+	OnRevoked(ctx context.Context, topic string, partitions []int32) error
+}
+
+// drainContext returns the context messages are handled with during a
+// session. It is cancelled DrainTimeout after the session ends, so that the
+// handlers of in-flight messages can complete when partitions are revoked.
+func (consumer *Consumer) drainContext(session context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(session))
+	stop := context.AfterFunc(session, func() {
+		timer := time.NewTimer(consumer.drainTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		cancel()
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// assigned records the partitions assigned in a new session and lets the
+// handlers load their state.
+func (consumer *Consumer) assigned(session sarama.ConsumerGroupSession) error {
+	ctx, span := consumer.startRebalanceSpan(session.Context(), RebalanceAssigned, session, time.Now())
+	defer span.End()
+	consumer.recordRebalance(ctx, RebalanceAssigned, session.Claims())
+
+	var errs []error
+	for _, topic := range sortedTopics(session.Claims()) {
+		if listener, ok := consumer.routes[topic].handler.(PartitionListener); ok {
+			if err := listener.OnAssigned(ctx, topic, session.Claims()[topic]); err != nil {
+				errs = append(errs, fmt.Errorf("failed to assign %s: %w", topic, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// revoked commits the offsets marked in a session that ended and lets the
+// handlers flush their state. By then ConsumeClaim has stopped pulling
+// messages and waited for the ones in flight.
+func (consumer *Consumer) revoked(session sarama.ConsumerGroupSession) error {
+	consumer.mu.Lock()
+	start := consumer.revokedAt
+	consumer.mu.Unlock()
+	if start.IsZero() {
+		start = time.Now()
+	}
+	ctx, span := consumer.startRebalanceSpan(context.Background(), RebalanceRevoked, session, start)
+	defer span.End()
+	consumer.recordRebalance(ctx, RebalanceRevoked, session.Claims())
+
+	var errs []error
+	for _, topic := range sortedTopics(session.Claims()) {
+		if listener, ok := consumer.routes[topic].handler.(PartitionListener); ok {
+			if err := listener.OnRevoked(ctx, topic, session.Claims()[topic]); err != nil {
+				errs = append(errs, fmt.Errorf("failed to revoke %s: %w", topic, err))
+			}
+		}
+	}
+	consumer.commitNow(session)
+	consumer.metrics.DrainDuration.Record(ctx, time.Since(start).Seconds())
+	logger.Get().Info("partitions revoked", zap.String("member_id", session.MemberID()), zap.Int32("generation_id", session.GenerationID()), zap.Any("claims", session.Claims()), zap.Duration("drain", time.Since(start)))
+
+	if err := errors.Join(errs...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// watchRevoke records when the session ends, which is when the partitions
+// are revoked and draining starts.
+func (consumer *Consumer) watchRevoke(session sarama.ConsumerGroupSession) {
+	consumer.mu.Lock()
+	consumer.revokedAt = time.Time{}
+	consumer.mu.Unlock()
+	context.AfterFunc(session.Context(), func() {
+		consumer.mu.Lock()
+		consumer.revokedAt = time.Now()
+		consumer.mu.Unlock()
+	})
+}
+
+func (consumer *Consumer) startRebalanceSpan(ctx context.Context, event string, session sarama.ConsumerGroupSession, start time.Time) (context.Context, trace.Span) {
+	var partitions []string
+	for _, topic := range sortedTopics(session.Claims()) {
+		for _, partition := range session.Claims()[topic] {
+			partitions = append(partitions, fmt.Sprintf("%s/%d", topic, partition))
+		}
+	}
+	return tracer.Start(ctx, "consumer group "+event,
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingKafkaConsumerGroup(consumer.groupID),
+			attribute.String("kafka.rebalance.event", event),
+			attribute.String("kafka.member.id", session.MemberID()),
+			attribute.Int("kafka.generation.id", int(session.GenerationID())),
+			attribute.StringSlice("kafka.partitions", partitions),
+		),
+	)
+}
+
+func (consumer *Consumer) recordRebalance(ctx context.Context, event string, claims map[string][]int32) {
+	consumer.metrics.Rebalances.Add(ctx, 1, metric.WithAttributes(attribute.String("kafka.rebalance.event", event)))
+	for topic, partitions := range claims {
+		consumer.metrics.Partitions.Add(ctx, int64(len(partitions)), metric.WithAttributes(
+			attribute.String("kafka.rebalance.event", event),
+			semconv.MessagingDestinationName(topic),
+		))
+	}
+}
+
+func sortedTopics(claims map[string][]int32) []string {
+	topics := make([]string, 0, len(claims))
+	for topic := range claims {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
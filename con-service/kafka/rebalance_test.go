@@ -0,0 +1,146 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type rebalanceSession struct {
+	commitSession
+	claims map[string][]int32
+}
+
+func (s *rebalanceSession) Claims() map[string][]int32 { return s.claims }
+func (s *rebalanceSession) MemberID() string           { return "member-1" }
+func (s *rebalanceSession) GenerationID() int32        { return 2 }
+
+type statefulHandler struct {
+	HandlerFunc
+	assigned map[string][]int32
+	revoked  map[string][]int32
+}
+
+func (h *statefulHandler) OnAssigned(_ context.Context, topic string, partitions []int32) error {
+	h.assigned[topic] = partitions
+	return nil
+}
+
+func (h *statefulHandler) OnRevoked(_ context.Context, topic string, partitions []int32) error {
+	h.revoked[topic] = partitions
+	return nil
+}
+
+func TestDrainContext(t *testing.T) {
+	consumer := &Consumer{drainTimeout: 20 * time.Millisecond}
+	session, end := context.WithCancel(context.Background())
+	ctx, cancel := consumer.drainContext(session)
+	defer cancel()
+
+	end()
+	select {
+	case <-ctx.Done():
+		t.Fatal("handler context cancelled as soon as the session ended")
+	case <-time.After(5 * time.Millisecond):
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("handler context not cancelled after the drain timeout")
+	}
+}
+
+func TestRevokeDrainsInFlightMessage(t *testing.T) {
+	session, revoke := context.WithCancel(context.Background())
+	handled := make(chan error, 2)
+	consumer := newTestConsumer(HandlerFunc(func(ctx context.Context, msg *Message) error {
+		if msg.Offset == 0 {
+			// The partition is revoked while the first message is handled.
+			revoke()
+			time.Sleep(10 * time.Millisecond)
+		}
+		handled <- ctx.Err()
+		return ctx.Err()
+	}))
+	consumer.drainTimeout = time.Second
+
+	claim := &channelClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- testMessage(0)
+	claim.messages <- testMessage(1)
+	s := &commitSession{ctx: session}
+	if err := consumer.ConsumeClaim(s, claim); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("handled %d messages, want only the one in flight", len(handled))
+	}
+	if err := <-handled; err != nil {
+		t.Errorf("in-flight handler was cancelled: %v", err)
+	}
+	if len(s.marked) != 1 || s.marked[0] != 1 {
+		t.Errorf("marked %v, want [1]", s.marked)
+	}
+}
+
+func TestRebalanceHooks(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	otel.SetMeterProvider(provider)
+
+	handler := &statefulHandler{
+		HandlerFunc: func(context.Context, *Message) error { return nil },
+		assigned:    make(map[string][]int32),
+		revoked:     make(map[string][]int32),
+	}
+	consumer := newTestConsumer(handler)
+	consumer.commitMode = CommitManual
+
+	ctx, end := context.WithCancel(context.Background())
+	session := &rebalanceSession{
+		commitSession: commitSession{ctx: ctx},
+		claims:        map[string][]int32{"dice-rolls": {0, 2}},
+	}
+	if err := consumer.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+	if got := handler.assigned["dice-rolls"]; len(got) != 2 || got[1] != 2 {
+		t.Errorf("assigned %v, want [0 2]", got)
+	}
+	session.MarkOffset("dice-rolls", 0, 5, "")
+	end()
+	if err := consumer.Cleanup(session); err != nil {
+		t.Fatal(err)
+	}
+	if got := handler.revoked["dice-rolls"]; len(got) != 2 {
+		t.Errorf("revoked %v, want [0 2]", got)
+	}
+	if len(session.committed) != 1 || session.committed[0] != 5 {
+		t.Errorf("committed %v on revoke, want [5]", session.committed)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	partitions := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "kafka.consumer.rebalance.partitions" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				event, _ := dp.Attributes.Value("kafka.rebalance.event")
+				partitions[event.AsString()] += dp.Value
+			}
+		}
+	}
+	if partitions[RebalanceAssigned] != 2 || partitions[RebalanceRevoked] != 2 {
+		t.Errorf("unexpected partition counts %v", partitions)
+	}
+}
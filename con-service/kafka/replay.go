@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidPosition = errors.New("invalid position")
+
+// Position is where a replay starts or ends in a partition: an offset, such
+// as sarama.OffsetOldest or sarama.OffsetNewest, or, if Time is set, the
+// first offset whose record timestamp is at or after Time.
+type Position struct {
+	Offset int64
+	Time   time.Time
+}
+
+// ParsePosition reads "oldest", "newest", an offset, an RFC 3339 timestamp or
+// a duration, which is the time that long before now.
+func ParsePosition(s string, now time.Time) (Position, error) {
+	switch s {
+	case "oldest":
+		return Position{Offset: sarama.OffsetOldest}, nil
+	case "newest":
+		return Position{Offset: sarama.OffsetNewest}, nil
+	}
+	if offset, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if offset < 0 {
+			return Position{}, fmt.Errorf("%w %q, offsets cannot be negative", ErrInvalidPosition, s)
+		}
+		return Position{Offset: offset}, nil
+	}
+	if at, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return Position{Time: at}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return Position{Time: now.Add(-d)}, nil
+	}
+	return Position{}, fmt.Errorf("%w %q, must be oldest, newest, an offset, an RFC 3339 time or a duration", ErrInvalidPosition, s)
+}
+
+// ReplayOptions selects the messages of a replay. The messages of Topic from
+// From up to, but not including, To are replayed. Only the partitions in
+// Partitions are read, all of them if it is empty, and only the messages
+// with every header in Headers and, if it is set, the key Key are handled.
+type ReplayOptions struct {
+	Topic      string
+	From       Position
+	To         Position
+	Partitions []int32
+	Headers    map[string]string
+	Key        string
+}
+
+// ReplayResult counts the messages of a replay: read from the topic, matching
+// the filters, and handled successfully or not.
+type ReplayResult struct {
+	Read    int `json:"read"`
+	Matched int `json:"matched"`
+	Handled int `json:"handled"`
+	Failed  int `json:"failed"`
+}
+
+// Replay passes the messages selected by opts to handler, one partition
+// after another in offset order. It reads the partitions directly rather than
+// as a member of a consumer group, so the offsets committed by the live
+// consumers are left alone. Messages the handler fails are logged and
+// counted, but not retried. A To past the newest offset ends the replay at
+// the newest offset when it started.
+func Replay(ctx context.Context, client sarama.Client, opts ReplayOptions, handler Handler) (ReplayResult, error) {
+	reader, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+	defer reader.Close()
+	return replay(ctx, client, reader, opts, handler)
+}
+
+func replay(ctx context.Context, client sarama.Client, reader sarama.Consumer, opts ReplayOptions, handler Handler) (ReplayResult, error) {
+	var result ReplayResult
+	partitions := opts.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = client.Partitions(opts.Topic); err != nil {
+			return result, err
+		}
+	}
+	for _, partition := range partitions {
+		if err := replayPartition(ctx, client, reader, opts, partition, handler, &result); err != nil {
+			return result, fmt.Errorf("partition %d: %w", partition, err)
+		}
+	}
+	return result, nil
+}
+
+func replayPartition(ctx context.Context, client sarama.Client, reader sarama.Consumer, opts ReplayOptions, partition int32, handler Handler, result *ReplayResult) error {
+	oldest, err := client.GetOffset(opts.Topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	newest, err := client.GetOffset(opts.Topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	from, err := resolvePosition(client, opts.Topic, partition, opts.From, oldest, newest)
+	if err != nil {
+		return err
+	}
+	to, err := resolvePosition(client, opts.Topic, partition, opts.To, oldest, newest)
+	if err != nil {
+		return err
+	}
+	log := logger.Get().With(zap.String("topic", opts.Topic), zap.Int32("partition", partition))
+	if from >= to {
+		log.Info("nothing to replay", zap.Int64("from", from), zap.Int64("to", to))
+		return nil
+	}
+	log.Info("replaying partition", zap.Int64("from", from), zap.Int64("to", to))
+
+	pc, err := reader.ConsumePartition(opts.Topic, partition, from)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	for next := from; next < to; {
+		var message *sarama.ConsumerMessage
+		select {
+		case message = <-pc.Messages():
+		case cerr := <-pc.Errors():
+			return cerr
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if message.Offset >= to {
+			break
+		}
+		next = message.Offset + 1
+		result.Read++
+		if !opts.matches(message) {
+			continue
+		}
+		result.Matched++
+		if err := handleMessage(ctx, handler, message); err != nil {
+			result.Failed++
+			log.Error("failed to replay message", zap.Int64("offset", message.Offset), zap.Error(err))
+			continue
+		}
+		result.Handled++
+	}
+	return nil
+}
+
+// resolvePosition is the offset of p in the partition, between oldest and
+// newest.
+func resolvePosition(client sarama.Client, topic string, partition int32, p Position, oldest, newest int64) (int64, error) {
+	offset := p.Offset
+	if !p.Time.IsZero() {
+		var err error
+		if offset, err = client.GetOffset(topic, partition, p.Time.UnixMilli()); err != nil {
+			return 0, err
+		}
+		// No record was written at or after the time.
+		if offset < 0 {
+			return newest, nil
+		}
+	}
+	switch offset {
+	case sarama.OffsetOldest:
+		return oldest, nil
+	case sarama.OffsetNewest:
+		return newest, nil
+	}
+	return min(max(offset, oldest), newest), nil
+}
+
+// matches reports whether message passes the key and header filters.
+func (opts ReplayOptions) matches(message *sarama.ConsumerMessage) bool {
+	if opts.Key != "" && string(message.Key) != opts.Key {
+		return false
+	}
+	for key, value := range opts.Headers {
+		if header(message.Headers, key) != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+var replayEpoch = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeTopic is a topic whose record n of every partition was written n
+// minutes after replayEpoch.
+type fakeTopic struct {
+	sarama.Client
+	records map[int32][]*sarama.ConsumerMessage
+	starts  map[int32]int64
+}
+
+func newFakeTopic(partitions, records int) *fakeTopic {
+	t := &fakeTopic{records: make(map[int32][]*sarama.ConsumerMessage), starts: make(map[int32]int64)}
+	for p := range int32(partitions) {
+		for n := range records {
+			msg := &sarama.ConsumerMessage{
+				Topic: "dice-rolls", Partition: p, Offset: int64(n),
+				Key: []byte("key-" + string(rune('a'+n%2))), Value: []byte(`{}`),
+				Timestamp: replayEpoch.Add(time.Duration(n) * time.Minute),
+			}
+			if n%3 == 0 {
+				msg.Headers = []*sarama.RecordHeader{{Key: []byte("source"), Value: []byte("api")}}
+			}
+			t.records[p] = append(t.records[p], msg)
+		}
+	}
+	return t
+}
+
+func (t *fakeTopic) Partitions(string) ([]int32, error) {
+	var partitions []int32
+	for p := range int32(len(t.records)) {
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+func (t *fakeTopic) GetOffset(_ string, partition int32, at int64) (int64, error) {
+	switch at {
+	case sarama.OffsetOldest:
+		return 0, nil
+	case sarama.OffsetNewest:
+		return int64(len(t.records[partition])), nil
+	}
+	for _, msg := range t.records[partition] {
+		if msg.Timestamp.UnixMilli() >= at {
+			return msg.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// reader reads the partitions of the topic.
+func (t *fakeTopic) reader() sarama.Consumer {
+	return &fakeReader{topic: t}
+}
+
+type fakeReader struct {
+	sarama.Consumer
+	topic *fakeTopic
+}
+
+func (r *fakeReader) ConsumePartition(_ string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	t := r.topic
+	t.starts[partition] = offset
+	records := t.records[partition][offset:]
+	pc := &fakeReplayPartition{messages: make(chan *sarama.ConsumerMessage, len(records))}
+	for _, msg := range records {
+		pc.messages <- msg
+	}
+	return pc, nil
+}
+
+type fakeReplayPartition struct {
+	sarama.PartitionConsumer
+	messages chan *sarama.ConsumerMessage
+}
+
+func (pc *fakeReplayPartition) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+
+func (pc *fakeReplayPartition) Errors() <-chan *sarama.ConsumerError { return nil }
+
+func (pc *fakeReplayPartition) Close() error { return nil }
+
+func TestParsePosition(t *testing.T) {
+	for s, want := range map[string]Position{
+		"oldest":               {Offset: sarama.OffsetOldest},
+		"newest":               {Offset: sarama.OffsetNewest},
+		"42":                   {Offset: 42},
+		"2026-01-01T12:00:00Z": {Time: replayEpoch},
+		"90m":                  {Time: replayEpoch.Add(-90 * time.Minute)},
+	} {
+		got, err := ParsePosition(s, replayEpoch)
+		if err != nil || got != want {
+			t.Errorf("got %+v, %v for %s, want %+v", got, err, s, want)
+		}
+	}
+	for _, s := range []string{"", "-1", "yesterday", "-5m"} {
+		if _, err := ParsePosition(s, replayEpoch); !errors.Is(err, ErrInvalidPosition) {
+			t.Errorf("got %v for %q, want ErrInvalidPosition", err, s)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	var handled []int64
+	handler := HandlerFunc(func(_ context.Context, msg *Message) error {
+		handled = append(handled, msg.Offset)
+		if msg.Offset == 4 {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+
+	topic := newFakeTopic(2, 10)
+	result, err := replay(ctx, topic, topic.reader(), ReplayOptions{
+		Topic:      "dice-rolls",
+		From:       Position{Time: replayEpoch.Add(90 * time.Second)},
+		To:         Position{Offset: 8},
+		Partitions: []int32{1},
+	}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := topic.starts[0]; ok || topic.starts[1] != 2 {
+		t.Errorf("got partitions started at %v, want partition 1 at offset 2", topic.starts)
+	}
+	if want := (ReplayResult{Read: 6, Matched: 6, Handled: 5, Failed: 1}); result != want {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+	if len(handled) != 6 || handled[0] != 2 || handled[5] != 7 {
+		t.Errorf("got offsets %v handled, want 2 to 7", handled)
+	}
+}
+
+func TestReplayFilters(t *testing.T) {
+	ctx := context.Background()
+	var handled []int64
+	handler := HandlerFunc(func(_ context.Context, msg *Message) error {
+		handled = append(handled, msg.Offset)
+		return nil
+	})
+
+	topic := newFakeTopic(2, 10)
+	result, err := replay(ctx, topic, topic.reader(), ReplayOptions{
+		Topic:   "dice-rolls",
+		From:    Position{Offset: sarama.OffsetOldest},
+		To:      Position{Offset: 100},
+		Headers: map[string]string{"source": "api"},
+		Key:     "key-a",
+	}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Offsets 0 and 6 of both partitions have the header and the key.
+	if want := (ReplayResult{Read: 20, Matched: 4, Handled: 4}); result != want {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+	if len(handled) != 4 || handled[1] != 6 {
+		t.Errorf("got offsets %v handled, want 0 and 6 of each partition", handled)
+	}
+
+	// A start after the last record leaves nothing to replay.
+	result, err = replay(ctx, topic, topic.reader(), ReplayOptions{
+		Topic: "dice-rolls",
+		From:  Position{Time: replayEpoch.Add(time.Hour)},
+		To:    Position{Offset: sarama.OffsetNewest},
+	}, handler)
+	if err != nil || result.Read != 0 {
+		t.Errorf("got %+v, %v replaying from after the last record", result, err)
+	}
+}
@@ -0,0 +1,315 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers set on the messages republished to retry and dead-letter topics.
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderError             = "x-error"
+	HeaderAttempts          = "x-attempts"
+	HeaderRetryAt           = "x-retry-at"
+)
+
+var (
+	ErrRetryDisabled = errors.New("retry topics are disabled")
+	ErrUnknownTopic  = errors.New("no handler registered for topic")
+)
+
+// RetryTopic is the name of the retry topic of topic for the tier with delay.
+func RetryTopic(topic string, delay time.Duration) string {
+	return topic + ".retry." + formatDelay(delay)
+}
+
+// DLQTopic is the name of the dead-letter topic of topic.
+func DLQTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// RetryTopics returns the retry and dead-letter topics of topic, or none if
+// retry topics are disabled.
+func RetryTopics(conf *config.RetryConfig, topic string) []string {
+	if conf == nil || !conf.Enabled {
+		return nil
+	}
+	var topics []string
+	for _, delay := range conf.Tiers {
+		topics = append(topics, RetryTopic(topic, delay))
+	}
+	return append(topics, DLQTopic(topic))
+}
+
+// formatDelay writes delay in the largest unit that divides it, such as 5s
+// or 1m rather than time.Duration's 1m0s.
+func formatDelay(delay time.Duration) string {
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{{time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}} {
+		if delay >= unit.d && delay%unit.d == 0 {
+			return strconv.FormatInt(int64(delay/unit.d), 10) + unit.name
+		}
+	}
+	return strconv.FormatInt(delay.Milliseconds(), 10) + "ms"
+}
+
+// route is where the messages of a consumed topic are handled: by the handler
+// of topic, on its first attempt for tier 0 or in the retry topic of tier
+// tier-1 otherwise.
+type route struct {
+	handler Handler
+	topic   string
+	tier    int
+}
+
+// retrier republishes failed messages to the next retry tier or, after the
+// last one, to the dead-letter topic.
+type retrier struct {
+	producer sarama.SyncProducer
+	tiers    []time.Duration
+	now      func() time.Time
+
+	redriveMu sync.Mutex
+}
+
+func newRetrier(client sarama.Client, conf *config.RetryConfig) (*retrier, error) {
+	if conf == nil || !conf.Enabled {
+		return nil, nil
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry producer: %w", err)
+	}
+	return &retrier{producer: producer, tiers: conf.Tiers, now: time.Now}, nil
+}
+
+// tierDelays returns the delays of the retry tiers, none if r is nil.
+func (r *retrier) tierDelays() []time.Duration {
+	if r == nil {
+		return nil
+	}
+	return r.tiers
+}
+
+func (r *retrier) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.producer.Close()
+}
+
+// wait blocks until message, read from a retry topic, is due.
+func (r *retrier) wait(ctx context.Context, message *sarama.ConsumerMessage) error {
+	if r == nil {
+		return nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, header(message.Headers, HeaderRetryAt))
+	if err != nil {
+		return nil
+	}
+	delay := at.Sub(r.now())
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forward republishes message, whose handling in rt failed with cause, to the
+// next retry tier, or to the dead-letter topic if the error is permanent or
+// the tiers are exhausted. It returns the topic the message was sent to.
+func (r *retrier) forward(rt route, message *sarama.ConsumerMessage, cause error) (string, error) {
+	attempts, _ := strconv.Atoi(header(message.Headers, HeaderAttempts))
+	attempts++
+
+	msg := &sarama.ProducerMessage{
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: withoutRetryHeaders(message.Headers),
+	}
+	if header(message.Headers, HeaderOriginalTopic) != "" {
+		for _, key := range []string{HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset} {
+			msg.Headers = appendHeader(msg.Headers, key, header(message.Headers, key))
+		}
+	} else {
+		msg.Headers = appendHeader(msg.Headers, HeaderOriginalTopic, message.Topic)
+		msg.Headers = appendHeader(msg.Headers, HeaderOriginalPartition, strconv.Itoa(int(message.Partition)))
+		msg.Headers = appendHeader(msg.Headers, HeaderOriginalOffset, strconv.FormatInt(message.Offset, 10))
+	}
+	msg.Headers = appendHeader(msg.Headers, HeaderError, cause.Error())
+	msg.Headers = appendHeader(msg.Headers, HeaderAttempts, strconv.Itoa(attempts))
+
+	if rt.tier < len(r.tiers) && !errors.Is(cause, ErrPermanent) {
+		delay := r.tiers[rt.tier]
+		msg.Topic = RetryTopic(rt.topic, delay)
+		msg.Headers = appendHeader(msg.Headers, HeaderRetryAt, r.now().Add(delay).UTC().Format(time.RFC3339Nano))
+	} else {
+		msg.Topic = DLQTopic(rt.topic)
+	}
+	if _, _, err := r.producer.SendMessage(msg); err != nil {
+		return msg.Topic, fmt.Errorf("failed to publish to %s: %w", msg.Topic, err)
+	}
+	return msg.Topic, nil
+}
+
+// redrive republishes a message read from a dead-letter topic to its
+// original topic, without the retry headers.
+func (r *retrier) redrive(message *sarama.ConsumerMessage) error {
+	topic := header(message.Headers, HeaderOriginalTopic)
+	if topic == "" {
+		topic = strings.TrimSuffix(message.Topic, ".dlq")
+	}
+	_, _, err := r.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: withoutRetryHeaders(message.Headers),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Redrive republishes the messages of the dead-letter topic of topic to it.
+// Redriven offsets are committed under the consumer group <group>.redrive, so
+// every message is redriven once. At most limit messages are redriven, all of
+// them if limit is 0. It returns how many were.
+func (consumer *Consumer) Redrive(ctx context.Context, topic string, limit int) (int, error) {
+	if consumer.retry == nil {
+		return 0, ErrRetryDisabled
+	}
+	if rt, ok := consumer.routes[topic]; !ok || rt.tier != 0 {
+		return 0, fmt.Errorf("%w %s", ErrUnknownTopic, topic)
+	}
+	consumer.retry.redriveMu.Lock()
+	defer consumer.retry.redriveMu.Unlock()
+
+	offsets, err := sarama.NewOffsetManagerFromClient(consumer.groupID+".redrive", consumer.client)
+	if err != nil {
+		return 0, err
+	}
+	defer offsets.Close()
+	reader, err := sarama.NewConsumerFromClient(consumer.client)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return consumer.redriveTopic(ctx, offsets, reader, DLQTopic(topic), limit)
+}
+
+// redriveTopic redrives the partitions of dlq, committing the redriven
+// offsets even if it fails. Closing offsets only commits them when
+// auto-commit is enabled, which it is not in the batch commit mode.
+func (consumer *Consumer) redriveTopic(ctx context.Context, offsets sarama.OffsetManager, reader sarama.Consumer, dlq string, limit int) (int, error) {
+	partitions, err := consumer.client.Partitions(dlq)
+	if err != nil {
+		return 0, err
+	}
+	defer offsets.Commit()
+
+	redriven := 0
+	for _, partition := range partitions {
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - redriven
+			if remaining <= 0 {
+				break
+			}
+		}
+		n, err := consumer.redrivePartition(ctx, offsets, reader, dlq, partition, remaining)
+		redriven += n
+		if err != nil {
+			return redriven, err
+		}
+	}
+	return redriven, nil
+}
+
+func (consumer *Consumer) redrivePartition(ctx context.Context, offsets sarama.OffsetManager, reader sarama.Consumer, dlq string, partition int32, limit int) (int, error) {
+	pom, err := offsets.ManagePartition(dlq, partition)
+	if err != nil {
+		return 0, err
+	}
+	defer pom.Close()
+	oldest, err := consumer.client.GetOffset(dlq, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	// Messages published after the redrive started are left for the next one.
+	newest, err := consumer.client.GetOffset(dlq, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	next, _ := pom.NextOffset()
+	next = max(next, oldest)
+	if next >= newest {
+		return 0, nil
+	}
+	pc, err := reader.ConsumePartition(dlq, partition, next)
+	if err != nil {
+		return 0, err
+	}
+	defer pc.Close()
+
+	n := 0
+	for next < newest && (limit == 0 || n < limit) {
+		select {
+		case message := <-pc.Messages():
+			if err := consumer.retry.redrive(message); err != nil {
+				return n, err
+			}
+			next = message.Offset + 1
+			pom.MarkOffset(next, "")
+			n++
+		case <-ctx.Done():
+			return n, ctx.Err()
+		}
+	}
+	return n, nil
+}
+
+func header(headers []*sarama.RecordHeader, key string) string {
+	for _, h := range headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func appendHeader(headers []sarama.RecordHeader, key, value string) []sarama.RecordHeader {
+	return append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// withoutRetryHeaders copies headers, leaving out the ones set by forward.
+func withoutRetryHeaders(headers []*sarama.RecordHeader) []sarama.RecordHeader {
+	copied := make([]sarama.RecordHeader, 0, len(headers))
+	for _, h := range headers {
+		switch string(h.Key) {
+		case HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset, HeaderError, HeaderAttempts, HeaderRetryAt:
+			continue
+		}
+		copied = append(copied, *h)
+	}
+	return copied
+}
@@ -0,0 +1,307 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+
+	"github.com/IBM/sarama"
+)
+
+type recordingProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.err != nil {
+		return 0, 0, p.err
+	}
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent)), nil
+}
+
+func producerHeader(msg *sarama.ProducerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// asConsumed turns a produced message into the message the consumer of its
+// topic reads.
+func asConsumed(msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	value, _ := msg.Value.Encode()
+	consumed := &sarama.ConsumerMessage{Topic: msg.Topic, Offset: offset, Value: value}
+	for i := range msg.Headers {
+		consumed.Headers = append(consumed.Headers, &msg.Headers[i])
+	}
+	return consumed
+}
+
+func TestRetryTopics(t *testing.T) {
+	conf := &config.RetryConfig{Enabled: true, Tiers: []time.Duration{5 * time.Second, time.Minute, 90 * time.Minute, 1500 * time.Millisecond}}
+	want := []string{"dice-rolls.retry.5s", "dice-rolls.retry.1m", "dice-rolls.retry.90m", "dice-rolls.retry.1500ms", "dice-rolls.dlq"}
+	got := RetryTopics(conf, "dice-rolls")
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if topics := RetryTopics(&config.RetryConfig{Tiers: conf.Tiers}, "dice-rolls"); topics != nil {
+		t.Errorf("expected no retry topics when disabled, got %v", topics)
+	}
+}
+
+func TestRetryPipeline(t *testing.T) {
+	producer := &recordingProducer{}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	consumer := newTestConsumer(nil)
+	consumer.retry = &retrier{producer: producer, tiers: []time.Duration{5 * time.Second, time.Minute}, now: func() time.Time { return now }}
+	consumer.retryTiers = consumer.retry.tiers
+	handled := 0
+	consumer.Handle("dice-rolls", HandlerFunc(func(context.Context, *Message) error {
+		handled++
+		return errors.New("store unavailable")
+	}))
+
+	message := testMessage(41)
+	message.Partition = 2
+	message.Headers = []*sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte("00-abc-def-01")}}
+	wantTopics := []string{"dice-rolls.retry.5s", "dice-rolls.retry.1m", "dice-rolls.dlq"}
+	for i, want := range wantTopics {
+		session := &commitSession{ctx: context.Background()}
+		if err := consumer.process(session.ctx, session, message); err != nil {
+			t.Fatal(err)
+		}
+		if len(session.marked) != 1 {
+			t.Fatalf("expected the forwarded message to be marked, got %v", session.marked)
+		}
+		sent := producer.sent[i]
+		if sent.Topic != want {
+			t.Fatalf("attempt %d sent to %s, want %s", i+1, sent.Topic, want)
+		}
+		for key, value := range map[string]string{
+			HeaderOriginalTopic:     "dice-rolls",
+			HeaderOriginalPartition: "2",
+			HeaderOriginalOffset:    "41",
+			HeaderError:             "store unavailable",
+			HeaderAttempts:          strconv.Itoa(i + 1),
+			"traceparent":           "00-abc-def-01",
+		} {
+			if got := producerHeader(sent, key); got != value {
+				t.Errorf("attempt %d header %s = %q, want %q", i+1, key, got, value)
+			}
+		}
+		wantHeaders := 6
+		if sent.Topic != "dice-rolls.dlq" {
+			wantHeaders++ // x-retry-at
+		}
+		if len(sent.Headers) != wantHeaders {
+			t.Errorf("attempt %d has %d headers, want %d", i+1, len(sent.Headers), wantHeaders)
+		}
+		// The retry topic is consumed once the message is due.
+		now = now.Add(2 * time.Minute)
+		message = asConsumed(sent, int64(i))
+	}
+	if handled != 3 {
+		t.Errorf("handled %d times, want 3", handled)
+	}
+	if producerHeader(producer.sent[0], HeaderRetryAt) != "2024-05-01T12:00:05Z" {
+		t.Errorf("unexpected retry time %q", producerHeader(producer.sent[0], HeaderRetryAt))
+	}
+}
+
+func TestRetryPermanentGoesToDLQ(t *testing.T) {
+	producer := &recordingProducer{}
+	consumer := newTestConsumer(nil)
+	consumer.retry = &retrier{producer: producer, tiers: []time.Duration{5 * time.Second}, now: time.Now}
+	consumer.retryTiers = consumer.retry.tiers
+	consumer.Handle("dice-rolls", HandlerFunc(func(context.Context, *Message) error {
+		return Permanent(errors.New("invalid roll"))
+	}))
+	if err := consumer.process(context.Background(), &commitSession{ctx: context.Background()}, testMessage(41)); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.sent) != 1 || producer.sent[0].Topic != "dice-rolls.dlq" {
+		t.Errorf("expected the message to go to the dead-letter topic, got %v", producer.sent)
+	}
+}
+
+func TestRetryProducerFailureIsNotMarked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	producer := &recordingProducer{err: errors.New("broker down")}
+	consumer := newTestConsumer(nil)
+	consumer.retry = &retrier{producer: producer, tiers: []time.Duration{5 * time.Second}, now: time.Now}
+	consumer.Handle("dice-rolls", HandlerFunc(func(context.Context, *Message) error {
+		cancel()
+		return errors.New("store unavailable")
+	}))
+	session := &commitSession{ctx: ctx}
+	if err := consumer.process(session.ctx, session, testMessage(41)); err == nil {
+		t.Error("expected an error once the session ended")
+	}
+	if len(session.marked) != 0 {
+		t.Errorf("marked %v although the message was not forwarded", session.marked)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	r := &retrier{now: time.Now}
+	message := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte(HeaderRetryAt), Value: []byte(time.Now().Add(time.Hour).Format(time.RFC3339Nano))},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.wait(ctx, message); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected to wait until the message is due, got %v", err)
+	}
+	message.Headers[0].Value = []byte(time.Now().Add(-time.Second).Format(time.RFC3339Nano))
+	if err := r.wait(context.Background(), message); err != nil {
+		t.Errorf("expected a due message not to wait, got %v", err)
+	}
+}
+
+func TestRedriveStripsRetryHeaders(t *testing.T) {
+	producer := &recordingProducer{}
+	r := &retrier{producer: producer}
+	message := &sarama.ConsumerMessage{
+		Topic: "dice-rolls.dlq",
+		Value: []byte(testRoll),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderOriginalTopic), Value: []byte("dice-rolls")},
+			{Key: []byte(HeaderAttempts), Value: []byte("3")},
+			{Key: []byte(HeaderError), Value: []byte("store unavailable")},
+			{Key: []byte("ce_id"), Value: []byte("7f1b")},
+		},
+	}
+	if err := r.redrive(message); err != nil {
+		t.Fatal(err)
+	}
+	sent := producer.sent[0]
+	if sent.Topic != "dice-rolls" || len(sent.Headers) != 1 || producerHeader(sent, "ce_id") != "7f1b" {
+		t.Errorf("unexpected redriven message %+v", sent)
+	}
+}
+
+// fakeDLQ is a dead-letter topic of two partitions holding three messages
+// each.
+type fakeDLQ struct {
+	sarama.Client
+	messages map[int32][]*sarama.ConsumerMessage
+}
+
+func newFakeDLQ() *fakeDLQ {
+	dlq := &fakeDLQ{messages: make(map[int32][]*sarama.ConsumerMessage)}
+	for partition := range int32(2) {
+		for offset := range int64(3) {
+			dlq.messages[partition] = append(dlq.messages[partition], &sarama.ConsumerMessage{
+				Topic: "dice-rolls.dlq", Partition: partition, Offset: offset, Value: []byte(testRoll),
+			})
+		}
+	}
+	return dlq
+}
+
+func (dlq *fakeDLQ) Partitions(string) ([]int32, error) { return []int32{0, 1}, nil }
+
+func (dlq *fakeDLQ) GetOffset(_ string, partition int32, at int64) (int64, error) {
+	if at == sarama.OffsetOldest {
+		return 0, nil
+	}
+	return int64(len(dlq.messages[partition])), nil
+}
+
+// fakeDLQReader reads the partitions of a fakeDLQ.
+type fakeDLQReader struct {
+	sarama.Consumer
+	dlq *fakeDLQ
+}
+
+func (r *fakeDLQReader) ConsumePartition(_ string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	messages := r.dlq.messages[partition][offset:]
+	pc := &fakeDLQPartition{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, message := range messages {
+		pc.messages <- message
+	}
+	return pc, nil
+}
+
+type fakeDLQPartition struct {
+	sarama.PartitionConsumer
+	messages chan *sarama.ConsumerMessage
+}
+
+func (pc *fakeDLQPartition) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+
+func (pc *fakeDLQPartition) Close() error { return nil }
+
+// fakeOffsetManager keeps the offsets marked through it until they are
+// committed.
+type fakeOffsetManager struct {
+	sarama.OffsetManager
+	marked    map[int32]int64
+	committed map[int32]int64
+}
+
+func (m *fakeOffsetManager) ManagePartition(_ string, partition int32) (sarama.PartitionOffsetManager, error) {
+	return &fakePartitionOffsetManager{manager: m, partition: partition}, nil
+}
+
+func (m *fakeOffsetManager) Commit() {
+	for partition, offset := range m.marked {
+		m.committed[partition] = offset
+	}
+}
+
+type fakePartitionOffsetManager struct {
+	sarama.PartitionOffsetManager
+	manager   *fakeOffsetManager
+	partition int32
+}
+
+func (pom *fakePartitionOffsetManager) NextOffset() (int64, string) {
+	if offset, ok := pom.manager.committed[pom.partition]; ok {
+		return offset, ""
+	}
+	return sarama.OffsetNewest, ""
+}
+
+func (pom *fakePartitionOffsetManager) MarkOffset(offset int64, _ string) {
+	pom.manager.marked[pom.partition] = offset
+}
+
+func (pom *fakePartitionOffsetManager) Close() error { return nil }
+
+func TestRedriveCommitsOffsets(t *testing.T) {
+	producer := &recordingProducer{}
+	consumer := newTestConsumer(nil)
+	consumer.retry = &retrier{producer: producer}
+	dlq := newFakeDLQ()
+	consumer.client = dlq
+
+	committed := make(map[int32]int64)
+	for i, want := range []int{6, 0} {
+		offsets := &fakeOffsetManager{marked: make(map[int32]int64), committed: committed}
+		n, err := consumer.redriveTopic(context.Background(), offsets, &fakeDLQReader{dlq: dlq}, "dice-rolls.dlq", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("redrive %d forwarded %d messages, want %d", i+1, n, want)
+		}
+	}
+	if len(producer.sent) != 6 || committed[0] != 3 || committed[1] != 3 {
+		t.Errorf("got %d messages sent and offsets %v committed", len(producer.sent), committed)
+	}
+}
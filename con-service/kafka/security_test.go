@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/rlindsey28/con-service/config"
+)
+
+func TestNewSaramaConfigSecurity(t *testing.T) {
+	saramaConfig, err := NewSaramaConfig(&config.KafkaConfig{
+		TLS:  &config.TLSConfig{Enabled: true, InsecureSkipVerify: true},
+		SASL: &config.SASLConfig{Mechanism: SASLScramSHA256, Username: "con", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saramaConfig.Net.TLS.Enable || !saramaConfig.Net.TLS.Config.InsecureSkipVerify {
+		t.Error("expected TLS without verification")
+	}
+	if !saramaConfig.Net.SASL.Enable || saramaConfig.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA256 {
+		t.Errorf("expected SCRAM-SHA-256, got %q", saramaConfig.Net.SASL.Mechanism)
+	}
+
+	_, err = NewSaramaConfig(&config.KafkaConfig{SASL: &config.SASLConfig{Mechanism: SASLOAuthBearer, TokenProvider: TokenProviderClientCredentials}})
+	if err == nil {
+		t.Error("expected an error for client credentials without a token url")
+	}
+}
+
+func TestFileTokenProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("token-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewTokenProvider(&config.SASLConfig{TokenProvider: TokenProviderFile, TokenFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "token-1" {
+		t.Errorf("expected token-1, got %q", token.Token)
+	}
+}
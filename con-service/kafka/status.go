@@ -0,0 +1,208 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+var ErrClientClosed = errors.New("kafka client is closed")
+
+// Status is a snapshot of the consumer's group membership and claims.
+type Status struct {
+	Group        string        `json:"group"`
+	Member       bool          `json:"member"`
+	MemberID     string        `json:"member_id,omitempty"`
+	GenerationID int32         `json:"generation_id,omitempty"`
+	Joined       time.Time     `json:"joined,omitempty"`
+	LastMessage  time.Time     `json:"last_message,omitempty"`
+	Claims       []ClaimStatus `json:"claims"`
+}
+
+// ClaimStatus is the progress of one claimed partition. Offset is the next
+// offset to consume, Committed the offset the group last committed, as of
+// the last lag refresh, and Lag the number of messages Offset is behind the
+// high water mark; all are -1 until they are known. Paused is set while the
+// partition is paused.
+type ClaimStatus struct {
+	Topic         string    `json:"topic"`
+	Partition     int32     `json:"partition"`
+	Offset        int64     `json:"offset"`
+	Committed     int64     `json:"committed"`
+	HighWaterMark int64     `json:"high_water_mark"`
+	Lag           int64     `json:"lag"`
+	LastMessage   time.Time `json:"last_message,omitempty"`
+	Paused        bool      `json:"paused"`
+}
+
+// TotalLag is the sum of the known lag of every claim.
+func (s Status) TotalLag() int64 {
+	var lag int64
+	for _, c := range s.Claims {
+		if c.Lag > 0 {
+			lag += c.Lag
+		}
+	}
+	return lag
+}
+
+// ActiveLag is the sum of the known lag of the claims that are not paused.
+func (s Status) ActiveLag() int64 {
+	var lag int64
+	for _, c := range s.Claims {
+		if c.Lag > 0 && !c.Paused {
+			lag += c.Lag
+		}
+	}
+	return lag
+}
+
+type claimState struct {
+	claim       sarama.ConsumerGroupClaim
+	offset      int64
+	committed   int64
+	newest      int64 // high water mark queried before the claim fetched
+	lastMessage time.Time
+}
+
+func newClaimState() *claimState {
+	return &claimState{offset: -1, committed: -1, newest: -1}
+}
+
+// joined records the claims of a new session.
+func (consumer *Consumer) joined(session sarama.ConsumerGroupSession) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.session = session
+	consumer.member = true
+	consumer.memberID = session.MemberID()
+	consumer.generation = session.GenerationID()
+	consumer.joinedAt = time.Now()
+	consumer.claims = make(map[string]map[int32]*claimState)
+	for topic, partitions := range session.Claims() {
+		consumer.claims[topic] = make(map[int32]*claimState, len(partitions))
+		for _, partition := range partitions {
+			consumer.claims[topic][partition] = newClaimState()
+		}
+	}
+}
+
+// left records the end of a session.
+func (consumer *Consumer) left() {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.session = nil
+	consumer.member = false
+	consumer.claims = make(map[string]map[int32]*claimState)
+}
+
+// claimed records the start of ConsumeClaim for claim.
+func (consumer *Consumer) claimed(claim sarama.ConsumerGroupClaim) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	state := consumer.claimState(claim.Topic(), claim.Partition())
+	state.claim = claim
+	if offset := claim.InitialOffset(); offset >= 0 {
+		state.offset = offset
+	}
+}
+
+// consumed records that the messages of a partition before next have been
+// processed.
+func (consumer *Consumer) consumed(topic string, partition int32, next int64) {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	now := time.Now()
+	state := consumer.claimState(topic, partition)
+	state.offset = next
+	state.lastMessage = now
+	consumer.lastMessage = now
+}
+
+func (consumer *Consumer) claimState(topic string, partition int32) *claimState {
+	partitions, ok := consumer.claims[topic]
+	if !ok {
+		partitions = make(map[int32]*claimState)
+		consumer.claims[topic] = partitions
+	}
+	state, ok := partitions[partition]
+	if !ok {
+		state = newClaimState()
+		partitions[partition] = state
+	}
+	return state
+}
+
+// Status returns the consumer's current membership, claims and lag.
+func (consumer *Consumer) Status() Status {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	status := Status{
+		Group:        consumer.groupID,
+		Member:       consumer.member,
+		MemberID:     consumer.memberID,
+		GenerationID: consumer.generation,
+		Joined:       consumer.joinedAt,
+		LastMessage:  consumer.lastMessage,
+		Claims:       []ClaimStatus{},
+	}
+	if !consumer.member {
+		status.MemberID, status.GenerationID, status.Joined = "", 0, time.Time{}
+	}
+	for topic, partitions := range consumer.claims {
+		for partition, state := range partitions {
+			c := ClaimStatus{
+				Topic:         topic,
+				Partition:     partition,
+				Offset:        state.offset,
+				Committed:     state.committed,
+				HighWaterMark: state.newest,
+				Lag:           -1,
+				LastMessage:   state.lastMessage,
+				Paused:        consumer.isPaused(topic, partition),
+			}
+			// The high water mark is updated by every fetch, so it is read
+			// from the claim rather than from the last message.
+			if state.claim != nil {
+				if hwm := state.claim.HighWaterMarkOffset(); hwm > 0 {
+					c.HighWaterMark = hwm
+				}
+			}
+			if c.Offset >= 0 && c.HighWaterMark >= 0 {
+				c.Lag = max(c.HighWaterMark-c.Offset, 0)
+			}
+			status.Claims = append(status.Claims, c)
+		}
+	}
+	sort.Slice(status.Claims, func(i, j int) bool {
+		a, b := status.Claims[i], status.Claims[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	return status
+}
+
+// CheckBrokers returns an error unless the consumer group's coordinator is
+// reachable.
+func (consumer *Consumer) CheckBrokers() error {
+	if consumer.client.Closed() {
+		return ErrClientClosed
+	}
+	coordinator, err := consumer.client.Coordinator(consumer.groupID)
+	if err != nil {
+		return fmt.Errorf("no coordinator for group %s: %w", consumer.groupID, err)
+	}
+	connected, err := coordinator.Connected()
+	if err != nil {
+		return err
+	}
+	if !connected {
+		return fmt.Errorf("not connected to coordinator %s", coordinator.Addr())
+	}
+	return nil
+}
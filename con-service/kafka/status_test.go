@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	claims map[string][]int32
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "member-1" }
+func (s *fakeSession) GenerationID() int32        { return 4 }
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	topic     string
+	partition int32
+	initial   int64
+	hwm       int64
+}
+
+func (c *fakeClaim) Topic() string              { return c.topic }
+func (c *fakeClaim) Partition() int32           { return c.partition }
+func (c *fakeClaim) InitialOffset() int64       { return c.initial }
+func (c *fakeClaim) HighWaterMarkOffset() int64 { return c.hwm }
+
+func TestStatus(t *testing.T) {
+	consumer := &Consumer{groupID: "con-service", claims: make(map[string]map[int32]*claimState)}
+	consumer.joined(&fakeSession{claims: map[string][]int32{"dice-rolls": {1, 0, 2}}})
+
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 0, initial: 5, hwm: 9})
+	consumer.claimed(&fakeClaim{topic: "dice-rolls", partition: 1, initial: sarama.OffsetNewest, hwm: 3})
+	consumer.consumed("dice-rolls", 0, 7)
+
+	status := consumer.Status()
+	if !status.Member || status.MemberID != "member-1" || status.GenerationID != 4 {
+		t.Errorf("unexpected membership %+v", status)
+	}
+	if status.LastMessage.IsZero() {
+		t.Error("last message not recorded")
+	}
+	want := []ClaimStatus{
+		{Topic: "dice-rolls", Partition: 0, Offset: 7, Committed: -1, HighWaterMark: 9, Lag: 2},
+		{Topic: "dice-rolls", Partition: 1, Offset: -1, Committed: -1, HighWaterMark: 3, Lag: -1},
+		{Topic: "dice-rolls", Partition: 2, Offset: -1, Committed: -1, HighWaterMark: -1, Lag: -1},
+	}
+	if len(status.Claims) != len(want) {
+		t.Fatalf("got %d claims, want %d", len(status.Claims), len(want))
+	}
+	for i, c := range status.Claims {
+		c.LastMessage = want[i].LastMessage
+		if c != want[i] {
+			t.Errorf("claim %d = %+v, want %+v", i, c, want[i])
+		}
+	}
+	if lag := status.TotalLag(); lag != 2 {
+		t.Errorf("total lag = %d, want 2", lag)
+	}
+
+	consumer.left()
+	status = consumer.Status()
+	if status.Member || len(status.Claims) != 0 || status.MemberID != "" {
+		t.Errorf("unexpected status after leaving %+v", status)
+	}
+}
@@ -0,0 +1,261 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+const (
+	TopicPolicyOff       = "off"
+	TopicPolicyWarn      = "warn"
+	TopicPolicyFail      = "fail"
+	TopicPolicyReconcile = "reconcile"
+)
+
+var ErrTopicDrift = errors.New("topic does not match its spec")
+
+// TopicSpec is the desired state of a topic. Configs only lists the topic
+// configs that are checked, every other config is left to the broker.
+type TopicSpec struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+// NewTopicSpec returns the spec of topic name. Zero retention, cleanup policy
+// and min.insync.replicas keep the broker defaults.
+func NewTopicSpec(name string, conf *config.TopicConfig) TopicSpec {
+	configs := maps.Clone(conf.Configs)
+	if configs == nil {
+		configs = make(map[string]string)
+	}
+	if conf.Retention > 0 {
+		configs["retention.ms"] = strconv.FormatInt(conf.Retention.Milliseconds(), 10)
+	}
+	if conf.CleanupPolicy != "" {
+		configs["cleanup.policy"] = conf.CleanupPolicy
+	}
+	if conf.MinInsyncReplicas > 0 {
+		configs["min.insync.replicas"] = strconv.Itoa(conf.MinInsyncReplicas)
+	}
+	return TopicSpec{
+		Name:              name,
+		Partitions:        conf.Partitions,
+		ReplicationFactor: conf.ReplicationFactor,
+		Configs:           configs,
+	}
+}
+
+// drift is a difference between a topic and its spec. Partitions can only be
+// added and the replication factor cannot be changed here, so only fixable
+// drift is reconciled.
+type drift struct {
+	field    string
+	want     string
+	got      string
+	fixable  bool
+	isConfig bool
+}
+
+func (d drift) String() string {
+	return fmt.Sprintf("%s is %q, want %q", d.field, d.got, d.want)
+}
+
+// ProvisionTopics creates the topics in conf.Topic, their retry and
+// dead-letter topics, and the topics in extra the service writes to, that are
+// missing and handles the drift of existing ones according to
+// conf.Provision.Policy.
+func ProvisionTopics(conf *config.KafkaConfig, saramaConfig *sarama.Config, extra ...string) error {
+	policy := conf.Provision.Policy
+	if err := validateTopicPolicy(policy); err != nil {
+		return err
+	}
+	if policy == TopicPolicyOff {
+		return nil
+	}
+	admin, err := sarama.NewClusterAdmin(conf.Brokers, saramaConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create cluster admin: %w", err)
+	}
+	defer admin.Close()
+
+	var specs []TopicSpec
+	for _, topic := range strings.Split(conf.Topic, ",") {
+		topic = strings.TrimSpace(topic)
+		specs = append(specs, NewTopicSpec(topic, conf.Provision))
+		for _, retryTopic := range RetryTopics(conf.Retry, topic) {
+			specs = append(specs, NewTopicSpec(retryTopic, conf.Provision))
+		}
+	}
+	for _, topic := range extra {
+		specs = append(specs, NewTopicSpec(topic, conf.Provision))
+	}
+	return EnsureTopics(admin, policy, specs...)
+}
+
+func validateTopicPolicy(policy string) error {
+	switch policy {
+	case TopicPolicyOff, TopicPolicyWarn, TopicPolicyFail, TopicPolicyReconcile:
+		return nil
+	}
+	return fmt.Errorf("invalid topic policy %q, must be one of off, warn, fail or reconcile", policy)
+}
+
+// EnsureTopics creates every missing topic of specs. Existing topics are
+// compared with their spec: with the warn policy drift is logged, with fail
+// it is returned as an error and with reconcile partitions are added and
+// configs altered to match.
+func EnsureTopics(admin sarama.ClusterAdmin, policy string, specs ...TopicSpec) error {
+	log := logger.Get()
+	if err := validateTopicPolicy(policy); err != nil {
+		return err
+	}
+	if policy == TopicPolicyOff {
+		return nil
+	}
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var errs []error
+	for _, spec := range specs {
+		detail, ok := topics[spec.Name]
+		if !ok {
+			if err := createTopic(admin, spec); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			log.Info("created topic", zap.String("topic", spec.Name), zap.Int32("partitions", spec.Partitions), zap.Int16("replication_factor", spec.ReplicationFactor))
+			continue
+		}
+
+		drifts, err := diffTopic(admin, spec, detail)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(drifts) == 0 {
+			log.Debug("topic matches its spec", zap.String("topic", spec.Name))
+			continue
+		}
+		switch policy {
+		case TopicPolicyWarn:
+			for _, d := range drifts {
+				log.Warn("topic does not match its spec", zap.String("topic", spec.Name), zap.Stringer("drift", d))
+			}
+		case TopicPolicyFail:
+			errs = append(errs, fmt.Errorf("%w: %s: %s", ErrTopicDrift, spec.Name, joinDrifts(drifts)))
+		case TopicPolicyReconcile:
+			if err := reconcileTopic(admin, spec, drifts); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func createTopic(admin sarama.ClusterAdmin, spec TopicSpec) error {
+	entries := make(map[string]*string, len(spec.Configs))
+	for k, v := range spec.Configs {
+		entries[k] = &v
+	}
+	err := admin.CreateTopic(spec.Name, &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		ConfigEntries:     entries,
+	}, false)
+	// Another instance may have created the topic since it was listed.
+	if errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+// diffTopic compares detail and the topic's configs, including broker
+// defaults, with spec.
+func diffTopic(admin sarama.ClusterAdmin, spec TopicSpec, detail sarama.TopicDetail) ([]drift, error) {
+	var drifts []drift
+	if detail.NumPartitions != spec.Partitions {
+		drifts = append(drifts, drift{
+			field:   "partitions",
+			want:    strconv.Itoa(int(spec.Partitions)),
+			got:     strconv.Itoa(int(detail.NumPartitions)),
+			fixable: detail.NumPartitions < spec.Partitions,
+		})
+	}
+	if detail.ReplicationFactor != spec.ReplicationFactor {
+		drifts = append(drifts, drift{
+			field: "replication factor",
+			want:  strconv.Itoa(int(spec.ReplicationFactor)),
+			got:   strconv.Itoa(int(detail.ReplicationFactor)),
+		})
+	}
+	if len(spec.Configs) == 0 {
+		return drifts, nil
+	}
+
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: spec.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe topic %s: %w", spec.Name, err)
+	}
+	current := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		current[entry.Name] = entry.Value
+	}
+	for _, name := range slices.Sorted(maps.Keys(spec.Configs)) {
+		if want := spec.Configs[name]; current[name] != want {
+			drifts = append(drifts, drift{field: name, want: want, got: current[name], fixable: true, isConfig: true})
+		}
+	}
+	return drifts, nil
+}
+
+func reconcileTopic(admin sarama.ClusterAdmin, spec TopicSpec, drifts []drift) error {
+	log := logger.Get()
+	entries := make(map[string]sarama.IncrementalAlterConfigsEntry)
+	for _, d := range drifts {
+		switch {
+		case !d.fixable:
+			log.Warn("topic drift cannot be reconciled", zap.String("topic", spec.Name), zap.Stringer("drift", d))
+		case d.isConfig:
+			value := d.want
+			entries[d.field] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &value}
+		default:
+			if err := admin.CreatePartitions(spec.Name, spec.Partitions, nil, false); err != nil {
+				return fmt.Errorf("failed to add partitions to topic %s: %w", spec.Name, err)
+			}
+			log.Info("reconciled topic", zap.String("topic", spec.Name), zap.Stringer("drift", d))
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := admin.IncrementalAlterConfig(sarama.TopicResource, spec.Name, entries, false); err != nil {
+		return fmt.Errorf("failed to alter configs of topic %s: %w", spec.Name, err)
+	}
+	log.Info("reconciled topic configs", zap.String("topic", spec.Name), zap.Strings("configs", slices.Sorted(maps.Keys(entries))))
+	return nil
+}
+
+func joinDrifts(drifts []drift) string {
+	s := make([]string, len(drifts))
+	for i, d := range drifts {
+		s[i] = d.String()
+	}
+	return strings.Join(s, ", ")
+}
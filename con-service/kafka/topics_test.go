@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/rlindsey28/con-service/config"
+)
+
+// fakeAdmin implements the cluster admin calls used to provision topics on
+// top of an in-memory set of topics.
+type fakeAdmin struct {
+	sarama.ClusterAdmin
+	topics  map[string]sarama.TopicDetail
+	configs map[string]map[string]string
+}
+
+func newFakeAdmin() *fakeAdmin {
+	return &fakeAdmin{topics: map[string]sarama.TopicDetail{}, configs: map[string]map[string]string{}}
+}
+
+func (a *fakeAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return a.topics, nil
+}
+
+func (a *fakeAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if _, ok := a.topics[topic]; ok {
+		return sarama.ErrTopicAlreadyExists
+	}
+	a.topics[topic] = sarama.TopicDetail{NumPartitions: detail.NumPartitions, ReplicationFactor: detail.ReplicationFactor}
+	a.configs[topic] = map[string]string{"cleanup.policy": "delete", "retention.ms": "604800000"}
+	for k, v := range detail.ConfigEntries {
+		a.configs[topic][k] = *v
+	}
+	return nil
+}
+
+func (a *fakeAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	var entries []sarama.ConfigEntry
+	for k, v := range a.configs[resource.Name] {
+		entries = append(entries, sarama.ConfigEntry{Name: k, Value: v})
+	}
+	return entries, nil
+}
+
+func (a *fakeAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	detail := a.topics[topic]
+	detail.NumPartitions = count
+	a.topics[topic] = detail
+	return nil
+}
+
+func (a *fakeAdmin) IncrementalAlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, validateOnly bool) error {
+	for k, e := range entries {
+		a.configs[name][k] = *e.Value
+	}
+	return nil
+}
+
+func testSpec() TopicSpec {
+	return NewTopicSpec("dice-rolls", &config.TopicConfig{
+		Partitions:        3,
+		ReplicationFactor: 1,
+		Retention:         24 * time.Hour,
+	})
+}
+
+func TestEnsureTopics(t *testing.T) {
+	admin := newFakeAdmin()
+	if err := EnsureTopics(admin, TopicPolicyFail, testSpec()); err != nil {
+		t.Fatal(err)
+	}
+	if got := admin.topics["dice-rolls"]; got.NumPartitions != 3 || got.ReplicationFactor != 1 {
+		t.Errorf("unexpected topic %+v", got)
+	}
+	if got := admin.configs["dice-rolls"]["retention.ms"]; got != "86400000" {
+		t.Errorf("expected retention.ms 86400000, got %q", got)
+	}
+	if err := EnsureTopics(admin, TopicPolicyFail, testSpec()); err != nil {
+		t.Errorf("expected the created topic to match its spec, got %v", err)
+	}
+}
+
+func TestEnsureTopicsDrift(t *testing.T) {
+	admin := newFakeAdmin()
+	admin.topics["dice-rolls"] = sarama.TopicDetail{NumPartitions: 1, ReplicationFactor: 1}
+	admin.configs["dice-rolls"] = map[string]string{"retention.ms": "604800000"}
+
+	if err := EnsureTopics(admin, TopicPolicyWarn, testSpec()); err != nil {
+		t.Errorf("expected warn to ignore drift, got %v", err)
+	}
+	if err := EnsureTopics(admin, TopicPolicyFail, testSpec()); !errors.Is(err, ErrTopicDrift) {
+		t.Errorf("expected ErrTopicDrift, got %v", err)
+	}
+	if err := EnsureTopics(admin, TopicPolicyReconcile, testSpec()); err != nil {
+		t.Fatal(err)
+	}
+	if got := admin.topics["dice-rolls"].NumPartitions; got != 3 {
+		t.Errorf("expected 3 partitions after reconciling, got %d", got)
+	}
+	if got := admin.configs["dice-rolls"]["retention.ms"]; got != "86400000" {
+		t.Errorf("expected retention.ms 86400000 after reconciling, got %q", got)
+	}
+}
@@ -4,13 +4,29 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rlindsey28/con-service/admin"
 	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/dedup"
+	"github.com/rlindsey28/con-service/dlq"
+	"github.com/rlindsey28/con-service/fair"
+	"github.com/rlindsey28/con-service/health"
 	"github.com/rlindsey28/con-service/kafka"
 	"github.com/rlindsey28/con-service/logger"
+	"github.com/rlindsey28/con-service/rolldice"
+	"github.com/rlindsey28/con-service/serde"
+	"github.com/rlindsey28/con-service/state"
+	"github.com/rlindsey28/con-service/stats"
 	"github.com/rlindsey28/con-service/telemetry"
+
+	"github.com/gorilla/mux"
 	"github.com/sethvargo/go-envconfig"
 	"go.uber.org/zap"
 )
@@ -22,8 +38,17 @@ type RollDiceResponse struct {
 }
 
 func main() {
-	// Handle SIGINT gracefully.
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(replay(os.Args[2:]))
+		case "offsets":
+			os.Exit(offsets(os.Args[2:]))
+		}
+	}
+
+	// Handle SIGINT and SIGTERM gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	// Load config
@@ -45,12 +70,162 @@ func main() {
 		err = errors.Join(err, otelShutdown(context.Background()))
 	}()
 
-	kafka.NewConsumer(conf.Kafka)
+	// Setup provably fair verification
+	var verifier *fair.Verifier
+	if conf.Fair.SeedURL != "" {
+		verifier = fair.NewVerifier(conf.Fair.SeedURL)
+		go verifier.Run(ctx, conf.Fair.RetryInterval)
+	}
+
+	// Setup schema registry deserialization
+	var deserializer *serde.Deserializer
+	if conf.Schema.RegistryURL != "" {
+		deserializer = serde.NewDeserializer(serde.NewClient(conf.Schema.RegistryURL))
+	}
+
+	// Create missing topics and validate existing ones
+	saramaConfig, err := kafka.NewSaramaConfig(conf.Kafka)
+	if err != nil {
+		zaplog.Panic("invalid kafka config", zap.Error(err))
+	}
+	var statsTopics []string
+	if conf.Stats.Topic != "" {
+		statsTopics = append(statsTopics, conf.Stats.Topic)
+	}
+	if err = kafka.ProvisionTopics(conf.Kafka, saramaConfig, statsTopics...); err != nil {
+		zaplog.Panic("failed to provision topics", zap.Error(err))
+	}
+
+	var topics []string
+	for _, topic := range strings.Split(conf.Kafka.Topic, ",") {
+		topics = append(topics, strings.TrimSpace(topic))
+	}
+
+	// Setup state stores
+	var totalsTable, seenTable *state.Table
+	if conf.State.Enabled {
+		stateManager, err := state.NewManager(conf.State, conf.Kafka, saramaConfig)
+		if err != nil {
+			zaplog.Panic("failed to setup state stores", zap.Error(err))
+		}
+		defer stateManager.Close()
+		totalsTable = stateManager.Table(stats.TotalsTable, topics...)
+		defer totalsTable.Close()
+		seenTable = stateManager.Table("dedup-seen", topics...)
+		defer seenTable.Close()
+	}
+
+	// Setup dice statistics
+	var publisher stats.Publisher
+	if conf.Stats.Topic != "" {
+		statsPublisher, err := stats.NewKafkaPublisher(conf.Kafka.Brokers, saramaConfig, conf.Stats.Topic)
+		if err != nil {
+			zaplog.Panic("failed to setup stats publisher", zap.Error(err))
+		}
+		defer statsPublisher.Close()
+		publisher = statsPublisher
+	}
+	aggregator, err := stats.NewAggregator(conf.Stats, publisher, totalsTable)
+	if err != nil {
+		zaplog.Panic("invalid stats config", zap.Error(err))
+	}
+	go aggregator.Run(ctx)
+
+	// Setup Kafka
+	consumer, err := kafka.NewConsumer(conf.Kafka)
+	if err != nil {
+		zaplog.Panic("failed to setup kafka", zap.Error(err))
+	}
+	defer func() {
+		err = errors.Join(err, consumer.Close())
+	}()
+	var rollHandler kafka.Handler = &rolldice.Handler{Verifier: verifier, Deserializer: deserializer, Stats: aggregator}
+	if conf.Dedup.Enabled {
+		dedupHandler := &dedup.Handler{Next: rollHandler, Seen: dedup.NewSeen(seenTable, conf.Dedup.TTL, conf.Dedup.MaxIDs)}
+		dedupHandler.Metrics.InitMetrics()
+		rollHandler = dedupHandler
+	}
+	for _, topic := range topics {
+		consumer.Handle(topic, rollHandler)
+	}
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- consumer.Run(ctx)
+	}()
+	go pauseOnSignal(ctx, consumer)
+
+	// Setup router
+	router := mux.NewRouter()
+
+	healthHandler := health.Handler{Consumer: consumer, MaxIdle: conf.Health.MaxIdle}
+	router.HandleFunc("/health", healthHandler.HealthCheck).Methods("GET")
+	router.HandleFunc("/livez", healthHandler.Livez).Methods("GET")
+	router.HandleFunc("/readyz", healthHandler.Readyz).Methods("GET")
+	router.HandleFunc("/status", healthHandler.Status).Methods("GET")
+
+	statsHandler := stats.Handler{Aggregator: aggregator}
+	router.HandleFunc("/stats", statsHandler.Totals).Methods("GET")
+	router.HandleFunc("/stats/windows", statsHandler.Windows).Methods("GET")
+	router.HandleFunc("/stats/windows/{window}", statsHandler.Window).Methods("GET")
+	router.HandleFunc("/stats/{sides:[0-9]+}", statsHandler.Total).Methods("GET")
+
+	if conf.Admin.Token != "" {
+		groupAdmin, err := kafka.NewGroupAdmin(conf.Kafka, saramaConfig)
+		if err != nil {
+			zaplog.Panic("failed to setup group admin", zap.Error(err))
+		}
+		defer groupAdmin.Close()
+		groupAdmin.SetMember(consumer)
+		adminRouter := router.PathPrefix("/admin").Subrouter()
+		adminRouter.Use(admin.RequireToken(conf.Admin.Token))
+		groupHandler := admin.GroupHandler{Group: groupAdmin}
+		adminRouter.HandleFunc("/group", groupHandler.Describe).Methods("GET")
+		adminRouter.HandleFunc("/group/members", groupHandler.Members).Methods("GET")
+		adminRouter.HandleFunc("/group/offsets", groupHandler.Offsets).Methods("GET")
+		adminRouter.HandleFunc("/group/offsets/reset", groupHandler.Reset).Methods("POST")
+		pauseHandler := admin.PauseHandler{Consumer: consumer}
+		adminRouter.HandleFunc("/pause", pauseHandler.Status).Methods("GET")
+		adminRouter.HandleFunc("/pause", pauseHandler.Pause).Methods("POST")
+		adminRouter.HandleFunc("/resume", pauseHandler.Resume).Methods("POST")
+		dlqHandler := dlq.Handler{Redriver: consumer}
+		adminRouter.HandleFunc("/dlq/{topic}/redrive", dlqHandler.Redrive).Methods("POST")
+	} else {
+		zaplog.Warn("admin endpoints disabled, set ADMIN_TOKEN to enable them")
+	}
+
+	zaplog.Debug("starting server", zap.String("service-name", conf.ServiceName), zap.String("port", conf.Port))
+	srv := &http.Server{
+		Addr:         conf.Port,
+		BaseContext:  func(_ net.Listener) context.Context { return ctx },
+		ReadTimeout:  time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler:      router,
+	}
+
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.ListenAndServe()
+	}()
+
 	//Wait for shutdown signal
 	select {
+	case err := <-srvErr:
+		zaplog.Error("server error", zap.Error(err))
+		return
+	case err := <-consumed:
+		zaplog.Error("consumer stopped", zap.Error(err))
+		return
 	case <-ctx.Done():
 		// Wait for CTRL+C
 		zaplog.Info("shutting down server")
 		stop()
 	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		zaplog.Error("failed to shut down server", zap.Error(err))
+	}
+	// Let the current session commit its offsets before the group is closed.
+	<-consumed
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/kafka"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+const offsetsUsage = `Usage: con-service offsets [flags]
+
+Describes the consumer group of KAFKA_CONSUMER_GROUP with its committed
+offsets and lag, or with -reset or -shift moves the committed offsets of a
+topic. Resets are printed without being committed unless -execute is given,
+and can only be executed while the group is empty, that is while every
+con-service consumer is stopped; partitions paused by a running consumer
+are reset through its POST /admin/group/offsets/reset endpoint instead.
+-reset takes earliest, latest, an offset, an RFC 3339 time or a duration
+before now, such as 2h.
+
+Flags:
+`
+
+// offsetsFlags are the command line flags of the offsets command.
+type offsetsFlags struct {
+	topic      string
+	partitions string
+	reset      string
+	shift      int64
+	execute    bool
+}
+
+func parseOffsetsFlags(args []string, output io.Writer) (*offsetsFlags, error) {
+	f := &offsetsFlags{}
+	fs := flag.NewFlagSet("offsets", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), offsetsUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&f.topic, "topic", "", "topic to show or reset, defaults to the first of KAFKA_TOPIC for resets")
+	fs.StringVar(&f.partitions, "partitions", "", "comma separated partitions to reset, defaults to all")
+	fs.StringVar(&f.reset, "reset", "", "position to reset the offsets to")
+	fs.Int64Var(&f.shift, "shift", 0, "number of messages to move the offsets by, negative to go back")
+	fs.BoolVar(&f.execute, "execute", false, "commit the reset instead of printing it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if f.reset != "" && f.shift != 0 {
+		return nil, errors.New("-reset and -shift cannot be combined")
+	}
+	return f, nil
+}
+
+// resetting reports whether the flags ask for a reset.
+func (f *offsetsFlags) resetting() bool {
+	return f.reset != "" || f.shift != 0
+}
+
+// offsetReset resolves the flags into a reset of topic.
+func (f *offsetsFlags) offsetReset(topic string, now time.Time) (kafka.OffsetReset, error) {
+	reset := kafka.OffsetReset{Topic: topic, DryRun: !f.execute}
+	var err error
+	if reset.Partitions, err = parsePartitions(f.partitions); err != nil {
+		return reset, err
+	}
+	if f.shift != 0 {
+		reset.To, reset.Shift = kafka.ResetShift, f.shift
+		return reset, nil
+	}
+	switch f.reset {
+	case kafka.ResetEarliest, kafka.ResetLatest:
+		reset.To = f.reset
+		return reset, nil
+	}
+	if offset, err := strconv.ParseInt(f.reset, 10, 64); err == nil {
+		reset.To, reset.Offset = kafka.ResetOffset, offset
+		return reset, nil
+	}
+	position, err := kafka.ParsePosition(f.reset, now)
+	if err != nil || position.Time.IsZero() {
+		return reset, fmt.Errorf("invalid reset %q, must be earliest, latest, an offset, an RFC 3339 time or a duration", f.reset)
+	}
+	reset.To, reset.Timestamp = kafka.ResetTimestamp, position.Time
+	return reset, nil
+}
+
+// groupOffsets is what the offsets command prints without a reset.
+type groupOffsets struct {
+	kafka.GroupDescription
+	Offsets []kafka.GroupOffset `json:"offsets"`
+}
+
+// offsets runs the offsets command and returns its exit code.
+func offsets(args []string) int {
+	flags, err := parseOffsetsFlags(args, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var conf config.AppConfig
+	if err := envconfig.Process(ctx, &conf); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to process config:", err)
+		return 1
+	}
+	saramaConfig, err := kafka.NewSaramaConfig(conf.Kafka)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid kafka config:", err)
+		return 1
+	}
+	groupAdmin, err := kafka.NewGroupAdmin(conf.Kafka, saramaConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer groupAdmin.Close()
+
+	var out any
+	if flags.resetting() {
+		topic := flags.topic
+		if topic == "" {
+			topic = firstTopic(conf.Kafka.Topic)
+		}
+		reset, err := flags.offsetReset(topic, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		results, err := groupAdmin.Reset(ctx, reset)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to reset offsets:", err)
+			return 1
+		}
+		out = results
+	} else {
+		desc, err := groupAdmin.Describe()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to describe group:", err)
+			return 1
+		}
+		var topics []string
+		if flags.topic != "" {
+			topics = append(topics, flags.topic)
+		}
+		offsets, err := groupAdmin.Offsets(topics...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to list offsets:", err)
+			return 1
+		}
+		out = groupOffsets{GroupDescription: desc, Offsets: offsets}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if flags.resetting() && !flags.execute {
+		fmt.Fprintln(os.Stderr, "dry run, rerun with -execute to commit the offsets")
+	}
+	return 0
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/fair"
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+	"github.com/rlindsey28/con-service/rolldice"
+	"github.com/rlindsey28/con-service/serde"
+	"github.com/rlindsey28/con-service/state"
+	"github.com/rlindsey28/con-service/stats"
+	"github.com/rlindsey28/con-service/telemetry"
+
+	"github.com/IBM/sarama"
+	"github.com/sethvargo/go-envconfig"
+	"go.uber.org/zap"
+)
+
+const replayUsage = `Usage: con-service replay [flags]
+
+Reprocesses the messages of a topic through the roll handler, without a
+consumer group, so the offsets committed by the running consumers are not
+changed. Duplicates are not skipped, and the totals of the replayed rolls
+are printed when the replay completes.
+
+Without -rebuild the state stores are not written, so a replay only
+verifies the results. With -rebuild the stats totals of the replayed
+partitions are replaced by those of the replayed rolls, in the state stores
+and their changelog, to rebuild results after a handler bug; replay from
+oldest to rebuild the totals of every retained roll. Rebuilding requires
+STATE_ENABLED and an empty consumer group, that is every con-service
+consumer stopped, as the consumers own the stores while they run.
+
+Positions are oldest, newest, an offset, an RFC 3339 time or a duration
+before now, such as 2h.
+
+Flags:
+`
+
+// replayFlags are the command line flags of the replay command.
+type replayFlags struct {
+	topic      string
+	from       string
+	to         string
+	partitions string
+	headers    map[string]string
+	key        string
+	dryRun     bool
+	rebuild    bool
+}
+
+func parseReplayFlags(args []string, output io.Writer) (*replayFlags, error) {
+	f := &replayFlags{headers: make(map[string]string)}
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&f.topic, "topic", "", "topic to replay, defaults to the first of KAFKA_TOPIC")
+	fs.StringVar(&f.from, "from", "oldest", "position to start at")
+	fs.StringVar(&f.to, "to", "newest", "position to stop before")
+	fs.StringVar(&f.partitions, "partitions", "", "comma separated partitions to replay, defaults to all")
+	fs.Func("header", "only replay messages with the header `key=value`, may be repeated", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("header %q must be key=value", s)
+		}
+		f.headers[key] = value
+		return nil
+	})
+	fs.StringVar(&f.key, "key", "", "only replay messages with this key")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "print the decoded rolls instead of handling them")
+	fs.BoolVar(&f.rebuild, "rebuild", false, "replace the stats totals of the replayed partitions in the state stores")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if f.dryRun && f.rebuild {
+		return nil, errors.New("-dry-run and -rebuild cannot be combined")
+	}
+	return f, nil
+}
+
+// options resolves the flags into the options of a replay of the first of
+// topics unless a topic was given.
+func (f *replayFlags) options(topics string, now time.Time) (kafka.ReplayOptions, error) {
+	opts := kafka.ReplayOptions{Topic: f.topic, Headers: f.headers, Key: f.key}
+	if opts.Topic == "" {
+		opts.Topic = firstTopic(topics)
+	}
+	if opts.Topic == "" {
+		return opts, errors.New("no topic to replay")
+	}
+	var err error
+	if opts.From, err = kafka.ParsePosition(f.from, now); err != nil {
+		return opts, err
+	}
+	if opts.To, err = kafka.ParsePosition(f.to, now); err != nil {
+		return opts, err
+	}
+	opts.Partitions, err = parsePartitions(f.partitions)
+	return opts, err
+}
+
+// firstTopic is the first of a comma separated list of topics.
+func firstTopic(topics string) string {
+	return strings.TrimSpace(strings.Split(topics, ",")[0])
+}
+
+// parsePartitions reads a comma separated list of partitions.
+func parsePartitions(list string) ([]int32, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var partitions []int32
+	for _, s := range strings.Split(list, ",") {
+		partition, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid partition %q", s)
+		}
+		partitions = append(partitions, int32(partition))
+	}
+	return partitions, nil
+}
+
+// replayedRoll is a line printed by a dry run.
+type replayedRoll struct {
+	Topic     string             `json:"topic"`
+	Partition int32              `json:"partition"`
+	Offset    int64              `json:"offset"`
+	Time      time.Time          `json:"time"`
+	Key       string             `json:"key,omitempty"`
+	Roll      *rolldice.DiceRoll `json:"roll"`
+}
+
+// printRolls is the handler of a dry run, which writes every roll to out as
+// a line of JSON.
+func printRolls(h *rolldice.Handler, out io.Writer) kafka.HandlerFunc {
+	encoder := json.NewEncoder(out)
+	return func(ctx context.Context, msg *kafka.Message) error {
+		roll, err := h.Decode(ctx, msg.Data())
+		if err != nil {
+			return kafka.Permanent(err)
+		}
+		return encoder.Encode(replayedRoll{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Time:      msg.Time(),
+			Key:       string(msg.Key),
+			Roll:      roll,
+		})
+	}
+}
+
+// openTotalsTable opens the table of the stats totals of topic once no
+// consumer of the group is running. Its stores are kept in memory, as the
+// changelog is what the consumers restore theirs from.
+func openTotalsTable(conf config.AppConfig, saramaConfig *sarama.Config, topic string) (*state.Manager, *state.Table, error) {
+	if !conf.State.Enabled {
+		return nil, nil, errors.New("the state stores are disabled, set STATE_ENABLED")
+	}
+	groupAdmin, err := kafka.NewGroupAdmin(conf.Kafka, saramaConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, err := groupAdmin.Describe()
+	groupAdmin.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !desc.Empty() {
+		return nil, nil, fmt.Errorf("%w: group %s is %s with %d members, stop the consumers first",
+			kafka.ErrGroupActive, desc.Group, desc.State, len(desc.Members))
+	}
+
+	stateConf := *conf.State
+	stateConf.Backend = state.BackendMemory
+	stateManager, err := state.NewManager(&stateConf, conf.Kafka, saramaConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stateManager, stateManager.Table(stats.TotalsTable, topic), nil
+}
+
+// resetTotals restores the stats totals of the partitions of the replay and
+// deletes them, so that the replayed rolls replace them.
+func resetTotals(ctx context.Context, client sarama.Client, aggregator *stats.Aggregator, opts kafka.ReplayOptions) error {
+	partitions := opts.Partitions
+	if partitions == nil {
+		var err error
+		if partitions, err = client.Partitions(opts.Topic); err != nil {
+			return err
+		}
+	}
+	if err := aggregator.OnAssigned(ctx, opts.Topic, partitions); err != nil {
+		return err
+	}
+	return aggregator.Reset(ctx, opts.Topic, partitions)
+}
+
+// replay runs the replay command and returns its exit code.
+func replay(args []string) int {
+	flags, err := parseReplayFlags(args, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var conf config.AppConfig
+	if err := envconfig.Process(ctx, &conf); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to process config:", err)
+		return 1
+	}
+	zaplog := logger.Get()
+	opts, err := flags.options(conf.Kafka.Topic, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	otelShutdown, err := telemetry.SetupOtelSDK(ctx, conf)
+	if err != nil {
+		zaplog.Error("failed to setup otel", zap.Error(err))
+		return 1
+	}
+	defer otelShutdown(context.Background())
+
+	saramaConfig, err := kafka.NewSaramaConfig(conf.Kafka)
+	if err != nil {
+		zaplog.Error("invalid kafka config", zap.Error(err))
+		return 1
+	}
+	client, err := sarama.NewClient(conf.Kafka.Brokers, saramaConfig)
+	if err != nil {
+		zaplog.Error("failed to connect to kafka", zap.Error(err))
+		return 1
+	}
+	defer client.Close()
+
+	rollHandler := &rolldice.Handler{}
+	if conf.Schema.RegistryURL != "" {
+		rollHandler.Deserializer = serde.NewDeserializer(serde.NewClient(conf.Schema.RegistryURL))
+	}
+	var handler kafka.Handler = printRolls(rollHandler, os.Stdout)
+	var aggregator *stats.Aggregator
+	if !flags.dryRun {
+		if conf.Fair.SeedURL != "" {
+			rollHandler.Verifier = fair.NewVerifier(conf.Fair.SeedURL)
+		}
+		// Without -rebuild the totals are only kept in memory.
+		var totalsTable *state.Table
+		if flags.rebuild {
+			stateManager, table, err := openTotalsTable(conf, saramaConfig, opts.Topic)
+			if err != nil {
+				zaplog.Error("cannot rebuild the stats totals", zap.Error(err))
+				return 1
+			}
+			defer stateManager.Close()
+			defer table.Close()
+			totalsTable = table
+		}
+		if aggregator, err = stats.NewAggregator(conf.Stats, nil, totalsTable); err != nil {
+			zaplog.Error("invalid stats config", zap.Error(err))
+			return 1
+		}
+		if flags.rebuild {
+			if err := resetTotals(ctx, client, aggregator, opts); err != nil {
+				zaplog.Error("failed to reset the stats totals", zap.Error(err))
+				return 1
+			}
+		}
+		rollHandler.Stats = aggregator
+		handler = rollHandler
+	}
+
+	result, err := kafka.Replay(ctx, client, opts, handler)
+	zaplog.Info("replay finished", zap.String("topic", opts.Topic), zap.Int("read", result.Read), zap.Int("matched", result.Matched),
+		zap.Int("handled", result.Handled), zap.Int("failed", result.Failed))
+	if aggregator != nil {
+		totals, _ := json.MarshalIndent(aggregator.Totals(), "", "  ")
+		fmt.Println(string(totals))
+	}
+	if err != nil {
+		zaplog.Error("replay failed", zap.Error(err))
+		return 1
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
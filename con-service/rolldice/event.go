@@ -0,0 +1,109 @@
+package rolldice
+
+import "github.com/rlindsey28/con-service/fair"
+
+// Event is a DiceRoll in the shape of the registered dice roll schema, as
+// published by pub-service with the avro or protobuf encoding. Maps are
+// written as lists because neither format supports integer map keys.
+type Event struct {
+	Rolls        int          `avro:"rolls" json:"rolls"`
+	Sides        int          `avro:"sides" json:"sides"`
+	Table        string       `avro:"table" json:"table"`
+	Distribution []EventFace  `avro:"distribution" json:"distribution"`
+	Result       *EventResult `avro:"result" json:"result,omitempty"`
+	Proof        *EventProof  `avro:"proof" json:"proof,omitempty"`
+}
+
+type EventFace struct {
+	Face  int `avro:"face" json:"face"`
+	Count int `avro:"count" json:"count"`
+}
+
+type EventResult struct {
+	Expression   string              `avro:"expression" json:"expression"`
+	Total        int                 `avro:"total" json:"total"`
+	Terms        []EventTerm         `avro:"terms" json:"terms"`
+	Distribution []EventDistribution `avro:"distribution" json:"distribution"`
+}
+
+type EventTerm struct {
+	Notation  string     `avro:"notation" json:"notation"`
+	Sign      int        `avro:"sign" json:"sign"`
+	Sides     int        `avro:"sides" json:"sides"`
+	Dice      []EventDie `avro:"dice" json:"dice"`
+	Successes *int       `avro:"successes" json:"successes,omitempty"`
+	Value     int        `avro:"value" json:"value"`
+}
+
+type EventDie struct {
+	Value    int  `avro:"value" json:"value"`
+	Kept     bool `avro:"kept" json:"kept"`
+	Exploded bool `avro:"exploded" json:"exploded"`
+	Success  bool `avro:"success" json:"success"`
+}
+
+type EventDistribution struct {
+	Sides int         `avro:"sides" json:"sides"`
+	Faces []EventFace `avro:"faces" json:"faces"`
+}
+
+type EventProof struct {
+	Version        string      `avro:"version" json:"version"`
+	ServerSeedHash string      `avro:"server_seed_hash" json:"server_seed_hash"`
+	ClientSeed     string      `avro:"client_seed" json:"client_seed"`
+	Nonce          uint64      `avro:"nonce" json:"nonce,string"`
+	Draws          []EventDraw `avro:"draws" json:"draws"`
+}
+
+type EventDraw struct {
+	Sides int `avro:"sides" json:"sides"`
+	Value int `avro:"value" json:"value"`
+}
+
+// DiceRoll converts e to the same DiceRoll a JSON message would decode to.
+func (e *Event) DiceRoll() *DiceRoll {
+	roll := &DiceRoll{
+		Rolls:        int8(e.Rolls),
+		Sides:        int8(e.Sides),
+		Table:        e.Table,
+		Distribution: make(map[int8]int32, len(e.Distribution)),
+	}
+	for _, f := range e.Distribution {
+		roll.Distribution[int8(f.Face)] = int32(f.Count)
+	}
+
+	if r := e.Result; r != nil {
+		roll.Result = &ExpressionResult{
+			Expression:   r.Expression,
+			Total:        r.Total,
+			Distribution: make(map[int]map[int]int32, len(r.Distribution)),
+		}
+		for _, t := range r.Terms {
+			term := TermResult{Notation: t.Notation, Sign: t.Sign, Sides: t.Sides, Successes: t.Successes, Value: t.Value}
+			for _, d := range t.Dice {
+				term.Dice = append(term.Dice, Die{Value: d.Value, Kept: d.Kept, Exploded: d.Exploded, Success: d.Success})
+			}
+			roll.Result.Terms = append(roll.Result.Terms, term)
+		}
+		for _, dist := range r.Distribution {
+			faces := make(map[int]int32, len(dist.Faces))
+			for _, f := range dist.Faces {
+				faces[f.Face] = int32(f.Count)
+			}
+			roll.Result.Distribution[dist.Sides] = faces
+		}
+	}
+
+	if p := e.Proof; p != nil {
+		roll.Proof = &fair.Proof{
+			Version:        p.Version,
+			ServerSeedHash: p.ServerSeedHash,
+			ClientSeed:     p.ClientSeed,
+			Nonce:          p.Nonce,
+		}
+		for _, d := range p.Draws {
+			roll.Proof.Draws = append(roll.Proof.Draws, fair.Draw{Sides: d.Sides, Value: d.Value})
+		}
+	}
+	return roll
+}
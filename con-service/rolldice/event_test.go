@@ -0,0 +1,141 @@
+package rolldice
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/rlindsey28/con-service/internal/serdetest"
+	"github.com/rlindsey28/con-service/serde"
+)
+
+// readSchema reads a schema published by pub-service, so the test fails when
+// the two services drift apart.
+func readSchema(t *testing.T, name string) string {
+	b, err := os.ReadFile("../../pub-service/rolldice/schema/" + name)
+	if os.IsNotExist(err) {
+		t.Skipf("pub-service schema %s not found", name)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestEventDiceRoll(t *testing.T) {
+	avroCodec, err := serde.NewAvroCodec(readSchema(t, "dice_roll.avsc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	protoCodec, err := serde.NewProtobufCodec(readSchema(t, "dice_roll.proto"), "gosandbox.dice.v1.DiceRoll")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	successes := 1
+	event := &Event{
+		Rolls:        2,
+		Sides:        6,
+		Table:        "table-7",
+		Distribution: []EventFace{{Face: 2, Count: 1}, {Face: 6, Count: 1}},
+		Result: &EventResult{
+			Expression: "2d6",
+			Total:      8,
+			Terms: []EventTerm{{
+				Notation:  "2d6",
+				Sign:      1,
+				Sides:     6,
+				Dice:      []EventDie{{Value: 2, Kept: true}, {Value: 6, Kept: true, Success: true}},
+				Successes: &successes,
+				Value:     8,
+			}},
+			Distribution: []EventDistribution{{Sides: 6, Faces: []EventFace{{Face: 2, Count: 1}, {Face: 6, Count: 1}}}},
+		},
+		Proof: &EventProof{
+			Version:        "v1",
+			ServerSeedHash: "3eb1bd43",
+			ClientSeed:     "player-1",
+			Nonce:          3,
+			Draws:          []EventDraw{{Sides: 6, Value: 2}, {Sides: 6, Value: 6}},
+		},
+	}
+
+	ctx := context.Background()
+	for name, codec := range map[string]serde.Codec{"avro": avroCodec, "protobuf": protoCodec} {
+		t.Run(name, func(t *testing.T) {
+			registry := serdetest.NewRegistry()
+			s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := s.Serialize(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := &Event{}
+			if err := serde.NewDeserializer(registry).Deserialize(ctx, data, got); err != nil {
+				t.Fatal(err)
+			}
+			roll := got.DiceRoll()
+
+			if roll.Rolls != 2 || roll.Sides != 6 || roll.Table != "table-7" {
+				t.Errorf("unexpected roll %+v", roll)
+			}
+			if roll.Distribution[2] != 1 || roll.Distribution[6] != 1 {
+				t.Errorf("unexpected distribution %v", roll.Distribution)
+			}
+			if roll.Result == nil || roll.Result.Total != 8 || len(roll.Result.Terms) != 1 {
+				t.Fatalf("unexpected result %+v", roll.Result)
+			}
+			if term := roll.Result.Terms[0]; len(term.Dice) != 2 || term.Successes == nil || *term.Successes != 1 {
+				t.Errorf("unexpected term %+v", term)
+			}
+			if roll.Result.Distribution[6][6] != 1 {
+				t.Errorf("unexpected result distribution %v", roll.Result.Distribution)
+			}
+			if roll.Proof == nil || roll.Proof.Nonce != 3 || len(roll.Proof.Draws) != 2 {
+				t.Errorf("unexpected proof %+v", roll.Proof)
+			}
+		})
+	}
+}
+
+func TestEventNonceRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range []string{"dice_roll.avsc", "dice_roll.proto"} {
+		t.Run(name, func(t *testing.T) {
+			var codec serde.Codec
+			var err error
+			if name == "dice_roll.avsc" {
+				codec, err = serde.NewAvroCodec(readSchema(t, name))
+			} else {
+				codec, err = serde.NewProtobufCodec(readSchema(t, name), "gosandbox.dice.v1.DiceRoll")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := serdetest.NewRegistry()
+			s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, nonce := range []uint64{0, math.MaxInt64, math.MaxInt64 + 1, math.MaxUint64} {
+				event := &Event{Distribution: []EventFace{}, Proof: &EventProof{Version: "v1", Nonce: nonce, Draws: []EventDraw{}}}
+				data, err := s.Serialize(event)
+				if err != nil {
+					t.Fatalf("nonce %d: %v", nonce, err)
+				}
+				got := &Event{}
+				if err := serde.NewDeserializer(registry).Deserialize(ctx, data, got); err != nil {
+					t.Fatalf("nonce %d: %v", nonce, err)
+				}
+				if roll := got.DiceRoll(); roll.Proof == nil || roll.Proof.Nonce != nonce {
+					t.Errorf("got proof %+v, want nonce %d", roll.Proof, nonce)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package rolldice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/rlindsey28/con-service/fair"
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+	"github.com/rlindsey28/con-service/serde"
+
+	"go.uber.org/zap"
+)
+
+var ErrNoRegistry = errors.New("received a schema registry encoded roll but no registry is configured")
+
+// Recorder is told of every roll and the message it was read from,
+// implemented by stats.Aggregator. A Recorder that also implements
+// kafka.PartitionListener is told of partition assignments.
+type Recorder interface {
+	Record(ctx context.Context, msg *kafka.Message, roll *DiceRoll) error
+}
+
+// Handler reads the dice rolls published by pub-service, verifies the
+// provably fair ones and records them in Stats. Deserializer, Verifier and
+// Stats are optional.
+type Handler struct {
+	Verifier     *fair.Verifier
+	Deserializer *serde.Deserializer
+	Stats        Recorder
+}
+
+func (h *Handler) Handle(ctx context.Context, msg *kafka.Message) error {
+	roll, err := h.Decode(ctx, msg.Data())
+	if err != nil {
+		return kafka.Permanent(err)
+	}
+	logger.FromCtx(ctx).Info("Dice roll", zap.Any("roll", roll))
+	h.verify(ctx, roll)
+	if h.Stats != nil {
+		return h.Stats.Record(ctx, msg, roll)
+	}
+	return nil
+}
+
+func (h *Handler) OnAssigned(ctx context.Context, topic string, partitions []int32) error {
+	if listener, ok := h.Stats.(kafka.PartitionListener); ok {
+		return listener.OnAssigned(ctx, topic, partitions)
+	}
+	return nil
+}
+
+func (h *Handler) OnRevoked(ctx context.Context, topic string, partitions []int32) error {
+	if listener, ok := h.Stats.(kafka.PartitionListener); ok {
+		return listener.OnRevoked(ctx, topic, partitions)
+	}
+	return nil
+}
+
+// Decode reads a roll published as JSON or, when it starts with the schema
+// registry wire format header, with the writer's registered schema.
+func (h *Handler) Decode(ctx context.Context, value []byte) (*DiceRoll, error) {
+	if !serde.IsWireFormat(value) {
+		roll := &DiceRoll{}
+		if err := json.Unmarshal(value, roll); err != nil {
+			return nil, err
+		}
+		return roll, nil
+	}
+	if h.Deserializer == nil {
+		return nil, ErrNoRegistry
+	}
+	event := &Event{}
+	if err := h.Deserializer.Deserialize(ctx, value, event); err != nil {
+		return nil, err
+	}
+	return event.DiceRoll(), nil
+}
+
+// verify re-derives a provably fair roll. Rolls whose server seed has not been
+// rotated yet are verified later by the Verifier.
+func (h *Handler) verify(ctx context.Context, roll *DiceRoll) {
+	if roll.Proof == nil || h.Verifier == nil {
+		return
+	}
+	log := logger.FromCtx(ctx)
+	err := h.Verifier.Verify(ctx, roll.Proof)
+	switch {
+	case err == nil:
+		log.Info("provably fair roll verified", zap.String("server_seed_hash", roll.Proof.ServerSeedHash), zap.Uint64("nonce", roll.Proof.Nonce))
+	case errors.Is(err, fair.ErrNotRevealed):
+		log.Debug("provably fair roll pending seed reveal", zap.String("server_seed_hash", roll.Proof.ServerSeedHash), zap.Uint64("nonce", roll.Proof.Nonce))
+	default:
+		log.Error("provably fair roll failed verification", zap.String("server_seed_hash", roll.Proof.ServerSeedHash), zap.Uint64("nonce", roll.Proof.Nonce), zap.Error(err))
+	}
+}
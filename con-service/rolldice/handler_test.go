@@ -0,0 +1,65 @@
+package rolldice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/kafka"
+
+	"github.com/IBM/sarama"
+)
+
+func TestHandlerDecode(t *testing.T) {
+	h := &Handler{}
+	roll, err := h.Decode(context.Background(), []byte(`{"rolls":3,"sides":6,"table":"table-7","distribution":{"2":1,"5":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roll.Rolls != 3 || roll.Table != "table-7" || roll.Distribution[5] != 2 {
+		t.Errorf("unexpected roll %+v", roll)
+	}
+}
+
+func TestHandlerInvalidRollIsPermanent(t *testing.T) {
+	tests := map[string][]byte{
+		"invalid json": []byte(`{"rolls":`),
+		"no registry":  {0, 0, 0, 0, 1, 2},
+	}
+	h := &Handler{}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			msg := &kafka.Message{ConsumerMessage: &sarama.ConsumerMessage{Value: value}}
+			if err := h.Handle(context.Background(), msg); !errors.Is(err, kafka.ErrPermanent) {
+				t.Errorf("got %v, want a permanent error", err)
+			}
+		})
+	}
+}
+
+type recorder struct {
+	roll *DiceRoll
+	at   time.Time
+}
+
+func (r *recorder) Record(_ context.Context, msg *kafka.Message, roll *DiceRoll) error {
+	r.roll, r.at = roll, msg.Time()
+	return nil
+}
+
+func TestHandlerRecordsEventTime(t *testing.T) {
+	stats := &recorder{}
+	h := &Handler{Stats: stats}
+	timestamp := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := &kafka.Message{ConsumerMessage: &sarama.ConsumerMessage{
+		Value:     []byte(`{"rolls":1,"sides":6,"distribution":{"4":1}}`),
+		Timestamp: timestamp,
+	}}
+	if err := h.Handle(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if stats.roll == nil || stats.roll.Distribution[4] != 1 || !stats.at.Equal(timestamp) {
+		t.Errorf("recorded %+v at %v, want the roll at the record timestamp", stats.roll, stats.at)
+	}
+}
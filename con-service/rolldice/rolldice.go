@@ -1,7 +1,39 @@
 package rolldice
 
+import "github.com/rlindsey28/con-service/fair"
+
 type DiceRoll struct {
-	Rolls        int8           `json:"rolls"`
-	Sides        int8           `json:"sides"`
-	Distribution map[int8]int32 `json:"distribution"`
+	Rolls        int8              `json:"rolls"`
+	Sides        int8              `json:"sides"`
+	Table        string            `json:"table,omitempty"`
+	Distribution map[int8]int32    `json:"distribution"`
+	Result       *ExpressionResult `json:"result,omitempty"`
+	Proof        *fair.Proof       `json:"proof,omitempty"`
+}
+
+// ExpressionResult is the outcome of a dice expression such as "4d6kh3+2".
+// Distribution counts every die rolled keyed by number of sides and then face.
+type ExpressionResult struct {
+	Expression   string                `json:"expression"`
+	Total        int                   `json:"total"`
+	Terms        []TermResult          `json:"terms"`
+	Distribution map[int]map[int]int32 `json:"distribution"`
+}
+
+// TermResult is the breakdown of a single term of an expression.
+type TermResult struct {
+	Notation  string `json:"notation"`
+	Sign      int    `json:"sign"`
+	Sides     int    `json:"sides,omitempty"`
+	Dice      []Die  `json:"dice,omitempty"`
+	Successes *int   `json:"successes,omitempty"`
+	Value     int    `json:"value"`
+}
+
+// Die is the outcome of a single die.
+type Die struct {
+	Value    int  `json:"value"`
+	Kept     bool `json:"kept"`
+	Exploded bool `json:"exploded,omitempty"`
+	Success  bool `json:"success,omitempty"`
 }
@@ -0,0 +1,206 @@
+package serde
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Codec encodes values for one schema. The bytes produced by Marshal follow
+// the schema ID in the wire format.
+type Codec interface {
+	Schema() Schema
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// NewCodec returns the codec for a schema fetched from the registry.
+func NewCodec(schema Schema) (Codec, error) {
+	switch schema.schemaType() {
+	case SchemaTypeAvro:
+		return NewAvroCodec(schema.Schema)
+	case SchemaTypeProtobuf:
+		return NewProtobufCodec(schema.Schema, "")
+	}
+	return nil, fmt.Errorf("unsupported schema type %q", schema.Type)
+}
+
+// AvroCodec encodes structs using their avro field tags.
+type AvroCodec struct {
+	source string
+	schema avro.Schema
+}
+
+func NewAvroCodec(schema string) (*AvroCodec, error) {
+	parsed, err := avro.Parse(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+	return &AvroCodec{source: schema, schema: parsed}, nil
+}
+
+func (c *AvroCodec) Schema() Schema {
+	return Schema{Type: SchemaTypeAvro, Schema: c.source}
+}
+
+func (c *AvroCodec) Marshal(v any) ([]byte, error) {
+	return avro.Marshal(c.schema, v)
+}
+
+func (c *AvroCodec) Unmarshal(data []byte, v any) error {
+	return avro.Unmarshal(c.schema, data, v)
+}
+
+// ProtobufCodec encodes values whose JSON form matches a protobuf message,
+// so no generated code is needed: values go through protojson and a dynamic
+// message built from the schema source. The bytes start with the Confluent
+// message indexes that locate the message within the schema.
+type ProtobufCodec struct {
+	source  string
+	file    protoreflect.FileDescriptor
+	message protoreflect.MessageDescriptor
+	indexes []int
+}
+
+const protoFileName = "schema.proto"
+
+// NewProtobufCodec compiles schema and encodes the message with the given
+// full name, or the first message of the schema if name is empty.
+func NewProtobufCodec(schema, name string) (*ProtobufCodec, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{protoFileName: schema}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), protoFileName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf schema: %w", err)
+	}
+	c := &ProtobufCodec{source: schema, file: files[0]}
+
+	if c.file.Messages().Len() == 0 {
+		return nil, errors.New("protobuf schema has no messages")
+	}
+	if name == "" {
+		c.message = c.file.Messages().Get(0)
+		c.indexes = []int{0}
+		return c, nil
+	}
+	desc := files[0].FindDescriptorByName(protoreflect.FullName(name))
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("message %q not found in protobuf schema", name)
+	}
+	c.message = message
+	c.indexes = messageIndexes(message)
+	return c, nil
+}
+
+// messageIndexes returns the path of m from the top of its file.
+func messageIndexes(m protoreflect.MessageDescriptor) []int {
+	var indexes []int
+	var d protoreflect.Descriptor = m
+	for {
+		if _, ok := d.(protoreflect.MessageDescriptor); !ok {
+			break
+		}
+		indexes = append([]int{d.Index()}, indexes...)
+		d = d.Parent()
+	}
+	return indexes
+}
+
+func (c *ProtobufCodec) Schema() Schema {
+	return Schema{Type: SchemaTypeProtobuf, Schema: c.source}
+}
+
+func (c *ProtobufCodec) Marshal(v any) ([]byte, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(c.message)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(js, msg); err != nil {
+		return nil, fmt.Errorf("value does not match %s: %w", c.message.FullName(), err)
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendIndexes(nil, c.indexes), payload...), nil
+}
+
+func (c *ProtobufCodec) Unmarshal(data []byte, v any) error {
+	indexes, n, err := readIndexes(data)
+	if err != nil {
+		return err
+	}
+	desc, err := c.resolve(indexes)
+	if err != nil {
+		return err
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data[n:], msg); err != nil {
+		return err
+	}
+	js, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+func (c *ProtobufCodec) resolve(indexes []int) (protoreflect.MessageDescriptor, error) {
+	messages := c.file.Messages()
+	var desc protoreflect.MessageDescriptor
+	for _, i := range indexes {
+		if i < 0 || i >= messages.Len() {
+			return nil, fmt.Errorf("message index %v not found in protobuf schema", indexes)
+		}
+		desc = messages.Get(i)
+		messages = desc.Messages()
+	}
+	return desc, nil
+}
+
+// appendIndexes writes message indexes as zig-zag varints prefixed with their
+// count. The common case of the first message is written as a single 0.
+func appendIndexes(b []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(b, 0)
+	}
+	b = binary.AppendVarint(b, int64(len(indexes)))
+	for _, i := range indexes {
+		b = binary.AppendVarint(b, int64(i))
+	}
+	return b
+}
+
+func readIndexes(data []byte) ([]int, int, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 || count > int64(len(data)) {
+		return nil, 0, errors.New("invalid protobuf message indexes")
+	}
+	if count == 0 {
+		return []int{0}, n, nil
+	}
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		index, m := binary.Varint(data[n:])
+		if m <= 0 {
+			return nil, 0, errors.New("invalid protobuf message indexes")
+		}
+		indexes = append(indexes, int(index))
+		n += m
+	}
+	return indexes, n, nil
+}
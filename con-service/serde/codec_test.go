@@ -0,0 +1,23 @@
+package serde
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestMessageIndexes(t *testing.T) {
+	for _, indexes := range [][]int{{0}, {1}, {1, 0}, {3, 2, 1}} {
+		b := appendIndexes(nil, indexes)
+		got, n, err := readIndexes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, indexes) || n != len(b) {
+			t.Errorf("got indexes %v from %d bytes, want %v from %d", got, n, indexes, len(b))
+		}
+	}
+	if b := appendIndexes(nil, []int{0}); !bytes.Equal(b, []byte{0}) {
+		t.Errorf("got %v for the first message, want the single byte 0", b)
+	}
+}
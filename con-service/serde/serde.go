@@ -0,0 +1,129 @@
+// Package serde encodes messages in the Confluent Schema Registry wire
+// format: a zero magic byte, the big-endian schema ID and the encoded value.
+package serde
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	EncodingJSON     = "json"
+	EncodingAvro     = "avro"
+	EncodingProtobuf = "protobuf"
+)
+
+const (
+	magicByte  = 0
+	headerSize = 5
+)
+
+var ErrNotWireFormat = errors.New("message is not in the schema registry wire format")
+
+// IsWireFormat reports whether data starts with the wire format header. JSON
+// documents never do.
+func IsWireFormat(data []byte) bool {
+	return len(data) >= headerSize && data[0] == magicByte
+}
+
+// Serializer encodes values with a codec whose schema is registered under a
+// subject.
+type Serializer struct {
+	codec Codec
+	id    int
+}
+
+// NewSerializer resolves the schema ID of codec under subject. With
+// autoRegister the schema is registered if needed, after checking that it is
+// compatible with the latest version; otherwise it must already exist.
+func NewSerializer(ctx context.Context, registry Registry, subject string, codec Codec, autoRegister bool) (*Serializer, error) {
+	schema := codec.Schema()
+	var id int
+	if autoRegister {
+		compatible, err := registry.Compatible(ctx, subject, schema)
+		if err != nil {
+			return nil, err
+		}
+		if !compatible {
+			return nil, fmt.Errorf("%s: %w", subject, ErrIncompatibleSchema)
+		}
+		if id, err = registry.Register(ctx, subject, schema); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if id, err = registry.Lookup(ctx, subject, schema); err != nil {
+			return nil, err
+		}
+	}
+	return &Serializer{codec: codec, id: id}, nil
+}
+
+// SchemaID is the registry ID written in front of every message.
+func (s *Serializer) SchemaID() int {
+	return s.id
+}
+
+func (s *Serializer) Serialize(v any) ([]byte, error) {
+	payload, err := s.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, headerSize, headerSize+len(payload))
+	data[0] = magicByte
+	binary.BigEndian.PutUint32(data[1:headerSize], uint32(s.id))
+	return append(data, payload...), nil
+}
+
+// Deserializer decodes wire format messages with the writer's schema, which
+// is fetched from the registry on first use.
+type Deserializer struct {
+	registry Registry
+
+	mu     sync.Mutex
+	codecs map[int]Codec
+}
+
+func NewDeserializer(registry Registry) *Deserializer {
+	return &Deserializer{registry: registry, codecs: make(map[int]Codec)}
+}
+
+func (d *Deserializer) Deserialize(ctx context.Context, data []byte, v any) error {
+	if !IsWireFormat(data) {
+		return ErrNotWireFormat
+	}
+	id := int(binary.BigEndian.Uint32(data[1:headerSize]))
+	codec, err := d.codec(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := codec.Unmarshal(data[headerSize:], v); err != nil {
+		return fmt.Errorf("failed to decode message with schema %d: %w", id, err)
+	}
+	return nil
+}
+
+func (d *Deserializer) codec(ctx context.Context, id int) (Codec, error) {
+	d.mu.Lock()
+	codec, ok := d.codecs[id]
+	d.mu.Unlock()
+	if ok {
+		return codec, nil
+	}
+
+	schema, err := d.registry.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	codec, err = NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.codecs[id] = codec
+	d.mu.Unlock()
+	return codec, nil
+}
@@ -0,0 +1,200 @@
+package serde_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rlindsey28/con-service/internal/serdetest"
+	"github.com/rlindsey28/con-service/serde"
+)
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "Roll",
+  "fields": [
+    {"name": "sides", "type": "int"},
+    {"name": "table", "type": "string"}
+  ]
+}`
+
+const testAvroSchemaV2 = `{
+  "type": "record",
+  "name": "Roll",
+  "fields": [
+    {"name": "sides", "type": "int"},
+    {"name": "table", "type": "string"},
+    {"name": "seat", "type": "int", "default": 0}
+  ]
+}`
+
+const testAvroSchemaIncompatible = `{
+  "type": "record",
+  "name": "Roll",
+  "fields": [
+    {"name": "sides", "type": "int"},
+    {"name": "table", "type": "string"},
+    {"name": "seat", "type": "int"}
+  ]
+}`
+
+const testProtoSchema = `syntax = "proto3";
+package test.v1;
+
+message Other {
+  string name = 1;
+}
+
+message Roll {
+  int32 sides = 1;
+  string table = 2;
+
+  message Nested {
+    int32 seat = 1;
+  }
+}`
+
+type testRoll struct {
+	Sides int    `avro:"sides" json:"sides"`
+	Table string `avro:"table" json:"table"`
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	registry := serdetest.NewRegistry()
+	codec, err := serde.NewAvroCodec(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.Serialize(&testRoll{Sides: 6, Table: "table-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serde.IsWireFormat(data) || !bytes.Equal(data[:5], []byte{0, 0, 0, 0, byte(s.SchemaID())}) {
+		t.Fatalf("got header %v, want the magic byte and schema ID %d", data[:5], s.SchemaID())
+	}
+
+	var got testRoll
+	if err := serde.NewDeserializer(registry).Deserialize(ctx, data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (testRoll{Sides: 6, Table: "table-7"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	registry := serdetest.NewRegistry()
+	codec, err := serde.NewProtobufCodec(testProtoSchema, "test.v1.Roll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.Serialize(&testRoll{Sides: 20, Table: "table-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Roll is the second message: one index, 1, as zig-zag varints.
+	if !bytes.Equal(data[5:7], []byte{2, 2}) {
+		t.Errorf("got message indexes %v, want [2 2]", data[5:7])
+	}
+
+	var got testRoll
+	if err := serde.NewDeserializer(registry).Deserialize(ctx, data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (testRoll{Sides: 20, Table: "table-1"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDeserializeNotWireFormat(t *testing.T) {
+	err := serde.NewDeserializer(serdetest.NewRegistry()).Deserialize(context.Background(), []byte(`{"sides":6}`), &testRoll{})
+	if !errors.Is(err, serde.ErrNotWireFormat) {
+		t.Errorf("got %v, want ErrNotWireFormat", err)
+	}
+}
+
+func TestDeserializeUnknownSchema(t *testing.T) {
+	err := serde.NewDeserializer(serdetest.NewRegistry()).Deserialize(context.Background(), []byte{0, 0, 0, 0, 9, 2}, &testRoll{})
+	if !errors.Is(err, serde.ErrSchemaNotFound) {
+		t.Errorf("got %v, want ErrSchemaNotFound", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(serdetest.NewRegistry())
+	defer srv.Close()
+	client := serde.NewClient(srv.URL)
+	subject := serde.TopicSubject("dice-rolls")
+	schema := serde.Schema{Schema: testAvroSchema}
+
+	if _, err := client.Lookup(ctx, subject, schema); !errors.Is(err, serde.ErrSchemaNotFound) {
+		t.Errorf("got %v looking up an unregistered schema, want ErrSchemaNotFound", err)
+	}
+	compatible, err := client.Compatible(ctx, subject, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compatible {
+		t.Error("a new subject should accept any schema")
+	}
+
+	id, err := client.Register(ctx, subject, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := client.Register(ctx, subject, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id {
+		t.Errorf("got ID %d registering the schema again, want %d", again, id)
+	}
+
+	got, err := client.SchemaByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Schema != testAvroSchema {
+		t.Errorf("got schema %s", got.Schema)
+	}
+
+	for schema, want := range map[string]bool{testAvroSchemaV2: true, testAvroSchemaIncompatible: false} {
+		compatible, err := client.Compatible(ctx, subject, serde.Schema{Schema: schema})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compatible != want {
+			t.Errorf("got compatible %t, want %t for %s", compatible, want, schema)
+		}
+	}
+
+	if _, err := client.Register(ctx, subject, serde.Schema{Schema: testAvroSchemaIncompatible}); !errors.Is(err, serde.ErrIncompatibleSchema) {
+		t.Errorf("got %v, want ErrIncompatibleSchema", err)
+	}
+}
+
+func TestNewSerializerWithoutAutoRegister(t *testing.T) {
+	codec, err := serde.NewAvroCodec(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = serde.NewSerializer(context.Background(), serdetest.NewRegistry(), "dice-rolls-value", codec, false)
+	if !errors.Is(err, serde.ErrSchemaNotFound) {
+		t.Errorf("got %v, want ErrSchemaNotFound", err)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+
+	"github.com/rlindsey28/con-service/kafka"
+)
+
+// pauseOnSignal does nothing without SIGUSR1 and SIGUSR2.
+func pauseOnSignal(context.Context, *kafka.Consumer) {}
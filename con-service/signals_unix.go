@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+
+	"go.uber.org/zap"
+)
+
+// pauseOnSignal pauses every partition of consumer on SIGUSR1 and resumes
+// them on SIGUSR2, until ctx is done.
+func pauseOnSignal(ctx context.Context, consumer *kafka.Consumer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			var err error
+			if sig == syscall.SIGUSR1 {
+				err = consumer.Pause(nil)
+			} else {
+				err = consumer.Resume(nil)
+			}
+			if err != nil {
+				logger.Get().Error("failed to handle signal", zap.Stringer("signal", sig), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	recordPut        byte = 1
+	recordDelete     byte = 2
+	recordCheckpoint byte = 3
+
+	// headerSize is the size of a record's CRC, kind, key and value lengths.
+	headerSize = 4 + 1 + 4 + 4
+
+	// compactMinRecords is the number of records below which the log of a
+	// DiskStore is never compacted.
+	compactMinRecords = 1024
+)
+
+// DiskStore is a Store persisted in an append-only log file. Every write
+// appends a record and an index of the live keys is kept in memory, so reads
+// take one positioned read. The log is compacted when it holds more than
+// twice as many records as live keys. A record torn by a crash, and anything
+// after it, is dropped when the store is opened again; the changelog restore
+// then fills in what was lost.
+type DiskStore struct {
+	path string
+
+	mu         sync.RWMutex
+	file       *os.File
+	size       int64
+	index      map[string]location
+	records    int
+	checkpoint int64
+}
+
+// location is where the value of a key is in the log.
+type location struct {
+	offset int64
+	length uint32
+}
+
+// OpenDiskStore opens the store at path, creating it if it does not exist.
+func OpenDiskStore(path string) (*DiskStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &DiskStore{path: path, file: file}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to load state store %s: %w", path, err)
+	}
+	if s.shouldCompact() {
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads the log into the index and truncates it after the last whole
+// record.
+func (s *DiskStore) load() error {
+	s.index = make(map[string]location)
+	s.records = 0
+	s.checkpoint = -1
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	var (
+		offset int64
+		header [headerSize]byte
+	)
+	for offset < info.Size() {
+		if _, err := s.file.ReadAt(header[:], offset); err != nil {
+			break
+		}
+		kind := header[4]
+		keyLen := binary.BigEndian.Uint32(header[5:9])
+		valueLen := binary.BigEndian.Uint32(header[9:13])
+		end := offset + headerSize + int64(keyLen) + int64(valueLen)
+		if end > info.Size() {
+			break
+		}
+		body := make([]byte, keyLen+valueLen)
+		if _, err := s.file.ReadAt(body, offset+headerSize); err != nil {
+			break
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+			break
+		}
+		key := string(body[:keyLen])
+		switch kind {
+		case recordPut:
+			s.index[key] = location{offset: offset + headerSize + int64(keyLen), length: valueLen}
+		case recordDelete:
+			delete(s.index, key)
+		case recordCheckpoint:
+			if valueLen == 8 {
+				s.checkpoint = int64(binary.BigEndian.Uint64(body[keyLen:]))
+			}
+		default:
+			return fmt.Errorf("unknown record kind %d at %d", kind, offset)
+		}
+		s.records++
+		offset = end
+	}
+	if offset < info.Size() {
+		if err := s.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	s.size = offset
+	return nil
+}
+
+// appendRecord writes a record at the end of the log and returns the
+// offset of its value. s.mu must be held.
+func (s *DiskStore) appendRecord(kind byte, key string, value []byte) (int64, error) {
+	if s.file == nil {
+		return 0, ErrStoreClosed
+	}
+	buf := encodeRecord(kind, key, value)
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		// Drop whatever part of the record was written.
+		_ = s.file.Truncate(s.size)
+		return 0, err
+	}
+	valueOffset := s.size + headerSize + int64(len(key))
+	s.size += int64(len(buf))
+	s.records++
+	return valueOffset, nil
+}
+
+func encodeRecord(kind byte, key string, value []byte) []byte {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = kind
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func (s *DiskStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.file == nil {
+		return nil, false, ErrStoreClosed
+	}
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value, err := s.read(loc)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *DiskStore) read(loc location) ([]byte, error) {
+	value := make([]byte, loc.length)
+	if _, err := s.file.ReadAt(value, loc.offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *DiskStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, err := s.appendRecord(recordPut, key, value)
+	if err != nil {
+		return err
+	}
+	s.index[key] = location{offset: offset, length: uint32(len(value))}
+	return s.maybeCompact()
+}
+
+func (s *DiskStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	if _, err := s.appendRecord(recordDelete, key, nil); err != nil {
+		return err
+	}
+	delete(s.index, key)
+	return s.maybeCompact()
+}
+
+func (s *DiskStore) Range(fn func(key string, value []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.file == nil {
+		return ErrStoreClosed
+	}
+	for _, key := range sortedKeys(s.index) {
+		value, err := s.read(s.index[key])
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *DiskStore) Checkpoint() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoint
+}
+
+func (s *DiskStore) SetCheckpoint(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], uint64(offset))
+	if _, err := s.appendRecord(recordCheckpoint, "", value[:]); err != nil {
+		return err
+	}
+	s.checkpoint = offset
+	return s.maybeCompact()
+}
+
+func (s *DiskStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrStoreClosed
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	s.size, s.records, s.checkpoint = 0, 0, -1
+	s.index = make(map[string]location)
+	return nil
+}
+
+func (s *DiskStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := errors.Join(s.file.Sync(), s.file.Close())
+	s.file = nil
+	return err
+}
+
+func (s *DiskStore) shouldCompact() bool {
+	return s.records > compactMinRecords && s.records > 2*(len(s.index)+1)
+}
+
+// maybeCompact compacts the log if it has grown too large. s.mu must be
+// held.
+func (s *DiskStore) maybeCompact() error {
+	if !s.shouldCompact() {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrites the log with only the live keys and the checkpoint, and
+// replaces the old log with it. s.mu must be held.
+func (s *DiskStore) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]location, len(s.index))
+	var size int64
+	write := func(kind byte, key string, value []byte) error {
+		buf := encodeRecord(kind, key, value)
+		if _, err := tmp.WriteAt(buf, size); err != nil {
+			return err
+		}
+		if kind == recordPut {
+			index[key] = location{offset: size + headerSize + int64(len(key)), length: uint32(len(value))}
+		}
+		size += int64(len(buf))
+		return nil
+	}
+	err = func() error {
+		for _, key := range sortedKeys(s.index) {
+			value, err := s.read(s.index[key])
+			if err != nil {
+				return err
+			}
+			if err := write(recordPut, key, value); err != nil {
+				return err
+			}
+		}
+		if s.checkpoint >= 0 {
+			var value [8]byte
+			binary.BigEndian.PutUint64(value[:], uint64(s.checkpoint))
+			if err := write(recordCheckpoint, "", value[:]); err != nil {
+				return err
+			}
+		}
+		return tmp.Sync()
+	}()
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact state store %s: %w", s.path, err)
+	}
+	s.file.Close()
+	s.file = tmp
+	s.index = index
+	s.size = size
+	s.records = len(index)
+	if s.checkpoint >= 0 {
+		s.records++
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendDisk   = "disk"
+)
+
+var ErrStoreClosed = errors.New("state store is closed")
+
+// Store is a key-value store holding the state of one partition. Checkpoint
+// is the offset of the partition's changelog the store has applied the
+// records before, or -1 if it has none; records after it are applied again
+// on restore, which is harmless as every record holds a whole value.
+type Store interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Range calls fn for every key in order until fn returns false.
+	Range(fn func(key string, value []byte) bool) error
+	Checkpoint() int64
+	SetCheckpoint(offset int64) error
+	// Clear deletes every key and the checkpoint.
+	Clear() error
+	Close() error
+}
+
+// MemoryStore is a Store kept in memory only, restored from the whole
+// changelog every time its partition is assigned.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	data       map[string][]byte
+	checkpoint int64
+	closed     bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte), checkpoint: -1}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, false, ErrStoreClosed
+	}
+	value, ok := s.data[key]
+	return bytes.Clone(value), ok, nil
+}
+
+func (s *MemoryStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.data[key] = bytes.Clone(value)
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	delete(s.data, key)
+	return nil
+}
+
+func (s *MemoryStore) Range(fn func(key string, value []byte) bool) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	keys := sortedKeys(s.data)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = bytes.Clone(s.data[key])
+	}
+	s.mu.RUnlock()
+
+	for i, key := range keys {
+		if !fn(key, values[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Checkpoint() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoint
+}
+
+func (s *MemoryStore) SetCheckpoint(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.checkpoint = offset
+	return nil
+}
+
+func (s *MemoryStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.data = make(map[string][]byte)
+	s.checkpoint = -1
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.data = nil
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
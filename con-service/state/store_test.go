@@ -0,0 +1,151 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		BackendMemory: func(*testing.T) Store { return NewMemoryStore() },
+		BackendDisk: func(t *testing.T) Store {
+			s, err := OpenDiskStore(filepath.Join(t.TempDir(), "store.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			if _, ok, err := s.Get("a"); ok || err != nil {
+				t.Fatalf("got a value for a missing key, err %v", err)
+			}
+			if s.Checkpoint() != -1 {
+				t.Errorf("got checkpoint %d of a new store, want -1", s.Checkpoint())
+			}
+			for _, kv := range [][2]string{{"b", "2"}, {"a", "1"}, {"c", "3"}, {"a", "4"}} {
+				if err := s.Put(kv[0], []byte(kv[1])); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Delete("c"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetCheckpoint(42); err != nil {
+				t.Fatal(err)
+			}
+
+			if v, ok, _ := s.Get("a"); !ok || string(v) != "4" {
+				t.Errorf("got a = %q, want 4", v)
+			}
+			var keys []string
+			if err := s.Range(func(key string, value []byte) bool {
+				keys = append(keys, key+"="+string(value))
+				return true
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 2 || keys[0] != "a=4" || keys[1] != "b=2" {
+				t.Errorf("got keys %v, want a=4 and b=2", keys)
+			}
+			if s.Checkpoint() != 42 {
+				t.Errorf("got checkpoint %d, want 42", s.Checkpoint())
+			}
+
+			if err := s.Clear(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, _ := s.Get("a"); ok || s.Checkpoint() != -1 {
+				t.Error("got state after a clear")
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("a", nil); !errors.Is(err, ErrStoreClosed) {
+				t.Errorf("got %v writing to a closed store, want ErrStoreClosed", err)
+			}
+		})
+	}
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s, err := OpenDiskStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("a", []byte("1"))
+	s.Put("b", []byte("2"))
+	s.Delete("a")
+	s.SetCheckpoint(7)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn record at the end of the log is dropped.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(recordPut, "c", []byte("3"))[:10])
+	f.Close()
+
+	s, err = OpenDiskStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok, _ := s.Get("a"); ok {
+		t.Error("got a deleted key after reopening")
+	}
+	if v, ok, _ := s.Get("b"); !ok || string(v) != "2" {
+		t.Errorf("got b = %q after reopening, want 2", v)
+	}
+	if s.Checkpoint() != 7 {
+		t.Errorf("got checkpoint %d after reopening, want 7", s.Checkpoint())
+	}
+	if err := s.Put("c", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := s.Get("c"); !ok || string(v) != "3" {
+		t.Errorf("got c = %q written after a torn record, want 3", v)
+	}
+}
+
+func TestDiskStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s, err := OpenDiskStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 3000
+	for i := range n {
+		if err := s.Put(strconv.Itoa(i%10), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetCheckpoint(n)
+	if s.records > compactMinRecords+1 {
+		t.Errorf("got %d records in the log of 10 keys, want it compacted", s.records)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenDiskStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, ok, _ := s.Get("9"); !ok || string(v) != strconv.Itoa(n-1) {
+		t.Errorf("got 9 = %q after compaction", v)
+	}
+	if s.Checkpoint() != n {
+		t.Errorf("got checkpoint %d after compaction, want %d", s.Checkpoint(), n)
+	}
+}
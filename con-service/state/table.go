@@ -0,0 +1,416 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.uber.org/zap"
+)
+
+const name = "state"
+
+var (
+	tracer = otel.Tracer(name)
+)
+
+var ErrNotAssigned = errors.New("partition is not assigned")
+
+// ChangelogTopic is the compacted topic the writes to table for the
+// partitions of topic are mirrored to, such as
+// con-service-stats-totals-dice-rolls-changelog. It has as many partitions
+// as topic.
+func ChangelogTopic(group, table, topic string) string {
+	return fmt.Sprintf("%s-%s-%s-changelog", group, table, topic)
+}
+
+// Manager creates the tables of the service and owns the Kafka clients they
+// share.
+type Manager struct {
+	group     string
+	backend   string
+	dir       string
+	policy    string
+	provision *config.TopicConfig
+
+	client   sarama.Client
+	producer sarama.SyncProducer
+	consumer sarama.Consumer
+
+	mu         sync.Mutex
+	admin      sarama.ClusterAdmin
+	changelogs map[string]bool // changelog topics known to exist
+}
+
+// NewManager creates a Manager for the consumer group of kafkaConf with its
+// own client. saramaConfig is copied to send every changelog record to the
+// partition of the state it belongs to.
+func NewManager(conf *config.StateConfig, kafkaConf *config.KafkaConfig, saramaConfig *sarama.Config) (*Manager, error) {
+	if conf.Backend != BackendMemory && conf.Backend != BackendDisk {
+		return nil, fmt.Errorf("invalid state backend %q, must be memory or disk", conf.Backend)
+	}
+	changelogConfig := *saramaConfig
+	changelogConfig.Producer.Partitioner = sarama.NewManualPartitioner
+	changelogConfig.Producer.Return.Successes = true
+	changelogConfig.Producer.RequiredAcks = sarama.WaitForAll
+	client, err := sarama.NewClient(kafkaConf.Brokers, &changelogConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state client: %w", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create changelog producer: %w", err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		producer.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create changelog consumer: %w", err)
+	}
+	policy := kafkaConf.Provision.Policy
+	if policy == kafka.TopicPolicyOff {
+		// Tables cannot work without their changelog.
+		policy = kafka.TopicPolicyWarn
+	}
+	return &Manager{
+		group:      kafkaConf.ConsumerGroup,
+		backend:    conf.Backend,
+		dir:        conf.Dir,
+		policy:     policy,
+		provision:  kafkaConf.Provision,
+		client:     client,
+		producer:   producer,
+		consumer:   consumer,
+		changelogs: make(map[string]bool),
+	}, nil
+}
+
+// Table returns a new table called name holding state for the partitions of
+// topics.
+func (m *Manager) Table(name string, topics ...string) *Table {
+	t := &Table{name: name, manager: m, topics: make(map[string]bool, len(topics)), stores: make(map[partitionKey]*partitionStore)}
+	for _, topic := range topics {
+		t.topics[topic] = true
+	}
+	return t
+}
+
+// openStore opens the store of the partition of topic in table.
+func (m *Manager) openStore(table, topic string, partition int32) (Store, error) {
+	if m.backend == BackendMemory {
+		return NewMemoryStore(), nil
+	}
+	return OpenDiskStore(filepath.Join(m.dir, table, topic, strconv.Itoa(int(partition))+".log"))
+}
+
+// ensureChangelog creates the changelog topic of the partitions of topic if
+// it does not exist, compacted and with as many partitions as topic.
+func (m *Manager) ensureChangelog(changelog, topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.changelogs[changelog] {
+		return nil
+	}
+	if m.admin == nil {
+		admin, err := sarama.NewClusterAdminFromClient(m.client)
+		if err != nil {
+			return fmt.Errorf("failed to create cluster admin: %w", err)
+		}
+		m.admin = admin
+	}
+	partitions, err := m.client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("failed to get the partitions of %s: %w", topic, err)
+	}
+	spec := kafka.TopicSpec{
+		Name:              changelog,
+		Partitions:        int32(len(partitions)),
+		ReplicationFactor: m.provision.ReplicationFactor,
+		Configs:           map[string]string{"cleanup.policy": "compact"},
+	}
+	if err := kafka.EnsureTopics(m.admin, m.policy, spec); err != nil {
+		return err
+	}
+	m.changelogs[changelog] = true
+	return nil
+}
+
+// Close closes the clients of the manager. Tables must not be used after.
+func (m *Manager) Close() error {
+	errs := []error{m.producer.Close(), m.consumer.Close()}
+	// Closing the admin closes the client it was created from.
+	if m.admin != nil {
+		errs = append(errs, m.admin.Close())
+	} else {
+		errs = append(errs, m.client.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionStore is the store of a partition and the changelog offset after
+// the last record written to it.
+type partitionStore struct {
+	Store
+	next int64
+}
+
+// Table is a key-value table partitioned like its topics, in the manner of a
+// Kafka Streams KTable. Each assigned partition has its own store, and every
+// write is mirrored to the same partition of the table's compacted changelog
+// topic before it is applied to the store. When a partition is assigned its
+// store is restored from the changelog, from the store's checkpoint on, so
+// that the consumer that takes over a partition takes over its state too.
+//
+// Tables implement kafka.PartitionListener; handlers that use one must call
+// its OnAssigned and OnRevoked.
+type Table struct {
+	name    string
+	manager *Manager
+	topics  map[string]bool
+
+	mu     sync.RWMutex
+	stores map[partitionKey]*partitionStore
+}
+
+func (t *Table) store(topic string, partition int32) (*partitionStore, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	store, ok := t.stores[partitionKey{topic, partition}]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%d", ErrNotAssigned, topic, partition)
+	}
+	return store, nil
+}
+
+// Get returns the value of key in the partition of topic.
+func (t *Table) Get(topic string, partition int32, key string) ([]byte, bool, error) {
+	store, err := t.store(topic, partition)
+	if err != nil {
+		return nil, false, err
+	}
+	return store.Get(key)
+}
+
+// Range calls fn for every key of the partition of topic in order until fn
+// returns false.
+func (t *Table) Range(topic string, partition int32, fn func(key string, value []byte) bool) error {
+	store, err := t.store(topic, partition)
+	if err != nil {
+		return err
+	}
+	return store.Range(fn)
+}
+
+// Put sets key to value in the partition of topic.
+func (t *Table) Put(ctx context.Context, topic string, partition int32, key string, value []byte) error {
+	return t.write(ctx, topic, partition, key, value)
+}
+
+// Delete removes key from the partition of topic, leaving a tombstone in the
+// changelog.
+func (t *Table) Delete(ctx context.Context, topic string, partition int32, key string) error {
+	return t.write(ctx, topic, partition, key, nil)
+}
+
+func (t *Table) write(ctx context.Context, topic string, partition int32, key string, value []byte) error {
+	store, err := t.store(topic, partition)
+	if err != nil {
+		return err
+	}
+	changelog := ChangelogTopic(t.manager.group, t.name, topic)
+	msg := &sarama.ProducerMessage{Topic: changelog, Partition: partition, Key: sarama.StringEncoder(key)}
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	_, offset, err := t.manager.producer.SendMessage(msg)
+	if err != nil {
+		return fmt.Errorf("failed to write to changelog %s: %w", changelog, err)
+	}
+	if value == nil {
+		err = store.Delete(key)
+	} else {
+		err = store.Put(key, value)
+	}
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	store.next = max(store.next, offset+1)
+	t.mu.Unlock()
+	return nil
+}
+
+// OnAssigned opens and restores the stores of the partitions of topic, if
+// topic is one of the table's.
+func (t *Table) OnAssigned(ctx context.Context, topic string, partitions []int32) error {
+	if !t.topics[topic] {
+		return nil
+	}
+	changelog := ChangelogTopic(t.manager.group, t.name, topic)
+	if err := t.manager.ensureChangelog(changelog, topic); err != nil {
+		return err
+	}
+	var errs []error
+	for _, partition := range partitions {
+		if err := t.assign(ctx, changelog, topic, partition); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s/%d of table %s: %w", topic, partition, t.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *Table) assign(ctx context.Context, changelog, topic string, partition int32) error {
+	key := partitionKey{topic, partition}
+	t.mu.RLock()
+	_, ok := t.stores[key]
+	t.mu.RUnlock()
+	if ok {
+		return nil
+	}
+
+	store, err := t.manager.openStore(t.name, topic, partition)
+	if err != nil {
+		return err
+	}
+	next, err := t.restore(ctx, changelog, partition, store)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	t.mu.Lock()
+	t.stores[key] = &partitionStore{Store: store, next: next}
+	t.mu.Unlock()
+	return nil
+}
+
+// restore applies the records of the changelog partition from the store's
+// checkpoint up to the high water mark to store, and returns the high water
+// mark.
+func (t *Table) restore(ctx context.Context, changelog string, partition int32, store Store) (int64, error) {
+	ctx, span := tracer.Start(ctx, fmt.Sprintf("%s restore", changelog))
+	defer span.End()
+	span.SetAttributes(
+		semconv.MessagingSystemKafka,
+		semconv.MessagingDestinationName(changelog),
+		semconv.MessagingKafkaDestinationPartition(int(partition)),
+		attribute.String("state.table", t.name),
+	)
+
+	restored, next, err := t.replay(ctx, changelog, partition, store)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int("state.restored", restored))
+	logger.FromCtx(ctx).Info("restored state store", zap.String("table", t.name), zap.String("changelog", changelog),
+		zap.Int32("partition", partition), zap.Int("restored", restored), zap.Int64("offset", next))
+	return next, nil
+}
+
+func (t *Table) replay(ctx context.Context, changelog string, partition int32, store Store) (int, int64, error) {
+	client := t.manager.client
+	oldest, err := client.GetOffset(changelog, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, err
+	}
+	newest, err := client.GetOffset(changelog, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := store.Checkpoint()
+	if start < oldest || start > newest {
+		// The store is new, or the records after its checkpoint are no longer
+		// in the changelog, or the changelog was recreated.
+		if start >= 0 {
+			if err := store.Clear(); err != nil {
+				return 0, 0, err
+			}
+		}
+		start = oldest
+	}
+	if start >= newest {
+		return 0, newest, store.SetCheckpoint(newest)
+	}
+
+	pc, err := t.manager.consumer.ConsumePartition(changelog, partition, start)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer pc.Close()
+	restored := 0
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Value == nil {
+				err = store.Delete(string(msg.Key))
+			} else {
+				err = store.Put(string(msg.Key), msg.Value)
+			}
+			if err != nil {
+				return restored, 0, err
+			}
+			restored++
+			if msg.Offset+1 >= newest {
+				return restored, newest, store.SetCheckpoint(newest)
+			}
+		case err := <-pc.Errors():
+			return restored, 0, err
+		case <-ctx.Done():
+			return restored, 0, ctx.Err()
+		}
+	}
+}
+
+// OnRevoked checkpoints and closes the stores of the partitions of topic.
+// Disk stores are kept so that a later assignment only restores the records
+// written since.
+func (t *Table) OnRevoked(_ context.Context, topic string, partitions []int32) error {
+	if !t.topics[topic] {
+		return nil
+	}
+	var errs []error
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, partition := range partitions {
+		key := partitionKey{topic, partition}
+		store, ok := t.stores[key]
+		if !ok {
+			continue
+		}
+		delete(t.stores, key)
+		errs = append(errs, store.SetCheckpoint(store.next), store.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Close checkpoints and closes the stores of every assigned partition.
+func (t *Table) Close() error {
+	var errs []error
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, store := range t.stores {
+		delete(t.stores, key)
+		errs = append(errs, store.SetCheckpoint(store.next), store.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,204 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeLog is an in-memory changelog topic.
+type fakeLog struct {
+	mu      sync.Mutex
+	records map[int32][]*sarama.ConsumerMessage
+	starts  []int64 // offsets partition consumers were started at
+}
+
+func (l *fakeLog) append(partition int32, key string, value []byte) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offset := int64(len(l.records[partition]))
+	l.records[partition] = append(l.records[partition], &sarama.ConsumerMessage{
+		Partition: partition, Offset: offset, Key: []byte(key), Value: value,
+	})
+	return offset
+}
+
+type fakeClient struct {
+	sarama.Client
+	log *fakeLog
+}
+
+func (c *fakeClient) GetOffset(_ string, partition int32, time int64) (int64, error) {
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	if time == sarama.OffsetOldest {
+		return 0, nil
+	}
+	return int64(len(c.log.records[partition])), nil
+}
+
+type fakeProducer struct {
+	sarama.SyncProducer
+	log *fakeLog
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	key, _ := msg.Key.Encode()
+	var value []byte
+	if msg.Value != nil {
+		value, _ = msg.Value.Encode()
+	}
+	return msg.Partition, p.log.append(msg.Partition, string(key), value), nil
+}
+
+type fakeConsumer struct {
+	sarama.Consumer
+	log *fakeLog
+}
+
+func (c *fakeConsumer) ConsumePartition(_ string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	c.log.starts = append(c.log.starts, offset)
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(c.log.records[partition]))}
+	for _, msg := range c.log.records[partition][offset:] {
+		pc.messages <- msg
+	}
+	return pc, nil
+}
+
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages chan *sarama.ConsumerMessage
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+
+func (pc *fakePartitionConsumer) Errors() <-chan *sarama.ConsumerError { return nil }
+
+func (pc *fakePartitionConsumer) Close() error { return nil }
+
+func newTestManager(t *testing.T, backend string) (*Manager, *fakeLog) {
+	log := &fakeLog{records: make(map[int32][]*sarama.ConsumerMessage)}
+	m := &Manager{
+		group:      "con-service",
+		backend:    backend,
+		dir:        t.TempDir(),
+		client:     &fakeClient{log: log},
+		producer:   &fakeProducer{log: log},
+		consumer:   &fakeConsumer{log: log},
+		changelogs: map[string]bool{ChangelogTopic("con-service", "totals", "dice-rolls"): true},
+	}
+	return m, log
+}
+
+func TestChangelogTopic(t *testing.T) {
+	if got := ChangelogTopic("con-service", "stats-totals", "dice-rolls"); got != "con-service-stats-totals-dice-rolls-changelog" {
+		t.Errorf("got changelog %s", got)
+	}
+}
+
+func TestTableRestore(t *testing.T) {
+	ctx := context.Background()
+	m, log := newTestManager(t, BackendMemory)
+	log.append(0, "a", []byte("1"))
+	log.append(0, "b", []byte("2"))
+	log.append(0, "a", nil)
+	log.append(1, "c", []byte("3"))
+
+	table := m.Table("totals", "dice-rolls")
+	if err := table.Put(ctx, "dice-rolls", 0, "a", []byte("1")); !errors.Is(err, ErrNotAssigned) {
+		t.Fatalf("got %v writing to an unassigned partition, want ErrNotAssigned", err)
+	}
+	if err := table.OnAssigned(ctx, "dice-rolls", []int32{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := table.Get("dice-rolls", 0, "a"); ok {
+		t.Error("got a key deleted by a tombstone")
+	}
+	if v, ok, _ := table.Get("dice-rolls", 0, "b"); !ok || string(v) != "2" {
+		t.Errorf("got b = %q, want 2", v)
+	}
+	if v, ok, _ := table.Get("dice-rolls", 1, "c"); !ok || string(v) != "3" {
+		t.Errorf("got c = %q of partition 1, want 3", v)
+	}
+
+	if err := table.Put(ctx, "dice-rolls", 2, "d", []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	if records := log.records[2]; len(records) != 1 || string(records[0].Key) != "d" {
+		t.Errorf("got changelog records %v of partition 2, want d", records)
+	}
+	if err := table.Delete(ctx, "dice-rolls", 0, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if records := log.records[0]; records[len(records)-1].Value != nil {
+		t.Error("got no tombstone for a deleted key")
+	}
+
+	// Partitions of other topics, such as retry topics, are not tracked.
+	if err := table.OnAssigned(ctx, "dice-rolls.retry.5s", []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := table.Get("dice-rolls.retry.5s", 0, "a"); !errors.Is(err, ErrNotAssigned) {
+		t.Errorf("got %v for a retry topic, want ErrNotAssigned", err)
+	}
+
+	if err := table.OnRevoked(ctx, "dice-rolls", []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := table.Get("dice-rolls", 0, "b"); !errors.Is(err, ErrNotAssigned) {
+		t.Errorf("got %v for a revoked partition, want ErrNotAssigned", err)
+	}
+}
+
+func TestTableDiskCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	m, log := newTestManager(t, BackendDisk)
+	log.append(0, "a", []byte("1"))
+
+	table := m.Table("totals", "dice-rolls")
+	if err := table.OnAssigned(ctx, "dice-rolls", []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Put(ctx, "dice-rolls", 0, "b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.OnRevoked(ctx, "dice-rolls", []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	// Written by the consumer the partition was assigned to in between.
+	log.append(0, "c", []byte("3"))
+
+	if err := table.OnAssigned(ctx, "dice-rolls", []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.starts) != 2 || log.starts[1] != 2 {
+		t.Errorf("got restores from offsets %v, want the second from the checkpoint 2", log.starts)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if v, ok, _ := table.Get("dice-rolls", 0, key); !ok || string(v) != want {
+			t.Errorf("got %s = %q, want %s", key, v, want)
+		}
+	}
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changelog was recreated, so the store no longer matches it.
+	log.records[0] = nil
+	log.append(0, "d", []byte("4"))
+	if err := table.OnAssigned(ctx, "dice-rolls", []int32{0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := table.Get("dice-rolls", 0, "a"); ok {
+		t.Error("got state that is not in the recreated changelog")
+	}
+	if v, ok, _ := table.Get("dice-rolls", 0, "d"); !ok || string(v) != "4" {
+		t.Errorf("got d = %q, want 4", v)
+	}
+	table.Close()
+}
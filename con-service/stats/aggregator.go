@@ -0,0 +1,455 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/logger"
+	"github.com/rlindsey28/con-service/rolldice"
+	"github.com/rlindsey28/con-service/state"
+
+	"go.uber.org/zap"
+)
+
+// tickInterval is how often Run checks whether an idle watermark should
+// advance.
+const tickInterval = time.Second
+
+// TotalsTable is the name of the table the running totals are kept in.
+const TotalsTable = "stats-totals"
+
+var ErrUnknownWindow = errors.New("unknown window")
+
+// Publisher receives the results of windows as they close.
+type Publisher interface {
+	Publish(ctx context.Context, results []Result) error
+}
+
+// Aggregator keeps running statistics of every roll per number of sides, and
+// of the rolls in each time window.
+//
+// Windows use event time, the time a roll was made rather than consumed. The
+// watermark trails the latest event time seen by the allowed lateness; a
+// window closes, and its result is published, once the watermark passes its
+// end. Rolls arriving for a window that has closed are late: they still
+// count towards the running totals but not towards the window. When no roll
+// arrives for the allowed lateness the watermark follows the clock instead,
+// so that the last windows close on an idle topic.
+//
+// Totals are kept per partition. With a table they are written to it and
+// restored from it when partitions are assigned, and the Aggregator only
+// holds the totals of its assigned partitions; windows are kept in memory
+// only.
+type Aggregator struct {
+	windows   []*windowState
+	lateness  time.Duration
+	history   int
+	publisher Publisher
+	table     *state.Table
+	now       func() time.Time
+
+	mu         sync.Mutex
+	since      time.Time
+	totals     map[partitionKey]*partitionTotals
+	maxEvent   time.Time
+	watermark  time.Time
+	lastRecord time.Time
+}
+
+// NewAggregator creates an Aggregator with the windows of conf. publisher and
+// table are optional.
+func NewAggregator(conf *config.StatsConfig, publisher Publisher, table *state.Table) (*Aggregator, error) {
+	windows, err := ParseWindows(conf.Tumbling, conf.Hopping)
+	if err != nil {
+		return nil, err
+	}
+	if conf.AllowedLateness < 0 {
+		return nil, fmt.Errorf("allowed lateness %s must not be negative", conf.AllowedLateness)
+	}
+	a := &Aggregator{
+		lateness:  conf.AllowedLateness,
+		history:   conf.History,
+		publisher: publisher,
+		table:     table,
+		now:       time.Now,
+		totals:    make(map[partitionKey]*partitionTotals),
+	}
+	for _, w := range windows {
+		a.windows = append(a.windows, &windowState{Window: w, open: make(map[int64]*openWindow)})
+	}
+	a.since = a.now()
+	return a, nil
+}
+
+// Record adds the dice of roll, read from msg, to the totals of msg's
+// partition and to the windows its event time falls in. With a table the
+// new totals are written to it first, and nothing is recorded if that fails.
+func (a *Aggregator) Record(ctx context.Context, msg *kafka.Message, roll *rolldice.DiceRoll) error {
+	faces := facesOf(roll)
+	if len(faces) == 0 {
+		return nil
+	}
+	if err := a.addTotals(ctx, partitionKey{msg.Topic, msg.Partition}, faces); err != nil {
+		return err
+	}
+
+	at := msg.Time()
+	a.mu.Lock()
+	a.lastRecord = a.now()
+	if at.After(a.maxEvent) {
+		a.maxEvent = at
+	}
+	closed := a.advance(a.maxEvent.Add(-a.lateness))
+
+	var late []string
+	for _, w := range a.windows {
+		dropped := false
+		for _, start := range w.starts(at) {
+			end := start.Add(w.Size)
+			if !end.After(a.watermark) {
+				dropped = true
+				continue
+			}
+			open, ok := w.open[start.UnixNano()]
+			if !ok {
+				open = &openWindow{start: start, end: end, aggregates: make(map[int]*Aggregate)}
+				w.open[start.UnixNano()] = open
+			}
+			for sides, f := range faces {
+				agg, ok := open.aggregates[sides]
+				if !ok {
+					agg = newAggregate(sides)
+					open.aggregates[sides] = agg
+				}
+				agg.add(f)
+			}
+		}
+		if dropped {
+			w.late++
+			late = append(late, w.Name())
+		}
+	}
+	watermark := a.watermark
+	a.mu.Unlock()
+
+	if len(late) > 0 {
+		logger.FromCtx(ctx).Debug("late roll dropped from closed windows", zap.Time("event_time", at),
+			zap.Time("watermark", watermark), zap.Strings("windows", late))
+	}
+	a.publish(ctx, closed)
+	return nil
+}
+
+// addTotals adds faces to the totals of the partition key.
+func (a *Aggregator) addTotals(ctx context.Context, key partitionKey, faces map[int]map[int]int64) error {
+	a.mu.Lock()
+	totals, ok := a.totals[key]
+	if !ok {
+		totals = &partitionTotals{aggregates: make(map[int]*Aggregate)}
+		a.totals[key] = totals
+	}
+	a.mu.Unlock()
+
+	// The partition stays locked while its totals are written to the table,
+	// so that concurrent rolls do not overwrite each other's.
+	totals.mu.Lock()
+	defer totals.mu.Unlock()
+	updated := make(map[int]*Aggregate, len(faces))
+	for sides, f := range faces {
+		agg := newAggregate(sides)
+		if current, ok := totals.aggregates[sides]; ok {
+			agg.merge(current)
+		}
+		agg.add(f)
+		updated[sides] = agg
+	}
+	if a.table != nil {
+		for sides, agg := range updated {
+			value, err := json.Marshal(agg)
+			if err != nil {
+				return err
+			}
+			err = a.table.Put(ctx, key.topic, key.partition, strconv.Itoa(sides), value)
+			if errors.Is(err, state.ErrNotAssigned) {
+				// Such as rolls read from a retry topic, which are only kept
+				// in memory.
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	maps.Copy(totals.aggregates, updated)
+	return nil
+}
+
+// OnAssigned loads the totals of the partitions of topic from the table.
+func (a *Aggregator) OnAssigned(ctx context.Context, topic string, partitions []int32) error {
+	if a.table == nil {
+		return nil
+	}
+	if err := a.table.OnAssigned(ctx, topic, partitions); err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		totals := &partitionTotals{aggregates: make(map[int]*Aggregate)}
+		var decodeErr error
+		err := a.table.Range(topic, partition, func(key string, value []byte) bool {
+			agg := &Aggregate{}
+			if decodeErr = json.Unmarshal(value, agg); decodeErr != nil {
+				decodeErr = fmt.Errorf("invalid totals %s of %s/%d: %w", key, topic, partition, decodeErr)
+				return false
+			}
+			totals.aggregates[agg.Sides] = agg
+			return true
+		})
+		if errors.Is(err, state.ErrNotAssigned) {
+			continue
+		}
+		if err = errors.Join(err, decodeErr); err != nil {
+			return err
+		}
+		a.mu.Lock()
+		a.totals[partitionKey{topic, partition}] = totals
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// OnRevoked drops the totals of the partitions of topic, which are now kept
+// by the consumer they were assigned to.
+func (a *Aggregator) OnRevoked(ctx context.Context, topic string, partitions []int32) error {
+	if a.table == nil {
+		return nil
+	}
+	a.mu.Lock()
+	for _, partition := range partitions {
+		delete(a.totals, partitionKey{topic, partition})
+	}
+	a.mu.Unlock()
+	return a.table.OnRevoked(ctx, topic, partitions)
+}
+
+// Reset drops the totals of the partitions of topic. With a table they are
+// deleted from it as well, which requires the partitions to be assigned.
+func (a *Aggregator) Reset(ctx context.Context, topic string, partitions []int32) error {
+	for _, partition := range partitions {
+		if a.table != nil {
+			var keys []string
+			err := a.table.Range(topic, partition, func(key string, _ []byte) bool {
+				keys = append(keys, key)
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := a.table.Delete(ctx, topic, partition, key); err != nil {
+					return err
+				}
+			}
+		}
+		a.mu.Lock()
+		delete(a.totals, partitionKey{topic, partition})
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// advance moves the watermark forward to watermark and returns the results
+// of the windows that closed. a.mu must be held.
+func (a *Aggregator) advance(watermark time.Time) []Result {
+	if !watermark.After(a.watermark) {
+		return nil
+	}
+	a.watermark = watermark
+	var closed []Result
+	for _, w := range a.windows {
+		var ended []*openWindow
+		for key, open := range w.open {
+			if !open.end.After(watermark) {
+				ended = append(ended, open)
+				delete(w.open, key)
+			}
+		}
+		sort.Slice(ended, func(i, j int) bool { return ended[i].start.Before(ended[j].start) })
+		for _, open := range ended {
+			result := open.result(w.Name(), true)
+			closed = append(closed, result)
+			w.closed = append(w.closed, result)
+		}
+		if extra := len(w.closed) - a.history; extra > 0 {
+			w.closed = append([]Result(nil), w.closed[extra:]...)
+		}
+	}
+	return closed
+}
+
+// Run advances the watermark of an idle Aggregator with the clock until ctx
+// is done.
+func (a *Aggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.publish(ctx, a.tick())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *Aggregator) tick() []Result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	if now.Sub(a.lastRecord) < a.lateness {
+		return nil
+	}
+	return a.advance(now.Add(-a.lateness))
+}
+
+func (a *Aggregator) publish(ctx context.Context, results []Result) {
+	if a.publisher == nil || len(results) == 0 {
+		return
+	}
+	if err := a.publisher.Publish(ctx, results); err != nil {
+		logger.FromCtx(ctx).Error("failed to publish window results", zap.Int("results", len(results)), zap.Error(err))
+	}
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionTotals is the running totals of the rolls of one partition.
+type partitionTotals struct {
+	mu         sync.Mutex
+	aggregates map[int]*Aggregate
+}
+
+// Totals is the running statistics since Since, of the partitions assigned
+// to the consumer when the totals are kept in a table.
+type Totals struct {
+	Since     time.Time `json:"since"`
+	Watermark time.Time `json:"watermark"`
+	Sides     []Summary `json:"sides"`
+}
+
+// Totals returns the running statistics of every number of sides seen.
+func (a *Aggregator) Totals() Totals {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Totals{Since: a.since, Watermark: a.watermark, Sides: summarize(a.mergedTotals())}
+}
+
+// Total returns the running statistics of dice with sides, if any were
+// rolled.
+func (a *Aggregator) Total(sides int) (Summary, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	total, ok := a.mergedTotals()[sides]
+	if !ok {
+		return Summary{}, false
+	}
+	return total.Summary(), true
+}
+
+// mergedTotals adds up the totals of every partition. a.mu must be held.
+func (a *Aggregator) mergedTotals() map[int]*Aggregate {
+	merged := make(map[int]*Aggregate)
+	for _, totals := range a.totals {
+		totals.mu.Lock()
+		for sides, agg := range totals.aggregates {
+			if _, ok := merged[sides]; !ok {
+				merged[sides] = newAggregate(sides)
+			}
+			merged[sides].merge(agg)
+		}
+		totals.mu.Unlock()
+	}
+	return merged
+}
+
+// WindowInfo describes a configured window. Late is the number of rolls that
+// arrived after their window closed.
+type WindowInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size string `json:"size"`
+	Hop  string `json:"hop"`
+	Open int    `json:"open"`
+	Late int64  `json:"late"`
+}
+
+// Windows describes the configured windows.
+func (a *Aggregator) Windows() []WindowInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	infos := make([]WindowInfo, 0, len(a.windows))
+	for _, w := range a.windows {
+		info := WindowInfo{
+			Name: w.Name(),
+			Type: "hopping",
+			Size: formatDuration(w.Size),
+			Hop:  formatDuration(w.Hop),
+			Open: len(w.open),
+			Late: w.late,
+		}
+		if w.Tumbling() {
+			info.Type = "tumbling"
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// WindowResults is the results of the open windows of a window spec and of
+// its most recently closed ones, both oldest first.
+type WindowResults struct {
+	Window    string    `json:"window"`
+	Watermark time.Time `json:"watermark"`
+	Late      int64     `json:"late"`
+	Open      []Result  `json:"open"`
+	Closed    []Result  `json:"closed"`
+}
+
+// Results returns the results of the window named name, restricted to dice
+// with sides unless sides is 0.
+func (a *Aggregator) Results(name string, sides int) (WindowResults, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, w := range a.windows {
+		if w.Name() != name {
+			continue
+		}
+		results := WindowResults{
+			Window:    name,
+			Watermark: a.watermark,
+			Late:      w.late,
+			Open:      make([]Result, 0, len(w.open)),
+			Closed:    make([]Result, 0, len(w.closed)),
+		}
+		for _, open := range w.open {
+			results.Open = append(results.Open, open.result(name, false).filter(sides))
+		}
+		sort.Slice(results.Open, func(i, j int) bool { return results.Open[i].Start.Before(results.Open[j].Start) })
+		for _, closed := range w.closed {
+			results.Closed = append(results.Closed, closed.filter(sides))
+		}
+		return results, nil
+	}
+	return WindowResults{}, fmt.Errorf("%w %s", ErrUnknownWindow, name)
+}
@@ -0,0 +1,204 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rlindsey28/con-service/config"
+	"github.com/rlindsey28/con-service/kafka"
+	"github.com/rlindsey28/con-service/rolldice"
+
+	"github.com/IBM/sarama"
+)
+
+type recordingPublisher struct {
+	results []Result
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, results []Result) error {
+	p.results = append(p.results, results...)
+	return nil
+}
+
+var epoch = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestAggregator(t *testing.T, publisher Publisher) *Aggregator {
+	t.Helper()
+	a, err := NewAggregator(&config.StatsConfig{
+		Tumbling:        []time.Duration{time.Minute},
+		Hopping:         []string{"2m/1m"},
+		AllowedLateness: 10 * time.Second,
+		History:         2,
+	}, publisher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return epoch }
+	return a
+}
+
+func d6(faces ...int8) *rolldice.DiceRoll {
+	roll := &rolldice.DiceRoll{Rolls: int8(len(faces)), Sides: 6, Distribution: make(map[int8]int32)}
+	for _, face := range faces {
+		roll.Distribution[face]++
+	}
+	return roll
+}
+
+// message returns a record of partition 0 of dice-rolls produced at.
+func message(at time.Time) *kafka.Message {
+	return &kafka.Message{ConsumerMessage: &sarama.ConsumerMessage{Topic: "dice-rolls", Timestamp: at}}
+}
+
+func TestWindowStarts(t *testing.T) {
+	at := epoch.Add(90 * time.Second)
+	if starts := (Window{Size: time.Minute, Hop: time.Minute}).starts(at); len(starts) != 1 || !starts[0].Equal(epoch.Add(time.Minute)) {
+		t.Errorf("got tumbling window starts %v", starts)
+	}
+	starts := (Window{Size: 5 * time.Minute, Hop: time.Minute}).starts(at)
+	if len(starts) != 5 || !starts[0].Equal(epoch.Add(time.Minute)) || !starts[4].Equal(epoch.Add(-3*time.Minute)) {
+		t.Errorf("got hopping window starts %v", starts)
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows([]time.Duration{time.Minute, time.Hour}, []string{"5m/1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, w := range windows {
+		names = append(names, w.Name())
+	}
+	if len(names) != 3 || names[0] != "tumbling-1m" || names[1] != "tumbling-1h" || names[2] != "hopping-5m-1m" {
+		t.Errorf("got windows %v", names)
+	}
+
+	for _, hopping := range []string{"5m", "5m/0s", "5m/2m", "1m/5m", "5m/x", "1m/1m"} {
+		if _, err := ParseWindows([]time.Duration{time.Minute}, []string{hopping}); !errors.Is(err, ErrInvalidWindow) {
+			t.Errorf("hopping window %q: got %v, want ErrInvalidWindow", hopping, err)
+		}
+	}
+}
+
+func TestAggregatorTotals(t *testing.T) {
+	a := newTestAggregator(t, nil)
+	ctx := context.Background()
+	a.Record(ctx, message(epoch), d6(1, 2, 3))
+	other := message(epoch)
+	other.Partition = 1
+	a.Record(ctx, other, &rolldice.DiceRoll{Result: &rolldice.ExpressionResult{
+		Distribution: map[int]map[int]int32{6: {6: 1}, 20: {20: 2}},
+	}})
+
+	totals := a.Totals()
+	if len(totals.Sides) != 2 || totals.Sides[0].Sides != 6 || totals.Sides[1].Sides != 20 {
+		t.Fatalf("got totals %+v", totals.Sides)
+	}
+	d6, _ := a.Total(6)
+	if d6.Rolls != 2 || d6.Dice != 4 || d6.Mean != 3 {
+		t.Errorf("got d6 totals %+v", d6)
+	}
+	if _, ok := a.Total(8); ok {
+		t.Error("got totals for d8 that were never rolled")
+	}
+
+	// Without a table the totals of revoked partitions are kept.
+	if err := a.OnRevoked(ctx, "dice-rolls", []int32{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if d6, _ := a.Total(6); d6.Dice != 4 {
+		t.Errorf("got %d dice after a revoke, want 4", d6.Dice)
+	}
+
+	if err := a.Reset(ctx, "dice-rolls", []int32{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if totals := a.Totals(); len(totals.Sides) != 0 {
+		t.Errorf("got totals %+v after a reset", totals.Sides)
+	}
+}
+
+func TestAggregatorWindows(t *testing.T) {
+	publisher := &recordingPublisher{}
+	a := newTestAggregator(t, publisher)
+	ctx := context.Background()
+
+	a.Record(ctx, message(epoch.Add(10*time.Second)), d6(1))
+	a.Record(ctx, message(epoch.Add(50*time.Second)), d6(2))
+	results, err := a.Results("hopping-2m-1m", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Open) != 2 || len(results.Closed) != 0 {
+		t.Fatalf("got %d open and %d closed hopping windows, want 2 and 0", len(results.Open), len(results.Closed))
+	}
+
+	// Within the allowed lateness of the first window's end.
+	a.Record(ctx, message(epoch.Add(65*time.Second)), d6(3))
+	a.Record(ctx, message(epoch.Add(30*time.Second)), d6(4))
+	if len(publisher.results) != 0 {
+		t.Fatalf("got %d results published before the watermark passed the window", len(publisher.results))
+	}
+
+	// Moves the watermark past the end of the first minute.
+	a.Record(ctx, message(epoch.Add(75*time.Second)), d6(5))
+	// The first minute and the hopping window of the minute before it.
+	if len(publisher.results) != 2 {
+		t.Fatalf("got %d published results, want 2", len(publisher.results))
+	}
+	first := publisher.results[0]
+	if first.Window != "tumbling-1m" || !first.Start.Equal(epoch) || !first.Final || first.Sides[0].Dice != 3 {
+		t.Errorf("got first window %+v", first)
+	}
+
+	// Too late for the first minute and the hopping window that ended with it,
+	// but the one that started with it is still open.
+	a.Record(ctx, message(epoch.Add(20*time.Second)), d6(6))
+	tumbling, _ := a.Results("tumbling-1m", 6)
+	if tumbling.Late != 1 || len(tumbling.Closed) != 1 || tumbling.Closed[0].Sides[0].Dice != 3 {
+		t.Errorf("got tumbling windows %+v", tumbling)
+	}
+	hopping, _ := a.Results("hopping-2m-1m", 6)
+	if hopping.Late != 1 || hopping.Open[0].Sides[0].Dice != 6 {
+		t.Errorf("got hopping windows %+v", hopping)
+	}
+	if total, _ := a.Total(6); total.Dice != 6 {
+		t.Errorf("got %d dice in total, want late dice to count", total.Dice)
+	}
+
+	if _, err := a.Results("tumbling-2m", 0); !errors.Is(err, ErrUnknownWindow) {
+		t.Errorf("got %v, want ErrUnknownWindow", err)
+	}
+}
+
+func TestAggregatorIdleWatermark(t *testing.T) {
+	publisher := &recordingPublisher{}
+	a := newTestAggregator(t, publisher)
+	a.Record(context.Background(), message(epoch.Add(-30*time.Second)), d6(1))
+
+	if closed := a.tick(); len(closed) != 0 {
+		t.Fatalf("got %d windows closed before the topic was idle", len(closed))
+	}
+	a.now = func() time.Time { return epoch.Add(5 * time.Minute) }
+	closed := a.tick()
+	if len(closed) != 3 {
+		t.Fatalf("got %d closed windows on an idle topic, want 3", len(closed))
+	}
+	if windows := a.Windows(); windows[0].Open != 0 || windows[1].Open != 0 {
+		t.Errorf("got open windows %+v", windows)
+	}
+}
+
+func TestAggregatorHistory(t *testing.T) {
+	a := newTestAggregator(t, nil)
+	for i := range 5 {
+		a.Record(context.Background(), message(epoch.Add(time.Duration(i)*time.Minute)), d6(1))
+	}
+	results, _ := a.Results("tumbling-1m", 0)
+	if len(results.Closed) != 2 || !results.Closed[1].Start.Equal(epoch.Add(2*time.Minute)) {
+		t.Errorf("got closed windows %+v, want the last 2", results.Closed)
+	}
+}
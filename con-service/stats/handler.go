@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rlindsey28/con-service/logger"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Handler serves the dice statistics of an Aggregator.
+type Handler struct {
+	Aggregator *Aggregator
+}
+
+// Totals reports the running statistics of every number of sides.
+func (h *Handler) Totals(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "stats totals")
+	defer span.End()
+
+	writeJSON(w, http.StatusOK, h.Aggregator.Totals())
+}
+
+// Total reports the running statistics of the number of sides in the path.
+func (h *Handler) Total(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "stats total")
+	defer span.End()
+
+	sides, err := strconv.Atoi(mux.Vars(r)["sides"])
+	if err != nil || sides <= 0 {
+		http.Error(w, "sides must be a positive number", http.StatusBadRequest)
+		return
+	}
+	summary, ok := h.Aggregator.Total(sides)
+	if !ok {
+		http.Error(w, "no dice with "+strconv.Itoa(sides)+" sides rolled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// Windows lists the configured windows.
+func (h *Handler) Windows(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "stats windows")
+	defer span.End()
+
+	writeJSON(w, http.StatusOK, h.Aggregator.Windows())
+}
+
+// Window reports the open and recently closed windows of the window in the
+// path. The optional sides query parameter restricts them to one die type.
+func (h *Handler) Window(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "stats window")
+	defer span.End()
+
+	sides := 0
+	if v := r.URL.Query().Get("sides"); v != "" {
+		var err error
+		if sides, err = strconv.Atoi(v); err != nil || sides <= 0 {
+			http.Error(w, "sides must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := h.Aggregator.Results(mux.Vars(r)["window"], sides)
+	if errors.Is(err, ErrUnknownWindow) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Get().Error("failed to encode response", zap.Error(err))
+	}
+}
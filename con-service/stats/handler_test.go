@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandler(t *testing.T) {
+	a := newTestAggregator(t, nil)
+	a.Record(context.Background(), message(epoch.Add(10*time.Second)), d6(1, 6))
+	h := Handler{Aggregator: a}
+	router := mux.NewRouter()
+	router.HandleFunc("/stats", h.Totals).Methods("GET")
+	router.HandleFunc("/stats/windows", h.Windows).Methods("GET")
+	router.HandleFunc("/stats/windows/{window}", h.Window).Methods("GET")
+	router.HandleFunc("/stats/{sides:[0-9]+}", h.Total).Methods("GET")
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{url: "/stats", code: http.StatusOK},
+		{url: "/stats/6", code: http.StatusOK},
+		{url: "/stats/20", code: http.StatusNotFound},
+		{url: "/stats/0", code: http.StatusBadRequest},
+		{url: "/stats/windows", code: http.StatusOK},
+		{url: "/stats/windows/tumbling-1m?sides=6", code: http.StatusOK},
+		{url: "/stats/windows/tumbling-1m?sides=d6", code: http.StatusBadRequest},
+		{url: "/stats/windows/tumbling-7m", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rr.Code != tt.code {
+				t.Errorf("got status %d, want %d", rr.Code, tt.code)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stats/windows/tumbling-1m?sides=6", nil))
+	var results WindowResults
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Open) != 1 || results.Open[0].Sides[0].Faces[6] != 1 || results.Open[0].Final {
+		t.Errorf("got window results %+v", results)
+	}
+}
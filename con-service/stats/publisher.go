@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// KafkaPublisher publishes window results as JSON to a topic, keyed by the
+// window name so that the results of a window stay in order.
+type KafkaPublisher struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaPublisher creates a KafkaPublisher writing to topic. saramaConfig
+// must have Producer.Return.Successes set.
+func NewKafkaPublisher(brokers []string, saramaConfig *sarama.Config, topic string) (*KafkaPublisher, error) {
+	producer, err := sarama.NewSyncProducer(brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stats producer: %w", err)
+	}
+	return &KafkaPublisher{producer: producer, topic: topic}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, results []Result) error {
+	_, span := tracer.Start(ctx, fmt.Sprintf("%s publish", p.topic),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(p.topic),
+			semconv.MessagingBatchMessageCount(len(results)),
+			semconv.MessagingOperationPublish,
+		))
+	defer span.End()
+
+	msgs := make([]*sarama.ProducerMessage, 0, len(results))
+	for _, result := range results {
+		value, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic:   p.topic,
+			Key:     sarama.StringEncoder(result.Window),
+			Value:   sarama.ByteEncoder(value),
+			Headers: []sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte("application/json")}},
+		})
+	}
+	if err := p.producer.SendMessages(msgs); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to publish to %s: %w", p.topic, err)
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.producer.Close()
+}
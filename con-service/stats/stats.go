@@ -0,0 +1,196 @@
+package stats
+
+import (
+	"math"
+	"sort"
+
+	"github.com/rlindsey28/con-service/rolldice"
+
+	"go.opentelemetry.io/otel"
+)
+
+const name = "stats"
+
+var (
+	tracer = otel.Tracer(name)
+)
+
+// Aggregate counts the faces rolled on dice with the same number of sides.
+type Aggregate struct {
+	Sides int `json:"sides"`
+	// Rolls is the number of rolls that included dice with Sides.
+	Rolls int64         `json:"rolls"`
+	Faces map[int]int64 `json:"faces"`
+}
+
+func newAggregate(sides int) *Aggregate {
+	return &Aggregate{Sides: sides, Faces: make(map[int]int64, sides)}
+}
+
+// add counts faces, the faces of one roll by number of rolls.
+func (a *Aggregate) add(faces map[int]int64) {
+	a.Rolls++
+	for face, n := range faces {
+		a.Faces[face] += n
+	}
+}
+
+// merge adds the counts of other to a.
+func (a *Aggregate) merge(other *Aggregate) {
+	a.Rolls += other.Rolls
+	for face, n := range other.Faces {
+		a.Faces[face] += n
+	}
+}
+
+// Summary is the statistics of an Aggregate. Mean and Variance are those of
+// the faces rolled, to be compared with ExpectedMean and ExpectedVariance of
+// a fair die. ChiSquared is Pearson's statistic of the face frequencies
+// against a uniform distribution and PValue the probability of a fair die
+// giving one at least as large; small p-values suggest the die is biased.
+type Summary struct {
+	Sides            int           `json:"sides"`
+	Rolls            int64         `json:"rolls"`
+	Dice             int64         `json:"dice"`
+	Faces            map[int]int64 `json:"faces"`
+	Mean             float64       `json:"mean"`
+	Variance         float64       `json:"variance"`
+	ExpectedMean     float64       `json:"expected_mean"`
+	ExpectedVariance float64       `json:"expected_variance"`
+	ChiSquared       float64       `json:"chi_squared"`
+	DegreesOfFreedom int           `json:"degrees_of_freedom"`
+	PValue           float64       `json:"p_value"`
+}
+
+// Summary computes the statistics of a.
+func (a *Aggregate) Summary() Summary {
+	s := Summary{
+		Sides:            a.Sides,
+		Rolls:            a.Rolls,
+		Faces:            make(map[int]int64, len(a.Faces)),
+		ExpectedMean:     float64(a.Sides+1) / 2,
+		ExpectedVariance: float64(a.Sides*a.Sides-1) / 12,
+		DegreesOfFreedom: a.Sides - 1,
+		PValue:           1,
+	}
+	var sum float64
+	for face, n := range a.Faces {
+		s.Faces[face] = n
+		s.Dice += n
+		sum += float64(face) * float64(n)
+	}
+	if s.Dice == 0 {
+		return s
+	}
+	dice := float64(s.Dice)
+	s.Mean = sum / dice
+	for face, n := range a.Faces {
+		d := float64(face) - s.Mean
+		s.Variance += d * d * float64(n)
+	}
+	s.Variance /= dice
+
+	if a.Sides < 2 {
+		return s
+	}
+	expected := dice / float64(a.Sides)
+	for face := 1; face <= a.Sides; face++ {
+		d := float64(a.Faces[face]) - expected
+		s.ChiSquared += d * d / expected
+	}
+	s.PValue = chiSquaredSurvival(s.ChiSquared, s.DegreesOfFreedom)
+	return s
+}
+
+// summarize returns the summaries of aggregates ordered by sides.
+func summarize(aggregates map[int]*Aggregate) []Summary {
+	summaries := make([]Summary, 0, len(aggregates))
+	for _, a := range aggregates {
+		summaries = append(summaries, a.Summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Sides < summaries[j].Sides })
+	return summaries
+}
+
+// facesOf returns the faces rolled in roll, counted by number of sides and
+// then face. Expression rolls may mix dice with different sides.
+func facesOf(roll *rolldice.DiceRoll) map[int]map[int]int64 {
+	faces := make(map[int]map[int]int64)
+	count := func(sides, face int, n int64) {
+		if sides <= 0 || n <= 0 {
+			return
+		}
+		if faces[sides] == nil {
+			faces[sides] = make(map[int]int64)
+		}
+		faces[sides][face] += n
+	}
+	if roll.Result != nil {
+		for sides, distribution := range roll.Result.Distribution {
+			for face, n := range distribution {
+				count(sides, face, int64(n))
+			}
+		}
+		return faces
+	}
+	for face, n := range roll.Distribution {
+		count(int(roll.Sides), int(face), int64(n))
+	}
+	return faces
+}
+
+// chiSquaredSurvival is the probability of a chi-squared distribution with
+// dof degrees of freedom exceeding x.
+func chiSquaredSurvival(x float64, dof int) float64 {
+	if x <= 0 || dof <= 0 {
+		return 1
+	}
+	return gammaQ(float64(dof)/2, x/2)
+}
+
+// gammaQ is the regularized upper incomplete gamma function Q(a, x),
+// evaluated by its series for x < a+1 and by its continued fraction
+// otherwise, see Numerical Recipes 6.2.
+func gammaQ(a, x float64) float64 {
+	const (
+		eps     = 1e-14
+		maxIter = 1000
+		tiny    = 1e-300
+	)
+	lgamma, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a*math.Log(x) - lgamma)
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < maxIter; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*eps {
+				break
+			}
+		}
+		return math.Max(0, 1-sum*prefix)
+	}
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIter; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < eps {
+			break
+		}
+	}
+	return math.Min(1, prefix*h)
+}
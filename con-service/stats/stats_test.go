@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/rlindsey28/con-service/rolldice"
+)
+
+func TestSummary(t *testing.T) {
+	a := newAggregate(6)
+	a.add(map[int]int64{1: 10, 2: 10, 3: 10, 4: 10, 5: 10, 6: 10})
+	s := a.Summary()
+	if s.Rolls != 1 || s.Dice != 60 {
+		t.Fatalf("got %d rolls of %d dice, want 1 of 60", s.Rolls, s.Dice)
+	}
+	if s.Mean != 3.5 || s.ExpectedMean != 3.5 {
+		t.Errorf("got mean %v and expected mean %v, want 3.5", s.Mean, s.ExpectedMean)
+	}
+	if math.Abs(s.Variance-35.0/12) > 1e-9 || math.Abs(s.ExpectedVariance-35.0/12) > 1e-9 {
+		t.Errorf("got variance %v and expected variance %v, want 35/12", s.Variance, s.ExpectedVariance)
+	}
+	if s.ChiSquared != 0 || s.PValue != 1 || s.DegreesOfFreedom != 5 {
+		t.Errorf("got chi-squared %v with p-value %v and %d degrees of freedom, want 0, 1 and 5", s.ChiSquared, s.PValue, s.DegreesOfFreedom)
+	}
+}
+
+func TestSummaryBiased(t *testing.T) {
+	a := newAggregate(6)
+	a.add(map[int]int64{1: 5, 2: 5, 3: 5, 4: 5, 5: 5, 6: 35})
+	s := a.Summary()
+	// Expected 10 of each face: 5 * 25/10 + 625/10.
+	if math.Abs(s.ChiSquared-75) > 1e-9 {
+		t.Errorf("got chi-squared %v, want 75", s.ChiSquared)
+	}
+	if s.PValue > 1e-10 {
+		t.Errorf("got p-value %v for a loaded die, want about 0", s.PValue)
+	}
+}
+
+func TestSummaryEmpty(t *testing.T) {
+	s := newAggregate(20).Summary()
+	if s.Dice != 0 || s.Mean != 0 || s.ChiSquared != 0 || s.PValue != 1 {
+		t.Errorf("unexpected summary of no dice %+v", s)
+	}
+}
+
+func TestChiSquaredSurvival(t *testing.T) {
+	tests := []struct {
+		x    float64
+		dof  int
+		want float64
+	}{
+		{x: 3.841459, dof: 1, want: 0.05},
+		{x: 11.0705, dof: 5, want: 0.05},
+		{x: 15.0863, dof: 5, want: 0.01},
+		{x: 4.35146, dof: 5, want: 0.5},
+		{x: 30.1435, dof: 19, want: 0.05},
+		{x: 2, dof: 2, want: math.Exp(-1)},
+	}
+	for _, tt := range tests {
+		if got := chiSquaredSurvival(tt.x, tt.dof); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("chiSquaredSurvival(%v, %d) = %v, want %v", tt.x, tt.dof, got, tt.want)
+		}
+	}
+}
+
+func TestFacesOf(t *testing.T) {
+	roll := &rolldice.DiceRoll{Rolls: 3, Sides: 6, Distribution: map[int8]int32{2: 1, 5: 2}}
+	faces := facesOf(roll)
+	if len(faces) != 1 || faces[6][2] != 1 || faces[6][5] != 2 {
+		t.Errorf("unexpected faces %v", faces)
+	}
+
+	roll = &rolldice.DiceRoll{Result: &rolldice.ExpressionResult{
+		Expression:   "2d6+1d20",
+		Distribution: map[int]map[int]int32{6: {3: 2}, 20: {17: 1}},
+	}}
+	faces = facesOf(roll)
+	if len(faces) != 2 || faces[6][3] != 2 || faces[20][17] != 1 {
+		t.Errorf("unexpected faces of an expression %v", faces)
+	}
+}
@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidWindow = errors.New("invalid window")
+
+// Window is a time window spec. Windows of Size start every Hop; tumbling
+// windows have a Hop equal to their Size and do not overlap, hopping windows
+// a shorter one, so that every event falls in Size/Hop of them. Windows are
+// aligned to the Unix epoch.
+type Window struct {
+	Size time.Duration
+	Hop  time.Duration
+}
+
+// Tumbling reports whether the windows of w do not overlap.
+func (w Window) Tumbling() bool {
+	return w.Hop == w.Size
+}
+
+// Name identifies w in the API and published results, such as tumbling-1m
+// or hopping-5m-1m.
+func (w Window) Name() string {
+	if w.Tumbling() {
+		return "tumbling-" + formatDuration(w.Size)
+	}
+	return "hopping-" + formatDuration(w.Size) + "-" + formatDuration(w.Hop)
+}
+
+// starts returns the start of every window of w that at falls in.
+func (w Window) starts(at time.Time) []time.Time {
+	hop := int64(w.Hop)
+	t := at.UnixNano()
+	first := t - t%hop
+	if t < 0 && t%hop != 0 {
+		first -= hop
+	}
+	var starts []time.Time
+	for start := first; start > t-int64(w.Size); start -= hop {
+		starts = append(starts, time.Unix(0, start).UTC())
+	}
+	return starts
+}
+
+// ParseWindows returns the tumbling windows of the given sizes followed by
+// the hopping windows, each given as size/hop such as 5m/1m. The hop of a
+// hopping window must divide its size.
+func ParseWindows(tumbling []time.Duration, hopping []string) ([]Window, error) {
+	windows := make([]Window, 0, len(tumbling)+len(hopping))
+	for _, size := range tumbling {
+		if size <= 0 {
+			return nil, fmt.Errorf("%w: tumbling size %s must be positive", ErrInvalidWindow, size)
+		}
+		windows = append(windows, Window{Size: size, Hop: size})
+	}
+	for _, spec := range hopping {
+		s, h, ok := strings.Cut(strings.TrimSpace(spec), "/")
+		if !ok {
+			return nil, fmt.Errorf("%w: hopping window %q must be size/hop", ErrInvalidWindow, spec)
+		}
+		size, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: hopping window %q: %w", ErrInvalidWindow, spec, err)
+		}
+		hop, err := time.ParseDuration(h)
+		if err != nil {
+			return nil, fmt.Errorf("%w: hopping window %q: %w", ErrInvalidWindow, spec, err)
+		}
+		if hop <= 0 || size <= hop || size%hop != 0 {
+			return nil, fmt.Errorf("%w: hopping window %q must hop by a positive divisor of its size", ErrInvalidWindow, spec)
+		}
+		windows = append(windows, Window{Size: size, Hop: hop})
+	}
+	seen := make(map[string]bool, len(windows))
+	for _, w := range windows {
+		if seen[w.Name()] {
+			return nil, fmt.Errorf("%w: duplicate window %s", ErrInvalidWindow, w.Name())
+		}
+		seen[w.Name()] = true
+	}
+	return windows, nil
+}
+
+// formatDuration formats d in the largest whole unit out of h, m, s and ms.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	}
+}
+
+// Result is the statistics of one window. Final is set once the watermark
+// has passed the end of the window, after which it no longer changes.
+type Result struct {
+	Window string    `json:"window"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Final  bool      `json:"final"`
+	Sides  []Summary `json:"sides"`
+}
+
+// filter returns r with only the summary of dice with sides, or r unchanged
+// if sides is 0.
+func (r Result) filter(sides int) Result {
+	if sides == 0 {
+		return r
+	}
+	filtered := r
+	filtered.Sides = []Summary{}
+	for _, s := range r.Sides {
+		if s.Sides == sides {
+			filtered.Sides = append(filtered.Sides, s)
+		}
+	}
+	return filtered
+}
+
+// openWindow is a window still accepting events.
+type openWindow struct {
+	start, end time.Time
+	aggregates map[int]*Aggregate
+}
+
+func (o *openWindow) result(name string, final bool) Result {
+	return Result{Window: name, Start: o.start, End: o.end, Final: final, Sides: summarize(o.aggregates)}
+}
+
+// windowState holds the open windows of a Window and the results of the
+// most recent closed ones.
+type windowState struct {
+	Window
+	open   map[int64]*openWindow // by start in Unix nanoseconds
+	closed []Result
+	late   int64
+}
@@ -0,0 +1,139 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTime = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func newTestEncoder(t *testing.T, mode, contentType string) *Encoder {
+	e, err := NewEncoder(mode, Event{
+		Source:          "/pub-service",
+		Type:            "dev.go-sandbox.dice.rolled",
+		DataContentType: contentType,
+		DataSchema:      "http://schema-registry:8081/schemas/ids/1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.now = func() time.Time { return testTime }
+	return e
+}
+
+// consumed returns msg as a consumer receives it.
+func consumed(t *testing.T, msg *sarama.ProducerMessage) ([]*sarama.RecordHeader, []byte) {
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		headers[i] = &msg.Headers[i]
+	}
+	return headers, value
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	e := newTestEncoder(t, ModeBinary, "application/avro")
+	data := []byte{0, 0, 0, 0, 1, 12}
+	msg := &sarama.ProducerMessage{}
+	sent, err := e.Encode(msg, data, "table-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers, value := consumed(t, msg)
+	assert.Equal(t, data, value, "binary mode sends the data as the value")
+	got, err := Decode(headers, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sent, got)
+	assert.Equal(t, SpecVersion, got.SpecVersion)
+	assert.NotEmpty(t, got.ID)
+	assert.Equal(t, testTime, got.Time)
+	assert.Equal(t, "table-7", got.Subject)
+	assert.Equal(t, "application/avro", got.DataContentType)
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		data        []byte
+		field       string
+	}{
+		{"application/json", []byte(`{"rolls":3,"sides":6}`), "data"},
+		{"application/avro", []byte{0, 0, 0, 0, 1, 12}, "data_base64"},
+	} {
+		t.Run(tc.contentType, func(t *testing.T) {
+			e := newTestEncoder(t, ModeStructured, tc.contentType)
+			msg := &sarama.ProducerMessage{}
+			sent, err := e.Encode(msg, tc.data, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			headers, value := consumed(t, msg)
+			var envelope map[string]any
+			if err := json.Unmarshal(value, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			assert.Contains(t, envelope, tc.field)
+			assert.Equal(t, "dev.go-sandbox.dice.rolled", envelope["type"])
+			assert.Equal(t, "2024-05-01T12:30:00Z", envelope["time"])
+
+			got, err := Decode(headers, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, sent, got)
+		})
+	}
+}
+
+func TestStructuredExtensions(t *testing.T) {
+	value := []byte(`{"specversion":"1.0","id":"1","source":"/other","type":"t","partitionkey":"table-7","sequence":7,"data":null}`)
+	got, err := Decode([]*sarama.RecordHeader{{Key: []byte("Content-Type"), Value: []byte(ContentTypeStructured + "; charset=utf-8")}}, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]string{"partitionkey": "table-7", "sequence": "7"}, got.Extensions)
+	assert.Nil(t, got.Data)
+}
+
+func TestDecodePlainMessage(t *testing.T) {
+	_, err := Decode([]*sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte("00-abc")}}, []byte(`{"rolls":3}`))
+	assert.ErrorIs(t, err, ErrNotCloudEvent)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	_, err := Decode([]*sarama.RecordHeader{{Key: []byte("ce_specversion"), Value: []byte("0.3")}}, nil)
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+
+	_, err = Decode([]*sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte(ContentTypeStructured)}}, []byte(`{"specversion":"1.0","id":"1"}`))
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
+
+func TestNewEncoder(t *testing.T) {
+	e, err := NewEncoder(ModeNone, Event{})
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+	assert.Equal(t, ModeNone, e.Mode())
+
+	msg := &sarama.ProducerMessage{}
+	event, err := e.Encode(msg, []byte(`{}`), "")
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	assert.Empty(t, msg.Headers)
+
+	_, err = NewEncoder("envelope", Event{Source: "/pub-service", Type: "t"})
+	assert.Error(t, err)
+
+	_, err = NewEncoder(ModeBinary, Event{Type: "t"})
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
@@ -1,12 +1,19 @@
 package config
 
+import "time"
+
 type AppConfig struct {
-	ServiceName string           `env:"SERVICE_NAME"`
-	Host        string           `env:"HOST"`
-	Port        string           `env:"PORT"`
-	LogLevel    string           `env:"LOG_LEVEL"`
-	Kafka       *KafkaConfig     `env:", prefix=KAFKA_"`
-	Telemetry   *TelemetryConfig `env:", prefix=OTEL_"`
+	ServiceName string             `env:"SERVICE_NAME"`
+	Host        string             `env:"HOST"`
+	Port        string             `env:"PORT"`
+	LogLevel    string             `env:"LOG_LEVEL"`
+	Kafka       *KafkaConfig       `env:", prefix=KAFKA_"`
+	Telemetry   *TelemetryConfig   `env:", prefix=OTEL_"`
+	Fair        *FairConfig        `env:", prefix=FAIR_"`
+	RNG         *RNGConfig         `env:", prefix=RNG_"`
+	Outbox      *OutboxConfig      `env:", prefix=OUTBOX_"`
+	Schema      *SchemaConfig      `env:", prefix=SCHEMA_"`
+	CloudEvents *CloudEventsConfig `env:", prefix=CLOUDEVENTS_"`
 }
 
 type TelemetryConfig struct {
@@ -17,6 +24,102 @@ type TelemetryConfig struct {
 }
 
 type KafkaConfig struct {
-	Brokers []string `env:"BROKERS, delimiter=;"`
-	Topic   string   `env:"TOPIC"`
+	Brokers         []string      `env:"BROKERS, delimiter=;"`
+	Topic           string        `env:"TOPIC"`
+	RequiredAcks    string        `env:"REQUIRED_ACKS, default=all"`
+	DeliveryMode    string        `env:"DELIVERY_MODE, default=ack"`
+	DeliveryTimeout time.Duration `env:"DELIVERY_TIMEOUT, default=5s"`
+	ProducerMode    string        `env:"PRODUCER_MODE, default=default"`
+	TransactionalID string        `env:"TRANSACTIONAL_ID"`
+	KeyStrategy     string        `env:"KEY_STRATEGY, default=none"`
+	KeyField        string        `env:"KEY_FIELD, default=table"`
+	ClientIDHeader  string        `env:"CLIENT_ID_HEADER, default=X-Client-ID"`
+	TenantHeader    string        `env:"TENANT_HEADER, default=X-Tenant-ID"`
+	Partitioner     string        `env:"PARTITIONER, default=hash"`
+	Provision       *TopicConfig  `env:", prefix=TOPIC_"`
+	TLS             *TLSConfig    `env:", prefix=TLS_"`
+	SASL            *SASLConfig   `env:", prefix=SASL_"`
+}
+
+// TLSConfig encrypts connections to the brokers. CertFile and KeyFile are
+// only needed for mutual TLS. Certificate files are reloaded when they change.
+type TLSConfig struct {
+	Enabled            bool   `env:"ENABLED"`
+	CAFile             string `env:"CA_FILE"`
+	CertFile           string `env:"CERT_FILE"`
+	KeyFile            string `env:"KEY_FILE"`
+	ServerName         string `env:"SERVER_NAME"`
+	InsecureSkipVerify bool   `env:"INSECURE_SKIP_VERIFY"`
+}
+
+// SASLConfig authenticates to the brokers. Mechanism is one of PLAIN,
+// SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER, or empty to disable SASL.
+// OAUTHBEARER tokens come from TokenProvider: client_credentials fetches them
+// from TokenURL and file reads them from TokenFile.
+type SASLConfig struct {
+	Mechanism     string   `env:"MECHANISM"`
+	Username      string   `env:"USERNAME"`
+	Password      string   `env:"PASSWORD" json:"-"`
+	TokenProvider string   `env:"TOKEN_PROVIDER, default=client_credentials"`
+	TokenURL      string   `env:"TOKEN_URL"`
+	ClientID      string   `env:"CLIENT_ID"`
+	ClientSecret  string   `env:"CLIENT_SECRET" json:"-"`
+	Scopes        []string `env:"SCOPES"`
+	TokenFile     string   `env:"TOKEN_FILE"`
+}
+
+// TopicConfig is the spec topics are created with and validated against at
+// startup. Policy is one of off, warn, fail or reconcile.
+type TopicConfig struct {
+	Policy            string            `env:"POLICY, default=warn"`
+	Partitions        int32             `env:"PARTITIONS, default=3"`
+	ReplicationFactor int16             `env:"REPLICATION_FACTOR, default=1"`
+	Retention         time.Duration     `env:"RETENTION"`
+	CleanupPolicy     string            `env:"CLEANUP_POLICY"`
+	MinInsyncReplicas int               `env:"MIN_INSYNC_REPLICAS"`
+	Configs           map[string]string `env:"CONFIGS"`
+}
+
+// FairConfig configures the server seeds. Seeds can only be rotated on demand
+// by callers presenting RotateToken as their bearer token; without it they
+// are only rotated every RotateInterval.
+type FairConfig struct {
+	RotateInterval time.Duration `env:"ROTATE_INTERVAL, default=24h"`
+	RevealHistory  int           `env:"REVEAL_HISTORY, default=1000"`
+	RotateToken    string        `env:"ROTATE_TOKEN" json:"-"`
+}
+
+// RNGConfig selects the RNG of the deployment. Requests can only choose a
+// seeded source of their own if AllowSeeded is set, as whoever picks the seed
+// picks the outcome.
+type RNGConfig struct {
+	Source      string `env:"SOURCE, default=math"`
+	AllowSeeded bool   `env:"ALLOW_SEEDED, default=false"`
+}
+
+// OutboxConfig enables the on-disk outbox when Dir is set.
+type OutboxConfig struct {
+	Dir             string        `env:"DIR"`
+	SegmentSize     int64         `env:"SEGMENT_SIZE, default=16777216"`
+	RetryBackoff    time.Duration `env:"RETRY_BACKOFF, default=1s"`
+	MaxRetryBackoff time.Duration `env:"MAX_RETRY_BACKOFF, default=30s"`
+	PublishTimeout  time.Duration `env:"PUBLISH_TIMEOUT, default=10s"`
+}
+
+// SchemaConfig selects how rolls are encoded. Avro and Protobuf use the
+// Schema Registry at RegistryURL; Subject defaults to "<topic>-value".
+type SchemaConfig struct {
+	Encoding     string `env:"ENCODING, default=json"`
+	RegistryURL  string `env:"REGISTRY_URL"`
+	Subject      string `env:"SUBJECT"`
+	AutoRegister bool   `env:"AUTO_REGISTER, default=true"`
+}
+
+// CloudEventsConfig wraps rolls in CloudEvents when Mode is binary or
+// structured. DataSchema defaults to the registered schema's URL.
+type CloudEventsConfig struct {
+	Mode       string `env:"MODE, default=none"`
+	Source     string `env:"SOURCE, default=/pub-service"`
+	Type       string `env:"TYPE, default=dev.go-sandbox.dice.rolled"`
+	DataSchema string `env:"DATA_SCHEMA"`
 }
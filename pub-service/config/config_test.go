@@ -1,20 +1,69 @@
 package config
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
 )
 
 func TestInitialize(t *testing.T) {
-	config := Initialize()
-	if config == nil {
-		t.Error("Expected config to be initialized, but it was nil")
+	var config AppConfig
+	err := envconfig.ProcessWith(context.Background(), &envconfig.Config{
+		Target: &config,
+		Lookuper: envconfig.MapLookuper(map[string]string{
+			"SERVICE_NAME":  "pub-service",
+			"PORT":          ":8080",
+			"KAFKA_BROKERS": "broker-1:9092;broker-2:9092",
+			"KAFKA_TOPIC":   "dice-rolls",
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if config.Service.Name == "" {
+	if config.ServiceName == "" {
 		t.Error("Expected Name to be set, but it was empty")
 	}
 
-	if config.Service.Port == "" {
+	if config.Port == "" {
 		t.Error("Expected Port to be set, but it was empty")
 	}
+
+	if len(config.Kafka.Brokers) != 2 {
+		t.Errorf("Expected 2 brokers, got %v", config.Kafka.Brokers)
+	}
+
+	if config.Fair.RotateInterval != 24*time.Hour {
+		t.Errorf("Expected default rotate interval of 24h, got %v", config.Fair.RotateInterval)
+	}
+
+	if config.RNG.Source != "math" || config.RNG.AllowSeeded {
+		t.Errorf("Expected default rng source math without seeded requests, got %+v", config.RNG)
+	}
+
+	if config.Kafka.KeyStrategy != "none" || config.Kafka.Partitioner != "hash" {
+		t.Errorf("Expected no key and the hash partitioner by default, got %q and %q", config.Kafka.KeyStrategy, config.Kafka.Partitioner)
+	}
+
+	if config.Schema.Encoding != "json" || !config.Schema.AutoRegister {
+		t.Errorf("Expected JSON encoding with auto registration by default, got %q and %v", config.Schema.Encoding, config.Schema.AutoRegister)
+	}
+
+	if p := config.Kafka.Provision; p.Policy != "warn" || p.Partitions != 3 || p.ReplicationFactor != 1 {
+		t.Errorf("Expected topics to be provisioned with the warn policy by default, got %+v", p)
+	}
+
+	if config.Kafka.TLS.Enabled || config.Kafka.SASL.Mechanism != "" {
+		t.Errorf("Expected TLS and SASL to be disabled by default, got %+v and %+v", config.Kafka.TLS, config.Kafka.SASL)
+	}
+
+	if config.CloudEvents.Mode != "none" || config.CloudEvents.Type != "dev.go-sandbox.dice.rolled" {
+		t.Errorf("Expected CloudEvents to be disabled by default, got mode %q and type %q", config.CloudEvents.Mode, config.CloudEvents.Type)
+	}
+
+	if config.Outbox.Dir != "" {
+		t.Errorf("Expected the outbox to be disabled by default, got dir %q", config.Outbox.Dir)
+	}
 }
@@ -0,0 +1,139 @@
+package dice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seqRand returns the given faces (1-based) in order.
+type seqRand struct {
+	faces []int
+	next  int
+}
+
+func (s *seqRand) IntN(n int) int {
+	face := s.faces[s.next%len(s.faces)]
+	s.next++
+	return (face - 1) % n
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"d20", "1d20"},
+		{"4d6kh3+2", "4d6kh3+2"},
+		{"4d6k3", "4d6kh3"},
+		{"2d20kl1", "2d20kl1"},
+		{"4d6dl1", "4d6dl1"},
+		{"3d6!", "3d6!"},
+		{"8d10>=7", "8d10>=7"},
+		{"1d8 + 2d6 - 1", "1d8+2d6-1"},
+		{"-1+d%", "-1+1d100"},
+		{"2D6", "2d6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			expr, err := Parse(tt.in)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, expr.String())
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+	}{
+		{"", 0},
+		{"4d", 2},
+		{"4d1", 2},
+		{"0d6", 0},
+		{"101d6", 0},
+		{"4d6kh5", 3},
+		{"4d6kh3kl1", 6},
+		{"2d6+", 4},
+		{"2d6 x", 4},
+		{"60d6+60d6", 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := Parse(tt.in)
+			var syntaxErr *SyntaxError
+			if assert.True(t, errors.As(err, &syntaxErr), "expected SyntaxError, got %v", err) {
+				assert.Equal(t, tt.pos, syntaxErr.Pos)
+			}
+		})
+	}
+}
+
+func TestRollKeepHighest(t *testing.T) {
+	res, err := Roll("4d6kh3+2", &seqRand{faces: []int{3, 6, 1, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 15, res.Total)
+	assert.Len(t, res.Terms, 2)
+	assert.Equal(t, []Die{{Value: 3, Kept: true}, {Value: 6, Kept: true}, {Value: 1}, {Value: 4, Kept: true}}, res.Terms[0].Dice)
+	assert.Equal(t, 2, res.Terms[1].Value)
+	assert.Equal(t, map[int]map[int]int32{6: {1: 1, 3: 1, 4: 1, 6: 1}}, res.Distribution)
+}
+
+func TestRollKeepLowest(t *testing.T) {
+	res, err := Roll("2d20kl1", &seqRand{faces: []int{17, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, res.Total)
+}
+
+func TestRollExploding(t *testing.T) {
+	res, err := Roll("3d6!", &seqRand{faces: []int{6, 6, 2, 3, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 18, res.Total)
+	dice := res.Terms[0].Dice
+	assert.Len(t, dice, 5)
+	assert.True(t, dice[0].Exploded)
+	assert.True(t, dice[1].Exploded)
+	assert.False(t, dice[2].Exploded)
+}
+
+func TestRollExplodingIsBounded(t *testing.T) {
+	res, err := Roll("1d2!", &seqRand{faces: []int{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, res.Terms[0].Dice, maxExplosions+1)
+}
+
+func TestRollSuccesses(t *testing.T) {
+	res, err := Roll("8d10>=7", &seqRand{faces: []int{7, 1, 10, 6, 8, 2, 3, 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 4, res.Total)
+	if assert.NotNil(t, res.Terms[0].Successes) {
+		assert.Equal(t, 4, *res.Terms[0].Successes)
+	}
+}
+
+func TestRollMixedPool(t *testing.T) {
+	res, err := Roll("1d8+2d6-1", &seqRand{faces: []int{5, 2, 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 12, res.Total)
+	assert.Equal(t, []int{5, 8, -1}, []int{res.Terms[0].Value, res.Terms[1].Value, res.Terms[2].Value})
+	assert.Equal(t, map[int]map[int]int32{8: {5: 1}, 6: {2: 1, 6: 1}}, res.Distribution)
+}
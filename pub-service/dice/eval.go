@@ -0,0 +1,158 @@
+package dice
+
+import (
+	"sort"
+)
+
+// maxExplosions bounds the number of extra dice a single exploding term may
+// add, so that "100d2!" cannot loop forever.
+const maxExplosions = 100
+
+// Rand is the source of randomness used to roll dice. IntN returns a value
+// in [0, n).
+type Rand interface {
+	IntN(n int) int
+}
+
+// Die is the outcome of a single die.
+type Die struct {
+	Value    int  `json:"value"`
+	Kept     bool `json:"kept"`
+	Exploded bool `json:"exploded,omitempty"`
+	Success  bool `json:"success,omitempty"`
+}
+
+// TermResult is the breakdown of a single term of an expression.
+type TermResult struct {
+	Notation  string `json:"notation"`
+	Sign      int    `json:"sign"`
+	Sides     int    `json:"sides,omitempty"`
+	Dice      []Die  `json:"dice,omitempty"`
+	Successes *int   `json:"successes,omitempty"`
+	Value     int    `json:"value"`
+}
+
+// Result is the evaluated outcome of an expression. Distribution counts every
+// die rolled, including dropped ones, keyed by number of sides and then face.
+type Result struct {
+	Expression   string                `json:"expression"`
+	Total        int                   `json:"total"`
+	Terms        []TermResult          `json:"terms"`
+	Distribution map[int]map[int]int32 `json:"distribution"`
+}
+
+// Roll parses and evaluates s using r.
+func Roll(s string, r Rand) (*Result, error) {
+	expr, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return expr.Eval(r), nil
+}
+
+// Eval rolls every dice term of e using r and returns the result.
+func (e *Expr) Eval(r Rand) *Result {
+	res := &Result{
+		Expression:   e.String(),
+		Terms:        make([]TermResult, 0, len(e.Terms)),
+		Distribution: make(map[int]map[int]int32),
+	}
+	for _, t := range e.Terms {
+		tr := t.eval(r)
+		res.Total += tr.Value
+		res.Terms = append(res.Terms, tr)
+
+		if !t.IsDice() {
+			continue
+		}
+		faces, ok := res.Distribution[t.Sides]
+		if !ok {
+			faces = make(map[int]int32)
+			res.Distribution[t.Sides] = faces
+		}
+		for _, d := range tr.Dice {
+			faces[d.Value]++
+		}
+	}
+	return res
+}
+
+func (t Term) eval(r Rand) TermResult {
+	tr := TermResult{
+		Notation: t.String(),
+		Sign:     t.Sign,
+		Sides:    t.Sides,
+	}
+	if !t.IsDice() {
+		tr.Value = t.Sign * t.Constant
+		return tr
+	}
+
+	dice := make([]Die, 0, t.Count)
+	explosions := 0
+	for i := 0; i < t.Count; i++ {
+		d := Die{Value: r.IntN(t.Sides) + 1, Kept: true}
+		dice = append(dice, d)
+		for t.Explode && d.Value == t.Sides && explosions < maxExplosions {
+			dice[len(dice)-1].Exploded = true
+			explosions++
+			d = Die{Value: r.IntN(t.Sides) + 1, Kept: true}
+			dice = append(dice, d)
+		}
+	}
+	t.selectDice(dice)
+
+	value := 0
+	if t.Success != CompareNone {
+		successes := 0
+		for i := range dice {
+			if dice[i].Kept && t.Success.match(dice[i].Value, t.Target) {
+				dice[i].Success = true
+				successes++
+			}
+		}
+		tr.Successes = &successes
+		value = successes
+	} else {
+		for _, d := range dice {
+			if d.Kept {
+				value += d.Value
+			}
+		}
+	}
+
+	tr.Dice = dice
+	tr.Value = t.Sign * value
+	return tr
+}
+
+// selectDice clears Kept on the dice removed by a keep or drop modifier. Ties
+// are broken by roll order so results are reproducible for a given Rand.
+func (t Term) selectDice(dice []Die) {
+	if t.Select == SelectNone {
+		return
+	}
+	order := make([]int, len(dice))
+	for i := range order {
+		order[i] = i
+	}
+	// Sort indexes from lowest to highest value.
+	sort.SliceStable(order, func(a, b int) bool {
+		return dice[order[a]].Value < dice[order[b]].Value
+	})
+
+	var drop []int
+	switch t.Select {
+	case SelectKeepHigh:
+		drop = order[:max(len(order)-t.SelectCount, 0)]
+	case SelectKeepLow:
+		drop = order[min(t.SelectCount, len(order)):]
+	case SelectDropHigh:
+		drop = order[max(len(order)-t.SelectCount, 0):]
+	case SelectDropLow:
+		drop = order[:min(t.SelectCount, len(order))]
+	}
+	for _, i := range drop {
+		dice[i].Kept = false
+	}
+}
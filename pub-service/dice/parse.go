@@ -0,0 +1,336 @@
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxExpressionLength = 256
+	maxTerms            = 20
+	maxDice             = 100
+	maxSides            = 1000
+	maxConstant         = 10000
+)
+
+// SyntaxError reports a malformed dice expression together with the
+// position (0-based, in bytes) at which parsing failed.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid dice expression at position %d: %s", e.Pos, e.Msg)
+}
+
+// Compare is a comparison operator used for success counting, e.g. the ">="
+// in "8d10>=7".
+type Compare string
+
+const (
+	CompareNone Compare = ""
+	CompareEQ   Compare = "="
+	CompareGT   Compare = ">"
+	CompareGE   Compare = ">="
+	CompareLT   Compare = "<"
+	CompareLE   Compare = "<="
+)
+
+func (c Compare) match(value, target int) bool {
+	switch c {
+	case CompareEQ:
+		return value == target
+	case CompareGT:
+		return value > target
+	case CompareGE:
+		return value >= target
+	case CompareLT:
+		return value < target
+	case CompareLE:
+		return value <= target
+	}
+	return false
+}
+
+// Selection keeps or drops the highest or lowest dice of a pool.
+type Selection string
+
+const (
+	SelectNone     Selection = ""
+	SelectKeepHigh Selection = "kh"
+	SelectKeepLow  Selection = "kl"
+	SelectDropHigh Selection = "dh"
+	SelectDropLow  Selection = "dl"
+)
+
+// Term is a single signed operand of an expression: either a pool of dice
+// such as "4d6kh3" or a constant such as "2".
+type Term struct {
+	Sign     int
+	Count    int
+	Sides    int
+	Constant int
+
+	Select      Selection
+	SelectCount int
+	Explode     bool
+	Success     Compare
+	Target      int
+}
+
+// IsDice reports whether the term rolls dice rather than adding a constant.
+func (t Term) IsDice() bool {
+	return t.Sides > 0
+}
+
+// String returns the canonical notation of the term without its sign.
+func (t Term) String() string {
+	if !t.IsDice() {
+		return strconv.Itoa(t.Constant)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%dd%d", t.Count, t.Sides)
+	if t.Explode {
+		sb.WriteString("!")
+	}
+	if t.Select != SelectNone {
+		fmt.Fprintf(&sb, "%s%d", t.Select, t.SelectCount)
+	}
+	if t.Success != CompareNone {
+		fmt.Fprintf(&sb, "%s%d", t.Success, t.Target)
+	}
+	return sb.String()
+}
+
+// Expr is a parsed dice expression.
+type Expr struct {
+	Source string
+	Terms  []Term
+}
+
+// String returns the canonical notation of the whole expression.
+func (e *Expr) String() string {
+	var sb strings.Builder
+	for i, t := range e.Terms {
+		switch {
+		case t.Sign < 0:
+			sb.WriteString("-")
+		case i > 0:
+			sb.WriteString("+")
+		}
+		sb.WriteString(t.String())
+	}
+	return sb.String()
+}
+
+// Parse parses tabletop dice notation such as "4d6kh3+2", "2d20kl1", "3d6!",
+// "8d10>=7" or "1d8+2d6-1".
+func Parse(s string) (*Expr, error) {
+	if len(s) > maxExpressionLength {
+		return nil, &SyntaxError{Pos: maxExpressionLength, Msg: fmt.Sprintf("expression longer than %d characters", maxExpressionLength)}
+	}
+	p := &parser{src: strings.ToLower(s)}
+	expr, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	expr.Source = s
+	return expr, nil
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) accept(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *parser) number() (int, bool, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, false, nil
+	}
+	digits := p.src[start:p.pos]
+	n, err := strconv.Atoi(digits)
+	if err != nil || n > maxConstant {
+		p.pos = start
+		return 0, false, p.errorf("number %s is larger than %d", digits, maxConstant)
+	}
+	return n, true, nil
+}
+
+func (p *parser) parse() (*Expr, error) {
+	expr := &Expr{}
+	dice := 0
+	for {
+		sign := 1
+		switch {
+		case p.accept("+"):
+		case p.accept("-"):
+			sign = -1
+		case len(expr.Terms) > 0:
+			if p.peek() == 0 {
+				return expr, nil
+			}
+			return nil, p.errorf("expected '+' or '-', found %q", p.peek())
+		}
+
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		term.Sign = sign
+		expr.Terms = append(expr.Terms, term)
+		dice += term.Count
+
+		if len(expr.Terms) > maxTerms {
+			return nil, p.errorf("expression has more than %d terms", maxTerms)
+		}
+		if dice > maxDice {
+			return nil, p.errorf("expression rolls more than %d dice", maxDice)
+		}
+	}
+}
+
+func (p *parser) term() (Term, error) {
+	start := p.pos
+	n, ok, err := p.number()
+	if err != nil {
+		return Term{}, err
+	}
+	if !p.accept("d") {
+		if !ok {
+			if p.peek() == 0 {
+				return Term{}, p.errorf("unexpected end of expression")
+			}
+			return Term{}, p.errorf("expected number or dice, found %q", p.peek())
+		}
+		return Term{Constant: n}, nil
+	}
+
+	term := Term{Count: 1}
+	if ok {
+		term.Count = n
+	}
+	if term.Count < 1 || term.Count > maxDice {
+		p.pos = start
+		return Term{}, p.errorf("number of dice must be >=1 and <=%d", maxDice)
+	}
+
+	if p.accept("%") {
+		term.Sides = 100
+	} else {
+		sidesPos := p.pos
+		sides, ok, err := p.number()
+		if err != nil {
+			return Term{}, err
+		}
+		if !ok {
+			return Term{}, p.errorf("expected number of sides")
+		}
+		if sides < 2 || sides > maxSides {
+			p.pos = sidesPos
+			return Term{}, p.errorf("number of sides must be >=2 and <=%d", maxSides)
+		}
+		term.Sides = sides
+	}
+
+	if err := p.modifiers(&term); err != nil {
+		return Term{}, err
+	}
+	return term, nil
+}
+
+func (p *parser) modifiers(term *Term) error {
+	for {
+		modPos := p.pos
+		switch {
+		case p.accept("!"):
+			if term.Explode {
+				p.pos = modPos
+				return p.errorf("duplicate explode modifier")
+			}
+			term.Explode = true
+
+		case p.accept("kh"), p.accept("kl"), p.accept("k"), p.accept("dh"), p.accept("dl"):
+			if term.Select != SelectNone {
+				p.pos = modPos
+				return p.errorf("only one keep or drop modifier is allowed")
+			}
+			sel := Selection(strings.TrimSpace(p.src[modPos:p.pos]))
+			if sel == "k" {
+				sel = SelectKeepHigh
+			}
+			n, ok, err := p.number()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return p.errorf("expected number after %q", sel)
+			}
+			switch sel {
+			case SelectKeepHigh, SelectKeepLow:
+				if n < 1 || n > term.Count {
+					p.pos = modPos
+					return p.errorf("can only keep between 1 and %d dice", term.Count)
+				}
+			default:
+				if n < 0 || n >= term.Count {
+					p.pos = modPos
+					return p.errorf("can only drop between 0 and %d dice", term.Count-1)
+				}
+			}
+			term.Select = sel
+			term.SelectCount = n
+
+		case p.accept(">="), p.accept("<="), p.accept(">"), p.accept("<"), p.accept("="):
+			if term.Success != CompareNone {
+				p.pos = modPos
+				return p.errorf("only one success condition is allowed")
+			}
+			term.Success = Compare(strings.TrimSpace(p.src[modPos:p.pos]))
+			n, ok, err := p.number()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return p.errorf("expected target number after %q", term.Success)
+			}
+			term.Target = n
+
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package fair
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"pub-service/logger"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Version identifies the derivation scheme used by Stream. It travels with
+// every proof so that verifiers can keep checking old rolls if the scheme
+// ever changes.
+const Version = "hmac-sha256-v1"
+
+const (
+	seedSize            = 32
+	maxClientSeedLength = 64
+	// maxNonceSkip bounds how far past the next nonce a caller may choose one,
+	// as the nonces it skips cannot be used any more.
+	maxNonceSkip = 1000
+)
+
+var (
+	ErrSeedActive        = errors.New("server seed is still active and has not been revealed")
+	ErrUnknownSeed       = errors.New("unknown server seed")
+	ErrClientSeedTooLong = fmt.Errorf("client seed must be at most %d characters", maxClientSeedLength)
+	ErrNonceUsed         = errors.New("nonce has already been used with the active server seed")
+	ErrNonceTooFar       = fmt.Errorf("nonce must be at most %d past the next nonce", maxNonceSkip)
+	ErrNoncesExhausted   = errors.New("nonces of the active server seed are exhausted, rotate it")
+)
+
+// Seed is a server seed. Secret stays private until the seed is rotated out,
+// Hash is published up front as the commitment.
+type Seed struct {
+	Secret     string     `json:"server_seed,omitempty"`
+	Hash       string     `json:"server_seed_hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevealedAt *time.Time `json:"revealed_at,omitempty"`
+}
+
+// Commitment is the public view of the active server seed.
+type Commitment struct {
+	Version        string    `json:"version"`
+	ServerSeedHash string    `json:"server_seed_hash"`
+	CreatedAt      time.Time `json:"created_at"`
+	NextNonce      uint64    `json:"next_nonce"`
+}
+
+// Draw is a single value taken from a Stream: a face in [1, Sides].
+type Draw struct {
+	Sides int `json:"sides"`
+	Value int `json:"value"`
+}
+
+// Proof carries everything needed to re-derive a roll once the server seed
+// has been revealed.
+type Proof struct {
+	Version        string `json:"version"`
+	ServerSeedHash string `json:"server_seed_hash"`
+	ClientSeed     string `json:"client_seed"`
+	Nonce          uint64 `json:"nonce"`
+	Draws          []Draw `json:"draws"`
+}
+
+// Manager owns the active server seed and remembers a bounded number of
+// revealed seeds.
+type Manager struct {
+	mu       sync.Mutex
+	current  Seed
+	nonce    uint64
+	revealed map[string]Seed
+	order    []string
+	history  int
+}
+
+// NewManager creates a Manager with a fresh server seed. history bounds the
+// number of revealed seeds kept for lookup.
+func NewManager(history int) (*Manager, error) {
+	seed, err := newSeed()
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		current:  seed,
+		revealed: make(map[string]Seed),
+		history:  max(history, 1),
+	}, nil
+}
+
+func newSeed() (Seed, error) {
+	secret := make([]byte, seedSize)
+	if _, err := rand.Read(secret); err != nil {
+		return Seed{}, fmt.Errorf("failed to generate server seed: %w", err)
+	}
+	sum := sha256.Sum256(secret)
+	return Seed{
+		Secret:    hex.EncodeToString(secret),
+		Hash:      hex.EncodeToString(sum[:]),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Commitment returns the hash of the active server seed.
+func (m *Manager) Commitment() Commitment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Commitment{
+		Version:        Version,
+		ServerSeedHash: m.current.Hash,
+		CreatedAt:      m.current.CreatedAt,
+		NextNonce:      m.nonce,
+	}
+}
+
+// NewStream returns a Stream derived from the active server seed. If nonce is
+// nil the next server-assigned nonce is used. A nonce below the next one has
+// already been used, or skipped, so the outcome of its roll may be known and
+// it is rejected. All callers share the nonces of the active seed, so a nonce
+// may only skip up to maxNonceSkip of them.
+func (m *Manager) NewStream(clientSeed string, nonce *uint64) (*Stream, error) {
+	if len(clientSeed) > maxClientSeedLength {
+		return nil, ErrClientSeedTooLong
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.nonce
+	if nonce != nil {
+		n = *nonce
+	}
+	switch {
+	case n < m.nonce:
+		return nil, fmt.Errorf("%w: next nonce is %d", ErrNonceUsed, m.nonce)
+	case n-m.nonce > maxNonceSkip:
+		return nil, fmt.Errorf("%w: next nonce is %d", ErrNonceTooFar, m.nonce)
+	case n == math.MaxUint64:
+		return nil, ErrNoncesExhausted
+	}
+	m.nonce = n + 1
+	secret, err := hex.DecodeString(m.current.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewStream(secret, m.current.Hash, clientSeed, n), nil
+}
+
+// Rotate reveals the active server seed and replaces it with a new one.
+func (m *Manager) Rotate() (Seed, Commitment, error) {
+	next, err := newSeed()
+	if err != nil {
+		return Seed{}, Commitment{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev := m.current
+	now := time.Now().UTC()
+	prev.RevealedAt = &now
+
+	m.revealed[prev.Hash] = prev
+	m.order = append(m.order, prev.Hash)
+	if len(m.order) > m.history {
+		delete(m.revealed, m.order[0])
+		m.order = m.order[1:]
+	}
+
+	m.current = next
+	m.nonce = 0
+	return prev, Commitment{
+		Version:        Version,
+		ServerSeedHash: next.Hash,
+		CreatedAt:      next.CreatedAt,
+	}, nil
+}
+
+// Revealed returns a rotated server seed by its hash.
+func (m *Manager) Revealed(hash string) (Seed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hash == m.current.Hash {
+		return Seed{}, ErrSeedActive
+	}
+	seed, ok := m.revealed[hash]
+	if !ok {
+		return Seed{}, ErrUnknownSeed
+	}
+	return seed, nil
+}
+
+// Run rotates the server seed every interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	log := logger.FromCtx(ctx)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			prev, next, err := m.Rotate()
+			if err != nil {
+				log.Error("failed to rotate server seed", zap.Error(err))
+				continue
+			}
+			log.Info("rotated server seed", zap.String("revealed", prev.Hash), zap.String("active", next.ServerSeedHash))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stream is a deterministic source of dice values derived from
+// HMAC-SHA256(serverSeed, "clientSeed:nonce:round"). Every value drawn is
+// recorded so it can be published as part of a Proof.
+type Stream struct {
+	secret     []byte
+	hash       string
+	clientSeed string
+	nonce      uint64
+	round      uint64
+	buf        []byte
+	draws      []Draw
+}
+
+// NewStream returns a Stream for the given server seed secret.
+func NewStream(secret []byte, hash, clientSeed string, nonce uint64) *Stream {
+	return &Stream{
+		secret:     secret,
+		hash:       hash,
+		clientSeed: clientSeed,
+		nonce:      nonce,
+	}
+}
+
+func (s *Stream) uint32() uint32 {
+	if len(s.buf) < 4 {
+		mac := hmac.New(sha256.New, s.secret)
+		fmt.Fprintf(mac, "%s:%d:%d", s.clientSeed, s.nonce, s.round)
+		s.round++
+		s.buf = mac.Sum(nil)
+	}
+	v := binary.BigEndian.Uint32(s.buf)
+	s.buf = s.buf[4:]
+	return v
+}
+
+// IntN returns a value in [0, n). Values are drawn by rejection sampling so
+// that every face is equally likely.
+func (s *Stream) IntN(n int) int {
+	if n <= 0 {
+		panic("fair: invalid argument to IntN")
+	}
+	limit := (1 << 32) - (1<<32)%uint64(n)
+	for {
+		v := uint64(s.uint32())
+		if v < limit {
+			r := int(v % uint64(n))
+			s.draws = append(s.draws, Draw{Sides: n, Value: r + 1})
+			return r
+		}
+	}
+}
+
+// Proof returns the proof for every value drawn so far.
+func (s *Stream) Proof() *Proof {
+	return &Proof{
+		Version:        Version,
+		ServerSeedHash: s.hash,
+		ClientSeed:     s.clientSeed,
+		Nonce:          s.nonce,
+		Draws:          append([]Draw(nil), s.draws...),
+	}
+}
+
+// Verify re-derives the draws in p from a revealed server seed.
+func Verify(serverSeed string, p *Proof) error {
+	if p.Version != Version {
+		return fmt.Errorf("unsupported derivation version %q", p.Version)
+	}
+	secret, err := hex.DecodeString(serverSeed)
+	if err != nil {
+		return fmt.Errorf("invalid server seed: %w", err)
+	}
+	sum := sha256.Sum256(secret)
+	if hex.EncodeToString(sum[:]) != p.ServerSeedHash {
+		return errors.New("server seed does not match committed hash")
+	}
+	s := NewStream(secret, p.ServerSeedHash, p.ClientSeed, p.Nonce)
+	for i, d := range p.Draws {
+		if v := s.IntN(d.Sides) + 1; v != d.Value {
+			return fmt.Errorf("draw %d: expected %d, got %d", i, v, d.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package fair
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamIsDeterministic(t *testing.T) {
+	secret := []byte("server-seed")
+	a := NewStream(secret, "hash", "client", 7)
+	b := NewStream(secret, "hash", "client", 7)
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, a.IntN(6), b.IntN(6))
+	}
+
+	c := NewStream(secret, "hash", "client", 8)
+	same := true
+	for i := 0; i < 50; i++ {
+		if a.IntN(20) != c.IntN(20) {
+			same = false
+		}
+	}
+	assert.False(t, same, "different nonces should produce different streams")
+}
+
+func TestStreamRange(t *testing.T) {
+	s := NewStream([]byte("server-seed"), "hash", "", 0)
+	for i := 0; i < 1000; i++ {
+		v := s.IntN(7)
+		assert.True(t, v >= 0 && v < 7, "value %d out of range", v)
+	}
+}
+
+func TestRotateAndVerify(t *testing.T) {
+	m, err := NewManager(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitment := m.Commitment()
+	stream, err := m.NewStream("lucky", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		stream.IntN(20)
+	}
+	proof := stream.Proof()
+	assert.Equal(t, commitment.ServerSeedHash, proof.ServerSeedHash)
+	assert.Equal(t, uint64(0), proof.Nonce)
+	assert.Equal(t, uint64(1), m.Commitment().NextNonce)
+
+	_, err = m.Revealed(proof.ServerSeedHash)
+	assert.ErrorIs(t, err, ErrSeedActive)
+
+	revealed, active, err := m.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, revealed.Hash, active.ServerSeedHash)
+
+	seed, err := m.Revealed(proof.ServerSeedHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, Verify(seed.Secret, proof))
+
+	proof.Draws[3].Value = proof.Draws[3].Value%20 + 1
+	assert.Error(t, Verify(seed.Secret, proof), "tampered draw should fail verification")
+}
+
+func TestRevealHistoryIsBounded(t *testing.T) {
+	m, err := NewManager(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _, err := m.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Revealed(first.Hash)
+	assert.ErrorIs(t, err, ErrUnknownSeed)
+}
+
+func TestNewStreamRejectsUsedNonces(t *testing.T) {
+	m, err := NewManager(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := uint64(5)
+	if _, err := m.NewStream("lucky", &nonce); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(6), m.Commitment().NextNonce)
+
+	for _, used := range []uint64{5, 2} {
+		_, err := m.NewStream("lucky", &used)
+		assert.ErrorIs(t, err, ErrNonceUsed, "nonce %d", used)
+	}
+	stream, err := m.NewStream("lucky", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(6), stream.Proof().Nonce)
+	}
+
+	for _, far := range []uint64{7 + maxNonceSkip + 1, math.MaxUint64 - 1} {
+		_, err = m.NewStream("lucky", &far)
+		assert.ErrorIs(t, err, ErrNonceTooFar, "nonce %d", far)
+	}
+	assert.Equal(t, uint64(7), m.Commitment().NextNonce)
+	skip := uint64(7 + maxNonceSkip)
+	if _, err := m.NewStream("lucky", &skip); err != nil {
+		t.Fatal(err)
+	}
+
+	m.nonce = math.MaxUint64
+	_, err = m.NewStream("lucky", nil)
+	assert.ErrorIs(t, err, ErrNoncesExhausted)
+
+	if _, _, err := m.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.NewStream("lucky", &nonce)
+	assert.NoError(t, err, "nonces start over with a new server seed")
+}
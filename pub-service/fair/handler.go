@@ -0,0 +1,97 @@
+package fair
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pub-service/logger"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+const name = "fair"
+
+var (
+	tracer = otel.Tracer(name)
+)
+
+type Handler struct {
+	Manager *Manager
+}
+
+type RotateResponse struct {
+	Revealed Seed       `json:"revealed"`
+	Active   Commitment `json:"active"`
+}
+
+// Commitment publishes the hash of the active server seed.
+func (h *Handler) Commitment(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "commitment")
+	defer span.End()
+
+	writeJSON(w, http.StatusOK, h.Manager.Commitment())
+}
+
+// Rotate reveals the active server seed and commits to a new one.
+func (h *Handler) Rotate(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "rotate")
+	defer span.End()
+	log := logger.FromCtx(ctx)
+
+	revealed, active, err := h.Manager.Rotate()
+	if err != nil {
+		log.Error("failed to rotate server seed", zap.Error(err))
+		span.SetStatus(otelcodes.Error, "failed to rotate server seed")
+		span.RecordError(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	log.Info("rotated server seed", zap.String("revealed", revealed.Hash), zap.String("active", active.ServerSeedHash))
+	writeJSON(w, http.StatusOK, RotateResponse{Revealed: revealed, Active: active})
+}
+
+// RequireToken rejects requests without token as their bearer token.
+func RequireToken(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				logger.Get().Warn("rejected request", zap.String("method", r.Method), zap.String("path", r.URL.Path),
+					zap.String("remote_addr", r.RemoteAddr))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="pub-service"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Reveal returns a rotated server seed by its hash.
+func (h *Handler) Reveal(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "reveal")
+	defer span.End()
+
+	seed, err := h.Manager.Revealed(mux.Vars(r)["hash"])
+	switch {
+	case errors.Is(err, ErrSeedActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrUnknownSeed):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		writeJSON(w, http.StatusOK, seed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Get().Error("failed to encode response", zap.Error(err))
+	}
+}
@@ -0,0 +1,39 @@
+package fair
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateRequiresToken(t *testing.T) {
+	m, err := NewManager(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Handler{Manager: m}
+	router := mux.NewRouter()
+	router.Handle("/fair/seed/rotate", RequireToken("secret")(http.HandlerFunc(h.Rotate))).Methods("POST")
+
+	active := m.Commitment().ServerSeedHash
+	for _, token := range []string{"", "wrong"} {
+		req := httptest.NewRequest(http.MethodPost, "/fair/seed/rotate", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "token %q", token)
+	}
+	assert.Equal(t, active, m.Commitment().ServerSeedHash, "rejected requests must not rotate the seed")
+
+	req := httptest.NewRequest(http.MethodPost, "/fair/seed/rotate", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, active, m.Commitment().ServerSeedHash)
+}
@@ -14,7 +14,8 @@ func TestHealthCheck(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(HealthCheck)
+	h := Handler{}
+	handler := http.HandlerFunc(h.HealthCheck)
 
 	handler.ServeHTTP(rr, req)
 
@@ -27,7 +28,7 @@ func TestHealthCheck(t *testing.T) {
 	}
 
 	// Check the response body
-	var resp HealthResponse
+	var resp Response
 	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
 		t.Fatalf("could not decode response: %v", err)
 	}
@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+)
+
+const meterName = "sarama"
+
+var (
+	bridgeOnce    sync.Once
+	defaultBridge *MetricsBridge
+
+	// brokerSuffix and topicSuffix match the names sarama gives per broker
+	// and per topic metrics, see sarama's metrics.go.
+	brokerSuffix = regexp.MustCompile(`-for-broker-(-?\d+)$`)
+	topicSuffix  = regexp.MustCompile(`-for-topic-(.+)$`)
+)
+
+// MetricsRegistry returns the go-metrics registry shared by every sarama
+// client of the process, bridged to the global OTel meter provider.
+func MetricsRegistry() metrics.Registry {
+	bridgeOnce.Do(func() {
+		defaultBridge = NewMetricsBridge(otel.Meter(meterName))
+	})
+	return defaultBridge
+}
+
+// MetricsBridge is a go-metrics registry that exposes the metrics sarama
+// records in it as OTel instruments named kafka.client.<metric>. Meters,
+// counters and gauges are read from the registry on every collection of the
+// meter provider; histograms are recorded into OTel histograms as sarama
+// updates them, as their samples cannot be read back incrementally. Broker
+// and topic specific metrics carry kafka.broker.id and
+// messaging.destination.name attributes. Sarama replaces dots in topic names
+// with underscores.
+type MetricsBridge struct {
+	metrics.Registry
+	meter metric.Meter
+
+	mu         sync.Mutex
+	observed   map[string][]string // sarama names by instrument name
+	histograms map[string]*bridgeHistogram
+	recorders  map[string]metric.Int64Histogram
+}
+
+func NewMetricsBridge(meter metric.Meter) *MetricsBridge {
+	return &MetricsBridge{
+		Registry:   metrics.NewRegistry(),
+		meter:      meter,
+		observed:   make(map[string][]string),
+		histograms: make(map[string]*bridgeHistogram),
+		recorders:  make(map[string]metric.Int64Histogram),
+	}
+}
+
+// bridgedName splits a sarama metric name into the OTel instrument name, its
+// unit and attributes.
+func bridgedName(name string) (string, string, []attribute.KeyValue) {
+	var attrs []attribute.KeyValue
+	if m := brokerSuffix.FindStringSubmatch(name); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		attrs = append(attrs, attribute.Int("kafka.broker.id", id))
+		name = strings.TrimSuffix(name, m[0])
+	} else if m := topicSuffix.FindStringSubmatch(name); m != nil {
+		attrs = append(attrs, semconv.MessagingDestinationName(m[1]))
+		name = strings.TrimSuffix(name, m[0])
+	}
+	unit := ""
+	if strings.HasSuffix(name, "-in-ms") {
+		name = strings.TrimSuffix(name, "-in-ms")
+		unit = "ms"
+	}
+	return "kafka.client." + strings.ReplaceAll(name, "-", "_"), unit, attrs
+}
+
+func (b *MetricsBridge) Get(name string) interface{} {
+	b.mu.Lock()
+	h, ok := b.histograms[name]
+	b.mu.Unlock()
+	if ok {
+		return h
+	}
+	return b.Registry.Get(name)
+}
+
+func (b *MetricsBridge) GetOrRegister(name string, i interface{}) interface{} {
+	return b.bridge(name, b.Registry.GetOrRegister(name, i))
+}
+
+func (b *MetricsBridge) Register(name string, i interface{}) error {
+	if err := b.Registry.Register(name, i); err != nil {
+		return err
+	}
+	b.bridge(name, i)
+	return nil
+}
+
+func (b *MetricsBridge) Unregister(name string) {
+	b.mu.Lock()
+	delete(b.histograms, name)
+	b.mu.Unlock()
+	b.Registry.Unregister(name)
+}
+
+// bridge starts exposing the metric m registered under name and returns the
+// value sarama should use.
+func (b *MetricsBridge) bridge(name string, m interface{}) interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	instrument, unit, attrs := bridgedName(name)
+	switch m := m.(type) {
+	case metrics.Histogram:
+		if h, ok := b.histograms[name]; ok && h.Histogram == m {
+			return h
+		}
+		recorder, ok := b.recorders[instrument]
+		if !ok {
+			var err error
+			recorder, err = b.meter.Int64Histogram(instrument, metric.WithUnit(unit),
+				metric.WithDescription("Sarama histogram "+name))
+			if err != nil {
+				otel.Handle(err)
+				return m
+			}
+			b.recorders[instrument] = recorder
+		}
+		h := &bridgeHistogram{Histogram: m, recorder: recorder, attrs: metric.WithAttributes(attrs...)}
+		b.histograms[name] = h
+		return h
+	case metrics.Meter, metrics.Counter, metrics.Gauge, metrics.GaugeFloat64:
+		names, ok := b.observed[instrument]
+		if !ok {
+			if err := b.observe(instrument, unit, m); err != nil {
+				otel.Handle(err)
+				return m
+			}
+		}
+		for _, n := range names {
+			if n == name {
+				return m
+			}
+		}
+		b.observed[instrument] = append(names, name)
+	}
+	return m
+}
+
+// observe registers a callback that reports every sarama metric of
+// instrument, whose first metric is m, on each collection.
+func (b *MetricsBridge) observe(instrument, unit string, m interface{}) error {
+	var (
+		observable metric.Observable
+		observe    func(obs metric.Observer, m interface{}, opt metric.ObserveOption)
+	)
+	switch m.(type) {
+	case metrics.Meter:
+		// Sarama's meters are rates, report the one minute moving average.
+		if unit == "" {
+			unit = "{event}/s"
+		}
+		gauge, err := b.meter.Float64ObservableGauge(instrument, metric.WithUnit(unit),
+			metric.WithDescription("One minute rate of the Sarama meter "+instrument))
+		if err != nil {
+			return err
+		}
+		observable = gauge
+		observe = func(obs metric.Observer, m interface{}, opt metric.ObserveOption) {
+			if meter, ok := m.(metrics.Meter); ok {
+				obs.ObserveFloat64(gauge, meter.Snapshot().Rate1(), opt)
+			}
+		}
+	case metrics.Counter:
+		counter, err := b.meter.Int64ObservableUpDownCounter(instrument, metric.WithUnit(unit),
+			metric.WithDescription("Sarama counter "+instrument))
+		if err != nil {
+			return err
+		}
+		observable = counter
+		observe = func(obs metric.Observer, m interface{}, opt metric.ObserveOption) {
+			if c, ok := m.(metrics.Counter); ok {
+				obs.ObserveInt64(counter, c.Count(), opt)
+			}
+		}
+	case metrics.Gauge:
+		gauge, err := b.meter.Int64ObservableGauge(instrument, metric.WithUnit(unit),
+			metric.WithDescription("Sarama gauge "+instrument))
+		if err != nil {
+			return err
+		}
+		observable = gauge
+		observe = func(obs metric.Observer, m interface{}, opt metric.ObserveOption) {
+			if g, ok := m.(metrics.Gauge); ok {
+				obs.ObserveInt64(gauge, g.Value(), opt)
+			}
+		}
+	case metrics.GaugeFloat64:
+		gauge, err := b.meter.Float64ObservableGauge(instrument, metric.WithUnit(unit),
+			metric.WithDescription("Sarama gauge "+instrument))
+		if err != nil {
+			return err
+		}
+		observable = gauge
+		observe = func(obs metric.Observer, m interface{}, opt metric.ObserveOption) {
+			if g, ok := m.(metrics.GaugeFloat64); ok {
+				obs.ObserveFloat64(gauge, g.Value(), opt)
+			}
+		}
+	}
+
+	_, err := b.meter.RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		b.mu.Lock()
+		names := append([]string(nil), b.observed[instrument]...)
+		b.mu.Unlock()
+		for _, name := range names {
+			// Metrics of closed clients are unregistered by sarama.
+			m := b.Registry.Get(name)
+			if m == nil {
+				continue
+			}
+			_, _, attrs := bridgedName(name)
+			observe(obs, m, metric.WithAttributes(attrs...))
+		}
+		return nil
+	}, observable)
+	return err
+}
+
+// bridgeHistogram records every value sarama adds to a histogram in the
+// OTel histogram as well.
+type bridgeHistogram struct {
+	metrics.Histogram
+	recorder metric.Int64Histogram
+	attrs    metric.MeasurementOption
+}
+
+func (h *bridgeHistogram) Update(v int64) {
+	h.Histogram.Update(v)
+	h.recorder.Record(context.Background(), v, h.attrs)
+}
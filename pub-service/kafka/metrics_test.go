@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestBridgedName(t *testing.T) {
+	name, unit, attrs := bridgedName("request-latency-in-ms-for-broker-1")
+	assert.Equal(t, "kafka.client.request_latency", name)
+	assert.Equal(t, "ms", unit)
+	assert.Equal(t, []attribute.KeyValue{attribute.Int("kafka.broker.id", 1)}, attrs)
+
+	name, unit, attrs = bridgedName("record-send-rate-for-topic-dice-rolls")
+	assert.Equal(t, "kafka.client.record_send_rate", name)
+	assert.Empty(t, unit)
+	assert.Equal(t, []attribute.KeyValue{attribute.String("messaging.destination.name", "dice-rolls")}, attrs)
+
+	name, _, attrs = bridgedName("compression-ratio")
+	assert.Equal(t, "kafka.client.compression_ratio", name)
+	assert.Empty(t, attrs)
+}
+
+func TestMetricsBridge(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	bridge := NewMetricsBridge(provider.Meter(meterName))
+
+	// Register metrics the way sarama does.
+	metrics.GetOrRegisterMeter("outgoing-byte-rate", bridge).Mark(512)
+	metrics.GetOrRegisterMeter("outgoing-byte-rate-for-broker-1", bridge).Mark(512)
+	metrics.GetOrRegisterCounter("requests-in-flight-for-broker-1", bridge).Inc(2)
+	latency := func() metrics.Histogram { return metrics.NewHistogram(metrics.NewUniformSample(10)) }
+	bridge.GetOrRegister("request-latency-in-ms-for-broker-1", latency).(metrics.Histogram).Update(12)
+	bridge.GetOrRegister("request-latency-in-ms-for-broker-2", latency).(metrics.Histogram).Update(30)
+	bridge.GetOrRegister("request-latency-in-ms-for-broker-1", latency).(metrics.Histogram).Update(18)
+	assert.Equal(t, int64(2), bridge.Get("request-latency-in-ms-for-broker-1").(metrics.Histogram).Count())
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+
+	rate, ok := got["kafka.client.outgoing_byte_rate"].(metricdata.Gauge[float64])
+	if assert.True(t, ok) {
+		assert.Len(t, rate.DataPoints, 2, "one point for the client and one for broker 1")
+	}
+	inFlight, ok := got["kafka.client.requests_in_flight"].(metricdata.Sum[int64])
+	if assert.True(t, ok) && assert.Len(t, inFlight.DataPoints, 1) {
+		assert.Equal(t, int64(2), inFlight.DataPoints[0].Value)
+	}
+	hist, ok := got["kafka.client.request_latency"].(metricdata.Histogram[int64])
+	if assert.True(t, ok) && assert.Len(t, hist.DataPoints, 2) {
+		counts := map[int]uint64{}
+		for _, dp := range hist.DataPoints {
+			id, _ := dp.Attributes.Value("kafka.broker.id")
+			counts[int(id.AsInt64())] = dp.Count
+		}
+		assert.Equal(t, map[int]uint64{1: 2, 2: 1}, counts)
+	}
+
+	// Metrics of closed clients are no longer reported.
+	bridge.Unregister("requests-in-flight-for-broker-1")
+	rm = metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == "kafka.client.requests_in_flight" {
+				assert.Empty(t, m.Data.(metricdata.Sum[int64]).DataPoints)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"pub-service/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	TokenProviderClientCredentials = "client_credentials"
+	TokenProviderFile              = "file"
+)
+
+// tokenExpiryMargin is how long before it expires a cached token is renewed.
+const tokenExpiryMargin = 30 * time.Second
+
+// TokenProviderFactory builds the OAUTHBEARER token provider for config.
+type TokenProviderFactory func(config *config.SASLConfig) (sarama.AccessTokenProvider, error)
+
+var (
+	tokenProvidersMu sync.RWMutex
+	tokenProviders   = map[string]TokenProviderFactory{
+		TokenProviderClientCredentials: newClientCredentialsProvider,
+		TokenProviderFile:              newFileTokenProvider,
+	}
+)
+
+// RegisterTokenProvider makes a token provider available under name, to be
+// selected with KAFKA_SASL_TOKEN_PROVIDER. It must be called before the
+// Kafka clients are created.
+func RegisterTokenProvider(name string, factory TokenProviderFactory) {
+	tokenProvidersMu.Lock()
+	defer tokenProvidersMu.Unlock()
+	tokenProviders[name] = factory
+}
+
+// NewTokenProvider returns the token provider selected by config.
+func NewTokenProvider(config *config.SASLConfig) (sarama.AccessTokenProvider, error) {
+	tokenProvidersMu.RLock()
+	factory, ok := tokenProviders[config.TokenProvider]
+	names := make([]string, 0, len(tokenProviders))
+	for name := range tokenProviders {
+		names = append(names, name)
+	}
+	tokenProvidersMu.RUnlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("invalid token provider %q, must be one of %s", config.TokenProvider, strings.Join(names, ", "))
+	}
+	return factory(config)
+}
+
+// clientCredentialsProvider fetches tokens with the OAuth 2.0 client
+// credentials grant and caches them until shortly before they expire.
+type clientCredentialsProvider struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	now     func() time.Time
+}
+
+func newClientCredentialsProvider(config *config.SASLConfig) (sarama.AccessTokenProvider, error) {
+	if config.TokenURL == "" || config.ClientID == "" {
+		return nil, errors.New("client_credentials token provider requires a token url and a client id")
+	}
+	return &clientCredentialsProvider{
+		tokenURL:     config.TokenURL,
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		scopes:       config.Scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		now:          time.Now,
+	}, nil
+}
+
+func (p *clientCredentialsProvider) Token() (*sarama.AccessToken, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && p.now().Before(p.expires) {
+		return &sarama.AccessToken{Token: p.token}, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(p.scopes) > 0 {
+		form.Set("scope", strings.Join(p.scopes, " "))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch oauth token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch oauth token: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode oauth token: %w", err)
+	}
+	if body.AccessToken == "" {
+		return nil, errors.New("oauth token response has no access_token")
+	}
+	p.token = body.AccessToken
+	p.expires = p.now().Add(time.Duration(body.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return &sarama.AccessToken{Token: p.token}, nil
+}
+
+// fileTokenProvider reads the token from a file on every authentication, for
+// tokens that are rotated on disk such as projected service account tokens.
+type fileTokenProvider struct {
+	path string
+}
+
+func newFileTokenProvider(config *config.SASLConfig) (sarama.AccessTokenProvider, error) {
+	if config.TokenFile == "" {
+		return nil, errors.New("file token provider requires a token file")
+	}
+	return &fileTokenProvider{path: config.TokenFile}, nil
+}
+
+func (p *fileTokenProvider) Token() (*sarama.AccessToken, error) {
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oauth token: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return nil, fmt.Errorf("oauth token file %s is empty", p.path)
+	}
+	return &sarama.AccessToken{Token: token}, nil
+}
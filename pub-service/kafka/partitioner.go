@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	PartitionerHash       = "hash"
+	PartitionerMurmur2    = "murmur2"
+	PartitionerRoundRobin = "roundrobin"
+	PartitionerSticky     = "sticky"
+	PartitionerManual     = "manual"
+)
+
+// stickyBatchSize is the number of keyless messages the sticky partitioner
+// sends to one partition before it switches to another.
+const stickyBatchSize = 100
+
+// NewPartitioner returns the sarama partitioner for name:
+//   - hash: FNV-1a of the key, sarama's default.
+//   - murmur2: murmur2 of the key, the same placement as the Java client.
+//   - roundrobin: ignores the key and cycles through partitions.
+//   - sticky: murmur2 for keyed messages, keyless messages stick to one
+//     partition for a batch, like the Java client's default partitioner.
+//   - manual: uses the partition set on the message.
+func NewPartitioner(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case "", PartitionerHash:
+		return sarama.NewHashPartitioner, nil
+	case PartitionerMurmur2:
+		return newMurmur2Partitioner, nil
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner, nil
+	case PartitionerSticky:
+		return newStickyPartitioner, nil
+	case PartitionerManual:
+		return sarama.NewManualPartitioner, nil
+	}
+	return nil, fmt.Errorf("invalid partitioner %q, must be one of hash, murmur2, roundrobin, sticky or manual", name)
+}
+
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if msg.Key == nil {
+		return p.random.Partition(msg, numPartitions)
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return murmur2Partition(key, numPartitions), nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+type stickyPartitioner struct {
+	mu        sync.Mutex
+	partition int32
+	sent      int
+}
+
+func newStickyPartitioner(string) sarama.Partitioner {
+	return &stickyPartitioner{partition: -1}
+}
+
+func (p *stickyPartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if msg.Key != nil {
+		key, err := msg.Key.Encode()
+		if err != nil {
+			return -1, err
+		}
+		return murmur2Partition(key, numPartitions), nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.partition < 0 || p.partition >= numPartitions || p.sent >= stickyBatchSize {
+		next := rand.Int31n(numPartitions)
+		if next == p.partition && numPartitions > 1 {
+			next = (next + 1) % numPartitions
+		}
+		p.partition = next
+		p.sent = 0
+	}
+	p.sent++
+	return p.partition, nil
+}
+
+func (p *stickyPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+// murmur2Partition mirrors the Java client:
+// toPositive(murmur2(key)) % numPartitions.
+func murmur2Partition(key []byte, numPartitions int32) int32 {
+	return (murmur2(key) & 0x7fffffff) % numPartitions
+}
+
+// murmur2 is the 32-bit murmur2 hash as implemented by the Java client's
+// org.apache.kafka.common.utils.Utils.murmur2.
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
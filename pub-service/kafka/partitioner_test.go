@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Vectors from the Java client's UtilsTest.
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range tests {
+		assert.Equal(t, want, murmur2([]byte(key)), key)
+	}
+}
+
+func TestNewPartitioner(t *testing.T) {
+	for _, name := range []string{PartitionerHash, PartitionerMurmur2, PartitionerRoundRobin, PartitionerSticky, PartitionerManual} {
+		_, err := NewPartitioner(name)
+		assert.NoError(t, err, name)
+	}
+	_, err := NewPartitioner("random")
+	assert.Error(t, err)
+}
+
+func TestMurmur2Partitioner(t *testing.T) {
+	constructor, _ := NewPartitioner(PartitionerMurmur2)
+	p := constructor("dice-rolls")
+	msg := &sarama.ProducerMessage{Key: sarama.StringEncoder("table-7")}
+
+	first, err := p.Partition(msg, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		partition, _ := p.Partition(msg, 12)
+		assert.Equal(t, first, partition, "the same key always lands on the same partition")
+	}
+	assert.Equal(t, (murmur2([]byte("table-7"))&0x7fffffff)%12, first)
+}
+
+func TestStickyPartitioner(t *testing.T) {
+	constructor, _ := NewPartitioner(PartitionerSticky)
+	p := constructor("dice-rolls")
+	msg := &sarama.ProducerMessage{}
+
+	first, _ := p.Partition(msg, 6)
+	for i := 1; i < stickyBatchSize; i++ {
+		partition, _ := p.Partition(msg, 6)
+		assert.Equal(t, first, partition)
+	}
+	next, _ := p.Partition(msg, 6)
+	assert.NotEqual(t, first, next, "a new batch switches partition")
+}
@@ -1,10 +1,17 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"pub-service/config"
 	"pub-service/logger"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -12,27 +19,321 @@ var (
 	ProtocolVersion = sarama.V3_6_0_0
 )
 
-func NewProducer(config *config.KafkaConfig) (sarama.AsyncProducer, error) {
+const (
+	ModeDefault       = "default"
+	ModeIdempotent    = "idempotent"
+	ModeTransactional = "transactional"
+)
+
+var (
+	ErrProducerClosed     = errors.New("producer is closed")
+	ErrTransactionAborted = errors.New("transaction aborted")
+)
+
+// Delivery is the broker's answer for a single message. Err is set if the
+// message could not be written.
+type Delivery struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Err       error     `json:"-"`
+}
+
+// Producer wraps a sarama.AsyncProducer and reports the outcome of every
+// message it sends. If the underlying producer is transactional, every
+// publish runs in its own transaction.
+type Producer struct {
+	producer        sarama.AsyncProducer
+	transactionalID string
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	// txnMu serialises transactions, sarama only supports one at a time.
+	txnMu sync.Mutex
+}
+
+func NewProducer(config *config.KafkaConfig) (*Producer, error) {
 	log := logger.Get()
 
+	saramaConfig, err := NewSaramaConfig(config)
+	if err != nil {
+		log.Error("invalid producer config", zap.Error(err))
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducer(config.Brokers, saramaConfig)
+	if err != nil {
+		log.Error("failed to create producer", zap.Error(err))
+		return nil, err
+	}
+
+	log.Info("producer created", zap.String("mode", config.ProducerMode), zap.Any("config", saramaConfig))
+	p := NewProducerFrom(producer)
+	p.transactionalID = saramaConfig.Producer.Transaction.ID
+	return p, nil
+}
+
+// NewSaramaConfig builds the sarama producer configuration for config.
+func NewSaramaConfig(config *config.KafkaConfig) (*sarama.Config, error) {
 	saramaConfig := sarama.NewConfig()
 	saramaConfig.Version = ProtocolVersion
+	saramaConfig.MetricRegistry = MetricsRegistry()
 	saramaConfig.Producer.Return.Successes = true
 	saramaConfig.Producer.Return.Errors = true
-	saramaConfig.Producer.RequiredAcks = sarama.NoResponse
 
-	producer, err := sarama.NewAsyncProducer(config.Brokers, nil)
+	acks, err := parseRequiredAcks(config.RequiredAcks)
 	if err != nil {
-		log.Error("failed to create producer", zap.Error(err))
 		return nil, err
 	}
+	saramaConfig.Producer.RequiredAcks = acks
+
+	saramaConfig.Producer.Partitioner, err = NewPartitioner(config.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.ProducerMode {
+	case "", ModeDefault:
+	case ModeTransactional:
+		if config.TransactionalID == "" {
+			return nil, errors.New("transactional producer mode requires a transactional id")
+		}
+		saramaConfig.Producer.Transaction.ID = config.TransactionalID
+		fallthrough
+	case ModeIdempotent:
+		if acks != sarama.WaitForAll {
+			return nil, fmt.Errorf("%s producer mode requires required acks all, got %q", config.ProducerMode, config.RequiredAcks)
+		}
+		saramaConfig.Producer.Idempotent = true
+		saramaConfig.Net.MaxOpenRequests = 1
+	default:
+		return nil, fmt.Errorf("invalid producer mode %q, must be one of default, idempotent or transactional", config.ProducerMode)
+	}
+
+	if err := ConfigureSecurity(saramaConfig, config); err != nil {
+		return nil, err
+	}
+
+	if err := saramaConfig.Validate(); err != nil {
+		return nil, err
+	}
+	return saramaConfig, nil
+}
+
+func parseRequiredAcks(acks string) (sarama.RequiredAcks, error) {
+	switch acks {
+	case "none":
+		return sarama.NoResponse, nil
+	case "local":
+		return sarama.WaitForLocal, nil
+	case "", "all":
+		return sarama.WaitForAll, nil
+	}
+	return 0, fmt.Errorf("invalid required acks %q, must be one of none, local or all", acks)
+}
+
+// NewProducerFrom wraps an existing sarama.AsyncProducer. The producer must be
+// configured with Return.Successes and Return.Errors enabled.
+func NewProducerFrom(producer sarama.AsyncProducer) *Producer {
+	p := &Producer{producer: producer}
+	p.wg.Add(2)
+	go p.drainSuccesses()
+	go p.drainErrors()
+	return p
+}
+
+func (p *Producer) drainSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		resolve(msg, Delivery{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+		})
+	}
+}
+
+func (p *Producer) drainErrors() {
+	defer p.wg.Done()
+	log := logger.Get()
+	for perr := range p.producer.Errors() {
+		log.Error("failed to send message", zap.Error(perr.Err))
+		resolve(perr.Msg, Delivery{
+			Topic:     perr.Msg.Topic,
+			Partition: perr.Msg.Partition,
+			Offset:    perr.Msg.Offset,
+			Err:       perr.Err,
+		})
+	}
+}
+
+func resolve(msg *sarama.ProducerMessage, d Delivery) {
+	if done, ok := msg.Metadata.(chan Delivery); ok {
+		done <- d
+	}
+}
+
+// Transactional reports whether every publish runs in a transaction.
+func (p *Producer) Transactional() bool {
+	return p.producer.IsTransactional()
+}
+
+// Publish enqueues msg and returns a channel that receives exactly one
+// Delivery once the broker acknowledges or rejects it. Publish overwrites
+// msg.Metadata. On a transactional producer Publish blocks until the
+// transaction has been committed or aborted.
+func (p *Producer) Publish(ctx context.Context, msg *sarama.ProducerMessage) (<-chan Delivery, error) {
+	if p.Transactional() {
+		deliveries, err := p.transact(ctx, []*sarama.ProducerMessage{msg})
+		if deliveries == nil {
+			return nil, err
+		}
+		done := make(chan Delivery, 1)
+		done <- deliveries[0]
+		return done, nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.enqueue(ctx, msg)
+}
+
+// PublishBatch sends msgs and waits until each of them has been acknowledged
+// or rejected. On a transactional producer the batch is written atomically:
+// either every message is committed or the transaction is aborted and the
+// returned error wraps ErrTransactionAborted. A nil slice is returned if the
+// batch could not be enqueued at all.
+func (p *Producer) PublishBatch(ctx context.Context, msgs []*sarama.ProducerMessage) ([]Delivery, error) {
+	if p.Transactional() {
+		return p.transact(ctx, msgs)
+	}
 
-	go func() {
-		for err := range producer.Errors() {
-			log.Error("failed to send message", zap.Error(err))
+	p.mu.RLock()
+	pending := make([]<-chan Delivery, 0, len(msgs))
+	for _, msg := range msgs {
+		done, err := p.enqueue(ctx, msg)
+		if err != nil {
+			p.mu.RUnlock()
+			return nil, err
 		}
-	}()
+		pending = append(pending, done)
+	}
+	p.mu.RUnlock()
+
+	return await(ctx, pending)
+}
+
+// enqueue must be called with p.mu held for reading.
+func (p *Producer) enqueue(ctx context.Context, msg *sarama.ProducerMessage) (<-chan Delivery, error) {
+	if p.closed {
+		return nil, ErrProducerClosed
+	}
+
+	done := make(chan Delivery, 1)
+	msg.Metadata = done
+	select {
+	case p.producer.Input() <- msg:
+		return done, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func await(ctx context.Context, pending []<-chan Delivery) ([]Delivery, error) {
+	deliveries := make([]Delivery, len(pending))
+	var errs []error
+	for i, done := range pending {
+		select {
+		case deliveries[i] = <-done:
+			if deliveries[i].Err != nil {
+				errs = append(errs, deliveries[i].Err)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return deliveries, errors.Join(errs...)
+}
+
+func (p *Producer) transact(ctx context.Context, msgs []*sarama.ProducerMessage) ([]Delivery, error) {
+	log := logger.FromCtx(ctx)
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("messaging.kafka.transactional_id", p.transactionalID))
+
+	p.txnMu.Lock()
+	defer p.txnMu.Unlock()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrProducerClosed
+	}
+
+	if err := p.producer.BeginTxn(); err != nil {
+		span.SetAttributes(attribute.String("messaging.kafka.transaction.outcome", "failed"))
+		log.Error("failed to begin transaction", zap.Error(err))
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	pending := make([]<-chan Delivery, 0, len(msgs))
+	var err error
+	for _, msg := range msgs {
+		var done <-chan Delivery
+		done, err = p.enqueue(ctx, msg)
+		if err != nil {
+			break
+		}
+		pending = append(pending, done)
+	}
+	if err == nil {
+		// CommitTxn flushes every message of the transaction first.
+		err = p.producer.CommitTxn()
+	}
+	if err != nil {
+		log.Error("transaction failed, aborting", zap.Int("messages", len(msgs)), zap.Error(err))
+		if abortErr := p.producer.AbortTxn(); abortErr != nil {
+			log.Error("failed to abort transaction", zap.Error(abortErr))
+			err = errors.Join(err, abortErr)
+		}
+	}
+
+	// Committing or aborting waits for in-flight messages, so every pending
+	// delivery has been resolved by now.
+	deliveries := make([]Delivery, len(msgs))
+	for i, done := range pending {
+		deliveries[i] = <-done
+	}
+	if err == nil {
+		span.SetAttributes(
+			attribute.String("messaging.kafka.transaction.outcome", "committed"),
+			attribute.Int("messaging.kafka.transaction.messages", len(msgs)),
+		)
+		return deliveries, nil
+	}
+
+	span.SetAttributes(attribute.String("messaging.kafka.transaction.outcome", "aborted"))
+	err = errors.Join(ErrTransactionAborted, err)
+	for i := range deliveries {
+		deliveries[i].Err = err
+	}
+	return deliveries, err
+}
+
+// Close flushes buffered messages and waits until every pending Delivery has
+// been reported.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
 
-	log.Info("producer created", zap.Any("config", saramaConfig))
-	return producer, nil
+	p.producer.AsyncClose()
+	p.wg.Wait()
+	return nil
 }
@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"pub-service/config"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSaramaConfig(t *testing.T) {
+	saramaConfig, err := NewSaramaConfig(&config.KafkaConfig{RequiredAcks: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sarama.WaitForLocal, saramaConfig.Producer.RequiredAcks)
+	assert.True(t, saramaConfig.Producer.Return.Successes)
+
+	_, err = NewSaramaConfig(&config.KafkaConfig{RequiredAcks: "some"})
+	assert.Error(t, err)
+}
+
+func TestNewSaramaConfigProducerModes(t *testing.T) {
+	saramaConfig, err := NewSaramaConfig(&config.KafkaConfig{RequiredAcks: "all", ProducerMode: ModeIdempotent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, saramaConfig.Producer.Idempotent)
+	assert.Equal(t, 1, saramaConfig.Net.MaxOpenRequests)
+	assert.Empty(t, saramaConfig.Producer.Transaction.ID)
+
+	saramaConfig, err = NewSaramaConfig(&config.KafkaConfig{RequiredAcks: "all", ProducerMode: ModeTransactional, TransactionalID: "pub-service-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, saramaConfig.Producer.Idempotent)
+	assert.Equal(t, "pub-service-1", saramaConfig.Producer.Transaction.ID)
+
+	_, err = NewSaramaConfig(&config.KafkaConfig{RequiredAcks: "all", ProducerMode: ModeTransactional})
+	assert.Error(t, err, "transactional mode requires a transactional id")
+
+	_, err = NewSaramaConfig(&config.KafkaConfig{RequiredAcks: "local", ProducerMode: ModeIdempotent})
+	assert.Error(t, err, "idempotent mode requires acks all")
+
+	_, err = NewSaramaConfig(&config.KafkaConfig{ProducerMode: "exactly-once"})
+	assert.Error(t, err)
+}
+
+// txnMock models sarama's transactional producer on top of the sarama mock:
+// committing or aborting waits until the expected number of messages has been
+// resolved.
+type txnMock struct {
+	*mocks.AsyncProducer
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	resolved  sync.WaitGroup
+	commitErr error
+	aborted   bool
+}
+
+func newTxnMock(t *testing.T, messages int, commitErr error) *txnMock {
+	saramaConfig := mocks.NewTestConfig()
+	saramaConfig.Version = ProtocolVersion
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Idempotent = true
+	saramaConfig.Producer.Transaction.ID = "pub-service-1"
+	saramaConfig.Net.MaxOpenRequests = 1
+	m := &txnMock{
+		AsyncProducer: mocks.NewAsyncProducer(t, saramaConfig),
+		successes:     make(chan *sarama.ProducerMessage, messages),
+		errors:        make(chan *sarama.ProducerError, messages),
+		commitErr:     commitErr,
+	}
+	m.resolved.Add(messages)
+	go func() {
+		for msg := range m.AsyncProducer.Successes() {
+			m.successes <- msg
+			m.resolved.Done()
+		}
+		close(m.successes)
+	}()
+	go func() {
+		for perr := range m.AsyncProducer.Errors() {
+			m.errors <- perr
+			m.resolved.Done()
+		}
+		close(m.errors)
+	}()
+	for i := 0; i < messages; i++ {
+		m.ExpectInputAndSucceed()
+	}
+	return m
+}
+
+func (m *txnMock) Successes() <-chan *sarama.ProducerMessage { return m.successes }
+func (m *txnMock) Errors() <-chan *sarama.ProducerError      { return m.errors }
+
+func (m *txnMock) CommitTxn() error {
+	m.resolved.Wait()
+	if m.commitErr != nil {
+		return m.commitErr
+	}
+	return m.AsyncProducer.CommitTxn()
+}
+
+func (m *txnMock) AbortTxn() error {
+	m.resolved.Wait()
+	m.aborted = true
+	return m.AsyncProducer.AbortTxn()
+}
+
+func TestPublishBatchTransactional(t *testing.T) {
+	mock := newTxnMock(t, 2, nil)
+	producer := NewProducerFrom(mock)
+	defer producer.Close()
+
+	assert.True(t, producer.Transactional())
+	deliveries, err := producer.PublishBatch(context.Background(), []*sarama.ProducerMessage{{Topic: "dice-rolls"}, {Topic: "dice-rolls"}})
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+	assert.False(t, mock.aborted)
+}
+
+func TestPublishBatchTransactionAborted(t *testing.T) {
+	mock := newTxnMock(t, 2, sarama.ErrProducerFenced)
+	producer := NewProducerFrom(mock)
+	defer producer.Close()
+
+	deliveries, err := producer.PublishBatch(context.Background(), []*sarama.ProducerMessage{{Topic: "dice-rolls"}, {Topic: "dice-rolls"}})
+	assert.ErrorIs(t, err, ErrTransactionAborted)
+	assert.True(t, mock.aborted)
+	for _, d := range deliveries {
+		assert.ErrorIs(t, d.Err, ErrTransactionAborted)
+	}
+}
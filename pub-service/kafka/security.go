@@ -0,0 +1,216 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"pub-service/config"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	SASLNone        = ""
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+	SASLOAuthBearer = "OAUTHBEARER"
+)
+
+// ConfigureSecurity enables TLS and SASL on saramaConfig as set in config.
+func ConfigureSecurity(saramaConfig *sarama.Config, config *config.KafkaConfig) error {
+	if config.TLS != nil && config.TLS.Enabled {
+		tlsConfig, err := NewTLSConfig(config.TLS)
+		if err != nil {
+			return err
+		}
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = tlsConfig
+	}
+	if config.SASL != nil {
+		return configureSASL(saramaConfig, config.SASL)
+	}
+	return nil
+}
+
+func configureSASL(saramaConfig *sarama.Config, config *config.SASLConfig) error {
+	sasl := &saramaConfig.Net.SASL
+	switch config.Mechanism {
+	case SASLNone:
+		return nil
+	case SASLPlain:
+		sasl.Mechanism = sarama.SASLTypePlaintext
+	case SASLScramSHA256:
+		sasl.Mechanism = sarama.SASLTypeSCRAMSHA256
+		sasl.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+	case SASLScramSHA512:
+		sasl.Mechanism = sarama.SASLTypeSCRAMSHA512
+		sasl.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+	case SASLOAuthBearer:
+		provider, err := NewTokenProvider(config)
+		if err != nil {
+			return err
+		}
+		sasl.Mechanism = sarama.SASLTypeOAuth
+		sasl.TokenProvider = provider
+	default:
+		return fmt.Errorf("invalid sasl mechanism %q, must be one of PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER", config.Mechanism)
+	}
+	if config.Mechanism != SASLOAuthBearer && (config.Username == "" || config.Password == "") {
+		return fmt.Errorf("sasl mechanism %s requires a username and password", config.Mechanism)
+	}
+	sasl.Enable = true
+	sasl.Handshake = true
+	sasl.Version = sarama.SASLHandshakeV1
+	sasl.User = config.Username
+	sasl.Password = config.Password
+	return nil
+}
+
+// scramClient implements sarama.SCRAMClient with xdg-go/scram.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
+
+// NewTLSConfig returns the client TLS config for config. The CA bundle and
+// the client certificate are read again whenever their files change, so
+// rotated certificates are used for new connections without a restart.
+func NewTLSConfig(config *config.TLSConfig) (*tls.Config, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("tls client certificate requires both a cert and a key file")
+	}
+	r := &certReloader{caFile: config.CAFile, certFile: config.CertFile, keyFile: config.KeyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+	}
+	if config.CertFile != "" {
+		tlsConfig.GetClientCertificate = r.clientCertificate
+	}
+	switch {
+	case config.InsecureSkipVerify:
+		tlsConfig.InsecureSkipVerify = true
+	case config.CAFile != "":
+		// The standard verification only knows a fixed RootCAs pool, so it is
+		// replaced by one against the current bundle.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = r.verifyConnection
+	}
+	return tlsConfig, nil
+}
+
+// certReloader holds the certificates read from disk and reloads them when
+// the modification time of a file changes.
+type certReloader struct {
+	caFile, certFile, keyFile string
+
+	mu      sync.Mutex
+	modTime map[string]time.Time
+	roots   *x509.CertPool
+	cert    *tls.Certificate
+}
+
+// reload reads the files again if any of them changed since the last read.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	modTime := make(map[string]time.Time, 3)
+	for _, file := range []string{r.caFile, r.certFile, r.keyFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTime[file] = info.ModTime()
+		if !info.ModTime().Equal(r.modTime[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	roots := r.roots
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+	cert := r.cert
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	r.roots, r.cert, r.modTime = roots, cert, modTime
+	return nil
+}
+
+func (r *certReloader) current() (*x509.CertPool, *tls.Certificate) {
+	// A failed reload, for instance while a rotation has only written the
+	// cert and not yet the key, keeps the previous certificates.
+	_ = r.reload()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.roots, r.cert
+}
+
+func (r *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	_, cert := r.current()
+	return cert, nil
+}
+
+func (r *certReloader) verifyConnection(cs tls.ConnectionState) error {
+	roots, _ := r.current()
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: broker presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,283 @@
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pub-service/config"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+)
+
+func TestConfigureSASL(t *testing.T) {
+	saramaConfig := sarama.NewConfig()
+	err := ConfigureSecurity(saramaConfig, &config.KafkaConfig{SASL: &config.SASLConfig{Mechanism: SASLScramSHA512, Username: "pub", Password: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, saramaConfig.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), saramaConfig.Net.SASL.Mechanism)
+	assert.NotNil(t, saramaConfig.Net.SASL.SCRAMClientGeneratorFunc)
+	assert.NoError(t, saramaConfig.Validate())
+
+	err = ConfigureSecurity(sarama.NewConfig(), &config.KafkaConfig{SASL: &config.SASLConfig{Mechanism: SASLPlain}})
+	assert.Error(t, err, "PLAIN requires a username and password")
+
+	err = ConfigureSecurity(sarama.NewConfig(), &config.KafkaConfig{SASL: &config.SASLConfig{Mechanism: "GSSAPI"}})
+	assert.Error(t, err)
+
+	saramaConfig = sarama.NewConfig()
+	err = ConfigureSecurity(saramaConfig, &config.KafkaConfig{SASL: &config.SASLConfig{}})
+	assert.NoError(t, err)
+	assert.False(t, saramaConfig.Net.SASL.Enable)
+}
+
+func TestScramClient(t *testing.T) {
+	kf := scram.KeyFactors{Salt: "salt", Iters: 4096}
+	client, err := scram.SHA256.NewClient("pub", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := client.GetStoredCredentials(kf)
+	server, err := scram.SHA256.NewServer(func(string) (scram.StoredCredentials, error) { return credentials, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &scramClient{hash: scram.SHA256}
+	if err := c.Begin("pub", "secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	conversation := server.NewConversation()
+	var challenge string
+	for !c.Done() {
+		response, err := c.Step(challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Done() {
+			break
+		}
+		if challenge, err = conversation.Step(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.True(t, conversation.Valid())
+}
+
+func TestTokenProviders(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		id, secret, _ := r.BasicAuth()
+		assert.Equal(t, "pub-service", id)
+		assert.Equal(t, "secret", secret)
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		assert.Equal(t, "kafka dice", r.FormValue("scope"))
+		w.Write([]byte(`{"access_token":"token-1","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	provider, err := NewTokenProvider(&config.SASLConfig{
+		TokenProvider: TokenProviderClientCredentials,
+		TokenURL:      srv.URL,
+		ClientID:      "pub-service",
+		ClientSecret:  "secret",
+		Scopes:        []string{"kafka", "dice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		token, err := provider.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "token-1", token.Token)
+	}
+	assert.Equal(t, 1, calls, "the token is cached until it expires")
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("token-2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err = NewTokenProvider(&config.SASLConfig{TokenProvider: TokenProviderFile, TokenFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "token-2", token.Token)
+
+	RegisterTokenProvider("static", func(*config.SASLConfig) (sarama.AccessTokenProvider, error) { return provider, nil })
+	_, err = NewTokenProvider(&config.SASLConfig{TokenProvider: "static"})
+	assert.NoError(t, err)
+	_, err = NewTokenProvider(&config.SASLConfig{TokenProvider: "kerberos"})
+	assert.Error(t, err)
+}
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for name.
+func (ca *testCA) issue(t *testing.T, name string, serial int64) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects with clientConfig to a TLS server presenting
+// serverCert and returns the client certificate the server received.
+func handshake(t *testing.T, clientConfig *tls.Config, serverCert tls.Certificate, clientCAs *x509.CertPool) (*x509.Certificate, error) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	peer := make(chan *x509.Certificate, 1)
+	go func() {
+		defer serverConn.Close()
+		server := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+		})
+		if err := server.Handshake(); err != nil {
+			peer <- nil
+			return
+		}
+		peer <- server.ConnectionState().PeerCertificates[0]
+	}()
+	client := tls.Client(clientConn, clientConfig)
+	err := client.Handshake()
+	if err != nil {
+		clientConn.Close()
+		<-peer
+		return nil, err
+	}
+	return <-peer, nil
+}
+
+func TestTLSConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	start := time.Now().Add(-time.Minute)
+
+	ca := newTestCA(t)
+	writeFile(t, caFile, ca.pem, start)
+	certPEM, keyPEM := ca.issue(t, "pub-service", 2)
+	writeFile(t, certFile, certPEM, start)
+	writeFile(t, keyFile, keyPEM, start)
+
+	tlsConfig, err := NewTLSConfig(&config.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "broker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPEM, serverKey := ca.issue(t, "broker", 3)
+	serverCert, err := tls.X509KeyPair(serverPEM, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	peer, err := handshake(t, tlsConfig, serverCert, clientCAs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), peer.SerialNumber.Int64())
+
+	// Rotate the client certificate.
+	certPEM, keyPEM = ca.issue(t, "pub-service", 4)
+	writeFile(t, certFile, certPEM, start.Add(time.Second))
+	writeFile(t, keyFile, keyPEM, start.Add(time.Second))
+	peer, err = handshake(t, tlsConfig, serverCert, clientCAs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(4), peer.SerialNumber.Int64(), "the rotated certificate is used")
+
+	// Rotate the CA, the broker's certificate from the old CA is rejected.
+	writeFile(t, caFile, newTestCA(t).pem, start.Add(2*time.Second))
+	_, err = handshake(t, tlsConfig, serverCert, clientCAs)
+	assert.Error(t, err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	_, err := NewTLSConfig(&config.TLSConfig{CertFile: "client.pem"})
+	assert.Error(t, err, "a cert requires a key")
+
+	tlsConfig, err := NewTLSConfig(&config.TLSConfig{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	assert.Nil(t, tlsConfig.VerifyConnection)
+}
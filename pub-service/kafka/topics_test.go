@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"pub-service/config"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdmin implements the cluster admin calls used to provision topics on
+// top of an in-memory set of topics.
+type fakeAdmin struct {
+	sarama.ClusterAdmin
+	topics  map[string]sarama.TopicDetail
+	configs map[string]map[string]string
+}
+
+func newFakeAdmin() *fakeAdmin {
+	return &fakeAdmin{topics: map[string]sarama.TopicDetail{}, configs: map[string]map[string]string{}}
+}
+
+func (a *fakeAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return a.topics, nil
+}
+
+func (a *fakeAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if _, ok := a.topics[topic]; ok {
+		return sarama.ErrTopicAlreadyExists
+	}
+	a.topics[topic] = sarama.TopicDetail{NumPartitions: detail.NumPartitions, ReplicationFactor: detail.ReplicationFactor}
+	a.configs[topic] = map[string]string{"cleanup.policy": "delete", "retention.ms": "604800000"}
+	for k, v := range detail.ConfigEntries {
+		a.configs[topic][k] = *v
+	}
+	return nil
+}
+
+func (a *fakeAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	var entries []sarama.ConfigEntry
+	for k, v := range a.configs[resource.Name] {
+		entries = append(entries, sarama.ConfigEntry{Name: k, Value: v})
+	}
+	return entries, nil
+}
+
+func (a *fakeAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	detail := a.topics[topic]
+	detail.NumPartitions = count
+	a.topics[topic] = detail
+	return nil
+}
+
+func (a *fakeAdmin) IncrementalAlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, validateOnly bool) error {
+	for k, e := range entries {
+		a.configs[name][k] = *e.Value
+	}
+	return nil
+}
+
+func testSpec() TopicSpec {
+	return NewTopicSpec("dice-rolls", &config.TopicConfig{
+		Partitions:        3,
+		ReplicationFactor: 1,
+		Retention:         24 * time.Hour,
+		MinInsyncReplicas: 1,
+	})
+}
+
+func TestNewTopicSpec(t *testing.T) {
+	spec := NewTopicSpec("dice-rolls", &config.TopicConfig{
+		Partitions:        6,
+		ReplicationFactor: 3,
+		Retention:         time.Hour,
+		CleanupPolicy:     "compact",
+		MinInsyncReplicas: 2,
+		Configs:           map[string]string{"max.message.bytes": "1048576"},
+	})
+	assert.Equal(t, TopicSpec{
+		Name:              "dice-rolls",
+		Partitions:        6,
+		ReplicationFactor: 3,
+		Configs: map[string]string{
+			"retention.ms":        "3600000",
+			"cleanup.policy":      "compact",
+			"min.insync.replicas": "2",
+			"max.message.bytes":   "1048576",
+		},
+	}, spec)
+}
+
+func TestEnsureTopicsCreatesMissing(t *testing.T) {
+	admin := newFakeAdmin()
+	if err := EnsureTopics(admin, TopicPolicyWarn, testSpec()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sarama.TopicDetail{NumPartitions: 3, ReplicationFactor: 1}, admin.topics["dice-rolls"])
+	assert.Equal(t, "86400000", admin.configs["dice-rolls"]["retention.ms"])
+
+	// The topic now matches its spec under every policy.
+	for _, policy := range []string{TopicPolicyWarn, TopicPolicyFail, TopicPolicyReconcile} {
+		assert.NoError(t, EnsureTopics(admin, policy, testSpec()), policy)
+	}
+}
+
+func TestEnsureTopicsDrift(t *testing.T) {
+	newDrifted := func() *fakeAdmin {
+		admin := newFakeAdmin()
+		admin.topics["dice-rolls"] = sarama.TopicDetail{NumPartitions: 1, ReplicationFactor: 1}
+		admin.configs["dice-rolls"] = map[string]string{"retention.ms": "604800000", "min.insync.replicas": "1"}
+		return admin
+	}
+
+	admin := newDrifted()
+	assert.NoError(t, EnsureTopics(admin, TopicPolicyWarn, testSpec()))
+	assert.Equal(t, int32(1), admin.topics["dice-rolls"].NumPartitions, "warn leaves the topic alone")
+
+	err := EnsureTopics(newDrifted(), TopicPolicyFail, testSpec())
+	assert.ErrorIs(t, err, ErrTopicDrift)
+	assert.ErrorContains(t, err, `partitions is "1", want "3"`)
+	assert.ErrorContains(t, err, `retention.ms is "604800000", want "86400000"`)
+
+	admin = newDrifted()
+	assert.NoError(t, EnsureTopics(admin, TopicPolicyReconcile, testSpec()))
+	assert.Equal(t, int32(3), admin.topics["dice-rolls"].NumPartitions)
+	assert.Equal(t, "86400000", admin.configs["dice-rolls"]["retention.ms"])
+}
+
+func TestEnsureTopicsUnfixableDrift(t *testing.T) {
+	admin := newFakeAdmin()
+	admin.topics["dice-rolls"] = sarama.TopicDetail{NumPartitions: 6, ReplicationFactor: 3}
+	admin.configs["dice-rolls"] = map[string]string{"retention.ms": "86400000", "min.insync.replicas": "1"}
+
+	// Partitions cannot be removed and the replication factor is not changed,
+	// reconcile only warns about them.
+	assert.NoError(t, EnsureTopics(admin, TopicPolicyReconcile, testSpec()))
+	assert.Equal(t, sarama.TopicDetail{NumPartitions: 6, ReplicationFactor: 3}, admin.topics["dice-rolls"])
+
+	assert.ErrorIs(t, EnsureTopics(admin, TopicPolicyFail, testSpec()), ErrTopicDrift)
+}
+
+func TestEnsureTopicsPolicy(t *testing.T) {
+	admin := newFakeAdmin()
+	assert.NoError(t, EnsureTopics(admin, TopicPolicyOff, testSpec()))
+	assert.Empty(t, admin.topics)
+
+	assert.Error(t, EnsureTopics(admin, "create", testSpec()))
+}
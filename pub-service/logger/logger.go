@@ -53,16 +53,13 @@ func Get() *zap.Logger {
 }
 
 // FromCtx returns the Logger associated with the ctx. If no logger
-// is associated, the default logger is returned, unless it is nil
-// in which case a disabled logger is returned.
+// is associated, the default logger is returned.
 func FromCtx(ctx context.Context) *zap.Logger {
 	if l, ok := ctx.Value(ctxKey{}).(*zap.Logger); ok {
 		return l
-	} else if l := logger; l != nil {
-		return l
 	}
 
-	return zap.NewNop()
+	return Get()
 }
 
 // WithCtx returns a copy of ctx with the Logger attached.
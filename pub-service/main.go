@@ -8,11 +8,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"pub-service/cloudevents"
 	"pub-service/config"
+	"pub-service/fair"
 	"pub-service/health"
 	"pub-service/kafka"
 	"pub-service/logger"
+	"pub-service/outbox"
 	"pub-service/rolldice"
+	"pub-service/serde"
 	"pub-service/telemetry"
 	"time"
 
@@ -45,11 +49,96 @@ func main() {
 		err = errors.Join(err, otelShutdown(context.Background()))
 	}()
 
+	// Create missing topics and validate existing ones
+	saramaConfig, err := kafka.NewSaramaConfig(conf.Kafka)
+	if err != nil {
+		zaplog.Panic("invalid kafka config", zap.Error(err))
+	}
+	if err = kafka.ProvisionTopics(conf.Kafka, saramaConfig); err != nil {
+		zaplog.Panic("failed to provision topics", zap.Error(err))
+	}
+
 	// Setup Kafka
 	producer, err := kafka.NewProducer(conf.Kafka)
 	if err != nil {
 		zaplog.Panic("failed to setup kafka", zap.Error(err))
 	}
+	defer func() {
+		err = errors.Join(err, producer.Close())
+	}()
+
+	// Setup the outbox, rolls are relayed to Kafka from disk.
+	var publisher rolldice.Publisher = producer
+	if conf.Outbox.Dir != "" {
+		var box *outbox.Outbox
+		box, err = outbox.New(conf.Outbox, producer)
+		if err != nil {
+			zaplog.Panic("failed to setup outbox", zap.Error(err))
+		}
+		// Deferred after producer.Close, so the relay stops first.
+		defer func() {
+			err = errors.Join(err, box.Close())
+		}()
+		box.Start(ctx)
+		publisher = box
+	}
+
+	// Setup provably fair seeds
+	seeds, err := fair.NewManager(conf.Fair.RevealHistory)
+	if err != nil {
+		zaplog.Panic("failed to setup provably fair seeds", zap.Error(err))
+	}
+	go seeds.Run(ctx, conf.Fair.RotateInterval)
+
+	if mode := conf.Kafka.DeliveryMode; mode != rolldice.DeliveryAck && mode != rolldice.DeliveryAsync {
+		zaplog.Panic("invalid delivery mode", zap.String("mode", mode))
+	}
+
+	rng, err := rolldice.NewRNG(conf.RNG.Source)
+	if err != nil {
+		zaplog.Panic("failed to setup rng", zap.Error(err))
+	}
+
+	// Setup schema registry serialization
+	codec, err := rolldice.NewCodec(conf.Schema.Encoding)
+	if err != nil {
+		zaplog.Panic("invalid encoding", zap.Error(err))
+	}
+	var serializer *serde.Serializer
+	if codec != nil {
+		if conf.Schema.RegistryURL == "" {
+			zaplog.Panic("encoding requires a schema registry url", zap.String("encoding", conf.Schema.Encoding))
+		}
+		subject := conf.Schema.Subject
+		if subject == "" {
+			subject = serde.TopicSubject(conf.Kafka.Topic)
+		}
+		serializer, err = serde.NewSerializer(ctx, serde.NewClient(conf.Schema.RegistryURL), subject, codec, conf.Schema.AutoRegister)
+		if err != nil {
+			zaplog.Panic("failed to setup schema registry serializer", zap.String("subject", subject), zap.Error(err))
+		}
+		zaplog.Info("rolls are encoded with the schema registry", zap.String("encoding", conf.Schema.Encoding), zap.String("subject", subject), zap.Int("schema_id", serializer.SchemaID()))
+	}
+
+	// Setup the CloudEvents envelope
+	dataSchema := conf.CloudEvents.DataSchema
+	if dataSchema == "" && serializer != nil {
+		dataSchema = serde.SchemaURL(conf.Schema.RegistryURL, serializer.SchemaID())
+	}
+	envelope, err := cloudevents.NewEncoder(conf.CloudEvents.Mode, cloudevents.Event{
+		Source:          conf.CloudEvents.Source,
+		Type:            conf.CloudEvents.Type,
+		DataContentType: rolldice.ContentType(conf.Schema.Encoding),
+		DataSchema:      dataSchema,
+	})
+	if err != nil {
+		zaplog.Panic("invalid cloudevents config", zap.Error(err))
+	}
+
+	keyRouter, err := rolldice.NewRouter(conf.Kafka)
+	if err != nil {
+		zaplog.Panic("invalid message key config", zap.Error(err))
+	}
 
 	// Setup router
 	router := mux.NewRouter()
@@ -58,12 +147,31 @@ func main() {
 	router.HandleFunc("/health", healthHandler.HealthCheck).Methods("GET")
 
 	rollHandler := rolldice.Handler{
-		Metrics:  rolldice.Metrics{},
-		Producer: producer,
-		Topic:    conf.Kafka.Topic,
+		Metrics:         rolldice.Metrics{},
+		Producer:        publisher,
+		Topic:           conf.Kafka.Topic,
+		Fair:            seeds,
+		RNG:             rng,
+		AllowSeededRNG:  conf.RNG.AllowSeeded,
+		Router:          keyRouter,
+		Serializer:      serializer,
+		Envelope:        envelope,
+		DeliveryMode:    conf.Kafka.DeliveryMode,
+		DeliveryTimeout: conf.Kafka.DeliveryTimeout,
 	}
 	rollHandler.Metrics.InitMetrics()
 	router.HandleFunc("/rolldice", rollHandler.RollDice).Methods("POST")
+	router.HandleFunc("/rolldice/batch", rollHandler.RollDiceBatch).Methods("POST")
+
+	fairHandler := fair.Handler{Manager: seeds}
+	router.HandleFunc("/fair/seed", fairHandler.Commitment).Methods("GET")
+	if conf.Fair.RotateToken != "" {
+		rotate := fair.RequireToken(conf.Fair.RotateToken)(http.HandlerFunc(fairHandler.Rotate))
+		router.Handle("/fair/seed/rotate", rotate).Methods("POST")
+	} else {
+		zaplog.Warn("seed rotation endpoint disabled, set FAIR_ROTATE_TOKEN to enable it")
+	}
+	router.HandleFunc("/fair/seeds/{hash}", fairHandler.Reveal).Methods("GET")
 
 	zaplog.Debug("starting server", zap.String("service-name", conf.ServiceName), zap.String("port", conf.Port))
 	srv := &http.Server{
@@ -90,4 +198,11 @@ func main() {
 		stop()
 	}
 
+	// Let in-flight requests finish before the producer is flushed.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		zaplog.Error("failed to shut down server", zap.Error(err))
+	}
+
 }
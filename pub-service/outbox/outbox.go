@@ -0,0 +1,346 @@
+// Package outbox persists messages on disk before they are published, so
+// that a roll accepted while the broker is unavailable is not lost.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"pub-service/config"
+	"pub-service/kafka"
+	"pub-service/logger"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const name = "outbox_relay"
+
+const (
+	defaultSegmentSize     = 16 << 20
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = 30 * time.Second
+	defaultPublishTimeout  = 10 * time.Second
+)
+
+// Outbox appends every message to a write-ahead log and relays it to Kafka in
+// the background. Records are relayed in order and at least once: a record
+// that was only partially acknowledged is published again.
+type Outbox struct {
+	producer *kafka.Producer
+	wal      *wal
+
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+	publishTimeout  time.Duration
+
+	mu      sync.Mutex
+	waiters map[uint64]func([]kafka.Delivery)
+
+	relayed      metric.Int64Counter
+	relayErrors  metric.Int64Counter
+	registration metric.Registration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New opens the outbox in config.Dir. Records left undelivered by a previous
+// run are relayed once Start is called.
+func New(config *config.OutboxConfig, producer *kafka.Producer) (*Outbox, error) {
+	log := logger.Get()
+
+	segmentSize := config.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	w, err := openWAL(config.Dir, segmentSize)
+	if err != nil {
+		log.Error("failed to open outbox", zap.String("dir", config.Dir), zap.Error(err))
+		return nil, err
+	}
+
+	o := &Outbox{
+		producer:        producer,
+		wal:             w,
+		retryBackoff:    orDefault(config.RetryBackoff, defaultRetryBackoff),
+		maxRetryBackoff: orDefault(config.MaxRetryBackoff, defaultMaxRetryBackoff),
+		publishTimeout:  orDefault(config.PublishTimeout, defaultPublishTimeout),
+		waiters:         make(map[uint64]func([]kafka.Delivery)),
+		stop:            make(chan struct{}),
+	}
+	if err := o.initMetrics(); err != nil {
+		log.Error("failed to create outbox metrics", zap.Error(err))
+	}
+
+	depth, _ := w.stats()
+	log.Info("outbox opened", zap.String("dir", config.Dir), zap.Int("undelivered", depth))
+	return o, nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+func (o *Outbox) initMetrics() error {
+	meter := otel.Meter(name)
+
+	var err error
+	o.relayed, err = meter.Int64Counter("outbox.relayed",
+		metric.WithDescription("The number of outbox records published to Kafka"),
+		metric.WithUnit("{record}"))
+	if err != nil {
+		return err
+	}
+	o.relayErrors, err = meter.Int64Counter("outbox.relay.errors",
+		metric.WithDescription("The number of failed attempts to publish an outbox record"),
+		metric.WithUnit("{attempt}"))
+	if err != nil {
+		return err
+	}
+	depth, err := meter.Int64ObservableGauge("outbox.depth",
+		metric.WithDescription("The number of records waiting in the outbox"),
+		metric.WithUnit("{record}"))
+	if err != nil {
+		return err
+	}
+	age, err := meter.Float64ObservableGauge("outbox.age",
+		metric.WithDescription("The age of the oldest record waiting in the outbox"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	o.registration, err = meter.RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		n, oldest := o.wal.stats()
+		obs.ObserveInt64(depth, int64(n))
+		var seconds float64
+		if !oldest.IsZero() {
+			seconds = time.Since(oldest).Seconds()
+		}
+		obs.ObserveFloat64(age, seconds)
+		return nil
+	}, depth, age)
+	return err
+}
+
+// Transactional reports whether every record is relayed in a transaction.
+func (o *Outbox) Transactional() bool {
+	return o.producer.Transactional()
+}
+
+// Durable reports that a message is safe once Publish or PublishBatch has
+// returned, even if its delivery has not been reported yet.
+func (o *Outbox) Durable() bool {
+	return true
+}
+
+// Publish appends msg to the outbox and returns a channel that receives the
+// Delivery once the relay has published it. The channel only receives
+// successful deliveries; failed attempts are retried.
+func (o *Outbox) Publish(ctx context.Context, msg *sarama.ProducerMessage) (<-chan kafka.Delivery, error) {
+	done := make(chan kafka.Delivery, 1)
+	_, err := o.append([]*sarama.ProducerMessage{msg}, func(d []kafka.Delivery) {
+		done <- d[0]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// PublishBatch appends msgs to the outbox as a single record and waits until
+// the relay has published them. If ctx expires first, the messages stay in
+// the outbox and a nil slice is returned with the context error.
+func (o *Outbox) PublishBatch(ctx context.Context, msgs []*sarama.ProducerMessage) ([]kafka.Delivery, error) {
+	done := make(chan []kafka.Delivery, 1)
+	_, err := o.append(msgs, func(d []kafka.Delivery) {
+		done <- d
+	})
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case d := <-done:
+		return d, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (o *Outbox) append(msgs []*sarama.ProducerMessage, resolve func([]kafka.Delivery)) (uint64, error) {
+	entries := make([]Message, 0, len(msgs))
+	for _, msg := range msgs {
+		m, err := fromSarama(msg)
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, m)
+	}
+
+	// Hold o.mu across the append so the relay cannot resolve the record
+	// before its waiter is registered.
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	seq, err := o.wal.append(entries)
+	if err != nil {
+		return 0, err
+	}
+	o.waiters[seq] = resolve
+	return seq, nil
+}
+
+func fromSarama(msg *sarama.ProducerMessage) (Message, error) {
+	m := Message{Topic: msg.Topic, Partition: msg.Partition}
+	var err error
+	if msg.Key != nil {
+		if m.Key, err = msg.Key.Encode(); err != nil {
+			return m, fmt.Errorf("failed to encode message key: %w", err)
+		}
+	}
+	if msg.Value != nil {
+		if m.Value, err = msg.Value.Encode(); err != nil {
+			return m, fmt.Errorf("failed to encode message value: %w", err)
+		}
+	}
+	for _, h := range msg.Headers {
+		m.Headers = append(m.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	return m, nil
+}
+
+func (m Message) toSarama() *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Value:     sarama.ByteEncoder(m.Value),
+	}
+	if m.Key != nil {
+		msg.Key = sarama.ByteEncoder(m.Key)
+	}
+	for _, h := range m.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return msg
+}
+
+// Start relays records to Kafka in the background until ctx is done or the
+// outbox is closed. Records are published in the order they were appended; a
+// failed record, or a failure to read the log, is retried with exponential
+// backoff and blocks the records behind it.
+func (o *Outbox) Start(ctx context.Context) {
+	o.wg.Add(1)
+	go o.run(ctx)
+}
+
+func (o *Outbox) run(ctx context.Context) {
+	defer o.wg.Done()
+	log := logger.Get()
+
+	cur := o.wal.newCursor()
+	defer cur.close()
+
+	backoff := o.retryBackoff
+	for {
+		rec, err := cur.next()
+		if err != nil {
+			// Records keep being appended, so the relay does not give up.
+			o.relayErrors.Add(ctx, 1)
+			log.Error("failed to read outbox, retrying", zap.Duration("backoff", backoff), zap.Error(err))
+			if !o.sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(2*backoff, o.maxRetryBackoff)
+			continue
+		}
+		backoff = o.retryBackoff
+		if rec == nil {
+			select {
+			case <-o.wal.notify:
+				continue
+			case <-o.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !o.relay(ctx, rec) {
+			return
+		}
+	}
+}
+
+// relay publishes rec until it succeeds. It returns false if the relay was
+// stopped first.
+func (o *Outbox) relay(ctx context.Context, rec *record) bool {
+	log := logger.Get().With(zap.Uint64("seq", rec.Seq))
+	backoff := o.retryBackoff
+	for {
+		msgs := make([]*sarama.ProducerMessage, 0, len(rec.Messages))
+		for _, m := range rec.Messages {
+			msgs = append(msgs, m.toSarama())
+		}
+
+		pubCtx, cancel := context.WithTimeout(ctx, o.publishTimeout)
+		deliveries, err := o.producer.PublishBatch(pubCtx, msgs)
+		cancel()
+		if err == nil {
+			if err := o.wal.markDelivered(rec.Seq); err != nil {
+				// The record will be relayed again after a restart.
+				log.Error("failed to mark outbox record delivered", zap.Error(err))
+			}
+			o.resolve(rec.Seq, deliveries)
+			o.relayed.Add(ctx, 1)
+			log.Debug("relayed outbox record", zap.Int("messages", len(deliveries)), zap.Duration("age", time.Since(rec.CreatedAt)))
+			return true
+		}
+
+		o.relayErrors.Add(ctx, 1)
+		log.Warn("failed to relay outbox record, retrying", zap.Duration("backoff", backoff), zap.Error(err))
+		if !o.sleep(ctx, backoff) {
+			return false
+		}
+		backoff = min(2*backoff, o.maxRetryBackoff)
+	}
+}
+
+// sleep waits for d. It returns false if the relay was stopped first.
+func (o *Outbox) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-o.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (o *Outbox) resolve(seq uint64, deliveries []kafka.Delivery) {
+	o.mu.Lock()
+	resolve, ok := o.waiters[seq]
+	delete(o.waiters, seq)
+	o.mu.Unlock()
+	if ok {
+		resolve(deliveries)
+	}
+}
+
+// Close stops the relay and closes the log. Undelivered records are kept on
+// disk and relayed by the next run.
+func (o *Outbox) Close() error {
+	o.stopOnce.Do(func() { close(o.stop) })
+	o.wg.Wait()
+	if o.registration != nil {
+		o.registration.Unregister()
+	}
+	return o.wal.close()
+}
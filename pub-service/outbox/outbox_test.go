@@ -0,0 +1,300 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pub-service/config"
+	"pub-service/kafka"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessage(value string) []Message {
+	return []Message{{Topic: "dice-rolls", Value: []byte(value)}}
+}
+
+func readAll(t *testing.T, w *wal) []string {
+	t.Helper()
+	cur := w.newCursor()
+	defer cur.close()
+	var values []string
+	for {
+		rec, err := cur.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec == nil {
+			return values
+		}
+		values = append(values, string(rec.Messages[0].Value))
+	}
+}
+
+func TestWALReopen(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"1", "2", "3", "4", "5"} {
+		if _, err := w.append(testMessage(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Greater(t, len(segments), 2, "small segments roll over")
+
+	if err := w.markDelivered(3); err != nil {
+		t.Fatal(err)
+	}
+	remaining, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Less(t, len(remaining), len(segments), "delivered segments are deleted")
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = openWAL(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	assert.Equal(t, []string{"4", "5"}, readAll(t, w))
+
+	depth, oldest := w.stats()
+	assert.Equal(t, 2, depth)
+	assert.False(t, oldest.IsZero())
+
+	seq, err := w.append(testMessage("6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(6), seq)
+}
+
+func TestCursorClosesDeletedSegment(t *testing.T) {
+	w, err := openWAL(t.TempDir(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	for _, v := range []string{"1", "2", "3", "4", "5"} {
+		if _, err := w.append(testMessage(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := w.segments[0]
+	if first.last < 2 {
+		t.Fatalf("first segment holds records up to %d, want at least 2", first.last)
+	}
+
+	cur := w.newCursor()
+	defer cur.close()
+	if rec, err := cur.next(); err != nil || rec.Seq != 1 {
+		t.Fatalf("got %v, %v, want record 1", rec, err)
+	}
+	read := cur.f
+	if err := w.markDelivered(first.last); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := cur.next()
+	if err != nil || rec.Seq != first.last+1 {
+		t.Fatalf("got %v, %v, want record %d", rec, err, first.last+1)
+	}
+	assert.ErrorIs(t, read.Close(), os.ErrClosed, "the deleted segment should be closed")
+}
+
+func TestWALTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, defaultSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(testMessage("1"))
+	w.append(testMessage("2"))
+	w.close()
+
+	// Simulate a crash in the middle of an append.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	w, err = openWAL(dir, defaultSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	w.append(testMessage("3"))
+	assert.Equal(t, []string{"1", "2", "3"}, readAll(t, w))
+}
+
+func TestWALIgnoresInvalidCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"1", "2", "3", "4", "5"} {
+		if _, err := w.append(testMessage(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.markDelivered(w.segments[0].last); err != nil {
+		t.Fatal(err)
+	}
+	oldest := w.segments[0].base
+	w.close()
+
+	// Simulate a crash while the checkpoint was written.
+	if err := os.WriteFile(filepath.Join(dir, checkpointFile), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err = openWAL(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	values := readAll(t, w)
+	if assert.NotEmpty(t, values) {
+		assert.Equal(t, strconv.FormatUint(oldest, 10), values[0], "relays from the oldest segment")
+		assert.Equal(t, "5", values[len(values)-1])
+	}
+	depth, at := w.stats()
+	assert.Equal(t, len(values), depth)
+	assert.False(t, at.IsZero())
+}
+
+func newTestOutbox(t *testing.T, dir string) (*Outbox, *mocks.AsyncProducer) {
+	saramaConfig := mocks.NewTestConfig()
+	saramaConfig.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(t, saramaConfig)
+	producer := kafka.NewProducerFrom(mock)
+
+	o, err := New(&config.OutboxConfig{Dir: dir, SegmentSize: 1 << 10, RetryBackoff: 10 * time.Millisecond}, producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, o.Close())
+		assert.NoError(t, producer.Close())
+	})
+	return o, mock
+}
+
+func TestOutboxRelayRetries(t *testing.T) {
+	o, mock := newTestOutbox(t, t.TempDir())
+	mock.ExpectInputAndFail(errors.New("broker unavailable"))
+	mock.ExpectInputAndSucceed()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o.Start(ctx)
+
+	done, err := o.Publish(ctx, &sarama.ProducerMessage{Topic: "dice-rolls", Value: sarama.StringEncoder("roll")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-done:
+		assert.NoError(t, d.Err)
+		assert.Equal(t, "dice-rolls", d.Topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("roll was not relayed")
+	}
+	depth, _ := o.wal.stats()
+	assert.Equal(t, 0, depth)
+}
+
+func TestOutboxReplaysAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// The relay never runs, as if the service stopped while Kafka was down.
+	o, err := New(&config.OutboxConfig{Dir: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"first", "second"} {
+		if _, err := o.Publish(ctx, &sarama.ProducerMessage{Topic: "dice-rolls", Value: sarama.StringEncoder(v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	o, mock := newTestOutbox(t, dir)
+	var relayed []string
+	check := func(msg *sarama.ProducerMessage) error {
+		b, _ := msg.Value.Encode()
+		relayed = append(relayed, string(b))
+		return nil
+	}
+	mock.ExpectInputWithMessageCheckerFunctionAndSucceed(check)
+	mock.ExpectInputWithMessageCheckerFunctionAndSucceed(check)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	o.Start(runCtx)
+
+	assert.Eventually(t, func() bool {
+		depth, _ := o.wal.stats()
+		return depth == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"first", "second"}, relayed)
+}
+
+func TestOutboxRelaysConcurrentAppends(t *testing.T) {
+	o, mock := newTestOutbox(t, t.TempDir())
+	const publishers, rolls = 4, 25
+	for range publishers * rolls {
+		mock.ExpectInputAndSucceed()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o.Start(ctx)
+
+	// The relay reads the active segment while it is appended to and rolled.
+	var wg sync.WaitGroup
+	errs := make(chan error, publishers*rolls)
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rolls {
+				value := sarama.StringEncoder(fmt.Sprintf("roll-%d-%d", p, r))
+				done, err := o.Publish(ctx, &sarama.ProducerMessage{Topic: "dice-rolls", Value: value})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				select {
+				case d := <-done:
+					errs <- d.Err
+				case <-time.After(5 * time.Second):
+					errs <- errors.New("roll was not relayed")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	depth, _ := o.wal.stats()
+	assert.Equal(t, 0, depth)
+}
@@ -0,0 +1,471 @@
+package outbox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"pub-service/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExt     = ".log"
+	checkpointFile = "checkpoint"
+
+	// frameHeaderSize is the length and CRC32 that precede every record.
+	frameHeaderSize = 8
+)
+
+var (
+	errCorrupt           = errors.New("corrupt outbox record")
+	errInvalidCheckpoint = errors.New("invalid outbox checkpoint")
+)
+
+// Header is a Kafka record header.
+type Header struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Message is a Kafka message waiting in the outbox.
+type Message struct {
+	Topic     string   `json:"topic"`
+	Partition int32    `json:"partition,omitempty"`
+	Key       []byte   `json:"key,omitempty"`
+	Value     []byte   `json:"value"`
+	Headers   []Header `json:"headers,omitempty"`
+}
+
+// record is a single WAL entry. All messages of a record are relayed in one
+// batch, so a transactional producer writes them atomically.
+type record struct {
+	Seq       uint64    `json:"seq"`
+	CreatedAt time.Time `json:"created_at"`
+	Messages  []Message `json:"messages"`
+}
+
+type segment struct {
+	base uint64
+	last uint64
+	path string
+	size int64
+}
+
+// wal is an append-only log split into segment files named after the
+// sequence number of their first record. A checkpoint file records the
+// highest sequence number that has been delivered; fully delivered segments
+// are deleted.
+type wal struct {
+	dir         string
+	segmentSize int64
+
+	mu        sync.Mutex
+	segments  []*segment
+	active    *os.File
+	nextSeq   uint64
+	delivered uint64
+	// pending holds the creation time of every undelivered record.
+	pending map[uint64]time.Time
+
+	notify chan struct{}
+}
+
+func openWAL(dir string, segmentSize int64) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	w := &wal{
+		dir:         dir,
+		segmentSize: segmentSize,
+		pending:     make(map[uint64]time.Time),
+		notify:      make(chan struct{}, 1),
+	}
+
+	delivered, err := w.readCheckpoint()
+	invalid := errors.Is(err, errInvalidCheckpoint)
+	if err != nil && !invalid {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, &segment{base: base, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].base < w.segments[j].base })
+
+	if invalid {
+		// A crash while the checkpoint was written can leave it empty. Every
+		// remaining record is relayed again rather than refusing to start.
+		delivered = 0
+		if len(w.segments) > 0 {
+			delivered = w.segments[0].base - 1
+		}
+		logger.Get().Warn("ignoring outbox checkpoint, relaying from the oldest segment",
+			zap.String("dir", dir), zap.Uint64("from", delivered+1), zap.Error(err))
+	}
+	w.delivered = delivered
+	w.nextSeq = delivered + 1
+
+	for i, seg := range w.segments {
+		last := i == len(w.segments)-1
+		if err := w.scan(seg, last); err != nil {
+			return nil, err
+		}
+		if seg.last >= w.nextSeq {
+			w.nextSeq = seg.last + 1
+		}
+	}
+	w.cleanup()
+
+	if n := len(w.segments); n > 0 && w.segments[n-1].size < segmentSize {
+		seg := w.segments[n-1]
+		w.active, err = os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open outbox segment: %w", err)
+		}
+		return w, nil
+	}
+	if err := w.roll(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// scan validates every record in seg and records its size and last sequence
+// number. A torn or corrupt tail of the last segment, left behind by a crash
+// during append, is truncated.
+func (w *wal) scan(seg *segment, last bool) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox segment: %w", err)
+	}
+	defer f.Close()
+
+	var off int64
+	for {
+		rec, n, err := readFrame(f, off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !last {
+				return fmt.Errorf("%s at offset %d: %w", seg.path, off, err)
+			}
+			if err := os.Truncate(seg.path, off); err != nil {
+				return fmt.Errorf("failed to truncate outbox segment: %w", err)
+			}
+			break
+		}
+		off += n
+		seg.last = rec.Seq
+		if rec.Seq > w.delivered {
+			w.pending[rec.Seq] = rec.CreatedAt
+		}
+	}
+	seg.size = off
+	return nil
+}
+
+func readFrame(r io.ReaderAt, off int64) (*record, int64, error) {
+	var header [frameHeaderSize]byte
+	n, err := r.ReadAt(header[:], off)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < frameHeaderSize {
+		return nil, 0, errCorrupt
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	sum := binary.BigEndian.Uint32(header[4:])
+
+	payload := make([]byte, size)
+	if n, _ := r.ReadAt(payload, off+frameHeaderSize); n < int(size) {
+		return nil, 0, errCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, 0, errCorrupt
+	}
+	rec := &record{}
+	if err := json.Unmarshal(payload, rec); err != nil {
+		return nil, 0, errCorrupt
+	}
+	return rec, frameHeaderSize + int64(size), nil
+}
+
+// roll starts a new active segment. Must be called with w.mu held.
+func (w *wal) roll() error {
+	if w.active != nil {
+		if err := w.active.Close(); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", w.nextSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox segment: %w", err)
+	}
+	w.active = f
+	w.segments = append(w.segments, &segment{base: w.nextSeq, path: path})
+	return nil
+}
+
+// append durably writes msgs as a single record and returns its sequence
+// number.
+func (w *wal) append(msgs []Message) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec := record{Seq: w.nextSeq, CreatedAt: time.Now().UTC(), Messages: msgs}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[frameHeaderSize:], payload)
+
+	if _, err := w.active.Write(frame); err != nil {
+		return 0, fmt.Errorf("failed to write outbox record: %w", err)
+	}
+	if err := w.active.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync outbox segment: %w", err)
+	}
+
+	seg := w.segments[len(w.segments)-1]
+	seg.size += int64(len(frame))
+	seg.last = rec.Seq
+	w.pending[rec.Seq] = rec.CreatedAt
+	w.nextSeq++
+	if seg.size >= w.segmentSize {
+		if err := w.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return rec.Seq, nil
+}
+
+// markDelivered advances the checkpoint to seq and removes segments that no
+// longer hold undelivered records.
+func (w *wal) markDelivered(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq <= w.delivered {
+		return nil
+	}
+
+	if err := w.writeCheckpoint(seq); err != nil {
+		return fmt.Errorf("failed to write outbox checkpoint: %w", err)
+	}
+	for s := w.delivered + 1; s <= seq; s++ {
+		delete(w.pending, s)
+	}
+	w.delivered = seq
+	w.cleanup()
+	return nil
+}
+
+// cleanup deletes every segment, except the active one, whose records have
+// all been delivered. Must be called with w.mu held.
+func (w *wal) cleanup() {
+	keep := w.segments[:0]
+	for i, seg := range w.segments {
+		active := i == len(w.segments)-1
+		if !active && seg.last <= w.delivered {
+			os.Remove(seg.path)
+			continue
+		}
+		keep = append(keep, seg)
+	}
+	w.segments = keep
+}
+
+// writeCheckpoint atomically replaces the checkpoint with seq: it is synced
+// to a temporary file that is renamed into place, and the directory is
+// synced so the rename survives a crash.
+func (w *wal) writeCheckpoint(seq uint64) error {
+	tmp := filepath.Join(w.dir, checkpointFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.FormatUint(seq, 10)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(w.dir, checkpointFile)); err != nil {
+		return err
+	}
+	dir, err := os.Open(w.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (w *wal) readCheckpoint() (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(w.dir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox checkpoint: %w", err)
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errInvalidCheckpoint, err)
+	}
+	return seq, nil
+}
+
+// stats returns the number of undelivered records and the creation time of
+// the oldest one.
+func (w *wal) stats() (int, time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Records are delivered in order, so the oldest undelivered record is
+	// always the one after the checkpoint.
+	return len(w.pending), w.pending[w.delivered+1]
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active.Close()
+}
+
+// cursor reads records in order, starting after the last delivered one.
+type cursor struct {
+	w    *wal
+	base uint64
+	f    *os.File
+	off  int64
+	seq  uint64
+}
+
+func (w *wal) newCursor() *cursor {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return &cursor{w: w, seq: w.delivered}
+}
+
+// next returns the next record, or nil if the cursor has caught up with the
+// end of the log.
+func (c *cursor) next() (*record, error) {
+	for {
+		c.w.mu.Lock()
+		// The size and last record of the active segment change with every
+		// append, so they are copied while w.mu is held.
+		var cur, following *segment
+		var size int64
+		var last uint64
+		if c.f != nil {
+			for i, seg := range c.w.segments {
+				if seg.base == c.base {
+					cur = seg
+					if i+1 < len(c.w.segments) {
+						following = c.w.segments[i+1]
+					}
+					break
+				}
+			}
+		}
+		if cur == nil {
+			// Either nothing has been read yet or the current segment was
+			// deleted after being delivered.
+			for i, seg := range c.w.segments {
+				if seg.last > c.seq {
+					cur = seg
+					if i+1 < len(c.w.segments) {
+						following = c.w.segments[i+1]
+					}
+					break
+				}
+			}
+		}
+		if cur != nil {
+			size, last = cur.size, cur.last
+		}
+		c.w.mu.Unlock()
+
+		if cur == nil {
+			return nil, nil
+		}
+		if c.f == nil || c.base != cur.base {
+			if c.f != nil {
+				// The segment being read was deleted.
+				c.f.Close()
+				c.f = nil
+			}
+			f, err := os.Open(cur.path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open outbox segment: %w", err)
+			}
+			c.f, c.base, c.off = f, cur.base, 0
+		}
+
+		if c.off < size {
+			rec, n, err := readFrame(c.f, c.off)
+			if err != nil {
+				// The segment is opened again by the next call.
+				c.f.Close()
+				c.f = nil
+				return nil, err
+			}
+			c.off += n
+			if rec.Seq <= c.seq {
+				continue
+			}
+			c.seq = rec.Seq
+			return rec, nil
+		}
+
+		if following == nil {
+			return nil, nil
+		}
+		c.f.Close()
+		c.f = nil
+		c.seq = max(c.seq, last)
+	}
+}
+
+func (c *cursor) close() {
+	if c.f != nil {
+		c.f.Close()
+	}
+}
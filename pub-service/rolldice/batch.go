@@ -0,0 +1,159 @@
+package rolldice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pub-service/kafka"
+	"pub-service/logger"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const maxBatchSize = 100
+
+// BatchRequest rolls several requests at once, each roll is a Request. On a
+// transactional producer the resulting messages are published atomically.
+type BatchRequest struct {
+	Rolls []json.RawMessage `json:"rolls"`
+}
+
+type BatchResponse struct {
+	Rolls       []*Response `json:"rolls"`
+	Transaction string      `json:"transaction,omitempty"`
+}
+
+// RollDiceBatch rolls every request in the batch and waits until all of the
+// resulting messages have been acknowledged.
+func (h *Handler) RollDiceBatch(w http.ResponseWriter, r *http.Request) {
+	log := logger.Get()
+	ctx, span := tracer.Start(r.Context(), "rollDice")
+	defer span.End()
+
+	batch := &BatchRequest{}
+	err := json.NewDecoder(r.Body).Decode(batch)
+	if err != nil {
+		log.Error("failed to decode BatchRequest", zap.Error(err))
+		span.SetStatus(otelcodes.Error, "failed to decode BatchRequest")
+		span.RecordError(err)
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Rolls) == 0 || len(batch.Rolls) > maxBatchSize {
+		err := fmt.Errorf("batch must contain between 1 and %d rolls", maxBatchSize)
+		span.SetStatus(otelcodes.Error, err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	span.SetAttributes(attribute.Int("dice.batch.size", len(batch.Rolls)))
+	h.Metrics.RollCount.Add(ctx, int64(len(batch.Rolls)))
+
+	resp := &BatchResponse{Rolls: make([]*Response, 0, len(batch.Rolls))}
+	msgs := make([]*sarama.ProducerMessage, 0, len(batch.Rolls))
+	for i, raw := range batch.Rolls {
+		rdr := &Request{}
+		if err := json.Unmarshal(raw, rdr); err != nil {
+			span.SetStatus(otelcodes.Error, "failed to decode BatchRequest")
+			span.RecordError(err)
+			http.Error(w, fmt.Sprintf("roll %d: invalid request", i), http.StatusBadRequest)
+			return
+		}
+		rte, err := h.Router.route(r, raw, rdr)
+		if err != nil {
+			span.SetStatus(otelcodes.Error, "failed to route roll")
+			span.RecordError(err)
+			http.Error(w, fmt.Sprintf("roll %d: %v", i, err), http.StatusUnprocessableEntity)
+			return
+		}
+		roll, err := h.evaluate(ctx, rdr)
+		if err != nil {
+			span.SetStatus(otelcodes.Error, "failed to roll dice")
+			span.RecordError(err)
+			http.Error(w, fmt.Sprintf("roll %d: %v", i, err), http.StatusUnprocessableEntity)
+			return
+		}
+		msg, err := h.newMessage(roll, rte)
+		if err != nil {
+			log.Error("failed to encode RollDiceResponse", zap.Error(err))
+			span.SetStatus(otelcodes.Error, "failed to encode RollDiceResponse")
+			span.RecordError(err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		resp.Rolls = append(resp.Rolls, roll)
+		msgs = append(msgs, msg)
+	}
+
+	status := http.StatusOK
+	deliveries, err := h.publishBatch(ctx, msgs)
+	if h.queued(err) {
+		log.Warn("rolls queued in outbox, broker acknowledgement pending", zap.Int("rolls", len(resp.Rolls)))
+		err = nil
+		status = http.StatusAccepted
+	}
+	if err != nil {
+		span.SetStatus(otelcodes.Error, "failed to publish rolls")
+		span.RecordError(err)
+		http.Error(w, "failed to publish rolls", publishErrorStatus(err))
+		return
+	}
+	for i := range deliveries {
+		resp.Rolls[i].Delivery = &deliveries[i]
+	}
+	if h.Producer.Transactional() && deliveries != nil {
+		resp.Transaction = "committed"
+	}
+	log.Info("rolldice batch response", zap.Int("rolls", len(resp.Rolls)), zap.String("transaction", resp.Transaction))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Error("failed to encode BatchResponse", zap.Error(err))
+		span.SetStatus(otelcodes.Error, "failed to encode BatchResponse")
+		span.RecordError(err)
+	}
+}
+
+func (h *Handler) publishBatch(ctx context.Context, msgs []*sarama.ProducerMessage) ([]kafka.Delivery, error) {
+	log := logger.FromCtx(ctx)
+
+	timeout := h.DeliveryTimeout
+	if timeout <= 0 {
+		timeout = defaultDeliveryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	spans := make([]trace.Span, 0, len(msgs))
+	for _, msg := range msgs {
+		span := createProducerSpan(ctx, msg)
+		defer span.End()
+		spans = append(spans, span)
+	}
+
+	startTime := time.Now()
+	deliveries, err := h.Producer.PublishBatch(ctx, msgs)
+	if deliveries == nil {
+		log.Error("failed to send messages to Kafka", zap.Error(err))
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, errors.Join(errDeliveryTimeout, err)
+		}
+		return nil, errors.Join(errEnqueue, err)
+	}
+	for i, d := range deliveries {
+		recordDelivery(spans[i], d, startTime)
+	}
+	if err != nil {
+		log.Error("failed to write batch", zap.Int("messages", len(msgs)), zap.Error(err))
+		return nil, errors.Join(errDelivery, err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,161 @@
+package rolldice
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"pub-service/serde"
+	"sort"
+)
+
+var (
+	//go:embed schema/dice_roll.avsc
+	avroSchema string
+	//go:embed schema/dice_roll.proto
+	protoSchema string
+)
+
+// NewCodec returns the schema registry codec for a roll encoding. The JSON
+// encoding has no codec and nil is returned.
+func NewCodec(encoding string) (serde.Codec, error) {
+	switch encoding {
+	case "", serde.EncodingJSON:
+		return nil, nil
+	case serde.EncodingAvro:
+		return serde.NewAvroCodec(avroSchema)
+	case serde.EncodingProtobuf:
+		return serde.NewProtobufCodec(protoSchema, "gosandbox.dice.v1.DiceRoll")
+	}
+	return nil, fmt.Errorf("invalid encoding %q, must be one of json, avro or protobuf", encoding)
+}
+
+// ContentType returns the media type of rolls sent with encoding.
+func ContentType(encoding string) string {
+	switch encoding {
+	case serde.EncodingAvro:
+		return "application/avro"
+	case serde.EncodingProtobuf:
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
+
+// Event is a Response in the shape of the registered dice roll schema. Maps
+// are written as lists sorted by face, because neither Avro nor Protobuf
+// supports integer or nested map keys.
+type Event struct {
+	Rolls        int          `avro:"rolls" json:"rolls"`
+	Sides        int          `avro:"sides" json:"sides"`
+	Table        string       `avro:"table" json:"table"`
+	Distribution []EventFace  `avro:"distribution" json:"distribution"`
+	Result       *EventResult `avro:"result" json:"result,omitempty"`
+	Proof        *EventProof  `avro:"proof" json:"proof,omitempty"`
+}
+
+type EventFace struct {
+	Face  int `avro:"face" json:"face"`
+	Count int `avro:"count" json:"count"`
+}
+
+type EventResult struct {
+	Expression   string              `avro:"expression" json:"expression"`
+	Total        int                 `avro:"total" json:"total"`
+	Terms        []EventTerm         `avro:"terms" json:"terms"`
+	Distribution []EventDistribution `avro:"distribution" json:"distribution"`
+}
+
+type EventTerm struct {
+	Notation  string     `avro:"notation" json:"notation"`
+	Sign      int        `avro:"sign" json:"sign"`
+	Sides     int        `avro:"sides" json:"sides"`
+	Dice      []EventDie `avro:"dice" json:"dice"`
+	Successes *int       `avro:"successes" json:"successes,omitempty"`
+	Value     int        `avro:"value" json:"value"`
+}
+
+type EventDie struct {
+	Value    int  `avro:"value" json:"value"`
+	Kept     bool `avro:"kept" json:"kept"`
+	Exploded bool `avro:"exploded" json:"exploded"`
+	Success  bool `avro:"success" json:"success"`
+}
+
+type EventDistribution struct {
+	Sides int         `avro:"sides" json:"sides"`
+	Faces []EventFace `avro:"faces" json:"faces"`
+}
+
+type EventProof struct {
+	Version        string      `avro:"version" json:"version"`
+	ServerSeedHash string      `avro:"server_seed_hash" json:"server_seed_hash"`
+	ClientSeed     string      `avro:"client_seed" json:"client_seed"`
+	Nonce          uint64      `avro:"nonce" json:"nonce,string"`
+	Draws          []EventDraw `avro:"draws" json:"draws"`
+}
+
+type EventDraw struct {
+	Sides int `avro:"sides" json:"sides"`
+	Value int `avro:"value" json:"value"`
+}
+
+func NewEvent(resp *Response) *Event {
+	e := &Event{
+		Rolls:        int(resp.Rolls),
+		Sides:        int(resp.Sides),
+		Table:        resp.Table,
+		Distribution: []EventFace{},
+	}
+	for face, count := range resp.Distribution {
+		e.Distribution = append(e.Distribution, EventFace{Face: int(face), Count: int(count)})
+	}
+	sortFaces(e.Distribution)
+
+	if r := resp.Result; r != nil {
+		e.Result = &EventResult{Expression: r.Expression, Total: r.Total, Terms: []EventTerm{}, Distribution: []EventDistribution{}}
+		for _, t := range r.Terms {
+			term := EventTerm{Notation: t.Notation, Sign: t.Sign, Sides: t.Sides, Dice: []EventDie{}, Successes: t.Successes, Value: t.Value}
+			for _, d := range t.Dice {
+				term.Dice = append(term.Dice, EventDie{Value: d.Value, Kept: d.Kept, Exploded: d.Exploded, Success: d.Success})
+			}
+			e.Result.Terms = append(e.Result.Terms, term)
+		}
+		for sides, faces := range r.Distribution {
+			dist := EventDistribution{Sides: sides, Faces: []EventFace{}}
+			for face, count := range faces {
+				dist.Faces = append(dist.Faces, EventFace{Face: face, Count: int(count)})
+			}
+			sortFaces(dist.Faces)
+			e.Result.Distribution = append(e.Result.Distribution, dist)
+		}
+		sort.Slice(e.Result.Distribution, func(i, j int) bool {
+			return e.Result.Distribution[i].Sides < e.Result.Distribution[j].Sides
+		})
+	}
+
+	if p := resp.Proof; p != nil {
+		e.Proof = &EventProof{
+			Version:        p.Version,
+			ServerSeedHash: p.ServerSeedHash,
+			ClientSeed:     p.ClientSeed,
+			Nonce:          p.Nonce,
+			Draws:          []EventDraw{},
+		}
+		for _, d := range p.Draws {
+			e.Proof.Draws = append(e.Proof.Draws, EventDraw{Sides: d.Sides, Value: d.Value})
+		}
+	}
+	return e
+}
+
+func sortFaces(faces []EventFace) {
+	sort.Slice(faces, func(i, j int) bool { return faces[i].Face < faces[j].Face })
+}
+
+// encode returns the Kafka payload of resp: its JSON form, or the registered
+// schema's encoding when a Serializer is configured.
+func (h *Handler) encode(resp *Response) ([]byte, error) {
+	if h.Serializer == nil {
+		return json.Marshal(resp)
+	}
+	return h.Serializer.Serialize(NewEvent(resp))
+}
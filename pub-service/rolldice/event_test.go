@@ -0,0 +1,159 @@
+package rolldice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"pub-service/dice"
+	"pub-service/fair"
+	"pub-service/internal/serdetest"
+	"pub-service/serde"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func testResponse(t *testing.T) *Response {
+	result, err := dice.Roll("4d6kh3+2", &Replay{Values: []int{5, 0, 3, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Response{
+		Rolls:        2,
+		Sides:        6,
+		Table:        "table-7",
+		Distribution: map[int8]int32{6: 1, 2: 1},
+		Result:       result,
+		Proof: &fair.Proof{
+			Version:        fair.Version,
+			ServerSeedHash: "3eb1bd43",
+			ClientSeed:     "player-1",
+			Nonce:          3,
+			Draws:          []fair.Draw{{Sides: 6, Value: 2}},
+		},
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, encoding := range []string{serde.EncodingAvro, serde.EncodingProtobuf} {
+		t.Run(encoding, func(t *testing.T) {
+			codec, err := NewCodec(encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := serdetest.NewRegistry()
+			s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := NewEvent(testResponse(t))
+			data, err := s.Serialize(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &Event{}
+			if err := serde.NewDeserializer(registry).Deserialize(ctx, data, got); err != nil {
+				t.Fatal(err)
+			}
+			normalize(got)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestEventNonceRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, encoding := range []string{serde.EncodingAvro, serde.EncodingProtobuf} {
+		t.Run(encoding, func(t *testing.T) {
+			codec, err := NewCodec(encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := serdetest.NewRegistry()
+			s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, nonce := range []uint64{0, math.MaxInt64, math.MaxInt64 + 1, math.MaxUint64} {
+				resp := testResponse(t)
+				resp.Proof.Nonce = nonce
+				data, err := s.Serialize(NewEvent(resp))
+				if err != nil {
+					t.Fatalf("nonce %d: %v", nonce, err)
+				}
+				got := &Event{}
+				if err := serde.NewDeserializer(registry).Deserialize(ctx, data, got); err != nil {
+					t.Fatalf("nonce %d: %v", nonce, err)
+				}
+				assert.Equal(t, nonce, got.Proof.Nonce)
+			}
+		})
+	}
+}
+
+// normalize replaces the nil slices protojson leaves for empty lists.
+func normalize(e *Event) {
+	if e.Distribution == nil {
+		e.Distribution = []EventFace{}
+	}
+	if e.Result != nil {
+		for i := range e.Result.Terms {
+			if e.Result.Terms[i].Dice == nil {
+				e.Result.Terms[i].Dice = []EventDie{}
+			}
+		}
+	}
+}
+
+func TestNewCodec(t *testing.T) {
+	codec, err := NewCodec(serde.EncodingJSON)
+	assert.NoError(t, err)
+	assert.Nil(t, codec)
+
+	_, err = NewCodec("xml")
+	assert.Error(t, err)
+}
+
+func TestRollDiceWithSerializer(t *testing.T) {
+	codec, err := NewCodec(serde.EncodingAvro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := serdetest.NewRegistry()
+	s, err := serde.NewSerializer(context.Background(), registry, serde.TopicSubject("dice-rolls"), codec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requestBody, _ := json.Marshal(map[string]any{"sides": 6, "rolls": 3, "table": "table-7"})
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h, mock := newTestHandler(t)
+	h.Serializer = s
+	mock.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		data, err := msg.Value.Encode()
+		if err != nil {
+			return err
+		}
+		event := &Event{}
+		if err := serde.NewDeserializer(registry).Deserialize(context.Background(), data, event); err != nil {
+			return err
+		}
+		assert.Equal(t, "table-7", event.Table)
+		assert.Equal(t, 3, event.Rolls)
+		return nil
+	})
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
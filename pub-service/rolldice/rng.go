@@ -0,0 +1,133 @@
+package rolldice
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	randv2 "math/rand/v2"
+	"sync"
+)
+
+// RNG is a source of dice values. IntN returns a value in [0, n).
+type RNG interface {
+	IntN(n int) int
+}
+
+const (
+	SourceMath    = "math"
+	SourceCrypto  = "crypto"
+	SourcePCG     = "pcg"
+	SourceChaCha8 = "chacha8"
+)
+
+var (
+	ErrUnknownSource     = errors.New("unknown rng source")
+	ErrSeededRNGDisabled = errors.New("seeded rng requests are disabled")
+)
+
+// NewRNG returns a RNG for the named source that is safe for concurrent use.
+// Seeded sources are seeded from crypto/rand.
+func NewRNG(source string) (RNG, error) {
+	switch source {
+	case "", SourceMath:
+		return mathRNG{}, nil
+	case SourceCrypto:
+		return cryptoRNG{}, nil
+	case SourcePCG, SourceChaCha8:
+		seed := make([]byte, 32)
+		if _, err := crand.Read(seed); err != nil {
+			return nil, fmt.Errorf("failed to seed %s rng: %w", source, err)
+		}
+		r, err := newSeeded(source, seed)
+		if err != nil {
+			return nil, err
+		}
+		return &lockedRNG{r: r}, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownSource, source)
+}
+
+// NewSeededRNG returns a deterministic RNG for a seeded source (pcg or
+// chacha8). The same source and seed always produce the same values. The
+// returned RNG is not safe for concurrent use.
+func NewSeededRNG(source, seed string) (RNG, error) {
+	sum := sha256.Sum256([]byte(seed))
+	return newSeeded(source, sum[:])
+}
+
+func newSeeded(source string, seed []byte) (*randv2.Rand, error) {
+	switch source {
+	case SourcePCG:
+		return randv2.New(randv2.NewPCG(binary.LittleEndian.Uint64(seed[:8]), binary.LittleEndian.Uint64(seed[8:16]))), nil
+	case SourceChaCha8:
+		return randv2.New(randv2.NewChaCha8([32]byte(seed[:32]))), nil
+	}
+	return nil, fmt.Errorf("%w %q for seeded mode", ErrUnknownSource, source)
+}
+
+// mathRNG uses the global math/rand source.
+type mathRNG struct{}
+
+func (mathRNG) IntN(n int) int {
+	return rand.Intn(n)
+}
+
+// cryptoRNG draws uniformly from crypto/rand.
+type cryptoRNG struct{}
+
+func (cryptoRNG) IntN(n int) int {
+	v, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		// crypto/rand only fails if the OS entropy source is broken.
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return int(v.Int64())
+}
+
+type lockedRNG struct {
+	mu sync.Mutex
+	r  *randv2.Rand
+}
+
+func (l *lockedRNG) IntN(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.IntN(n)
+}
+
+// Recorder wraps a RNG and records every value it returns, so that a roll can
+// later be reproduced with a Replay.
+type Recorder struct {
+	RNG    RNG
+	Values []int
+}
+
+func (r *Recorder) IntN(n int) int {
+	v := r.RNG.IntN(n)
+	r.Values = append(r.Values, v)
+	return v
+}
+
+// Replay returns previously recorded values in order. It panics if it runs
+// out of values or a value is out of range for n, which indicates the replayed
+// roll differs from the recorded one.
+type Replay struct {
+	Values []int
+	pos    int
+}
+
+func (r *Replay) IntN(n int) int {
+	if r.pos >= len(r.Values) {
+		panic("rolldice: replay exhausted")
+	}
+	v := r.Values[r.pos]
+	if v < 0 || v >= n {
+		panic(fmt.Sprintf("rolldice: replayed value %d out of range [0, %d)", v, n))
+	}
+	r.pos++
+	return v
+}
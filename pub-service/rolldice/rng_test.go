@@ -0,0 +1,76 @@
+package rolldice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRNG(t *testing.T) {
+	for _, source := range []string{"", SourceMath, SourceCrypto, SourcePCG, SourceChaCha8} {
+		t.Run(source, func(t *testing.T) {
+			rng, err := NewRNG(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				v := rng.IntN(6)
+				assert.True(t, v >= 0 && v < 6, "value %d out of range", v)
+			}
+		})
+	}
+
+	_, err := NewRNG("dev-urandom")
+	assert.ErrorIs(t, err, ErrUnknownSource)
+}
+
+func TestSeededRNGIsDeterministic(t *testing.T) {
+	for _, source := range []string{SourcePCG, SourceChaCha8} {
+		t.Run(source, func(t *testing.T) {
+			a, err := NewSeededRNG(source, "table-7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewSeededRNG(source, "table-7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, a.IntN(20), b.IntN(20))
+			}
+		})
+	}
+
+	_, err := NewSeededRNG(SourceCrypto, "table-7")
+	assert.ErrorIs(t, err, ErrUnknownSource, "crypto cannot be seeded")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	rng, err := NewSeededRNG(SourcePCG, "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{}
+	rec := &Recorder{RNG: rng}
+	recorded, err := h.roll(context.Background(), 6, 20, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := h.roll(context.Background(), 6, 20, &Replay{Values: rec.Values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, recorded, replayed)
+}
+
+func TestReplayExactOutput(t *testing.T) {
+	h := &Handler{}
+	result, err := h.rollExpression(context.Background(), "4d6kh3+2", &Replay{Values: []int{2, 5, 0, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 15, result.Total)
+	assert.Panics(t, func() { (&Replay{}).IntN(6) }, "exhausted replay should panic")
+}
@@ -3,13 +3,19 @@ package rolldice
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
+	"pub-service/cloudevents"
+	"pub-service/dice"
+	"pub-service/fair"
+	"pub-service/kafka"
 	"pub-service/logger"
+	"pub-service/serde"
 	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	otelcodes "go.opentelemetry.io/otel/codes"
@@ -22,23 +28,100 @@ import (
 
 type Handler struct {
 	Metrics  Metrics
-	Producer sarama.AsyncProducer
+	Producer Publisher
 	Topic    string
+	Fair     *fair.Manager
+	RNG      RNG
+	Router   *Router
+	// AllowSeededRNG lets requests roll with an RNG seeded by themselves.
+	AllowSeededRNG bool
+	// Serializer encodes rolls with a registered schema. Rolls are sent as
+	// JSON if it is nil.
+	Serializer *serde.Serializer
+	// Envelope wraps rolls in CloudEvents. Rolls are sent bare if it is nil.
+	Envelope *cloudevents.Encoder
+
+	// DeliveryMode is the default for requests without a delivery query
+	// parameter: DeliveryAck waits for the broker, DeliveryAsync does not.
+	DeliveryMode    string
+	DeliveryTimeout time.Duration
+}
+
+// HeaderMessageID is the record header carrying the UUIDv7 every message is
+// stamped with, so that consumers can recognise redeliveries.
+const HeaderMessageID = "message-id"
+
+const (
+	DeliveryAck   = "ack"
+	DeliveryAsync = "async"
+
+	defaultDeliveryTimeout = 5 * time.Second
+)
+
+var (
+	errEnqueue         = errors.New("failed to enqueue message")
+	errDelivery        = errors.New("broker rejected message")
+	errDeliveryTimeout = errors.New("timed out waiting for broker acknowledgement")
+)
+
+// Publisher sends rolls to Kafka. It is implemented by *kafka.Producer and
+// by *outbox.Outbox.
+type Publisher interface {
+	Publish(ctx context.Context, msg *sarama.ProducerMessage) (<-chan kafka.Delivery, error)
+	PublishBatch(ctx context.Context, msgs []*sarama.ProducerMessage) ([]kafka.Delivery, error)
+	Transactional() bool
+}
+
+// durable is implemented by publishers that persist a message before Publish
+// returns. A roll that times out waiting for the broker is still accepted
+// because it will be delivered later.
+type durable interface {
+	Durable() bool
 }
 
 type Metrics struct {
 	RollCount metric.Int64Counter
 }
 
+// Request rolls either a single pool of identical dice (Sides and Rolls) or,
+// when Expression is set, a dice expression such as "4d6kh3+2". Setting Fair
+// derives the roll from the committed server seed and setting RNG from a
+// caller-provided seed, if the handler allows it; otherwise the deployment's
+// RNG is used. Table is passed through to consumers and, with the field key
+// strategy, keeps rolls of one table in order. Partition is only used with
+// the manual partitioner.
 type Request struct {
-	Sides int8 `json:"sides"`
-	Rolls int8 `json:"rolls"`
+	Sides      int8         `json:"sides"`
+	Rolls      int8         `json:"rolls"`
+	Expression string       `json:"expression,omitempty"`
+	Table      string       `json:"table,omitempty"`
+	Partition  *int32       `json:"partition,omitempty"`
+	Fair       *FairRequest `json:"fair,omitempty"`
+	RNG        *RNGRequest  `json:"rng,omitempty"`
+}
+
+// RNGRequest selects a seeded source (pcg or chacha8) so that the same seed
+// always produces the same roll.
+type RNGRequest struct {
+	Source string `json:"source"`
+	Seed   string `json:"seed"`
+}
+
+// FairRequest selects provably fair mode. When Nonce is omitted the server
+// assigns the next nonce for the active server seed.
+type FairRequest struct {
+	ClientSeed string  `json:"client_seed"`
+	Nonce      *uint64 `json:"nonce,omitempty"`
 }
 
 type Response struct {
-	Rolls        int8           `json:"rolls"`
-	Sides        int8           `json:"sides"`
-	Distribution map[int8]int32 `json:"distribution"`
+	Rolls        int8            `json:"rolls"`
+	Sides        int8            `json:"sides"`
+	Table        string          `json:"table,omitempty"`
+	Distribution map[int8]int32  `json:"distribution"`
+	Result       *dice.Result    `json:"result,omitempty"`
+	Proof        *fair.Proof     `json:"proof,omitempty"`
+	Delivery     *kafka.Delivery `json:"delivery,omitempty"`
 }
 
 const name = "rolldice_producer"
@@ -66,8 +149,12 @@ func (h *Handler) RollDice(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 	h.Metrics.RollCount.Add(ctx, 1)
 
+	var raw json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&raw)
 	rdr := &Request{}
-	err := json.NewDecoder(r.Body).Decode(rdr)
+	if err == nil {
+		err = json.Unmarshal(raw, rdr)
+	}
 	if err != nil {
 		log.Error("failed to decode RollDiceRequest", zap.Error(err))
 		span.SetStatus(otelcodes.Error, "failed to decode RollDiceRequest")
@@ -77,43 +164,124 @@ func (h *Handler) RollDice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Debug("rolldice request", zap.Any("request", rdr))
-	distribution, err := h.roll(ctx, rdr.Sides, rdr.Rolls)
+	rte, err := h.Router.route(r, raw, rdr)
 	if err != nil {
-		span.SetStatus(otelcodes.Error, "failed to roll dice")
+		span.SetStatus(otelcodes.Error, "failed to route roll")
 		span.RecordError(err)
 		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-
-	resp := &Response{
-		Rolls:        rdr.Rolls,
-		Sides:        rdr.Sides,
-		Distribution: distribution,
+	resp, err := h.evaluate(ctx, rdr)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, "failed to roll dice")
+		span.RecordError(err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
 	}
 	log.Info("rolldice response", zap.Any("response", resp))
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(resp)
+	msg, err := h.newMessage(resp, rte)
 	if err != nil {
 		log.Error("failed to encode RollDiceResponse", zap.Error(err))
 		span.SetStatus(otelcodes.Error, "failed to encode RollDiceResponse")
 		span.RecordError(err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	mode := h.DeliveryMode
+	if m := r.URL.Query().Get("delivery"); m != "" {
+		mode = m
+	}
+	status := http.StatusOK
+	switch mode {
+	case DeliveryAsync:
+		err = h.publishRollAsync(ctx, msg)
+		status = http.StatusAccepted
+	case "", DeliveryAck:
+		resp.Delivery, err = h.publishRoll(ctx, msg)
+		if h.queued(err) {
+			log.Warn("roll queued in outbox, broker acknowledgement pending")
+			err = nil
+			status = http.StatusAccepted
+		}
+	default:
+		http.Error(w, fmt.Sprintf("invalid delivery mode %q", mode), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		span.SetStatus(otelcodes.Error, "failed to publish roll")
+		span.RecordError(err)
+		http.Error(w, "failed to publish roll", publishErrorStatus(err))
+		return
 	}
 
-	encoder, err := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		log.Error("failed to encode RollDiceResponse", zap.Error(err))
 		span.SetStatus(otelcodes.Error, "failed to encode RollDiceResponse")
 		span.RecordError(err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+	}
+}
+
+// evaluate rolls the dice for a single request using the RNG it selects.
+func (h *Handler) evaluate(ctx context.Context, rdr *Request) (*Response, error) {
+	span := trace.SpanFromContext(ctx)
+	rng := h.RNG
+	if rng == nil {
+		rng = mathRNG{}
+	}
+	if rdr.Fair != nil && rdr.RNG != nil {
+		return nil, errors.New("fair and rng are mutually exclusive")
+	}
+
+	var err error
+	if rdr.RNG != nil {
+		if !h.AllowSeededRNG {
+			return nil, ErrSeededRNGDisabled
+		}
+		rng, err = NewSeededRNG(rdr.RNG.Source, rdr.RNG.Seed)
+		if err != nil {
+			return nil, err
+		}
+		span.SetAttributes(attribute.String("rng.source", rdr.RNG.Source))
+	}
+	var stream *fair.Stream
+	if rdr.Fair != nil {
+		if h.Fair == nil {
+			return nil, errors.New("provably fair mode is not enabled")
+		}
+		stream, err = h.Fair.NewStream(rdr.Fair.ClientSeed, rdr.Fair.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		rng = stream
 	}
 
-	h.publishRoll(ctx, encoder)
+	resp := &Response{Table: rdr.Table}
+	if rdr.Expression != "" {
+		resp.Result, err = h.rollExpression(ctx, rdr.Expression, rng)
+	} else {
+		resp.Rolls = rdr.Rolls
+		resp.Sides = rdr.Sides
+		resp.Distribution, err = h.roll(ctx, rdr.Sides, rdr.Rolls, rng)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stream != nil {
+		resp.Proof = stream.Proof()
+		span.SetAttributes(
+			attribute.String("fair.server_seed_hash", resp.Proof.ServerSeedHash),
+			attribute.Int64("fair.nonce", int64(resp.Proof.Nonce)),
+		)
+	}
+	return resp, nil
 }
 
-func (h *Handler) roll(ctx context.Context, sides int8, rolls int8) (map[int8]int32, error) {
+func (h *Handler) roll(ctx context.Context, sides int8, rolls int8, rng RNG) (map[int8]int32, error) {
 	ctx, span := tracer.Start(ctx, "roll")
 	defer span.End()
 	log := logger.FromCtx(ctx)
@@ -133,65 +301,162 @@ func (h *Handler) roll(ctx context.Context, sides int8, rolls int8) (map[int8]in
 	}
 	distribution := make(map[int8]int32)
 	for i := int8(0); i < rolls; i++ {
-		roll := int8(rand.Intn(int(sides)) + 1)
+		roll := int8(rng.IntN(int(sides)) + 1)
 		distribution[roll]++
 	}
 	span.SetStatus(otelcodes.Ok, "success")
 	return distribution, nil
 }
 
-func (h *Handler) publishRoll(ctx context.Context, roll json.RawMessage) {
+func (h *Handler) rollExpression(ctx context.Context, expression string, rng RNG) (*dice.Result, error) {
+	_, span := tracer.Start(ctx, "rollExpression", trace.WithAttributes(attribute.String("dice.expression", expression)))
+	defer span.End()
 	log := logger.FromCtx(ctx)
 
-	msg := sarama.ProducerMessage{
-		Topic: h.Topic,
-		Value: sarama.ByteEncoder(roll),
+	result, err := dice.Roll(expression, rng)
+	if err != nil {
+		log.Error("invalid input", zap.Error(err))
+		span.SetStatus(otelcodes.Error, "invalid dice expression")
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("dice.total", result.Total))
+	span.SetStatus(otelcodes.Ok, "success")
+	return result, nil
+}
+
+// newMessage encodes resp and, when an Envelope is configured, wraps it in a
+// CloudEvent whose subject is the roll's table. The message is stamped with a
+// new message ID, which is kept when the message is retried.
+func (h *Handler) newMessage(resp *Response, rte route) (*sarama.ProducerMessage, error) {
+	payload, err := h.encode(resp)
+	if err != nil {
+		return nil, err
 	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:     h.Topic,
+		Partition: rte.Partition,
+		Headers:   []sarama.RecordHeader{{Key: []byte(HeaderMessageID), Value: []byte(id.String())}},
+	}
+	if rte.Key != nil {
+		msg.Key = sarama.ByteEncoder(rte.Key)
+	}
+	if _, err := h.Envelope.Encode(msg, payload, resp.Table); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// publishRoll sends msg and waits for the broker to acknowledge it.
+func (h *Handler) publishRoll(ctx context.Context, msg *sarama.ProducerMessage) (*kafka.Delivery, error) {
+	log := logger.FromCtx(ctx)
 
 	// Inject tracing info into message
-	span := createProducerSpan(ctx, &msg)
+	span := createProducerSpan(ctx, msg)
 	defer span.End()
 
-	// Send message and handle response
 	startTime := time.Now()
+	done, err := h.Producer.Publish(ctx, msg)
+	if err != nil {
+		recordDelivery(span, kafka.Delivery{Err: err}, startTime)
+		log.Error("failed to send message to Kafka", zap.Error(err))
+		return nil, errors.Join(errEnqueue, err)
+	}
+
+	timeout := h.DeliveryTimeout
+	if timeout <= 0 {
+		timeout = defaultDeliveryTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	select {
-	case h.Producer.Input() <- &msg:
-		log.Info("Message sent to Kafka", zap.Any("message", msg))
-	/*	select {
-		case successMsg := <-h.Producer.Successes():
-			span.SetAttributes(
-				attribute.Bool("messaging.kafka.producer.success", true),
-				attribute.Int("messaging.kafka.producer.duration_ms", int(time.Since(startTime).Milliseconds())),
-				attribute.KeyValue(semconv.MessagingKafkaMessageOffset(int(successMsg.Offset))),
-			)
-			log.Info("Successfully wrote message.", zap.Int64("offset", successMsg.Offset), zap.Duration("duration", time.Since(startTime)))
-		case errMsg := <-h.Producer.Errors():
-			span.SetAttributes(
-				attribute.Bool("messaging.kafka.producer.success", false),
-				attribute.Int("messaging.kafka.producer.duration_ms", int(time.Since(startTime).Milliseconds())),
-			)
-			span.SetStatus(otelcodes.Error, errMsg.Err.Error())
-			log.Error("Failed to write message.", zap.Error(errMsg.Err))
-		case <-ctx.Done():
-			span.SetAttributes(
-				attribute.Bool("messaging.kafka.producer.success", false),
-				attribute.Int("messaging.kafka.producer.duration_ms", int(time.Since(startTime).Milliseconds())),
-			)
-			span.SetStatus(otelcodes.Error, "Context cancelled: "+ctx.Err().Error())
-			log.Warn("Context canceled before success message received.", zap.Error(ctx.Err()))
+	case d := <-done:
+		recordDelivery(span, d, startTime)
+		if d.Err != nil {
+			log.Error("failed to write message", zap.Error(d.Err))
+			return nil, errors.Join(errDelivery, d.Err)
 		}
-
-	*/
+		log.Info("successfully wrote message", zap.Int32("partition", d.Partition), zap.Int64("offset", d.Offset), zap.Duration("duration", time.Since(startTime)))
+		return &d, nil
+	case <-timer.C:
+		recordDelivery(span, kafka.Delivery{Err: errDeliveryTimeout}, startTime)
+		log.Warn("timed out waiting for broker acknowledgement", zap.Duration("timeout", timeout))
+		return nil, errDeliveryTimeout
 	case <-ctx.Done():
-		span.SetAttributes(
-			attribute.Bool("messaging.kafka.producer.success", false),
-			attribute.Int("messaging.kafka.producer.duration_ms", int(time.Since(startTime).Milliseconds())),
-		)
-		span.SetStatus(otelcodes.Error, "Failed to send: "+ctx.Err().Error())
-		log.Error("Failed to send message to Kafka within context deadline.", zap.Error(ctx.Err()))
+		recordDelivery(span, kafka.Delivery{Err: ctx.Err()}, startTime)
+		log.Warn("context canceled before broker acknowledgement", zap.Error(ctx.Err()))
+		return nil, errors.Join(errDeliveryTimeout, ctx.Err())
+	}
+}
+
+// publishRollAsync enqueues msg without waiting for the broker. The producer
+// span is completed in the background once the acknowledgement arrives.
+func (h *Handler) publishRollAsync(ctx context.Context, msg *sarama.ProducerMessage) error {
+	log := logger.FromCtx(ctx)
+
+	span := createProducerSpan(ctx, msg)
+
+	startTime := time.Now()
+	done, err := h.Producer.Publish(ctx, msg)
+	if err != nil {
+		recordDelivery(span, kafka.Delivery{Err: err}, startTime)
+		span.End()
+		log.Error("failed to send message to Kafka", zap.Error(err))
+		return errors.Join(errEnqueue, err)
+	}
+
+	go func() {
+		defer span.End()
+		d := <-done
+		recordDelivery(span, d, startTime)
+		if d.Err != nil {
+			log.Error("failed to write message", zap.Error(d.Err))
+			return
+		}
+		log.Debug("successfully wrote message", zap.Int32("partition", d.Partition), zap.Int64("offset", d.Offset))
+	}()
+	return nil
+}
+
+func recordDelivery(span trace.Span, d kafka.Delivery, startTime time.Time) {
+	span.SetAttributes(
+		attribute.Bool("messaging.kafka.producer.success", d.Err == nil),
+		attribute.Int("messaging.kafka.producer.duration_ms", int(time.Since(startTime).Milliseconds())),
+	)
+	if d.Err != nil {
+		span.SetStatus(otelcodes.Error, d.Err.Error())
+		span.RecordError(d.Err)
 		return
 	}
-	return
+	span.SetAttributes(
+		semconv.MessagingKafkaDestinationPartition(int(d.Partition)),
+		semconv.MessagingKafkaMessageOffset(int(d.Offset)),
+	)
+	span.SetStatus(otelcodes.Ok, "success")
+}
+
+// queued reports whether err is a delivery timeout on a durable publisher, in
+// which case the roll is safe and will be delivered once the broker is back.
+func (h *Handler) queued(err error) bool {
+	d, ok := h.Producer.(durable)
+	return ok && d.Durable() && errors.Is(err, errDeliveryTimeout)
+}
+
+// publishErrorStatus maps a publish error to the HTTP status returned to the
+// client.
+func publishErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errDeliveryTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errDelivery):
+		return http.StatusBadGateway
+	default:
+		return http.StatusServiceUnavailable
+	}
 }
 
 func createProducerSpan(ctx context.Context, msg *sarama.ProducerMessage) trace.Span {
@@ -205,9 +470,18 @@ func createProducerSpan(ctx context.Context, msg *sarama.ProducerMessage) trace.
 			semconv.MessagingSystemKafka,
 			semconv.MessagingDestinationName(msg.Topic),
 			semconv.MessagingOperationPublish,
-			semconv.MessagingKafkaDestinationPartition(int(msg.Partition)),
 		),
 	)
+	if msg.Key != nil {
+		if key, err := msg.Key.Encode(); err == nil {
+			span.SetAttributes(semconv.MessagingKafkaMessageKey(string(key)))
+		}
+	}
+	for _, header := range msg.Headers {
+		if string(header.Key) == HeaderMessageID {
+			span.SetAttributes(semconv.MessagingMessageID(string(header.Value)))
+		}
+	}
 
 	carrier := propagation.MapCarrier{}
 	propagator := otel.GetTextMapPropagator()
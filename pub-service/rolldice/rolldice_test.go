@@ -4,15 +4,41 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"pub-service/cloudevents"
+	"pub-service/config"
+	"pub-service/fair"
+	"pub-service/kafka"
 	"pub-service/logger"
+	"pub-service/outbox"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
 
+func newTestHandler(t *testing.T) (*Handler, *mocks.AsyncProducer) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(t, config)
+	producer := kafka.NewProducerFrom(mock)
+	t.Cleanup(func() {
+		if err := producer.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	h := &Handler{Producer: producer, Topic: "dice-rolls", DeliveryMode: DeliveryAck}
+	h.Metrics.InitMetrics()
+	return h, mock
+}
+
 func TestRollDice(t *testing.T) {
 	requestBody, _ := json.Marshal(map[string]int8{
 		"sides": 6,
@@ -25,7 +51,9 @@ func TestRollDice(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(RollDice)
+	h, mock := newTestHandler(t)
+	mock.ExpectInputAndSucceed()
+	handler := http.HandlerFunc(h.RollDice)
 
 	handler.ServeHTTP(rr, req)
 
@@ -39,18 +67,153 @@ func TestRollDice(t *testing.T) {
 
 	assert.Equal(t, int8(6), response.Sides, "Sides should be equal to 6")
 	assert.Equal(t, int8(3), response.Rolls, "Rolls should be equal to 3")
+	if assert.NotNil(t, response.Delivery, "Delivery should be reported") {
+		assert.Equal(t, "dice-rolls", response.Delivery.Topic)
+	}
+}
+
+func TestRollDiceMessageID(t *testing.T) {
+	h, mock := newTestHandler(t)
+	var ids []string
+	checker := func(msg *sarama.ProducerMessage) error {
+		for _, header := range msg.Headers {
+			if string(header.Key) == HeaderMessageID {
+				ids = append(ids, string(header.Value))
+			}
+		}
+		return nil
+	}
+	mock.ExpectInputWithMessageCheckerFunctionAndSucceed(checker)
+	mock.ExpectInputWithMessageCheckerFunctionAndSucceed(checker)
+	for range 2 {
+		requestBody, _ := json.Marshal(map[string]int8{"sides": 6, "rolls": 1})
+		req := httptest.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	if assert.Len(t, ids, 2) {
+		assert.NotEqual(t, ids[0], ids[1], "every message should get its own ID")
+		for _, id := range ids {
+			parsed, err := uuid.Parse(id)
+			assert.NoError(t, err)
+			assert.Equal(t, uuid.Version(7), parsed.Version())
+		}
+	}
+}
+
+func TestRollDiceDeliveryModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		expect func(*mocks.AsyncProducer)
+		status int
+	}{
+		{"async", "?delivery=async", func(m *mocks.AsyncProducer) { m.ExpectInputAndSucceed() }, http.StatusAccepted},
+		{"ack", "?delivery=ack", func(m *mocks.AsyncProducer) { m.ExpectInputAndSucceed() }, http.StatusOK},
+		{"rejected", "", func(m *mocks.AsyncProducer) { m.ExpectInputAndFail(sarama.ErrNotLeaderForPartition) }, http.StatusBadGateway},
+		{"invalid", "?delivery=maybe", func(m *mocks.AsyncProducer) {}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestBody, _ := json.Marshal(map[string]int8{"sides": 6, "rolls": 3})
+			req, err := http.NewRequest("POST", "/rolldice"+tt.query, bytes.NewBuffer(requestBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			h, mock := newTestHandler(t)
+			tt.expect(mock)
+			http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+		})
+	}
+}
+
+func TestRollDiceOutboxQueued(t *testing.T) {
+	h, _ := newTestHandler(t)
+	// The relay is not running, as if Kafka were unavailable.
+	box, err := outbox.New(&config.OutboxConfig{Dir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+	h.Producer = box
+	h.DeliveryTimeout = 10 * time.Millisecond
+
+	requestBody, _ := json.Marshal(map[string]int8{"sides": 6, "rolls": 3})
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusAccepted, rr.Code, "a queued roll is accepted")
+}
+
+func TestRollDiceExpression(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]string{
+		"expression": "4d6kh3+2",
+	})
+
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h, mock := newTestHandler(t)
+	mock.ExpectInputAndSucceed()
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "RollDiceResponse should be OK")
+
+	var response Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.NotNil(t, response.Result) {
+		assert.Equal(t, "4d6kh3+2", response.Result.Expression)
+		assert.GreaterOrEqual(t, response.Result.Total, 5)
+		assert.LessOrEqual(t, response.Result.Total, 20)
+		assert.Len(t, response.Result.Terms, 2)
+	}
+}
+
+func TestRollDiceInvalidExpression(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]string{
+		"expression": "4d6kh5",
+	})
+
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h, _ := newTestHandler(t)
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
 }
 
 func TestRollDiceInvalidInput(t *testing.T) {
-	_, err := rollDice(context.Background(), 1, 1)
+	h, _ := newTestHandler(t)
+	_, err := h.roll(context.Background(), 1, 1, mathRNG{})
 	assert.Error(t, err, "Should return an error for invalid input")
 
-	_, err = rollDice(context.Background(), 101, 1)
+	_, err = h.roll(context.Background(), 101, 1, mathRNG{})
 	assert.Error(t, err, "Should return an error for invalid input")
 }
 
 func TestRollDiceDistribution(t *testing.T) {
-	distribution, err := rollDice(context.Background(), 6, 100)
+	h, _ := newTestHandler(t)
+	distribution, err := h.roll(context.Background(), 6, 100, mathRNG{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,3 +228,189 @@ func TestRollDiceDistribution(t *testing.T) {
 
 	assert.Equal(t, int32(100), total, "Total rolls should be equal to 100")
 }
+
+func TestRollDiceSeededRNG(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]any{
+		"sides": 20,
+		"rolls": 5,
+		"rng":   map[string]any{"source": "pcg", "seed": "table-7"},
+	})
+
+	for _, allowed := range []bool{false, true} {
+		req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h, mock := newTestHandler(t)
+		h.AllowSeededRNG = allowed
+		if allowed {
+			mock.ExpectInputAndSucceed()
+		}
+		http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+		if allowed {
+			assert.Equal(t, http.StatusOK, rr.Code, "seeded requests should be rolled when allowed")
+		} else {
+			assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, "seeded requests should be rejected by default")
+			assert.Contains(t, rr.Body.String(), ErrSeededRNGDisabled.Error())
+		}
+	}
+}
+
+func TestRollDiceFair(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]any{
+		"expression": "3d6",
+		"fair":       map[string]any{"client_seed": "abc", "nonce": 42},
+	})
+
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seeds, err := fair.NewManager(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h, mock := newTestHandler(t)
+	h.Fair = seeds
+	mock.ExpectInputAndSucceed()
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "RollDiceResponse should be OK")
+
+	var response Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, response.Proof) {
+		assert.Equal(t, uint64(42), response.Proof.Nonce)
+		assert.Len(t, response.Proof.Draws, 3)
+
+		revealed, _, err := seeds.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, fair.Verify(revealed.Secret, response.Proof))
+	}
+}
+
+func TestRollDiceFairReusedNonce(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]any{
+		"expression": "3d6",
+		"fair":       map[string]any{"client_seed": "abc", "nonce": 42},
+	})
+	seeds, err := fair.NewManager(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, mock := newTestHandler(t)
+	h.Fair = seeds
+	mock.ExpectInputAndSucceed()
+
+	for _, code := range []int{http.StatusOK, http.StatusUnprocessableEntity} {
+		req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+		assert.Equal(t, code, rr.Code, "a nonce can only be used once per server seed")
+	}
+
+	// A nonce far ahead would use up the nonces of every other client.
+	requestBody, _ = json.Marshal(map[string]any{
+		"expression": "3d6",
+		"fair":       map[string]any{"client_seed": "abc", "nonce": uint64(math.MaxUint64 - 1)},
+	})
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, uint64(43), seeds.Commitment().NextNonce)
+}
+
+func TestRollDiceBatch(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]any{
+		"rolls": []map[string]any{
+			{"sides": 6, "rolls": 2},
+			{"expression": "2d20kl1"},
+		},
+	})
+
+	req, err := http.NewRequest("POST", "/rolldice/batch", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h, mock := newTestHandler(t)
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndSucceed()
+	http.HandlerFunc(h.RollDiceBatch).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response BatchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, response.Rolls, 2) {
+		assert.NotNil(t, response.Rolls[0].Delivery)
+		assert.NotNil(t, response.Rolls[1].Result)
+	}
+	assert.Empty(t, response.Transaction)
+}
+
+func TestRollDiceCloudEvents(t *testing.T) {
+	for _, mode := range []string{cloudevents.ModeBinary, cloudevents.ModeStructured} {
+		t.Run(mode, func(t *testing.T) {
+			envelope, err := cloudevents.NewEncoder(mode, cloudevents.Event{
+				Source:          "/pub-service",
+				Type:            "dev.go-sandbox.dice.rolled",
+				DataContentType: ContentType("json"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			requestBody, _ := json.Marshal(map[string]any{"sides": 6, "rolls": 3, "table": "table-7"})
+			req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			h, mock := newTestHandler(t)
+			h.Envelope = envelope
+			mock.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+				value, err := msg.Value.Encode()
+				if err != nil {
+					return err
+				}
+				headers := make([]*sarama.RecordHeader, len(msg.Headers))
+				for i := range msg.Headers {
+					headers[i] = &msg.Headers[i]
+				}
+				event, err := cloudevents.Decode(headers, value)
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, "dev.go-sandbox.dice.rolled", event.Type)
+				assert.Equal(t, "table-7", event.Subject)
+				roll := &Response{}
+				if err := json.Unmarshal(event.Data, roll); err != nil {
+					return err
+				}
+				assert.Equal(t, int8(3), roll.Rolls)
+				return nil
+			})
+			http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+		})
+	}
+}
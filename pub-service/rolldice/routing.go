@@ -0,0 +1,130 @@
+package rolldice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pub-service/config"
+	"pub-service/kafka"
+	"strings"
+)
+
+const (
+	KeyNone     = "none"
+	KeyClientID = "client_id"
+	KeyField    = "field"
+	KeyTenant   = "tenant"
+)
+
+var errPartitionRequired = errors.New("partition is required with the manual partitioner")
+
+// Router decides the key and partition of every roll. Rolls with the same
+// key are written to the same partition and therefore consumed in order.
+type Router struct {
+	// Strategy is one of KeyNone, KeyClientID, KeyField or KeyTenant.
+	Strategy string
+	// Header is the request header read by KeyClientID and KeyTenant.
+	Header string
+	// Field is the dot separated path of the roll request field read by
+	// KeyField, for example "table" or "fair.client_seed".
+	Field string
+	// Manual requires every roll to set its partition.
+	Manual bool
+}
+
+// route is where a single roll is published. A nil Key leaves placement to
+// the partitioner.
+type route struct {
+	Key       []byte
+	Partition int32
+}
+
+func NewRouter(config *config.KafkaConfig) (*Router, error) {
+	rt := &Router{
+		Strategy: config.KeyStrategy,
+		Field:    config.KeyField,
+		Manual:   config.Partitioner == kafka.PartitionerManual,
+	}
+	switch config.KeyStrategy {
+	case "", KeyNone:
+	case KeyClientID:
+		rt.Header = config.ClientIDHeader
+	case KeyTenant:
+		rt.Header = config.TenantHeader
+	case KeyField:
+		if config.KeyField == "" {
+			return nil, errors.New("field key strategy requires a key field")
+		}
+	default:
+		return nil, fmt.Errorf("invalid key strategy %q, must be one of none, client_id, field or tenant", config.KeyStrategy)
+	}
+	return rt, nil
+}
+
+// route returns the key and partition of a roll request. raw is the request
+// as sent by the client.
+func (rt *Router) route(r *http.Request, raw json.RawMessage, req *Request) (route, error) {
+	var rte route
+	if rt == nil {
+		return rte, nil
+	}
+
+	switch rt.Strategy {
+	case KeyClientID, KeyTenant:
+		if v := r.Header.Get(rt.Header); v != "" {
+			rte.Key = []byte(v)
+		}
+	case KeyField:
+		key, err := lookupField(raw, rt.Field)
+		if err != nil {
+			return rte, err
+		}
+		rte.Key = key
+	}
+
+	if rt.Manual {
+		if req.Partition == nil {
+			return rte, errPartitionRequired
+		}
+		if *req.Partition < 0 {
+			return rte, fmt.Errorf("invalid partition %d", *req.Partition)
+		}
+		rte.Partition = *req.Partition
+	}
+	return rte, nil
+}
+
+// lookupField returns the value at path in raw. Strings are used as is, other
+// values in their JSON form. A missing field or null yields a nil key.
+func lookupField(raw json.RawMessage, path string) ([]byte, error) {
+	value := raw
+	for _, name := range strings.Split(path, ".") {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return nil, nil
+		}
+		var ok bool
+		value, ok = fields[name]
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	value = bytes.TrimSpace(value)
+	if bytes.Equal(value, []byte("null")) {
+		return nil, nil
+	}
+	if len(value) > 0 && value[0] == '"' {
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, fmt.Errorf("invalid key field %q: %w", path, err)
+		}
+		if s == "" {
+			return nil, nil
+		}
+		return []byte(s), nil
+	}
+	return value, nil
+}
@@ -0,0 +1,102 @@
+package rolldice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pub-service/config"
+	"pub-service/kafka"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRouter(t *testing.T) {
+	rt, err := NewRouter(&config.KafkaConfig{KeyStrategy: KeyTenant, TenantHeader: "X-Tenant-ID", Partitioner: kafka.PartitionerManual})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "X-Tenant-ID", rt.Header)
+	assert.True(t, rt.Manual)
+
+	_, err = NewRouter(&config.KafkaConfig{KeyStrategy: "player"})
+	assert.Error(t, err)
+
+	_, err = NewRouter(&config.KafkaConfig{KeyStrategy: KeyField})
+	assert.Error(t, err, "field strategy requires a field")
+}
+
+func TestRoute(t *testing.T) {
+	raw := json.RawMessage(`{"table":"table-7","sides":6,"fair":{"client_seed":"player-1"}}`)
+	req := httptest.NewRequest("POST", "/rolldice", nil)
+	req.Header.Set("X-Client-ID", "client-42")
+
+	tests := []struct {
+		name   string
+		router *Router
+		key    []byte
+	}{
+		{"nil", nil, nil},
+		{"none", &Router{Strategy: KeyNone}, nil},
+		{"client id", &Router{Strategy: KeyClientID, Header: "X-Client-ID"}, []byte("client-42")},
+		{"missing header", &Router{Strategy: KeyTenant, Header: "X-Tenant-ID"}, nil},
+		{"field", &Router{Strategy: KeyField, Field: "table"}, []byte("table-7")},
+		{"nested field", &Router{Strategy: KeyField, Field: "fair.client_seed"}, []byte("player-1")},
+		{"number field", &Router{Strategy: KeyField, Field: "sides"}, []byte("6")},
+		{"missing field", &Router{Strategy: KeyField, Field: "seat"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rte, err := tt.router.route(req, raw, &Request{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.key, rte.Key)
+		})
+	}
+}
+
+func TestRouteManual(t *testing.T) {
+	rt := &Router{Manual: true}
+	req := httptest.NewRequest("POST", "/rolldice", nil)
+
+	_, err := rt.route(req, json.RawMessage(`{}`), &Request{})
+	assert.ErrorIs(t, err, errPartitionRequired)
+
+	partition := int32(3)
+	rte, err := rt.route(req, json.RawMessage(`{}`), &Request{Partition: &partition})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int32(3), rte.Partition)
+}
+
+func TestRollDiceKeyedByTable(t *testing.T) {
+	requestBody, _ := json.Marshal(map[string]any{"sides": 6, "rolls": 3, "table": "table-7"})
+	req, err := http.NewRequest("POST", "/rolldice", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h, mock := newTestHandler(t)
+	h.Router = &Router{Strategy: KeyField, Field: "table"}
+	mock.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		key, err := msg.Key.Encode()
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, "table-7", string(key))
+		return nil
+	})
+	http.HandlerFunc(h.RollDice).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "table-7", response.Table)
+}
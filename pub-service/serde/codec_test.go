@@ -0,0 +1,20 @@
+package serde
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageIndexes(t *testing.T) {
+	for _, indexes := range [][]int{{0}, {1}, {1, 0}, {3, 2, 1}} {
+		b := appendIndexes(nil, indexes)
+		got, n, err := readIndexes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, indexes, got)
+		assert.Equal(t, len(b), n)
+	}
+	assert.Equal(t, []byte{0}, appendIndexes(nil, []int{0}))
+}
@@ -0,0 +1,203 @@
+package serde
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+)
+
+var (
+	ErrSchemaNotFound     = errors.New("schema not found")
+	ErrIncompatibleSchema = errors.New("schema is incompatible with the latest registered version")
+)
+
+// Schema is a schema as stored in the registry. An empty Type means AVRO.
+type Schema struct {
+	Type   string `json:"schemaType,omitempty"`
+	Schema string `json:"schema"`
+}
+
+func (s Schema) schemaType() string {
+	if s.Type == "" {
+		return SchemaTypeAvro
+	}
+	return s.Type
+}
+
+// Registry is the subset of the Confluent Schema Registry API used by the
+// serializers. It is implemented by Client, and by serdetest.Registry in
+// tests.
+type Registry interface {
+	// Register registers schema under subject and returns its ID. Registering
+	// a schema that already exists returns the existing ID.
+	Register(ctx context.Context, subject string, schema Schema) (int, error)
+	// Lookup returns the ID of schema if it is registered under subject.
+	Lookup(ctx context.Context, subject string, schema Schema) (int, error)
+	// SchemaByID returns the schema registered with id.
+	SchemaByID(ctx context.Context, id int) (Schema, error)
+	// Compatible reports whether schema is compatible with the latest version
+	// of subject according to the subject's compatibility level. A subject
+	// without versions is compatible with any schema.
+	Compatible(ctx context.Context, subject string, schema Schema) (bool, error)
+}
+
+// TopicSubject returns the subject of the values of topic under the default
+// TopicNameStrategy.
+func TopicSubject(topic string) string {
+	return topic + "-value"
+}
+
+// SchemaURL returns the registry URL a schema can be fetched from by its ID.
+func SchemaURL(registryURL string, id int) string {
+	return strings.TrimSuffix(registryURL, "/") + "/schemas/ids/" + strconv.Itoa(id)
+}
+
+// Client talks to a Schema Registry over HTTP. Schemas and IDs are immutable
+// once registered, so both are cached for the lifetime of the Client.
+type Client struct {
+	url    string
+	client *http.Client
+
+	mu   sync.Mutex
+	ids  map[string]int
+	byID map[int]Schema
+}
+
+func NewClient(registryURL string) *Client {
+	return &Client{
+		url:    strings.TrimSuffix(registryURL, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+		ids:    make(map[string]int),
+		byID:   make(map[int]Schema),
+	}
+}
+
+func cacheKey(subject string, schema Schema) string {
+	return subject + "\x00" + schema.schemaType() + "\x00" + schema.Schema
+}
+
+func (c *Client) cachedID(subject string, schema Schema) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[cacheKey(subject, schema)]
+	return id, ok
+}
+
+func (c *Client) cache(subject string, schema Schema, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids[cacheKey(subject, schema)] = id
+	c.byID[id] = schema
+}
+
+func (c *Client) Register(ctx context.Context, subject string, schema Schema) (int, error) {
+	if id, ok := c.cachedID(subject, schema); ok {
+		return id, nil
+	}
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", schema, &resp); err != nil {
+		return 0, fmt.Errorf("failed to register schema for %s: %w", subject, err)
+	}
+	c.cache(subject, schema, resp.ID)
+	return resp.ID, nil
+}
+
+func (c *Client) Lookup(ctx context.Context, subject string, schema Schema) (int, error) {
+	if id, ok := c.cachedID(subject, schema); ok {
+		return id, nil
+	}
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject), schema, &resp); err != nil {
+		return 0, fmt.Errorf("failed to look up schema for %s: %w", subject, err)
+	}
+	c.cache(subject, schema, resp.ID)
+	return resp.ID, nil
+}
+
+func (c *Client) SchemaByID(ctx context.Context, id int) (Schema, error) {
+	c.mu.Lock()
+	schema, ok := c.byID[id]
+	c.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+	if err := c.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &schema); err != nil {
+		return Schema{}, fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	c.mu.Lock()
+	c.byID[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+func (c *Client) Compatible(ctx context.Context, subject string, schema Schema) (bool, error) {
+	var resp struct {
+		IsCompatible bool `json:"is_compatible"`
+	}
+	err := c.do(ctx, http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", schema, &resp)
+	if errors.Is(err, ErrSchemaNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check compatibility for %s: %w", subject, err)
+	}
+	return resp.IsCompatible, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrSchemaNotFound
+	case resp.StatusCode >= 300:
+		var apiErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: %s", ErrIncompatibleSchema, apiErr.Message)
+		}
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, apiErr.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,176 @@
+package serde_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"pub-service/internal/serdetest"
+	"pub-service/serde"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "Roll",
+  "fields": [
+    {"name": "sides", "type": "int"},
+    {"name": "table", "type": "string"}
+  ]
+}`
+
+const testAvroSchemaV2 = `{
+  "type": "record",
+  "name": "Roll",
+  "fields": [
+    {"name": "sides", "type": "int"},
+    {"name": "table", "type": "string"},
+    {"name": "seat", "type": "int", "default": 0}
+  ]
+}`
+
+const testAvroSchemaIncompatible = `{
+  "type": "record",
+  "name": "Roll",
+  "fields": [
+    {"name": "sides", "type": "int"},
+    {"name": "table", "type": "string"},
+    {"name": "seat", "type": "int"}
+  ]
+}`
+
+const testProtoSchema = `syntax = "proto3";
+package test.v1;
+
+message Other {
+  string name = 1;
+}
+
+message Roll {
+  int32 sides = 1;
+  string table = 2;
+
+  message Nested {
+    int32 seat = 1;
+  }
+}`
+
+type testRoll struct {
+	Sides int    `avro:"sides" json:"sides"`
+	Table string `avro:"table" json:"table"`
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	registry := serdetest.NewRegistry()
+	codec, err := serde.NewAvroCodec(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.Serialize(&testRoll{Sides: 6, Table: "table-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, serde.IsWireFormat(data))
+	assert.Equal(t, []byte{0, 0, 0, 0, byte(s.SchemaID())}, data[:5])
+
+	var got testRoll
+	if err := serde.NewDeserializer(registry).Deserialize(ctx, data, &got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testRoll{Sides: 6, Table: "table-7"}, got)
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	registry := serdetest.NewRegistry()
+	codec, err := serde.NewProtobufCodec(testProtoSchema, "test.v1.Roll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := serde.NewSerializer(ctx, registry, serde.TopicSubject("dice-rolls"), codec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.Serialize(&testRoll{Sides: 20, Table: "table-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Roll is the second message: one index, 1, as zig-zag varints.
+	assert.Equal(t, []byte{2, 2}, data[5:7])
+
+	var got testRoll
+	if err := serde.NewDeserializer(registry).Deserialize(ctx, data, &got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testRoll{Sides: 20, Table: "table-1"}, got)
+}
+
+func TestDeserializeNotWireFormat(t *testing.T) {
+	err := serde.NewDeserializer(serdetest.NewRegistry()).Deserialize(context.Background(), []byte(`{"sides":6}`), &testRoll{})
+	assert.ErrorIs(t, err, serde.ErrNotWireFormat)
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(serdetest.NewRegistry())
+	defer srv.Close()
+	client := serde.NewClient(srv.URL)
+	subject := serde.TopicSubject("dice-rolls")
+	schema := serde.Schema{Schema: testAvroSchema}
+
+	_, err := client.Lookup(ctx, subject, schema)
+	assert.ErrorIs(t, err, serde.ErrSchemaNotFound)
+
+	compatible, err := client.Compatible(ctx, subject, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, compatible, "a new subject accepts any schema")
+
+	id, err := client.Register(ctx, subject, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := client.Register(ctx, subject, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, again)
+
+	got, err := client.SchemaByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testAvroSchema, got.Schema)
+
+	compatible, err = client.Compatible(ctx, subject, serde.Schema{Schema: testAvroSchemaV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, compatible, "adding a field with a default is backward compatible")
+
+	compatible, err = client.Compatible(ctx, subject, serde.Schema{Schema: testAvroSchemaIncompatible})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, compatible, "adding a field without a default is not backward compatible")
+
+	_, err = client.Register(ctx, subject, serde.Schema{Schema: testAvroSchemaIncompatible})
+	assert.ErrorIs(t, err, serde.ErrIncompatibleSchema)
+}
+
+func TestNewSerializerWithoutAutoRegister(t *testing.T) {
+	codec, err := serde.NewAvroCodec(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = serde.NewSerializer(context.Background(), serdetest.NewRegistry(), "dice-rolls-value", codec, false)
+	assert.ErrorIs(t, err, serde.ErrSchemaNotFound)
+}